@@ -0,0 +1,258 @@
+package main
+
+import (
+	"awesomeVM/internal/mips32"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// codeListings disassembles the executable sections of elfFile, or all of
+// raw if elfFile is nil.
+func codeListings(elfFile *elf.File, raw *os.File) ([]*mips32.Listing, error) {
+	if elfFile == nil {
+		l, err := rawListing(raw)
+		if err != nil {
+			return nil, err
+		}
+		return []*mips32.Listing{l}, nil
+	}
+
+	funcs, objects := funcSymbols(elfFile), mips32.ELFObjects(elfFile)
+	var listings []*mips32.Listing
+	for _, section := range codeSections(elfFile) {
+		l, err := sectionListing(section, orderOf(elfFile), funcs, objects)
+		if err != nil {
+			return nil, err
+		}
+		listings = append(listings, l)
+	}
+	return listings, nil
+}
+
+// codeSections returns the sections of elfFile holding instructions.
+func codeSections(elfFile *elf.File) []*elf.Section {
+	var sections []*elf.Section
+	for _, section := range elfFile.Sections {
+		if section.Flags&elf.SHF_EXECINSTR != 0 && section.Type == elf.SHT_PROGBITS {
+			sections = append(sections, section)
+		}
+	}
+	return sections
+}
+
+// sectionListing disassembles section.
+func sectionListing(section *elf.Section, order binary.ByteOrder, funcs, objects *mips32.SymbolTable) (*mips32.Listing, error) {
+	data, err := section.Data()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", section.Name, err)
+	}
+	return mips32.NewListingISA(data, uint32(section.Addr), order, release(), funcs, objects), nil
+}
+
+// rawListing disassembles a raw image loaded at -base, which is also
+// where function discovery starts.
+func rawListing(raw *os.File) (*mips32.Listing, error) {
+	if _, err := raw.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(raw)
+	if err != nil {
+		return nil, err
+	}
+	funcs := mips32.NewSymbolTable(mips32.DiscoverFuncsISA(data, rawBase, orderOf(nil), rawBase, release()))
+	if isa == "mips16" {
+		funcs = mips32.NewSymbolTable([]mips32.Symbol{wholeMIPS16(rawBase, uint32(len(data)))})
+	}
+	return mips32.NewListingISA(data, rawBase, orderOf(nil), release(), funcs, nil), nil
+}
+
+// funcSymbols returns the function symbols of elfFile, or the functions
+// discovered in its code if it is stripped.
+func funcSymbols(elfFile *elf.File) *mips32.SymbolTable {
+	funcs := mips32.ELFSymbols(elfFile)
+	if len(funcs.Symbols()) > 0 && isa == "mips16" {
+		syms := append([]mips32.Symbol(nil), funcs.Symbols()...)
+		for i := range syms {
+			syms[i].MIPS16 = true
+		}
+		return mips32.NewSymbolTable(syms)
+	}
+	if len(funcs.Symbols()) > 0 {
+		return funcs
+	}
+	var found []mips32.Symbol
+	for _, section := range codeSections(elfFile) {
+		if isa == "mips16" {
+			found = append(found, wholeMIPS16(uint32(section.Addr), uint32(section.Size)))
+		} else if data, err := section.Data(); err == nil {
+			found = append(found, mips32.DiscoverFuncsISA(data, uint32(section.Addr), orderOf(elfFile), uint32(elfFile.Entry), release())...)
+		}
+	}
+	return mips32.NewSymbolTable(found)
+}
+
+// release returns the instruction set selected by -isa.
+func release() mips32.ISA {
+	switch isa {
+	case "r6":
+		return mips32.ISAR6
+	case "mips64":
+		return mips32.ISAMIPS64
+	}
+	return mips32.ISAR2
+}
+
+// wholeMIPS16 returns a MIPS16e function covering size bytes at addr, for
+// code decoded with -isa mips16 without symbols.
+func wholeMIPS16(addr, size uint32) mips32.Symbol {
+	return mips32.Symbol{Name: fmt.Sprintf("sub_%08x", addr), Addr: addr, Size: size, MIPS16: true}
+}
+
+// findFunc returns the function called name, or starting at the address
+// name, and the listing holding it.
+func findFunc(listings []*mips32.Listing, name string) (*mips32.Listing, mips32.Symbol, error) {
+	addr, err := strconv.ParseUint(name, 0, 32)
+	isAddr := err == nil
+	for _, l := range listings {
+		if !isAddr {
+			for _, sym := range l.Funcs.Symbols() {
+				if sym.Name == name {
+					return l, sym, nil
+				}
+			}
+			continue
+		}
+		if len(l.Insts) == 0 || uint32(addr) < l.Insts[0].Addr || uint32(addr) >= listingEnd(l) {
+			continue
+		}
+		if sym, ok := l.FuncStart(uint32(addr)); ok {
+			return l, sym, nil
+		}
+		return l, mips32.Symbol{Name: fmt.Sprintf("sub_%08x", addr), Addr: uint32(addr)}, nil
+	}
+	return nil, mips32.Symbol{}, fmt.Errorf("no function %s in the code", name)
+}
+
+// exportCFG writes the control-flow graph of the function fn as Graphviz
+// DOT, JSON or both. Without out, a single format goes to stdout and both
+// go to files named after the function.
+func exportCFG(listings []*mips32.Listing, fn, format, out string) error {
+	l, sym, err := findFunc(listings, fn)
+	if err != nil {
+		return err
+	}
+	g, err := l.CFG(sym)
+	if err != nil {
+		return err
+	}
+
+	var formats []string
+	switch format {
+	case "dot", "json":
+		formats = []string{format}
+	case "both":
+		formats = []string{"dot", "json"}
+		if out == "" {
+			out = g.Func.Name
+		}
+	default:
+		return fmt.Errorf("unknown CFG format %q", format)
+	}
+
+	for _, f := range formats {
+		w := io.Writer(os.Stdout)
+		var file *os.File
+		if out != "" {
+			if file, err = os.Create(out + "." + f); err != nil {
+				return err
+			}
+			w = file
+		}
+		if f == "dot" {
+			err = writeDOT(w, l, g)
+		} else {
+			err = writeCFGJSON(w, l, g)
+		}
+		if file != nil {
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDOT writes g as a Graphviz digraph with one box per basic block.
+func writeDOT(w io.Writer, l *mips32.Listing, g *mips32.CFG) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", strconv.Quote(g.Func.Name))
+	fmt.Fprintf(&b, "\tnode [shape=box, fontname=\"monospace\"];\n")
+	for _, block := range g.Blocks {
+		var label strings.Builder
+		if block.Start == g.Func.Addr {
+			fmt.Fprintf(&label, "%s:\\l", g.Func.Name)
+		} else if name, ok := l.Labels[block.Start]; ok {
+			fmt.Fprintf(&label, "%s:\\l", name)
+		}
+		for _, inst := range block.Insts {
+			text := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(inst.Format(regNames))
+			fmt.Fprintf(&label, "0x%08x: %s\\l", inst.Addr, text)
+		}
+		fmt.Fprintf(&b, "\t\"0x%08x\" [label=\"%s\"];\n", block.Start, label.String())
+	}
+	for _, block := range g.Blocks {
+		for _, e := range block.Succs {
+			fmt.Fprintf(&b, "\t\"0x%08x\" -> \"0x%08x\" [label=%q];\n", block.Start, e.To, e.Kind)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// JSON form of a control-flow graph
+type jsonCFG struct {
+	Function string      `json:"function"`
+	Start    uint32      `json:"start"`
+	End      uint32      `json:"end"`
+	Blocks   []jsonBlock `json:"blocks"`
+}
+
+type jsonBlock struct {
+	Start uint32        `json:"start"`
+	End   uint32        `json:"end"`
+	Insts []jsonInst    `json:"insts"`
+	Succs []jsonCFGEdge `json:"succs"`
+}
+
+type jsonCFGEdge struct {
+	To   uint32 `json:"to"`
+	Kind string `json:"kind"`
+}
+
+// writeCFGJSON writes g as indented JSON.
+func writeCFGJSON(w io.Writer, l *mips32.Listing, g *mips32.CFG) error {
+	out := jsonCFG{Function: g.Func.Name, Start: g.Func.Addr, End: g.Func.Addr + g.Func.Size}
+	for _, block := range g.Blocks {
+		jb := jsonBlock{Start: block.Start, End: block.End(), Succs: []jsonCFGEdge{}}
+		for _, inst := range block.Insts {
+			jb.Insts = append(jb.Insts, newJSONInst(l, inst))
+		}
+		for _, e := range block.Succs {
+			jb.Succs = append(jb.Succs, jsonCFGEdge{To: e.To, Kind: e.Kind.String()})
+		}
+		out.Blocks = append(out.Blocks, jb)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"awesomeVM/internal/mips32"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// gnuSection is a code section as GNU objdump sees it.
+type gnuSection struct {
+	name string
+	addr uint32
+	data []byte
+	syms []mips32.Symbol // symbols defined in the section, by address
+}
+
+// disassembleGNU prints the code sections of elfFile, or the raw image if
+// elfFile is nil, exactly as mips-linux-gnu-objdump -d does.
+func disassembleGNU(name string, elfFile *elf.File, raw *os.File) error {
+	order := orderOf(elfFile)
+	var sections []gnuSection
+	class := "elf32"
+	if elfFile != nil && elfFile.Class == elf.ELFCLASS64 {
+		class = "elf64"
+	}
+	format := class + "-trad" + strings.TrimSuffix(orderName(order), "-endian") + "mips"
+	if elfFile == nil {
+		// objdump -b binary -m mips shows the image as .data
+		if _, err := raw.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		data, err := io.ReadAll(raw)
+		if err != nil {
+			return err
+		}
+		format = "binary"
+		sections = append(sections, gnuSection{name: ".data", addr: rawBase, data: data})
+	} else {
+		symbols, _ := elfFile.Symbols()
+		for _, section := range codeSections(elfFile) {
+			if onlySection != "" && section.Name != onlySection {
+				continue
+			}
+			data, err := section.Data()
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", section.Name, err)
+			}
+			sections = append(sections, gnuSection{
+				name: section.Name,
+				addr: uint32(section.Addr),
+				data: data,
+				syms: sectionSymbols(elfFile, section, symbols),
+			})
+		}
+	}
+
+	fmt.Printf("\n%s:     file format %s\n\n", name, format)
+	for _, s := range sections {
+		fmt.Printf("\nDisassembly of section %s:\n", s.name)
+		s.print(order, sections)
+	}
+	return nil
+}
+
+// sectionSymbols returns the symbols of section, one per address, preferring
+// global functions.
+func sectionSymbols(elfFile *elf.File, section *elf.Section, symbols []elf.Symbol) []mips32.Symbol {
+	rank := func(sym elf.Symbol) int {
+		r := 0
+		if elf.ST_TYPE(sym.Info) == elf.STT_FUNC {
+			r += 2
+		}
+		if elf.ST_BIND(sym.Info) != elf.STB_LOCAL {
+			r++
+		}
+		return r
+	}
+	best := make(map[uint32]elf.Symbol)
+	for _, sym := range symbols {
+		if sym.Name == "" || int(sym.Section) >= len(elfFile.Sections) || elfFile.Sections[sym.Section] != section {
+			continue
+		}
+		switch elf.ST_TYPE(sym.Info) {
+		case elf.STT_NOTYPE, elf.STT_FUNC, elf.STT_OBJECT:
+		default:
+			continue
+		}
+		if b, ok := best[uint32(sym.Value)]; !ok || rank(sym) > rank(b) {
+			best[uint32(sym.Value)] = sym
+		}
+	}
+	out := make([]mips32.Symbol, 0, len(best))
+	for addr, sym := range best {
+		mips16 := elf.ST_TYPE(sym.Info) == elf.STT_FUNC && (sym.Other&0xF0 == 0xF0 || addr&1 != 0)
+		out = append(out, mips32.Symbol{Name: sym.Name, Addr: addr &^ 1, MIPS16: mips16 || isa == "mips16"})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Addr < out[j].Addr })
+	return out
+}
+
+// symbolize returns the symbolic form of addr objdump prints after targets:
+// the preceding symbol of its section plus an offset, or the section name.
+func (s *gnuSection) symbolize(addr uint32) (string, bool) {
+	if addr < s.addr || addr-s.addr >= uint32(len(s.data)) {
+		return "", false
+	}
+	i := sort.Search(len(s.syms), func(i int) bool { return s.syms[i].Addr > addr })
+	base, at := s.name, s.addr
+	if i > 0 {
+		base, at = s.syms[i-1].Name, s.syms[i-1].Addr
+	}
+	if addr == at {
+		return base, true
+	}
+	return fmt.Sprintf("%s+0x%x", base, addr-at), true
+}
+
+// print disassembles the section one symbol at a time, like objdump:
+// runs of at least two zero words that do not follow a branch become "...".
+func (s *gnuSection) print(order binary.ByteOrder, sections []gnuSection) {
+	end := s.addr + uint32(len(s.data))
+	addrWidth := gnuAddrWidth(end)
+	target := func(addr uint32) string {
+		for i := range sections {
+			if name, ok := sections[i].symbolize(addr); ok {
+				return fmt.Sprintf("%x <%s>", addr, name)
+			}
+		}
+		return fmt.Sprintf("%x", addr)
+	}
+
+	// segments start at the section and at each symbol
+	starts := []mips32.Symbol{{Name: s.name, Addr: s.addr, MIPS16: isa == "mips16"}}
+	for _, sym := range s.syms {
+		if sym.Addr == s.addr {
+			starts[0] = sym
+		} else if sym.Addr > s.addr && sym.Addr < end {
+			starts = append(starts, sym)
+		}
+	}
+
+	for i, seg := range starts {
+		stop := end
+		if i+1 < len(starts) {
+			stop = starts[i+1].Addr
+		}
+		if !inRange(seg.Addr) && !inRange(stop-1) {
+			continue
+		}
+		fmt.Printf("\n%08x <%s>:\n", seg.Addr, seg.Name)
+		if seg.MIPS16 {
+			s.printMIPS16(seg.Addr, stop, order, addrWidth, target)
+			continue
+		}
+
+		afterBranch := false
+		for addr := seg.Addr; addr+4 <= stop; {
+			off := addr - s.addr
+			zeros := uint32(0)
+			for addr+zeros+4 <= stop && order.Uint32(s.data[off+zeros:]) == 0 {
+				zeros += 4
+			}
+			if !afterBranch && zeros >= 8 {
+				if inRange(addr) {
+					fmt.Println("\t...")
+				}
+				addr += zeros
+				continue
+			}
+
+			inst := mips32.DecodeISA(order.Uint32(s.data[off:]), addr, release())
+			if inRange(addr) {
+				if source != nil {
+					source.print(addr)
+				}
+				fmt.Printf("%*x:\t%08x \t%s\n", addrWidth, addr, inst.Word, inst.GNU(target))
+			}
+			afterBranch = inst.HasDelaySlot()
+			addr += 4
+		}
+	}
+}
+
+// printMIPS16 disassembles the MIPS16e code between addr and stop, showing
+// the halfwords of each instruction as objdump does.
+func (s *gnuSection) printMIPS16(addr, stop uint32, order binary.ByteOrder, addrWidth int, target func(uint32) string) {
+	for addr+2 <= stop {
+		inst := mips32.DecodeMIPS16(s.data[addr-s.addr:stop-s.addr], addr, order)
+		if inRange(addr) {
+			if source != nil {
+				source.print(addr)
+			}
+			word := fmt.Sprintf("%04x", inst.Word)
+			if inst.Size() == 4 {
+				word = fmt.Sprintf("%04x %04x", inst.Word>>16, inst.Word&0xFFFF)
+			}
+			fmt.Printf("%*x:\t%-9s \t%s\n", addrWidth, addr, word, inst.GNU(target))
+		}
+		addr += inst.Size()
+	}
+}
+
+// gnuAddrWidth returns the width objdump gives the addresses of a section
+// ending at end: eight digits less the leading zeros, dropped four at a
+// time while keeping one.
+func gnuAddrWidth(end uint32) int {
+	digits := fmt.Sprintf("%08x", end)
+	skip := len(digits) - len(strings.TrimLeft(digits, "0"))
+	if skip != 0 {
+		skip = (skip - 1) &^ 3
+	}
+	return 8 - skip
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// inspect writes the parts of the file selected by -h, -t, -r and -s to w,
+// in the manner of objdump.
+func inspect(w io.Writer, name string, elfFile *elf.File, raw *os.File, headers, syms, relocs, contents bool) error {
+	if elfFile == nil {
+		if _, err := raw.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		data, err := io.ReadAll(raw)
+		if err != nil {
+			return err
+		}
+		if headers {
+			fmt.Fprintf(w, "%s:     raw image, %d bytes at 0x%08x, %s\n\n", name, len(data), rawBase, orderName(orderOf(nil)))
+		}
+		if syms {
+			fmt.Fprintf(w, "SYMBOL TABLE:\nno symbols\n\n")
+		}
+		if relocs {
+			fmt.Fprintf(w, "no relocations\n\n")
+		}
+		if contents {
+			fmt.Fprintln(w, "Contents of the image:")
+			hexDump(w, data, rawBase)
+			fmt.Fprintln(w)
+		}
+		return nil
+	}
+
+	if headers {
+		printHeaders(w, name, elfFile, raw)
+	}
+	if syms {
+		if err := printSymbols(w, elfFile); err != nil {
+			return err
+		}
+	}
+	if relocs {
+		if err := printRelocs(w, elfFile); err != nil {
+			return err
+		}
+	}
+	if contents {
+		for _, section := range elfFile.Sections {
+			if onlySection != "" && section.Name != onlySection || !hasContents(section, onlySection != "") {
+				continue
+			}
+			data, err := section.Data()
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", section.Name, err)
+			}
+			fmt.Fprintf(w, "Contents of section %s:\n", section.Name)
+			hexDump(w, data, uint32(section.Addr))
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// hasContents reports whether -s shows section: like objdump, only named
+// sections show their symbol, string and relocation tables.
+func hasContents(section *elf.Section, named bool) bool {
+	switch section.Type {
+	case elf.SHT_NULL, elf.SHT_NOBITS:
+		return false
+	case elf.SHT_SYMTAB, elf.SHT_STRTAB, elf.SHT_REL, elf.SHT_RELA:
+		return named
+	}
+	return section.Size > 0
+}
+
+// printHeaders prints the file header and the section headers.
+func printHeaders(w io.Writer, name string, elfFile *elf.File, r io.ReaderAt) {
+	fmt.Fprintf(w, "%s:     file format elf32-trad%smips\n", name, strings.TrimSuffix(orderName(elfFile.ByteOrder), "-endian"))
+	fmt.Fprintf(w, "architecture: %s, type %s, flags 0x%08x\n", elfFile.Machine, elfFile.Type, elfHeaderFlags(elfFile, r))
+	fmt.Fprintf(w, "start address 0x%08x\n\n", elfFile.Entry)
+
+	if len(elfFile.Progs) > 0 {
+		fmt.Fprintln(w, "Program Header:")
+		for _, p := range elfFile.Progs {
+			fmt.Fprintf(w, "%8s off    0x%08x vaddr 0x%08x paddr 0x%08x align 2**%d\n",
+				strings.TrimPrefix(p.Type.String(), "PT_"), p.Off, p.Vaddr, p.Paddr, log2(p.Align))
+			fmt.Fprintf(w, "         filesz 0x%08x memsz 0x%08x flags %s\n", p.Filesz, p.Memsz, progFlagsString(p.Flags))
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w, "Sections:")
+	fmt.Fprintln(w, "Idx Name          Size      VMA       File off  Algn  Type")
+	for i, section := range elfFile.Sections {
+		if section.Type == elf.SHT_NULL || onlySection != "" && section.Name != onlySection {
+			continue
+		}
+		fmt.Fprintf(w, "%3d %-13s %08x  %08x  %08x  2**%-2d  %s\n",
+			i, section.Name, section.Size, section.Addr, section.Offset, log2(section.Addralign),
+			strings.TrimPrefix(section.Type.String(), "SHT_"))
+		fmt.Fprintf(w, "                  %s\n", strings.Join(sectionAttrs(section), ", "))
+	}
+	fmt.Fprintln(w)
+}
+
+// elfHeaderFlags returns e_flags, which debug/elf does not expose.
+func elfHeaderFlags(elfFile *elf.File, r io.ReaderAt) uint32 {
+	var hdr elf.Header32
+	if err := binary.Read(io.NewSectionReader(r, 0, int64(binary.Size(hdr))), elfFile.ByteOrder, &hdr); err != nil {
+		return 0
+	}
+	return hdr.Flags
+}
+
+// sectionAttrs describes a section with the attribute names of objdump -h.
+func sectionAttrs(section *elf.Section) []string {
+	var attrs []string
+	if section.Type != elf.SHT_NOBITS {
+		attrs = append(attrs, "CONTENTS")
+	}
+	if section.Flags&elf.SHF_ALLOC != 0 {
+		attrs = append(attrs, "ALLOC")
+		if section.Type != elf.SHT_NOBITS {
+			attrs = append(attrs, "LOAD")
+		}
+	}
+	if section.Type == elf.SHT_REL || section.Type == elf.SHT_RELA {
+		attrs = append(attrs, "RELOC")
+	}
+	if section.Flags&elf.SHF_WRITE == 0 {
+		attrs = append(attrs, "READONLY")
+	}
+	switch {
+	case section.Flags&elf.SHF_EXECINSTR != 0:
+		attrs = append(attrs, "CODE")
+	case section.Flags&elf.SHF_ALLOC != 0:
+		attrs = append(attrs, "DATA")
+	}
+	return attrs
+}
+
+func progFlagsString(flags elf.ProgFlag) string {
+	b := []byte("---")
+	if flags&elf.PF_R != 0 {
+		b[0] = 'r'
+	}
+	if flags&elf.PF_W != 0 {
+		b[1] = 'w'
+	}
+	if flags&elf.PF_X != 0 {
+		b[2] = 'x'
+	}
+	return string(b)
+}
+
+func log2(n uint64) int {
+	i := 0
+	for n > 1 {
+		n >>= 1
+		i++
+	}
+	return i
+}
+
+// printSymbols prints .symtab with the columns of objdump -t: value, flags,
+// section, size and name.
+func printSymbols(w io.Writer, elfFile *elf.File) error {
+	symbols, err := elfFile.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return err
+	}
+	fmt.Fprintln(w, "SYMBOL TABLE:")
+	if len(symbols) == 0 {
+		fmt.Fprintf(w, "no symbols\n\n")
+		return nil
+	}
+	for _, sym := range symbols {
+		if !inRange(uint32(sym.Value)) {
+			continue
+		}
+		flags := []byte("       ")
+		switch elf.ST_BIND(sym.Info) {
+		case elf.STB_LOCAL:
+			flags[0] = 'l'
+		case elf.STB_GLOBAL:
+			flags[0] = 'g'
+			if sym.Section == elf.SHN_UNDEF {
+				flags[0] = ' '
+			}
+		case elf.STB_WEAK:
+			flags[1] = 'w'
+		}
+		switch elf.ST_TYPE(sym.Info) {
+		case elf.STT_SECTION:
+			flags[5] = 'd'
+		case elf.STT_FUNC:
+			flags[6] = 'F'
+		case elf.STT_OBJECT:
+			flags[6] = 'O'
+		case elf.STT_FILE:
+			flags[5], flags[6] = 'd', 'f'
+		}
+		name := sym.Name
+		if elf.ST_TYPE(sym.Info) == elf.STT_SECTION && name == "" && int(sym.Section) < len(elfFile.Sections) {
+			name = elfFile.Sections[sym.Section].Name
+		}
+		fmt.Fprintf(w, "%08x %s %-8s\t%08x %s\n", sym.Value, flags, symbolSection(elfFile, sym.Section), sym.Size, name)
+	}
+	fmt.Fprintln(w)
+	return nil
+}
+
+func symbolSection(elfFile *elf.File, index elf.SectionIndex) string {
+	switch {
+	case index == elf.SHN_UNDEF:
+		return "*UND*"
+	case index == elf.SHN_ABS:
+		return "*ABS*"
+	case index == elf.SHN_COMMON:
+		return "*COM*"
+	case int(index) < len(elfFile.Sections):
+		return elfFile.Sections[index].Name
+	}
+	return fmt.Sprintf("[%d]", index)
+}
+
+// printRelocs prints the REL and RELA sections with the columns of
+// objdump -r: offset, type and symbol plus addend.
+func printRelocs(w io.Writer, elfFile *elf.File) error {
+	symbols, err := elfFile.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return err
+	}
+	found := false
+	for _, section := range elfFile.Sections {
+		if section.Type != elf.SHT_REL && section.Type != elf.SHT_RELA {
+			continue
+		}
+		target := symbolSection(elfFile, elf.SectionIndex(section.Info))
+		if onlySection != "" && target != onlySection {
+			continue
+		}
+		data, err := section.Data()
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", section.Name, err)
+		}
+		if len(data) == 0 {
+			continue
+		}
+		found = true
+
+		fmt.Fprintf(w, "RELOCATION RECORDS FOR [%s]:\n", target)
+		fmt.Fprintf(w, "%-8s %-17s %s\n", "OFFSET", "TYPE", "VALUE")
+		size := 8
+		if section.Type == elf.SHT_RELA {
+			size = 12
+		}
+		order := elfFile.ByteOrder
+		for off := 0; off+size <= len(data); off += size {
+			offset, info := order.Uint32(data[off:]), order.Uint32(data[off+4:])
+			value := "*ABS*"
+			if i := int(elf.R_SYM32(info)); i > 0 && i <= len(symbols) {
+				sym := symbols[i-1]
+				value = sym.Name
+				if elf.ST_TYPE(sym.Info) == elf.STT_SECTION || value == "" {
+					value = symbolSection(elfFile, sym.Section)
+				}
+			}
+			if section.Type == elf.SHT_RELA {
+				if addend := int32(order.Uint32(data[off+8:])); addend < 0 {
+					value += fmt.Sprintf("-0x%x", -int64(addend))
+				} else if addend > 0 {
+					value += fmt.Sprintf("+0x%x", addend)
+				}
+			}
+			fmt.Fprintf(w, "%08x %-17s %s\n", offset, elf.R_MIPS(elf.R_TYPE32(info)), value)
+		}
+		fmt.Fprintln(w)
+	}
+	if !found {
+		fmt.Fprintf(w, "no relocations\n\n")
+	}
+	return nil
+}
+
+// hexDump prints data in the format of objdump -s: the address, sixteen
+// bytes in groups of four and the printable characters.
+func hexDump(w io.Writer, data []byte, addr uint32) {
+	for off := 0; off < len(data); off += 16 {
+		line := addr + uint32(off)
+		if !inRange(line) && !inRange(line+15) {
+			continue
+		}
+		var hex, text strings.Builder
+		for i := 0; i < 16; i++ {
+			if i > 0 && i%4 == 0 {
+				hex.WriteByte(' ')
+			}
+			if off+i >= len(data) {
+				hex.WriteString("  ")
+				text.WriteByte(' ')
+				continue
+			}
+			c := data[off+i]
+			fmt.Fprintf(&hex, "%02x", c)
+			if c >= 0x20 && c < 0x7f {
+				text.WriteByte(c)
+			} else {
+				text.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(w, " %08x %s  %s\n", line, hex.String(), text.String())
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"awesomeVM/internal/mips32"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// writeTestELF assembles src with the MARS layout and writes it as an
+// executable in a temporary directory.
+func writeTestELF(t *testing.T, src string) string {
+	t.Helper()
+	img, err := mips32.Assemble("prog.s", []byte(src), mips32.MARSLayout)
+	if err != nil {
+		t.Fatalf("Assemble:\n%v", err)
+	}
+	var b bytes.Buffer
+	if err := img.WriteELF(&b); err != nil {
+		t.Fatalf("WriteELF: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "prog")
+	if err := os.WriteFile(path, b.Bytes(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// checkGolden compares got with testdata/name, or rewrites the file with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestInspectGolden(t *testing.T) {
+	path := writeTestELF(t, `
+		.text
+		.globl main
+main:	la $a0, msg
+		li $v0, 4
+		syscall
+		lw $a0, count
+		jal print
+		li $v0, 10
+		syscall
+print:	li $v0, 1
+		syscall
+		jr $ra
+		.data
+msg:	.asciiz "hello, world\n"
+		.align 2
+count:	.word 42
+`)
+	f, err := elf.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	raw, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+
+	tests := []struct {
+		golden                  string
+		section                 string // -j
+		headers, syms, contents bool
+	}{
+		{"headers.golden", "", true, false, false},
+		{"symbols.golden", "", false, true, false},
+		{"contents.golden", "", false, false, true},
+		{"data.golden", ".data", true, false, true},
+	}
+	defer func() { onlySection = "" }()
+	for _, tt := range tests {
+		onlySection = tt.section
+		var out bytes.Buffer
+		if err := inspect(&out, "prog", f, raw, tt.headers, tt.syms, false, tt.contents); err != nil {
+			t.Fatalf("inspect: %v", err)
+		}
+		checkGolden(t, tt.golden, out.Bytes())
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"awesomeVM/internal/mips32"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// JSON form of the disassembly, selected by -format json
+type jsonFile struct {
+	File      string        `json:"file"`
+	Format    string        `json:"format"` // elf or raw
+	Machine   string        `json:"machine,omitempty"`
+	ByteOrder string        `json:"byte_order"`
+	Entry     uint32        `json:"entry"`
+	Sections  []jsonSection `json:"sections"`
+}
+
+type jsonSection struct {
+	Name  string     `json:"name"`
+	Type  string     `json:"type"`
+	Addr  uint32     `json:"addr"`
+	Size  uint32     `json:"size"`
+	Flags string     `json:"flags"`
+	Insts []jsonInst `json:"instructions,omitempty"`
+}
+
+type jsonInst struct {
+	Addr      uint32        `json:"addr"`
+	Word      uint32        `json:"word"` // both halfwords of 32-bit MIPS16e instructions
+	Size      uint32        `json:"size"`
+	MIPS16    bool          `json:"mips16,omitempty"`
+	Mnemonic  string        `json:"mnemonic"` // .word or .half if the word is not an instruction
+	Operands  []jsonOperand `json:"operands"`
+	Text      string        `json:"text"`
+	Symbol    string        `json:"symbol,omitempty"`
+	Ref       *jsonRef      `json:"ref,omitempty"` // address recovered from a lui pair
+	Branch    bool          `json:"branch"`
+	DelaySlot bool          `json:"delay_slot"`
+}
+
+// jsonOperand holds the fields of one operand kind:
+// reg: reg, num; imm and uimm: value; mem: base, num, offset;
+// target: addr, symbol; cp0reg, copreg, fpreg and fcc: num;
+// indexed: base, num, index.
+type jsonOperand struct {
+	Kind   string  `json:"kind"`
+	Reg    string  `json:"reg,omitempty"`
+	Base   string  `json:"base,omitempty"`
+	Index  string  `json:"index,omitempty"`
+	Num    *uint8  `json:"num,omitempty"`
+	Value  *int64  `json:"value,omitempty"`
+	Offset *int64  `json:"offset,omitempty"`
+	Addr   *uint32 `json:"addr,omitempty"`
+	Symbol string  `json:"symbol,omitempty"`
+}
+
+type jsonRef struct {
+	Addr   uint32 `json:"addr"`
+	Symbol string `json:"symbol,omitempty"`
+}
+
+// writeJSON writes the sections of elfFile, or the raw image if elfFile is
+// nil, with the disassembly of the code sections.
+func writeJSON(w io.Writer, name string, elfFile *elf.File, raw *os.File) error {
+	out := jsonFile{File: name, Sections: []jsonSection{}}
+	if elfFile == nil {
+		l, err := rawListing(raw)
+		if err != nil {
+			return err
+		}
+		out.Format, out.ByteOrder = "raw", orderJSON(orderOf(nil))
+		out.Sections = append(out.Sections, jsonSection{
+			Name:  "raw",
+			Type:  "raw",
+			Addr:  rawBase,
+			Size:  listingEnd(l) - rawBase,
+			Flags: "X",
+			Insts: jsonInsts(l),
+		})
+	} else {
+		out.Format, out.Machine, out.Entry = "elf", elfFile.Machine.String(), uint32(elfFile.Entry)
+		out.ByteOrder = orderJSON(orderOf(elfFile))
+
+		funcs, objects := funcSymbols(elfFile), mips32.ELFObjects(elfFile)
+		code := make(map[*elf.Section]bool)
+		for _, section := range codeSections(elfFile) {
+			code[section] = true
+		}
+		for _, section := range elfFile.Sections {
+			if onlySection != "" && section.Name != onlySection {
+				continue
+			}
+			js := jsonSection{
+				Name:  section.Name,
+				Type:  section.Type.String(),
+				Addr:  uint32(section.Addr),
+				Size:  uint32(section.Size),
+				Flags: sectionFlagsString(section.Flags),
+			}
+			if code[section] {
+				l, err := sectionListing(section, orderOf(elfFile), funcs, objects)
+				if err != nil {
+					return err
+				}
+				js.Insts = jsonInsts(l)
+			}
+			out.Sections = append(out.Sections, js)
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// listingEnd returns the address just past the last instruction of l.
+func listingEnd(l *mips32.Listing) uint32 {
+	if len(l.Insts) == 0 {
+		return rawBase
+	}
+	last := l.Insts[len(l.Insts)-1]
+	return last.Addr + last.Size()
+}
+
+// jsonInsts converts the instructions of l between -start and -stop.
+func jsonInsts(l *mips32.Listing) []jsonInst {
+	insts := []jsonInst{}
+	for _, inst := range l.Insts {
+		if inRange(inst.Addr) {
+			insts = append(insts, newJSONInst(l, inst))
+		}
+	}
+	return insts
+}
+
+func orderJSON(order binary.ByteOrder) string {
+	if order == binary.LittleEndian {
+		return "little"
+	}
+	return "big"
+}
+
+// newJSONInst converts inst, naming addresses after the symbols of l.
+func newJSONInst(l *mips32.Listing, inst mips32.Inst) jsonInst {
+	ji := jsonInst{
+		Addr:      inst.Addr,
+		Word:      inst.Word,
+		Size:      inst.Size(),
+		MIPS16:    inst.IsMIPS16(),
+		Mnemonic:  inst.Op,
+		Operands:  []jsonOperand{},
+		Text:      inst.Format(regNames),
+		Symbol:    l.Name(inst.Addr),
+		Branch:    inst.Control() != mips32.ControlNone,
+		DelaySlot: inst.HasDelaySlot(),
+	}
+	if inst.Op == "" && inst.Size() == 2 {
+		ji.Mnemonic = ".half"
+	} else if inst.Op == "" {
+		ji.Mnemonic = ".word"
+	}
+	if ref, ok := l.Refs[inst.Addr]; ok {
+		ji.Ref = &jsonRef{Addr: ref, Symbol: l.Name(ref)}
+	}
+	for _, a := range inst.Args {
+		op := jsonOperand{Kind: a.Kind.String()}
+		reg, imm := a.Reg, a.Imm
+		switch a.Kind {
+		case mips32.ArgReg:
+			op.Reg, op.Num = mips32.RegName(a.Reg, regNames), &reg
+		case mips32.ArgImm, mips32.ArgUImm:
+			op.Value = &imm
+		case mips32.ArgMem:
+			op.Base, op.Num, op.Offset = mips32.RegName(a.Reg, regNames), &reg, &imm
+		case mips32.ArgTarget:
+			addr := uint32(a.Imm)
+			op.Addr, op.Symbol = &addr, l.Name(addr)
+		case mips32.ArgCP0Reg, mips32.ArgCopReg, mips32.ArgFPReg, mips32.ArgFCC:
+			op.Num = &reg
+		case mips32.ArgIndexed:
+			op.Base, op.Num = mips32.RegName(a.Reg, regNames), &reg
+			op.Index = mips32.RegName(uint8(a.Imm), regNames)
+		}
+		ji.Operands = append(ji.Operands, op)
+	}
+	return ji
+}
@@ -1,21 +1,86 @@
 package main
 
 import (
+	"awesomeVM/internal/mips32"
 	"debug/elf"
 	"encoding/binary"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
+	"strconv"
+)
+
+// Options shared by the output modes, set from the flags.
+var (
+	regNames    = mips32.ABIRegs           // -regs
+	forcedOrder binary.ByteOrder           // -endian, nil to follow the ELF header
+	rawBase     uint32                     // -base
+	startAddr   uint32                     // -start
+	stopAddr    uint64           = 1 << 32 // -stop, exclusive
+	onlySection string                     // -j
+	isa         string                     // -isa
 )
 
 func main() {
+	regsFlag := flag.String("regs", "abi", "register names: abi ($t0, $sp) or numeric ($8, $29)")
+	cfgFlag := flag.String("cfg", "", "export the control-flow graph of the function `name` or address instead of the listing")
+	cfgFormat := flag.String("cfg-format", "dot", "control-flow graph format: dot, json or both")
+	cfgOut := flag.String("cfg-out", "", "write the control-flow graph to `base`.dot/.json instead of stdout")
+	formatFlag := flag.String("format", "text", "output format: text or json")
+	endianFlag := flag.String("endian", "auto", "byte order: auto (from the ELF header, big for raw images), big or little")
+	baseFlag := flag.String("base", "0", "load `address` of a raw image")
+	startFlag := flag.String("start", "", "start at `address`")
+	stopFlag := flag.String("stop", "", "stop before `address`")
+	flag.StringVar(&onlySection, "j", "", "only show `section`")
+	disasm := flag.Bool("d", false, "disassemble, the default unless -h, -t, -r or -s is given")
+	headers := flag.Bool("h", false, "show the file and section headers")
+	syms := flag.Bool("t", false, "show the symbol table")
+	relocs := flag.Bool("r", false, "show the relocations")
+	contents := flag.Bool("s", false, "show the section contents in hex")
+	gnu := flag.Bool("gnu", false, "disassemble exactly like mips-linux-gnu-objdump -d")
+	withSource := flag.Bool("S", false, "interleave the source lines found through DWARF")
+	xrefs := flag.Bool("xrefs", false, "show the call graph, data references and strings instead of the listing")
+	flag.StringVar(&isa, "isa", "mips32", "instruction set: mips32, r6 for MIPS32 Release 6, mips64 (the default for ELF64 files), or mips16 to decode all code as MIPS16e; functions marked MIPS16 in the symbol table always are")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: mips_disassemble [flags] mips32_binary_file\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	switch *regsFlag {
+	case "abi":
+	case "numeric":
+		regNames = mips32.NumericRegs
+	default:
+		log.Fatalf("unknown register naming %q", *regsFlag)
+	}
+	if isa != "mips32" && isa != "mips16" && isa != "r6" && isa != "mips64" {
+		log.Fatalf("unknown instruction set %q", isa)
+	}
+	if *formatFlag != "text" && *formatFlag != "json" {
+		log.Fatalf("unknown output format %q", *formatFlag)
+	}
+	switch *endianFlag {
+	case "auto":
+	case "big":
+		forcedOrder = binary.BigEndian
+	case "little":
+		forcedOrder = binary.LittleEndian
+	default:
+		log.Fatalf("unknown byte order %q", *endianFlag)
+	}
+	rawBase = uint32(parseAddr(*baseFlag))
+	if *startFlag != "" {
+		startAddr = uint32(parseAddr(*startFlag))
+	}
+	if *stopFlag != "" {
+		stopAddr = parseAddr(*stopFlag)
+	}
+
 	if flag.NArg() != 1 {
-		fmt.Println("Usage: go run main.go [-endian=auto|big|little] <mips32_binary_file>")
-		return
+		flag.Usage()
+		os.Exit(2)
 	}
 
 	fileName := flag.Arg(0)
@@ -37,28 +102,128 @@ func main() {
 				log.Printf("Failed to close ELF file: %v", err)
 			}
 		}()
+		// ELF64 code is MIPS64 unless -isa says otherwise
+		if elfFile.Class == elf.ELFCLASS64 && !flagSet("isa") {
+			isa = "mips64"
+		}
+	} else {
+		elfFile = nil
+	}
+
+	if *withSource {
+		if elfFile == nil {
+			log.Fatal("-S needs an ELF file with DWARF line tables")
+		}
+		if source, err = newSourceLines(elfFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *cfgFlag != "" {
+		listings, err := codeListings(elfFile, file)
+		if err != nil {
+			log.Fatalf("Failed to disassemble: %v", err)
+		}
+		if err := exportCFG(listings, *cfgFlag, *cfgFormat, *cfgOut); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *xrefs {
+		if err := printXRefs(os.Stdout, elfFile, file, *formatFlag); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *formatFlag == "json" {
+		if err := writeJSON(os.Stdout, fileName, elfFile, file); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *headers || *syms || *relocs || *contents {
+		if err := inspect(os.Stdout, fileName, elfFile, file, *headers, *syms, *relocs, *contents); err != nil {
+			log.Fatal(err)
+		}
+		if !*disasm {
+			return
+		}
+		if *gnu {
+			if err := disassembleGNU(fileName, elfFile, file); err != nil {
+				log.Fatal(err)
+			}
+		} else if elfFile != nil {
+			disassembleCode(elfFile, orderOf(elfFile))
+		} else {
+			disassembleRaw(file)
+		}
+		return
+	}
+
+	if *gnu {
+		if err := disassembleGNU(fileName, elfFile, file); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if elfFile != nil {
 		disassembleELF(elfFile)
 		return
 	}
 
 	// If not ELF, treat as raw binary
 	fmt.Println("Not an ELF file, treating as raw binary")
+	fmt.Printf("Using byte order: %s\n", orderName(orderOf(nil)))
 	disassembleRaw(file)
 }
 
+// parseAddr parses an address flag, exiting if it is invalid.
+func parseAddr(s string) uint64 {
+	addr, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		log.Fatalf("invalid address %q", s)
+	}
+	return addr
+}
+
+// inRange reports whether addr lies between -start and -stop.
+func inRange(addr uint32) bool {
+	return addr >= startAddr && uint64(addr) < stopAddr
+}
+
+// orderOf returns the byte order to read elfFile with, or a raw image if
+// elfFile is nil.
+func orderOf(elfFile *elf.File) binary.ByteOrder {
+	switch {
+	case forcedOrder != nil:
+		return forcedOrder
+	case elfFile != nil:
+		return elfFile.ByteOrder
+	}
+	return binary.BigEndian
+}
+
+func orderName(order binary.ByteOrder) string {
+	if order == binary.LittleEndian {
+		return "little-endian"
+	}
+	return "big-endian"
+}
+
 func disassembleELF(elfFile *elf.File) {
 	fmt.Printf("ELF File: %s\n", elfFile.Machine)
 	fmt.Printf("Entry point: 0x%08X\n", elfFile.Entry)
 	fmt.Println()
 
-	// Decide byte order based on ELF endianness
-	var order binary.ByteOrder
-	if elfFile.ByteOrder == binary.LittleEndian {
-		order = binary.LittleEndian
-		fmt.Println("Using byte order: little-endian (from ELF header)")
+	order := orderOf(elfFile)
+	if forcedOrder != nil {
+		fmt.Printf("Using byte order: %s (forced)\n", orderName(order))
 	} else {
-		order = binary.BigEndian
-		fmt.Println("Using byte order: big-endian (from ELF header)")
+		fmt.Printf("Using byte order: %s (from ELF header)\n", orderName(order))
 	}
 	fmt.Println()
 
@@ -75,6 +240,23 @@ func disassembleELF(elfFile *elf.File) {
 	}
 	fmt.Println()
 
+	disassembleCode(elfFile, order)
+}
+
+// disassembleCode disassembles .text, the section chosen by -j, or every
+// executable section if there is no .text.
+func disassembleCode(elfFile *elf.File, order binary.ByteOrder) {
+	funcs, objects := funcSymbols(elfFile), mips32.ELFObjects(elfFile)
+
+	if onlySection != "" {
+		section := elfFile.Section(onlySection)
+		if section == nil {
+			log.Fatalf("no section %s", onlySection)
+		}
+		disassembleSection(section, order, funcs, objects)
+		return
+	}
+
 	// Find and disassemble .text section
 	textSection := elfFile.Section(".text")
 	if textSection == nil {
@@ -84,7 +266,7 @@ func disassembleELF(elfFile *elf.File) {
 		for _, section := range elfFile.Sections {
 			if section.Flags&elf.SHF_EXECINSTR != 0 {
 				fmt.Printf("Found executable section: %s\n", section.Name)
-				disassembleSection(section, order)
+				disassembleSection(section, order, funcs, objects)
 			}
 		}
 		return
@@ -92,25 +274,16 @@ func disassembleELF(elfFile *elf.File) {
 
 	fmt.Printf("Disassembling .text section (0x%08X - 0x%08X):\n", textSection.Addr, textSection.Addr+textSection.Size)
 	fmt.Println("=======================================================================")
-	disassembleSection(textSection, order)
+	disassembleSection(textSection, order, funcs, objects)
 }
 
-func disassembleSection(section *elf.Section, order binary.ByteOrder) {
-	data, err := section.Data()
+func disassembleSection(section *elf.Section, order binary.ByteOrder, funcs, objects *mips32.SymbolTable) {
+	l, err := sectionListing(section, order, funcs, objects)
 	if err != nil {
 		log.Printf("Failed to read section %s: %v", section.Name, err)
 		return
 	}
-
-	addr := section.Addr
-	for i := 0; i < len(data); i += 4 {
-		if i+4 > len(data) {
-			break
-		}
-
-		inst := order.Uint32(data[i : i+4])
-		fmt.Printf("0x%08X: 0x%08X\t%s\n", addr+uint64(i), inst, disassemble(inst, uint32(addr+uint64(i))))
-	}
+	printListing(l)
 }
 
 func sectionFlagsString(flags elf.SectionFlag) string {
@@ -131,295 +304,56 @@ func sectionFlagsString(flags elf.SectionFlag) string {
 }
 
 func disassembleRaw(file *os.File) {
-	// Decide byte order: force big-endian for raw files
-	var order binary.ByteOrder = binary.BigEndian
-	fmt.Println("Using byte order: big-endian (forced)")
-
-	// Begin reading from start
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		log.Fatalf("Failed to seek file: %v", err)
-	}
-
-	var offset int64 = 0
-	for {
-		var inst uint32
-		err := binary.Read(file, order, &inst)
-		if err != nil {
-			break
-		}
-
-		fmt.Printf("0x%08X: 0x%08X\t%s\n", offset, inst, disassemble(inst, uint32(offset)))
-		offset += 4
-	}
-}
-
-// Simple MIPS disassembler for a few instructions
-func disassemble(inst uint32, pc uint32) string {
-	op := inst >> 26
-
-	switch op {
-	case 0x0: // R-type
-		return disassembleR(inst)
-	case 0x1: // REGIMM
-		return disassembleRegimm(inst, pc)
-	case 0x2: // J
-		addr := inst & 0x3FFFFFF
-		// target = (pc & 0xF0000000) | (addr << 2)
-		target := ((pc + 4) & 0xF0000000) | (addr << 2)
-		return fmt.Sprintf("j 0x%08X", target)
-	case 0x3: // JAL
-		addr := inst & 0x3FFFFFF
-		target := ((pc + 4) & 0xF0000000) | (addr << 2)
-		return fmt.Sprintf("jal 0x%08X", target)
-	default: // I-type
-		return disassembleI(op, inst, pc)
-	}
-}
-
-func disassembleR(inst uint32) string {
-	rs := (inst >> 21) & 0x1F
-	rt := (inst >> 16) & 0x1F
-	rd := (inst >> 11) & 0x1F
-	shamt := (inst >> 6) & 0x1F
-	funct := inst & 0x3F
-
-	switch funct {
-	case 0x20:
-		return fmt.Sprintf("add $%d, $%d, $%d", rd, rs, rt)
-	case 0x21:
-		return fmt.Sprintf("addu $%d, $%d, $%d", rd, rs, rt)
-	case 0x22:
-		return fmt.Sprintf("sub $%d, $%d, $%d", rd, rs, rt)
-	case 0x23:
-		return fmt.Sprintf("subu $%d, $%d, $%d", rd, rs, rt)
-	case 0x24:
-		return fmt.Sprintf("and $%d, $%d, $%d", rd, rs, rt)
-	case 0x25:
-		return fmt.Sprintf("or $%d, $%d, $%d", rd, rs, rt)
-	case 0x2A:
-		return fmt.Sprintf("slt $%d, $%d, $%d", rd, rs, rt)
-	case 0x00:
-		return fmt.Sprintf("sll $%d, $%d, %d", rd, rt, shamt)
-	case 0x02:
-		return fmt.Sprintf("srl $%d, $%d, %d", rd, rt, shamt)
-	case 0x03:
-		return fmt.Sprintf("sra $%d, $%d, %d", rd, rt, shamt)
-	case 0x04:
-		return fmt.Sprintf("sllv $%d, $%d, $%d", rd, rt, rs)
-	case 0x06:
-		return fmt.Sprintf("srlv $%d, $%d, $%d", rd, rt, rs)
-	case 0x07:
-		return fmt.Sprintf("srav $%d, $%d, $%d", rd, rt, rs)
-	case 0x08:
-		return fmt.Sprintf("jr $%d", rs)
-	case 0x09:
-		return fmt.Sprintf("jalr $%d", rs)
-	case 0x10:
-		return fmt.Sprintf("mfhi $%d", rd)
-	case 0x12:
-		return fmt.Sprintf("mflo $%d", rd)
-	case 0x18:
-		return fmt.Sprintf("mult $%d, $%d", rs, rt)
-	case 0x19:
-		return fmt.Sprintf("multu $%d, $%d", rs, rt)
-	case 0x1A:
-		return fmt.Sprintf("div $%d, $%d", rs, rt)
-	case 0x1B:
-		return fmt.Sprintf("divu $%d, $%d", rs, rt)
-	case 0x0C:
-		return fmt.Sprintf("syscall")
-	case 0x0D:
-		return fmt.Sprintf("break")
-	case 0x0F:
-		return fmt.Sprintf("sync")
-	default:
-		return fmt.Sprintf("unknown R-funct 0x%02X", funct)
-	}
-}
-
-func disassembleI(op, inst uint32, pc uint32) string {
-	rs := (inst >> 21) & 0x1F
-	rt := (inst >> 16) & 0x1F
-	imm := inst & 0xFFFF
-
-	signExt := int32(int16(imm))
-
-	switch op {
-	case 0x08:
-		return fmt.Sprintf("addi $%d, $%d, %d", rt, rs, int16(imm))
-	case 0x09:
-		return fmt.Sprintf("addiu $%d, $%d, %d", rt, rs, int16(imm))
-	case 0x0C:
-		return fmt.Sprintf("andi $%d, $%d, %d", rt, rs, imm)
-	case 0x0D:
-		return fmt.Sprintf("ori $%d, $%d, %d", rt, rs, imm)
-	case 0x0E:
-		return fmt.Sprintf("xori $%d, $%d, %d", rt, rs, imm)
-	case 0x0A:
-		return fmt.Sprintf("slti $%d, $%d, %d", rt, rs, int16(imm))
-	case 0x0B:
-		return fmt.Sprintf("sltiu $%d, $%d, %d", rt, rs, int16(imm))
-	case 0x0F:
-		return fmt.Sprintf("lui $%d, 0x%04X", rt, imm)
-	case 0x23:
-		return fmt.Sprintf("lw $%d, %d($%d)", rt, int16(imm), rs)
-	case 0x20:
-		return fmt.Sprintf("lb $%d, %d($%d)", rt, int16(imm), rs)
-	case 0x21:
-		return fmt.Sprintf("lh $%d, %d($%d)", rt, int16(imm), rs)
-	case 0x24:
-		return fmt.Sprintf("lbu $%d, %d($%d)", rt, int16(imm), rs)
-	case 0x25:
-		return fmt.Sprintf("lhu $%d, %d($%d)", rt, int16(imm), rs)
-	case 0x22:
-		return fmt.Sprintf("lwl $%d, %d($%d)", rt, int16(imm), rs)
-	case 0x26:
-		return fmt.Sprintf("lwr $%d, %d($%d)", rt, int16(imm), rs)
-	case 0x2B:
-		return fmt.Sprintf("sw $%d, %d($%d)", rt, int16(imm), rs)
-	case 0x28:
-		return fmt.Sprintf("sb $%d, %d($%d)", rt, int16(imm), rs)
-	case 0x29:
-		return fmt.Sprintf("sh $%d, %d($%d)", rt, int16(imm), rs)
-	case 0x2A:
-		return fmt.Sprintf("swl $%d, %d($%d)", rt, int16(imm), rs)
-	case 0x2E:
-		return fmt.Sprintf("swr $%d, %d($%d)", rt, int16(imm), rs)
-	case 0x30: // ll
-		return fmt.Sprintf("ll $%d, %d($%d)", rt, int16(imm), rs)
-	case 0x38: // sc
-		return fmt.Sprintf("sc $%d, %d($%d)", rt, int16(imm), rs)
-	case 0x31: // lwc1
-		return fmt.Sprintf("lwc1 $f%d, %d($%d)", rt, int16(imm), rs)
-	case 0x32: // lwc2
-		return fmt.Sprintf("lwc2 %d, %d($%d)", rt, int16(imm), rs)
-	case 0x35: // ldc1
-		return fmt.Sprintf("ldc1 $f%d, %d($%d)", rt, int16(imm), rs)
-	case 0x36: // ldc2
-		return fmt.Sprintf("ldc2 %d, %d($%d)", rt, int16(imm), rs)
-	case 0x39: // swc1
-		return fmt.Sprintf("swc1 $f%d, %d($%d)", rt, int16(imm), rs)
-	case 0x3A: // swc2
-		return fmt.Sprintf("swc2 %d, %d($%d)", rt, int16(imm), rs)
-	case 0x3D: // sdc1
-		return fmt.Sprintf("sdc1 $f%d, %d($%d)", rt, int16(imm), rs)
-	case 0x3E: // sdc2
-		return fmt.Sprintf("sdc2 %d, %d($%d)", rt, int16(imm), rs)
-	case 0x04: // beq
-		offset := signExt << 2
-		target := pc + 4 + uint32(offset)
-		return fmt.Sprintf("beq $%d, $%d, 0x%08X", rs, rt, target)
-	case 0x05: // bne
-		offset := signExt << 2
-		target := pc + 4 + uint32(offset)
-		return fmt.Sprintf("bne $%d, $%d, 0x%08X", rs, rt, target)
-	case 0x06: // blez
-		offset := signExt << 2
-		target := pc + 4 + uint32(offset)
-		return fmt.Sprintf("blez $%d, 0x%08X", rs, target)
-	case 0x07: // bgtz
-		offset := signExt << 2
-		target := pc + 4 + uint32(offset)
-		return fmt.Sprintf("bgtz $%d, 0x%08X", rs, target)
-	case 0x10:
-		return disassembleCop0(inst)
-	case 0x11:
-		return disassembleCop1(inst)
-	case 0x12:
-		return disassembleCop2(inst)
-	default:
-		return fmt.Sprintf("unknown I-op 0x%02X", op)
-	}
-}
-
-func disassembleRegimm(inst uint32, pc uint32) string {
-	rs := (inst >> 21) & 0x1F
-	rt := (inst >> 16) & 0x1F
-	imm := inst & 0xFFFF
-
-	signExt := int32(int16(imm))
-	offset := signExt << 2
-	target := pc + 4 + uint32(offset)
-
-	switch rt {
-	case 0x00: // bltz
-		return fmt.Sprintf("bltz $%d, 0x%08X", rs, target)
-	case 0x01: // bgez
-		return fmt.Sprintf("bgez $%d, 0x%08X", rs, target)
-	case 0x10: // bltzal
-		return fmt.Sprintf("bltzal $%d, 0x%08X", rs, target)
-	case 0x11: // bgezal
-		return fmt.Sprintf("bgezal $%d, 0x%08X", rs, target)
-	default:
-		return fmt.Sprintf("unknown regimm rt=0x%02X", rt)
+	l, err := rawListing(file)
+	if err != nil {
+		log.Fatalf("Failed to read file: %v", err)
 	}
+	printListing(l)
 }
 
-func disassembleCop0(inst uint32) string {
-	rs := (inst >> 21) & 0x1F
-	rt := (inst >> 16) & 0x1F
-	rd := (inst >> 11) & 0x1F
-
-	switch rs {
-	case 0x00: // mfc0
-		return fmt.Sprintf("mfc0 $%d, $%d", rt, rd)
-	case 0x04: // mtc0
-		return fmt.Sprintf("mtc0 $%d, $%d", rt, rd)
-	case 0x10: // CO
-		funct := inst & 0x3F
-		switch funct {
-		case 0x01:
-			return "tlbr"
-		case 0x02:
-			return "tlbwi"
-		case 0x06:
-			return "tlbwr"
-		case 0x08:
-			return "tlbp"
-		case 0x18:
-			return "eret"
-		default:
-			return fmt.Sprintf("cop0-co funct=0x%02X", funct)
+// printListing prints one instruction per line, with function headers,
+// labels, symbolic branch targets and the addresses recovered from lui pairs.
+func printListing(l *mips32.Listing) {
+	for _, inst := range l.Insts {
+		if !inRange(inst.Addr) {
+			continue
+		}
+		if sym, ok := l.FuncStart(inst.Addr); ok {
+			fmt.Printf("\n0x%08X <%s>:\n", inst.Addr, sym.Name)
+		} else if label, ok := l.Labels[inst.Addr]; ok {
+			fmt.Printf("%s:\n", label)
+		}
+		if source != nil {
+			source.print(inst.Addr)
 		}
-	default:
-		return fmt.Sprintf("unknown cop0 rs=0x%02X", rs)
-	}
-}
 
-func disassembleCop1(inst uint32) string {
-	// FPU instructions
-	rs := (inst >> 21) & 0x1F
-	switch rs {
-	case 0x00: // mfc1
-		rt := (inst >> 16) & 0x1F
-		fs := (inst >> 11) & 0x1F
-		return fmt.Sprintf("mfc1 $%d, $f%d", rt, fs)
-	case 0x04: // mtc1
-		rt := (inst >> 16) & 0x1F
-		fs := (inst >> 11) & 0x1F
-		return fmt.Sprintf("mtc1 $%d, $f%d", rt, fs)
-	case 0x02: // cfc1
-		rt := (inst >> 16) & 0x1F
-		fs := (inst >> 11) & 0x1F
-		return fmt.Sprintf("cfc1 $%d, $f%d", rt, fs)
-	case 0x06: // ctc1
-		rt := (inst >> 16) & 0x1F
-		fs := (inst >> 11) & 0x1F
-		return fmt.Sprintf("ctc1 $%d, $f%d", rt, fs)
-	case 0x08: // BC
-		// bc1t, bc1f
-		return "bc1..."
-	case 0x10: // S
-		return "cop1-s"
-	case 0x11: // D
-		return "cop1-d"
-	case 0x14: // W
-		return "cop1-w"
-	default:
-		return fmt.Sprintf("unknown cop1 rs=0x%02X", rs)
+		text := inst.Format(regNames)
+		if target, ok := inst.Target(); ok {
+			if name := l.Name(target); name != "" {
+				text += " <" + name + ">"
+			}
+		}
+		if ref, ok := l.Refs[inst.Addr]; ok {
+			text += fmt.Sprintf("\t# 0x%08x", ref)
+			if name := l.Name(ref); name != "" {
+				text += " <" + name + ">"
+			}
+		}
+		word := fmt.Sprintf("0x%08X", inst.Word)
+		if inst.IsMIPS16() && inst.Size() == 2 {
+			word = fmt.Sprintf("0x%04X    ", inst.Word)
+		}
+		fmt.Printf("0x%08X: %s\t%s\n", inst.Addr, word, text)
 	}
 }
 
-func disassembleCop2(_ uint32) string {
-	return "cop2 instruction"
+// flagSet reports whether the flag name was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"debug/dwarf"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// sourceContext is the number of lines shown before a line when the
+// previous line printed from its file is further back.
+const sourceContext = 5
+
+// sourcePos is a position in the source code.
+type sourcePos struct {
+	file string
+	line int
+}
+
+// sourceLines interleaves source with the disassembly, set by -S.
+type sourceLines struct {
+	rows    map[uint32]sourcePos // statement addresses from the DWARF line tables
+	files   map[string][]string  // file contents, nil if unreadable
+	printed map[string]int       // last line shown of each file
+	last    sourcePos
+}
+
+// source is non-nil when -S is given.
+var source *sourceLines
+
+// newSourceLines reads the DWARF line tables of elfFile.
+func newSourceLines(elfFile *elf.File) (*sourceLines, error) {
+	data, err := elfFile.DWARF()
+	if err != nil {
+		return nil, fmt.Errorf("reading DWARF: %w", err)
+	}
+	s := &sourceLines{
+		rows:    make(map[uint32]sourcePos),
+		files:   make(map[string][]string),
+		printed: make(map[string]int),
+	}
+	r := data.Reader()
+	for {
+		cu, err := r.Next()
+		if err != nil {
+			return nil, fmt.Errorf("reading DWARF: %w", err)
+		}
+		if cu == nil {
+			break
+		}
+		if cu.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+		lr, err := data.LineReader(cu)
+		if err != nil {
+			return nil, fmt.Errorf("reading DWARF: %w", err)
+		}
+		r.SkipChildren()
+		if lr == nil {
+			continue
+		}
+		var entry dwarf.LineEntry
+		for {
+			if err := lr.Next(&entry); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("reading DWARF line table: %w", err)
+			}
+			if entry.EndSequence || entry.File == nil {
+				continue
+			}
+			s.rows[uint32(entry.Address)] = sourcePos{entry.File.Name, entry.Line}
+		}
+	}
+	return s, nil
+}
+
+// print prints the source position of the code at addr and its lines,
+// if addr starts a new line. Lines already shown are not repeated unless
+// the code goes back to them.
+func (s *sourceLines) print(addr uint32) {
+	pos, ok := s.rows[addr]
+	if !ok || pos == s.last {
+		return
+	}
+	s.last = pos
+	fmt.Printf("%s:%d\n", pos.file, pos.line)
+
+	text := s.file(pos.file)
+	if pos.line < 1 || pos.line > len(text) {
+		return
+	}
+	first := pos.line
+	if prev := s.printed[pos.file]; prev < pos.line {
+		first = max(prev+1, pos.line-sourceContext)
+	}
+	for n := first; n <= pos.line; n++ {
+		fmt.Println(text[n-1])
+	}
+	s.printed[pos.file] = max(s.printed[pos.file], pos.line)
+}
+
+// file returns the lines of the source file name, read once.
+func (s *sourceLines) file(name string) []string {
+	if text, ok := s.files[name]; ok {
+		return text
+	}
+	var text []string
+	if f, err := os.Open(name); err == nil {
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			text = append(text, sc.Text())
+		}
+		f.Close()
+	}
+	s.files[name] = text
+	return text
+}
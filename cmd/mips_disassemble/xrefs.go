@@ -0,0 +1,154 @@
+package main
+
+import (
+	"awesomeVM/internal/mips32"
+	"debug/elf"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// JSON form of the cross-reference report
+type jsonXRefs struct {
+	Calls   []jsonCall   `json:"calls"`
+	Data    []jsonData   `json:"data"`
+	Strings []jsonString `json:"strings"`
+}
+
+type jsonCall struct {
+	From     uint32 `json:"from"`
+	Caller   string `json:"caller,omitempty"`
+	To       uint32 `json:"to,omitempty"`
+	Callee   string `json:"callee,omitempty"`
+	Indirect bool   `json:"indirect"`
+}
+
+type jsonData struct {
+	From    uint32 `json:"from"`
+	Func    string `json:"func,omitempty"`
+	Addr    uint32 `json:"addr"`
+	Symbol  string `json:"symbol,omitempty"`
+	Section string `json:"section,omitempty"`
+}
+
+type jsonString struct {
+	Addr uint32   `json:"addr"`
+	Text string   `json:"text"`
+	Refs []uint32 `json:"refs"`
+}
+
+// memoryRegions returns the sections of elfFile loaded in memory, or the
+// raw image at -base if elfFile is nil.
+func memoryRegions(elfFile *elf.File, raw *os.File) ([]mips32.Region, error) {
+	if elfFile == nil {
+		if _, err := raw.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(raw)
+		if err != nil {
+			return nil, err
+		}
+		return []mips32.Region{{Name: "raw", Addr: rawBase, Size: uint32(len(data)), Data: data}}, nil
+	}
+
+	var regions []mips32.Region
+	for _, section := range elfFile.Sections {
+		if section.Flags&elf.SHF_ALLOC == 0 {
+			continue
+		}
+		r := mips32.Region{Name: section.Name, Addr: uint32(section.Addr), Size: uint32(section.Size)}
+		if section.Type != elf.SHT_NOBITS {
+			data, err := section.Data()
+			if err != nil {
+				return nil, fmt.Errorf("reading %s: %w", section.Name, err)
+			}
+			r.Data = data
+		}
+		regions = append(regions, r)
+	}
+	return regions, nil
+}
+
+// printXRefs prints the call graph, the data references and the strings
+// of the code, as text or JSON.
+func printXRefs(w io.Writer, elfFile *elf.File, raw *os.File, format string) error {
+	listings, err := codeListings(elfFile, raw)
+	if err != nil {
+		return err
+	}
+	regions, err := memoryRegions(elfFile, raw)
+	if err != nil {
+		return err
+	}
+
+	var all mips32.XRefs
+	for _, l := range listings {
+		x := l.XRefs(regions)
+		all.Calls = append(all.Calls, x.Calls...)
+		all.Data = append(all.Data, x.Data...)
+		all.Strings = append(all.Strings, x.Strings...)
+	}
+
+	if format == "json" {
+		out := jsonXRefs{Calls: []jsonCall{}, Data: []jsonData{}, Strings: []jsonString{}}
+		for _, c := range all.Calls {
+			out.Calls = append(out.Calls, jsonCall(c))
+		}
+		for _, d := range all.Data {
+			out.Data = append(out.Data, jsonData{d.From, d.Func, d.Addr, d.Symbol, d.Region})
+		}
+		for _, s := range all.Strings {
+			out.Strings = append(out.Strings, jsonString(s))
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+
+	site := func(addr uint32, fn string) string {
+		if fn == "" {
+			return fmt.Sprintf("0x%08x", addr)
+		}
+		return fmt.Sprintf("0x%08x <%s>", addr, fn)
+	}
+	named := func(addr uint32, name string) string {
+		if name == "" {
+			return fmt.Sprintf("0x%08x", addr)
+		}
+		return fmt.Sprintf("0x%08x <%s>", addr, name)
+	}
+
+	fmt.Fprintln(w, "Call graph:")
+	for _, c := range all.Calls {
+		callee := named(c.To, c.Callee)
+		switch {
+		case c.Indirect && c.To == 0:
+			callee = "(indirect)"
+		case c.Indirect:
+			callee += " (indirect)"
+		}
+		fmt.Fprintf(w, "  %s -> %s\n", site(c.From, c.Caller), callee)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "Data references:")
+	for _, d := range all.Data {
+		section := d.Region
+		if section == "" {
+			section = "unmapped"
+		}
+		fmt.Fprintf(w, "  %s -> %s [%s]\n", site(d.From, d.Func), named(d.Addr, d.Symbol), section)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "Strings:")
+	for _, s := range all.Strings {
+		fmt.Fprintf(w, "  0x%08x %s\n", s.Addr, strconv.Quote(s.Text))
+		for _, ref := range s.Refs {
+			fmt.Fprintf(w, "      referenced at 0x%08x\n", ref)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"awesomeVM/internal/mips32"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("mipsas: ")
+	output := flag.String("o", "", "write the object to `file` (default: the source name with .o)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: mipsas [-o file.o] file.s\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	path := flag.Arg(0)
+	src, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	obj, err := mips32.AssembleObject(path, src)
+	var errs mips32.AsmErrors
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "%v\n\t%s\n\t%s^\n", e, strings.ReplaceAll(e.Source, "\t", " "), strings.Repeat(" ", e.Col-1))
+		}
+		os.Exit(1)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	if *output == "" {
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + ".o"
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := obj.WriteELF(f); err != nil {
+		f.Close()
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
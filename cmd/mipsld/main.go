@@ -0,0 +1,144 @@
+package main
+
+import (
+	"awesomeVM/internal/mips32"
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("mipsld: ")
+	output := flag.String("o", "a.out", "write the executable to `file`")
+	script := flag.String("T", "", "read the layout from the linker script `file`")
+	textFlag := flag.String("Ttext", "", "place .text at `addr` (default 0x00400000)")
+	dataFlag := flag.String("Tdata", "", "place .data at `addr` (default 0x10010000)")
+	entryFlag := flag.String("e", "", "start execution at `symbol` (default __start or main)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: mipsld [flags] file.o|file.s...\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), `
+A linker script holds one statement per line, # starts a comment:
+	ENTRY(symbol)
+	.text addr
+	.data addr
+Flags override the script.
+`)
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	layout, entry := mips32.MARSLayout, ""
+	if *script != "" {
+		var err error
+		if layout, entry, err = readScript(*script, layout); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, f := range []struct {
+		value string
+		addr  *uint32
+	}{{*textFlag, &layout.TextAddr}, {*dataFlag, &layout.DataAddr}} {
+		if f.value == "" {
+			continue
+		}
+		addr, err := strconv.ParseUint(f.value, 0, 32)
+		if err != nil {
+			log.Fatalf("invalid address %q", f.value)
+		}
+		*f.addr = uint32(addr)
+	}
+	if *entryFlag != "" {
+		entry = *entryFlag
+	}
+
+	var objs []*mips32.Object
+	for _, path := range flag.Args() {
+		obj, err := readObject(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		objs = append(objs, obj)
+	}
+
+	img, err := mips32.Link(objs, layout, entry)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := img.WriteELF(&b); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*output, b.Bytes(), 0o755); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// readObject reads an ELF object, or assembles path if it is source.
+func readObject(path string) (*mips32.Object, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".s", ".asm":
+	default:
+		return mips32.ReadObject(path)
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := mips32.AssembleObject(path, src)
+	var errs mips32.AsmErrors
+	if !errors.As(err, &errs) {
+		return obj, err
+	}
+	var b strings.Builder
+	for _, e := range errs {
+		fmt.Fprintf(&b, "\n%v\n\t%s\n\t%s^", e, strings.ReplaceAll(e.Source, "\t", " "), strings.Repeat(" ", e.Col-1))
+	}
+	return nil, fmt.Errorf("%d assembly error(s):%s", len(errs), b.String())
+}
+
+// readScript reads the linker script in path, starting from layout.
+func readScript(path string, layout mips32.Layout) (mips32.Layout, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return layout, "", err
+	}
+	defer f.Close()
+
+	entry := ""
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case len(fields) == 1 && strings.HasPrefix(fields[0], "ENTRY(") && strings.HasSuffix(fields[0], ")"):
+			entry = strings.TrimSuffix(strings.TrimPrefix(fields[0], "ENTRY("), ")")
+		case len(fields) == 2 && (fields[0] == ".text" || fields[0] == ".data"):
+			addr, err := strconv.ParseUint(fields[1], 0, 32)
+			if err != nil {
+				return layout, "", fmt.Errorf("%s:%d: invalid address %q", path, n, fields[1])
+			}
+			if fields[0] == ".text" {
+				layout.TextAddr = uint32(addr)
+			} else {
+				layout.DataAddr = uint32(addr)
+			}
+		default:
+			return layout, "", fmt.Errorf("%s:%d: expected ENTRY(symbol), .text addr or .data addr", path, n)
+		}
+	}
+	return layout, entry, sc.Err()
+}
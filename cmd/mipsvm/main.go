@@ -2,11 +2,20 @@ package main
 
 import (
 	"awesomeVM/internal/mips32"
+	"bufio"
+	"context"
+	"debug/elf"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"math"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -14,7 +23,22 @@ import (
 func main() {
 	// parse flags
 	verbose := flag.Bool("v", false, "enable verbose logging")
-	memoryFlag := flag.Uint64("memory", 1<<20, "memory size in bytes (max 4294967295)")
+	memoryFlag := flag.Uint64("memory", 1<<20, "memory size in bytes (max 4294967295), raised to fit the program if not set")
+	profileFlag := flag.String("profile", "", "write a pprof profile of the guest to `file`")
+	profilePeriod := flag.Uint64("profile-period", 1, "sample every N retired instructions (1 = exact)")
+	coresFlag := flag.Int("cores", 1, "number of CPU cores")
+	schedFlag := flag.String("sched", "roundrobin", "core scheduling: roundrobin (deterministic) or parallel")
+	quantumFlag := flag.Int("quantum", 1000, "instructions per core turn in roundrobin scheduling")
+	ipiBaseFlag := flag.Uint64("ipi-base", 0xFFFF0000, "physical address of the IPI mailbox")
+	icacheFlag := flag.String("icache", "", "simulate an L1 I-cache of `size:ways:line` bytes, e.g. 16k:4:32")
+	dcacheFlag := flag.String("dcache", "", "simulate an L1 D-cache of `size:ways:line` bytes, e.g. 16k:4:32")
+	timingFlag := flag.Bool("timing", false, "model 5-stage pipeline timing and report the CPI at exit")
+	missPenalty := flag.Int("miss-penalty", mips32.DefaultTimingConfig().MissPenalty, "cache miss penalty in cycles for -timing")
+	syscallsFlag := flag.String("syscalls", "none", "SYSCALL handling: none (raise the exception) or spim (SPIM/MARS services)")
+	maxInstructions := flag.Uint64("max-instructions", 0, "stop each core after `N` instructions (0 = no limit)")
+	traceFlag := flag.Bool("trace", false, "print every executed instruction to stderr")
+	regsFlag := flag.String("regs", "abi", "register names in -trace: abi ($t0, $sp) or numeric ($8, $29)")
+	isaFlag := flag.String("isa", "r2", "instruction set: r2 (MIPS32 Release 1 and 2), r6 (Release 6) or mips64 (MIPS64 Release 2, the default for ELF64 files)")
 	flag.Parse()
 
 	printIfVerbose(*verbose, "Starting MIPS VM...")
@@ -24,44 +48,378 @@ func main() {
 		log.Fatalf("memory size %d exceeds max uint32 %d", *memoryFlag, math.MaxUint32)
 	}
 
+	if *ipiBaseFlag > uint64(math.MaxUint32) {
+		log.Fatalf("IPI mailbox address 0x%x exceeds max uint32", *ipiBaseFlag)
+	}
+
 	definedMemory := uint32(*memoryFlag)
 
+	// assembly source is laid out like MARS does, which needs memory up to
+	// the end of its data segment, and expects the SPIM system calls
+	var img *mips32.Image
+	if flag.NArg() > 0 && isAssembly(flag.Arg(0)) {
+		printIfVerbose(*verbose, "Assembling %s...", flag.Arg(0))
+		var err error
+		if img, err = assembleFile(flag.Arg(0)); err != nil {
+			log.Fatal(err)
+		}
+		if !flagSet("memory") {
+			definedMemory = imageMemorySize(img.End())
+		}
+		if !flagSet("syscalls") {
+			*syscallsFlag = "spim"
+		}
+	} else if flag.NArg() > 0 && !flagSet("memory") {
+		// executables get enough memory for their segments, as placed by
+		// mipsld with the MARS layout
+		end, err := elfEnd(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("failed to load program: %v", err)
+		}
+		if end > definedMemory {
+			definedMemory = imageMemorySize(end)
+		}
+	}
+
 	printIfVerbose(*verbose, "Allocating %d bytes of memory...", definedMemory)
 	memory := mips32.NewMemory(definedMemory)
 
-	printIfVerbose(*verbose, "Starting CPU...")
-	cpu := mips32.NewCPU(memory)
+	var sched mips32.Scheduling
+	switch *schedFlag {
+	case "roundrobin":
+		sched = mips32.RoundRobin
+	case "parallel":
+		sched = mips32.Parallel
+	default:
+		log.Fatalf("unknown scheduling %q", *schedFlag)
+	}
+
+	var isa mips32.ISA
+	switch *isaFlag {
+	case "r2":
+	case "r6":
+		isa = mips32.ISAR6
+	case "mips64":
+		isa = mips32.ISAMIPS64
+	default:
+		log.Fatalf("unknown instruction set %q", *isaFlag)
+	}
 
-	// create a channel to wait for CPU to stop
-	done := make(chan struct{})
+	printIfVerbose(*verbose, "Starting %d CPU core(s)...", *coresFlag)
+	system, err := mips32.NewSystem(memory, *coresFlag, uint32(*ipiBaseFlag))
+	if err != nil {
+		log.Fatalf("failed to create system: %v", err)
+	}
 
-	printIfVerbose(*verbose, "Running CPU...")
-	start := time.Now()
+	if *icacheFlag != "" || *dcacheFlag != "" {
+		for _, cpu := range system.CPUs {
+			icache, err := newCache(*icacheFlag)
+			if err != nil {
+				log.Fatalf("invalid -icache: %v", err)
+			}
+			dcache, err := newCache(*dcacheFlag)
+			if err != nil {
+				log.Fatalf("invalid -dcache: %v", err)
+			}
+			cpu.SetCaches(icache, dcache)
+		}
+	}
+
+	if *timingFlag {
+		cfg := mips32.DefaultTimingConfig()
+		cfg.MissPenalty = *missPenalty
+		for _, cpu := range system.CPUs {
+			cpu.SetTiming(mips32.NewTiming(cfg))
+		}
+	}
+
+	var symbols *mips32.SymbolTable
+	var heap uint32
+	switch {
+	case img != nil:
+		if err := img.Load(memory); err != nil {
+			log.Fatalf("failed to load program: %v", err)
+		}
+		system.SetPC(img.Entry())
+		for _, cpu := range system.CPUs {
+			cpu.SetReg(28, marsGP)
+			cpu.SetReg(29, (definedMemory-8)&^7) // $sp at the top of memory
+		}
+		symbols = img.TextSymbols()
+		heap = img.End()
+
+	case flag.NArg() > 0:
+		printIfVerbose(*verbose, "Loading %s...", flag.Arg(0))
+		prog, err := mips32.LoadELF(memory, flag.Arg(0))
+		if err != nil {
+			log.Fatalf("failed to load program: %v", err)
+		}
+		system.SetPC(prog.Entry)
+		symbols = prog.Symbols
+		heap = prog.End
+		if prog.MIPS64 && !flagSet("isa") {
+			isa = mips32.ISAMIPS64
+		}
+	}
 
-	// run the CPU in a goroutine so we can handle signals
-	go func() {
-		cpu.Run()
-		close(done)
-	}()
+	for _, cpu := range system.CPUs {
+		cpu.SetISA(isa)
+	}
 
-	// set up signal handling for Ctrl+C (os.Interrupt) and SIGTERM
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	var spim *mips32.SPIM
+	switch *syscallsFlag {
+	case "none":
+	case "spim":
+		spim = mips32.NewSPIM(memory, os.Stdin, os.Stdout, os.Stderr, heap)
+	default:
+		log.Fatalf("unknown syscall mode %q", *syscallsFlag)
+	}
+
+	var trace *tracer
+	if *traceFlag {
+		trace = &tracer{w: bufio.NewWriter(os.Stderr), symbols: symbols, isa: isa}
+		switch *regsFlag {
+		case "abi":
+		case "numeric":
+			trace.names = mips32.NumericRegs
+		default:
+			log.Fatalf("unknown register naming %q", *regsFlag)
+		}
+	}
 
-	// wait for either the CPU to finish or a signal
-	select {
-	case <-sigCh:
-		printIfVerbose(*verbose, "Signal received, stopping CPU...")
-		cpu.Stop()
-	case <-done:
-		// CPU finished on its own
+	for _, cpu := range system.CPUs {
+		var hooks mips32.Hooks
+		if spim != nil {
+			hooks.Syscall = func() bool { return spim.Syscall(cpu) }
+		}
+		if trace != nil {
+			hooks.Instruction = func(pc, word uint32) bool {
+				trace.trace(cpu.CPUNum(), pc, word)
+				return false
+			}
+		}
+		cpu.SetHooks(hooks)
+	}
+
+	var profiler *mips32.Profiler
+	if *profileFlag != "" {
+		profiler = mips32.NewProfiler(*profilePeriod, symbols)
+		system.CPUs[0].SetProfiler(profiler)
+	}
+
+	if *maxInstructions > 0 {
+		for _, cpu := range system.CPUs {
+			cpu.SetInstructionLimit(*maxInstructions)
+		}
+	}
+
+	// stop the cores on Ctrl+C (os.Interrupt) or SIGTERM
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	printIfVerbose(*verbose, "Running CPU...")
+	start := time.Now()
+
+	results, err := system.Run(ctx, sched, *quantumFlag)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	elapsed := time.Since(start)
+	if trace != nil {
+		trace.w.Flush()
+	}
 
-	printIfVerbose(*verbose, "CPU stopped.")
+	for i, r := range results {
+		printIfVerbose(*verbose, "core %d %v", i, r)
+	}
+	result := primaryResult(results)
+	if result.Reason != mips32.StopExit && result.Reason != mips32.StopHalted {
+		log.Printf("CPU stopped: %v", result)
+	}
 
 	printIfVerbose(*verbose, "Total execution time: %s", elapsed)
+
+	for _, cpu := range system.CPUs {
+		if c := cpu.ICache(); c != nil {
+			log.Printf("core %d I-cache: %v", cpu.CPUNum(), c.Stats)
+		}
+		if c := cpu.DCache(); c != nil {
+			log.Printf("core %d D-cache: %v", cpu.CPUNum(), c.Stats)
+		}
+		if t := cpu.Timing(); t != nil {
+			log.Printf("core %d timing: %v", cpu.CPUNum(), t.Stats)
+		}
+	}
+
+	if profiler != nil {
+		if err := writeProfile(*profileFlag, profiler); err != nil {
+			log.Fatalf("failed to write profile: %v", err)
+		}
+		printIfVerbose(*verbose, "Wrote profile of %d instructions to %s", profiler.Retired(), *profileFlag)
+	}
+
+	if spim != nil {
+		spim.Close()
+	}
+	cancel()
+	os.Exit(exitStatus(result))
+}
+
+// tracer prints executed instructions. It is shared by the cores.
+type tracer struct {
+	mu      sync.Mutex
+	w       *bufio.Writer
+	names   mips32.RegNames
+	isa     mips32.ISA
+	symbols *mips32.SymbolTable
+}
+
+func (t *tracer) trace(core int, pc, word uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if sym, ok := t.symbols.Lookup(pc); ok && sym.Addr == pc {
+		fmt.Fprintf(t.w, "core %d <%s>:\n", core, sym.Name)
+	}
+	fmt.Fprintf(t.w, "core %d 0x%08x: %08x  %s\n", core, pc, word, mips32.DecodeISA(word, pc, t.isa).Format(t.names))
+}
+
+// primaryResult picks the result that decides the exit status: the first core
+// that stopped for a reason other than being halted, or core 0.
+func primaryResult(results []mips32.Result) mips32.Result {
+	for _, r := range results {
+		if r.Reason != mips32.StopHalted {
+			return r
+		}
+	}
+	return results[0]
+}
+
+// exitStatus maps a run result to the process exit status.
+func exitStatus(r mips32.Result) int {
+	switch r.Reason {
+	case mips32.StopExit:
+		return r.ExitCode & 0xFF
+	case mips32.StopHalted:
+		return 0
+	case mips32.StopInstructionLimit:
+		return 124 // like timeout(1)
+	case mips32.StopCanceled:
+		return 130 // 128 + SIGINT
+	}
+	return 1
+}
+
+const (
+	// memoryReserve is the memory left above a program for its heap and stack.
+	memoryReserve = 4 << 20
+	// marsGP is the initial global pointer of MARS.
+	marsGP = 0x10008000
+)
+
+// elfEnd returns the end of the highest loadable segment of the ELF file at path.
+func elfEnd(path string) (uint32, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var end uint64
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_LOAD && p.Memsz > 0 {
+			// ELF64 addresses are sign-extended 32-bit ones
+			end = max(end, uint64(uint32(p.Vaddr))+p.Memsz)
+		}
+	}
+	return uint32(min(end, math.MaxUint32)), nil
+}
+
+// imageMemorySize returns the memory size for a program ending at end: the
+// program, rounded up to a page, and memoryReserve bytes of heap and stack.
+func imageMemorySize(end uint32) uint32 {
+	size := (uint64(end)+0xFFF)&^0xFFF + memoryReserve
+	return uint32(min(size, math.MaxUint32))
+}
+
+// isAssembly reports whether path names assembly source rather than an executable.
+func isAssembly(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".s", ".asm":
+		return true
+	}
+	return false
+}
+
+// flagSet reports whether the flag name was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// assembleFile assembles the source in path with the MARS layout. Errors are
+// returned with the offending source lines.
+func assembleFile(path string) (*mips32.Image, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	img, err := mips32.Assemble(path, src, mips32.MARSLayout)
+	var errs mips32.AsmErrors
+	if !errors.As(err, &errs) {
+		return img, err
+	}
+
+	var b strings.Builder
+	for _, e := range errs {
+		fmt.Fprintf(&b, "\n%v\n\t%s\n\t%s^", e, strings.ReplaceAll(e.Source, "\t", " "), strings.Repeat(" ", e.Col-1))
+	}
+	return nil, fmt.Errorf("%d assembly error(s):%s", len(errs), b.String())
+}
+
+// newCache creates a cache from a size:ways:line description, or returns nil
+// if desc is empty. Sizes accept a k or m suffix.
+func newCache(desc string) (*mips32.Cache, error) {
+	if desc == "" {
+		return nil, nil
+	}
+	parts := strings.Split(desc, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%q is not size:ways:line", desc)
+	}
+	var vals [3]int
+	for i, p := range parts {
+		mult := 1
+		switch {
+		case strings.HasSuffix(strings.ToLower(p), "k"):
+			mult, p = 1<<10, p[:len(p)-1]
+		case strings.HasSuffix(strings.ToLower(p), "m"):
+			mult, p = 1<<20, p[:len(p)-1]
+		}
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not size:ways:line", desc)
+		}
+		vals[i] = v * mult
+	}
+	return mips32.NewCache(mips32.CacheConfig{Size: vals[0], Ways: vals[1], LineSize: vals[2]})
+}
+
+// writeProfile stores the guest profile in path.
+func writeProfile(path string, profiler *mips32.Profiler) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := profiler.WriteProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
 // printIfVerbose prints a formatted message if verbose is true.
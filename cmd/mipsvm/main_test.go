@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestImageMemorySize(t *testing.T) {
+	tests := []struct {
+		end, want uint32
+	}{
+		{0x10010004, 0x10011000 + memoryReserve},
+		{0x10011000, 0x10011000 + memoryReserve},
+		{0xFFFFF000, 0xFFFFFFFF},
+	}
+	for _, tt := range tests {
+		if got := imageMemorySize(tt.end); got != tt.want {
+			t.Errorf("imageMemorySize(0x%x) = 0x%x, want 0x%x", tt.end, got, tt.want)
+		}
+	}
+}
+
+// TestAssembleLinkRun builds the tools and runs mipsld output with mipsvm's
+// default memory size.
+func TestAssembleLinkRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the commands")
+	}
+	dir := t.TempDir()
+	build := exec.Command("go", "build", "-o", dir, "awesomeVM/cmd/mipsas", "awesomeVM/cmd/mipsld", "awesomeVM/cmd/mipsvm")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+
+	for name, src := range map[string]string{
+		"a.s": `
+		.globl main
+		.text
+main:	la $a0, msg
+		jal puts
+		li $v0, 10
+		syscall
+		.data
+msg:	.asciiz "linked\n"
+`,
+		"b.s": `
+		.globl puts
+		.text
+puts:	li $v0, 4
+		syscall
+		jr $ra
+`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run := func(name string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command(filepath.Join(dir, name), args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s %v: %v\n%s", name, args, err, out)
+		}
+		return string(out)
+	}
+	run("mipsas", "a.s")
+	run("mipsas", "b.s")
+	run("mipsld", "-o", "prog", "a.o", "b.o")
+	if out := run("mipsvm", "-syscalls", "spim", "prog"); out != "linked\n" {
+		t.Errorf("mipsvm output = %q, want %q", out, "linked\n")
+	}
+}
@@ -0,0 +1,954 @@
+package mips32
+
+import "debug/elf"
+
+// insnFormat is the operand syntax of a native instruction.
+type insnFormat uint8
+
+const (
+	fmtNone       insnFormat = iota // no operands
+	fmtR3                           // rd, rs, rt
+	fmtShift                        // rd, rt, sa
+	fmtShiftV                       // rd, rt, rs
+	fmtRsRt                         // rs, rt (mult, div, traps)
+	fmtRd                           // rd (mfhi, mflo)
+	fmtRs                           // rs (mthi, mtlo, jr)
+	fmtJALR                         // rd, rs or rs
+	fmtArithI                       // rt, rs, signed immediate
+	fmtLogicI                       // rt, rs, unsigned immediate
+	fmtLUI                          // rt, immediate
+	fmtMem                          // rt, offset(base)
+	fmtCache                        // op, offset(base)
+	fmtBranch2                      // rs, rt, label
+	fmtBranch1                      // rs, label
+	fmtJump                         // label
+	fmtCOP0                         // rt, rd[, sel]
+	fmtTrapI                        // rs, signed immediate
+	fmtRdRs                         // rd, rs (clz, clo)
+	fmtRdRt                         // rd, rt (seb, wsbh, rdpgpr)
+	fmtRt                           // [rt] (di, ei)
+	fmtRDHWR                        // rt, hardware register
+	fmtBitField                     // rt, rs, pos, size
+	fmtAddr                         // offset(base)
+	fmtMovCC                        // rd, rs, cc
+	fmtCopMove                      // rt, coprocessor register
+	fmtCopMem                       // coprocessor register, offset(base)
+	fmtCOP2                         // cofun
+	fmtFPUMove                      // rt, fs
+	fmtFPUMem                       // ft, offset(base)
+	fmtFPUIndexed                   // fd, index(base) or fs, index(base)
+	fmtFP3                          // fd, fs, ft
+	fmtFP2                          // fd, fs
+	fmtFP4                          // fd, fr, fs, ft
+	fmtFPMovR                       // fd, fs, rt
+	fmtFPMovCC                      // fd, fs, cc
+	fmtFPCmp                        // [cc,] fs, ft
+	fmtBranchCC                     // [cc,] label
+	fmtMIPS16                       // decoded MIPS16e, never assembled
+	fmtCompact                      // [rs,] [rt,] label, Release 6, never assembled
+	fmtJIC                          // rt, offset (jic, jialc)
+	fmtPCRel                        // rs, label or immediate (addiupc, auipc)
+)
+
+// insnSpec describes a native instruction: its syntax and its encoding with
+// all operand fields zero.
+type insnSpec struct {
+	format insnFormat
+	word   uint32
+}
+
+func special(funct OpCode) uint32 { return uint32(funct) }
+func iType(op OpCode) uint32      { return uint32(op) << 26 }
+func regimm(rt uint8) uint32      { return uint32(OpCodeREGIMM)<<26 | uint32(rt)<<16 }
+func cop0(rs uint8) uint32        { return uint32(OpCodeCOP0)<<26 | uint32(rs)<<21 }
+func cop0Op(funct uint8) uint32   { return cop0(0x10) | uint32(funct) }
+func special2(funct uint8) uint32 { return uint32(OpCodeSPECIAL2)<<26 | uint32(funct) }
+func special3(funct uint8) uint32 { return uint32(OpCodeSPECIAL3)<<26 | uint32(funct) }
+func cop1(rs uint8) uint32        { return uint32(OpCodeCOP1)<<26 | uint32(rs)<<21 }
+func cop2(rs uint8) uint32        { return uint32(OpCodeCOP2)<<26 | uint32(rs)<<21 }
+func cop1x(funct uint8) uint32    { return uint32(OpCodeCOP1X)<<26 | uint32(funct) }
+
+// FPU operand formats, in the fmt field of COP1 arithmetic
+const (
+	fpuS = 16 // single
+	fpuD = 17 // double
+	fpuW = 20 // word
+	fpuL = 21 // long
+)
+
+// fpuCompares are the conditions of c.cond.fmt, by their encoding.
+var fpuCompares = [16]string{
+	"f", "un", "eq", "ueq", "olt", "ult", "ole", "ule",
+	"sf", "ngle", "seq", "ngl", "lt", "nge", "le", "ngt",
+}
+
+// addFPUInstructions adds the COP1 arithmetic instructions to m, one per
+// operation and operand format.
+func addFPUInstructions(m map[string]insnSpec) {
+	ops := []struct {
+		name    string
+		format  insnFormat
+		funct   uint32
+		formats []uint8
+	}{
+		{"add", fmtFP3, 0, []uint8{fpuS, fpuD}},
+		{"sub", fmtFP3, 1, []uint8{fpuS, fpuD}},
+		{"mul", fmtFP3, 2, []uint8{fpuS, fpuD}},
+		{"div", fmtFP3, 3, []uint8{fpuS, fpuD}},
+		{"sqrt", fmtFP2, 4, []uint8{fpuS, fpuD}},
+		{"abs", fmtFP2, 5, []uint8{fpuS, fpuD}},
+		{"mov", fmtFP2, 6, []uint8{fpuS, fpuD}},
+		{"neg", fmtFP2, 7, []uint8{fpuS, fpuD}},
+		{"round.l", fmtFP2, 8, []uint8{fpuS, fpuD}},
+		{"trunc.l", fmtFP2, 9, []uint8{fpuS, fpuD}},
+		{"ceil.l", fmtFP2, 10, []uint8{fpuS, fpuD}},
+		{"floor.l", fmtFP2, 11, []uint8{fpuS, fpuD}},
+		{"round.w", fmtFP2, 12, []uint8{fpuS, fpuD}},
+		{"trunc.w", fmtFP2, 13, []uint8{fpuS, fpuD}},
+		{"ceil.w", fmtFP2, 14, []uint8{fpuS, fpuD}},
+		{"floor.w", fmtFP2, 15, []uint8{fpuS, fpuD}},
+		{"movf", fmtFPMovCC, 17, []uint8{fpuS, fpuD}},
+		{"movt", fmtFPMovCC, 1<<16 | 17, []uint8{fpuS, fpuD}},
+		{"movz", fmtFPMovR, 18, []uint8{fpuS, fpuD}},
+		{"movn", fmtFPMovR, 19, []uint8{fpuS, fpuD}},
+		{"recip", fmtFP2, 21, []uint8{fpuS, fpuD}},
+		{"rsqrt", fmtFP2, 22, []uint8{fpuS, fpuD}},
+		{"cvt.s", fmtFP2, 32, []uint8{fpuD, fpuW, fpuL}},
+		{"cvt.d", fmtFP2, 33, []uint8{fpuS, fpuW, fpuL}},
+		{"cvt.w", fmtFP2, 36, []uint8{fpuS, fpuD}},
+		{"cvt.l", fmtFP2, 37, []uint8{fpuS, fpuD}},
+	}
+	for cond, name := range fpuCompares {
+		ops = append(ops, struct {
+			name    string
+			format  insnFormat
+			funct   uint32
+			formats []uint8
+		}{"c." + name, fmtFPCmp, 48 + uint32(cond), []uint8{fpuS, fpuD}})
+	}
+	suffix := map[uint8]string{fpuS: ".s", fpuD: ".d", fpuW: ".w", fpuL: ".l"}
+	for _, op := range ops {
+		for _, f := range op.formats {
+			m[op.name+suffix[f]] = insnSpec{op.format, cop1(f) | op.funct}
+		}
+	}
+
+	// COP1X multiply-add: the funct field holds the operation and the format
+	for i, name := range []string{"madd", "msub", "nmadd", "nmsub"} {
+		m[name+".s"] = insnSpec{fmtFP4, cop1x(uint8(4+i)<<3 | 0)}
+		m[name+".d"] = insnSpec{fmtFP4, cop1x(uint8(4+i)<<3 | 1)}
+	}
+}
+
+// native instructions by mnemonic
+var asmInstructions = func() map[string]insnSpec {
+	m := map[string]insnSpec{
+		"add":  {fmtR3, special(OpCodeADD)},
+		"addu": {fmtR3, special(OpCodeADDU)},
+		"and":  {fmtR3, special(OpCodeAND)},
+		"nor":  {fmtR3, special(OpCodeNOR)},
+		"or":   {fmtR3, special(OpCodeOR)},
+		"slt":  {fmtR3, special(OpCodeSLT)},
+		"sltu": {fmtR3, special(OpCodeSLTU)},
+		"sub":  {fmtR3, special(OpCodeSUB)},
+		"subu": {fmtR3, special(OpCodeSUBU)},
+		"xor":  {fmtR3, special(OpCodeXOR)},
+		"movn": {fmtR3, special(OpCodeMOVN)},
+		"movz": {fmtR3, special(OpCodeMOVZ)},
+		"sll":  {fmtShift, special(OpCodeSLL)},
+		"srl":  {fmtShift, special(OpCodeSRL)},
+		"sra":  {fmtShift, special(OpCodeSRA)},
+		"sllv": {fmtShiftV, special(OpCodeSLLV)},
+		"srlv": {fmtShiftV, special(OpCodeSRLV)},
+		"srav": {fmtShiftV, special(OpCodeSRAV)},
+
+		"mult":  {fmtRsRt, special(OpCodeMULT)},
+		"multu": {fmtRsRt, special(OpCodeMULTU)},
+		"div":   {fmtRsRt, special(OpCodeDIV)},
+		"divu":  {fmtRsRt, special(OpCodeDIVU)},
+		"teq":   {fmtRsRt, special(OpCodeTEQ)},
+		"tge":   {fmtRsRt, special(OpCodeTGE)},
+		"tgeu":  {fmtRsRt, special(OpCodeTGEU)},
+		"tlt":   {fmtRsRt, special(OpCodeTLT)},
+		"tltu":  {fmtRsRt, special(OpCodeTLTU)},
+		"tne":   {fmtRsRt, special(OpCodeTNE)},
+		"mfhi":  {fmtRd, special(OpCodeMFHI)},
+		"mflo":  {fmtRd, special(OpCodeMFLO)},
+		"mthi":  {fmtRs, special(OpCodeMTHI)},
+		"mtlo":  {fmtRs, special(OpCodeMTLO)},
+		"jr":    {fmtRs, special(OpCodeJR)},
+		"jalr":  {fmtJALR, special(OpCodeJALR)},
+
+		"syscall": {fmtNone, special(OpCodeSYSCALL)},
+		"break":   {fmtNone, special(OpCodeBREAK)},
+		"sync":    {fmtNone, special(OpCodeSYNC)},
+		"eret":    {fmtNone, cop0Op(COP0Funct_ERET)},
+		"tlbp":    {fmtNone, cop0Op(COP0Funct_TLBP)},
+		"tlbr":    {fmtNone, cop0Op(COP0Funct_TLBR)},
+		"tlbwi":   {fmtNone, cop0Op(COP0Funct_TLBWI)},
+		"tlbwr":   {fmtNone, cop0Op(COP0Funct_TLBWR)},
+		"mfc0":    {fmtCOP0, cop0(COP0Funct_MFC0)},
+		"mtc0":    {fmtCOP0, cop0(COP0Funct_MTC0)},
+
+		"addi":  {fmtArithI, iType(OpCodeADDI)},
+		"addiu": {fmtArithI, iType(OpCodeADDIU)},
+		"slti":  {fmtArithI, iType(OpCodeSLTI)},
+		"sltiu": {fmtArithI, iType(OpCodeSLTIU)},
+		"andi":  {fmtLogicI, iType(OpCodeANDI)},
+		"ori":   {fmtLogicI, iType(OpCodeORI)},
+		"xori":  {fmtLogicI, iType(OpCodeXORI)},
+		"lui":   {fmtLUI, iType(OPCodeLUI)},
+
+		"lb":    {fmtMem, iType(OpCodeLB)},
+		"lbu":   {fmtMem, iType(OpCodeLBU)},
+		"lh":    {fmtMem, iType(OpCodeLH)},
+		"lhu":   {fmtMem, iType(OpCodeLHU)},
+		"lw":    {fmtMem, iType(OpCodeLW)},
+		"lwu":   {fmtMem, iType(OpCodeLWU)},
+		"ll":    {fmtMem, iType(OpCodeLL)},
+		"sb":    {fmtMem, iType(OpCodeSB)},
+		"sh":    {fmtMem, iType(OpCodeSH)},
+		"sw":    {fmtMem, iType(OpCodeSW)},
+		"sc":    {fmtMem, iType(OpCodeSC)},
+		"cache": {fmtCache, iType(OpCodeCACHE)},
+
+		"beq":     {fmtBranch2, iType(OpCodeBEQ)},
+		"bne":     {fmtBranch2, iType(OpCodeBNE)},
+		"beql":    {fmtBranch2, iType(OpCodeBEQL)},
+		"bnel":    {fmtBranch2, iType(OpCodeBNEL)},
+		"blez":    {fmtBranch1, iType(OpCodeBLEZ)},
+		"bgtz":    {fmtBranch1, iType(OpCodeBGTZ)},
+		"blezl":   {fmtBranch1, iType(OpCodeBLEZL)},
+		"bgtzl":   {fmtBranch1, iType(OpCodeBGTZL)},
+		"bltz":    {fmtBranch1, regimm(REGIMM_BLTZ)},
+		"bgez":    {fmtBranch1, regimm(REGIMM_BGEZ)},
+		"bltzl":   {fmtBranch1, regimm(REGIMM_BLTZL)},
+		"bgezl":   {fmtBranch1, regimm(REGIMM_BGEZL)},
+		"bltzal":  {fmtBranch1, regimm(REGIMM_BLTZAL)},
+		"bgezal":  {fmtBranch1, regimm(REGIMM_BGEZAL)},
+		"bltzall": {fmtBranch1, regimm(REGIMM_BLTZALL)},
+		"bgezall": {fmtBranch1, regimm(REGIMM_BGEZALL)},
+		"j":       {fmtJump, iType(OpCodeJ)},
+		"jal":     {fmtJump, iType(OpCodeJAL)},
+		"jalx":    {fmtJump, iType(OpCodeJALX)},
+
+		// Release 2 and the SPECIAL2/SPECIAL3 extensions
+		"rotr":    {fmtShift, special(OpCodeSRL) | 1<<21},
+		"rotrv":   {fmtShiftV, special(OpCodeSRLV) | 1<<6},
+		"jr.hb":   {fmtRs, special(OpCodeJR) | 1<<10},
+		"jalr.hb": {fmtJALR, special(OpCodeJALR) | 1<<10},
+		"movf":    {fmtMovCC, special(0x01)},
+		"movt":    {fmtMovCC, special(0x01) | 1<<16},
+		"madd":    {fmtRsRt, special2(0x00)},
+		"maddu":   {fmtRsRt, special2(0x01)},
+		"mul":     {fmtR3, special2(0x02)},
+		"msub":    {fmtRsRt, special2(0x04)},
+		"msubu":   {fmtRsRt, special2(0x05)},
+		"clz":     {fmtRdRs, special2(0x20)},
+		"clo":     {fmtRdRs, special2(0x21)},
+		"sdbbp":   {fmtNone, special2(0x3F)},
+		"ext":     {fmtBitField, special3(0x00)},
+		"ins":     {fmtBitField, special3(0x04)},
+		"wsbh":    {fmtRdRt, special3(0x20) | 0x02<<6},
+		"seb":     {fmtRdRt, special3(0x20) | 0x10<<6},
+		"seh":     {fmtRdRt, special3(0x20) | 0x18<<6},
+		"rdhwr":   {fmtRDHWR, special3(0x3B)},
+		"tgei":    {fmtTrapI, regimm(REGIMM_TGEI)},
+		"tgeiu":   {fmtTrapI, regimm(REGIMM_TGEIU)},
+		"tlti":    {fmtTrapI, regimm(REGIMM_TLTI)},
+		"tltiu":   {fmtTrapI, regimm(REGIMM_TLTIU)},
+		"teqi":    {fmtTrapI, regimm(REGIMM_TEQI)},
+		"tnei":    {fmtTrapI, regimm(REGIMM_TNEI)},
+		"synci":   {fmtAddr, regimm(REGIMM_SYNCI)},
+		"lwl":     {fmtMem, iType(OpCodeLWL)},
+		"lwr":     {fmtMem, iType(OpCodeLWR)},
+		"swl":     {fmtMem, iType(OpCodeSWL)},
+		"swr":     {fmtMem, iType(OpCodeSWR)},
+		"pref":    {fmtCache, iType(OpCodePREF)},
+
+		// coprocessor 0 control
+		"deret":  {fmtNone, cop0Op(COP0Funct_DERET)},
+		"wait":   {fmtNone, cop0Op(COP0Funct_WAIT)},
+		"di":     {fmtRt, cop0(0x0B) | 12<<11},
+		"ei":     {fmtRt, cop0(0x0B) | 12<<11 | 1<<5},
+		"rdpgpr": {fmtRdRt, cop0(0x0A)},
+		"wrpgpr": {fmtRdRt, cop0(0x0E)},
+
+		// coprocessor 1 moves, loads and branches
+		"mfc1":  {fmtFPUMove, cop1(0x00)},
+		"mfhc1": {fmtFPUMove, cop1(0x03)},
+		"mtc1":  {fmtFPUMove, cop1(0x04)},
+		"mthc1": {fmtFPUMove, cop1(0x07)},
+		"cfc1":  {fmtCopMove, cop1(0x02)},
+		"ctc1":  {fmtCopMove, cop1(0x06)},
+		"lwc1":  {fmtFPUMem, iType(OpCodeLWC1)},
+		"ldc1":  {fmtFPUMem, iType(OpCodeLDC1)},
+		"swc1":  {fmtFPUMem, iType(OpCodeSWC1)},
+		"sdc1":  {fmtFPUMem, iType(OpCodeSDC1)},
+		"lwxc1": {fmtFPUIndexed, cop1x(0x00)},
+		"ldxc1": {fmtFPUIndexed, cop1x(0x01)},
+		"swxc1": {fmtFPUIndexed, cop1x(0x08)},
+		"sdxc1": {fmtFPUIndexed, cop1x(0x09)},
+		"bc1f":  {fmtBranchCC, cop1(0x08)},
+		"bc1t":  {fmtBranchCC, cop1(0x08) | 1<<16},
+		"bc1fl": {fmtBranchCC, cop1(0x08) | 2<<16},
+		"bc1tl": {fmtBranchCC, cop1(0x08) | 3<<16},
+
+		// coprocessor 2, whose operations are implementation defined
+		"mfc2":  {fmtCopMove, cop2(0x00)},
+		"cfc2":  {fmtCopMove, cop2(0x02)},
+		"mfhc2": {fmtCopMove, cop2(0x03)},
+		"mtc2":  {fmtCopMove, cop2(0x04)},
+		"ctc2":  {fmtCopMove, cop2(0x06)},
+		"mthc2": {fmtCopMove, cop2(0x07)},
+		"lwc2":  {fmtCopMem, iType(OpCodeLWC2)},
+		"ldc2":  {fmtCopMem, iType(OpCodeLDC2)},
+		"swc2":  {fmtCopMem, iType(OpCodeSWC2)},
+		"sdc2":  {fmtCopMem, iType(OpCodeSDC2)},
+		"bc2f":  {fmtBranchCC, cop2(0x08)},
+		"bc2t":  {fmtBranchCC, cop2(0x08) | 1<<16},
+		"bc2fl": {fmtBranchCC, cop2(0x08) | 2<<16},
+		"bc2tl": {fmtBranchCC, cop2(0x08) | 3<<16},
+		"cop2":  {fmtCOP2, cop2(0x10)},
+	}
+	addFPUInstructions(m)
+	return m
+}()
+
+// compare-and-branch pseudo-instructions: the set-on-less-than to compute
+// into $at, whether its operands are swapped, and the branch on $at.
+var asmCompareBranches = map[string]struct {
+	slt    OpCode
+	swap   bool
+	branch OpCode
+}{
+	"blt":  {OpCodeSLT, false, OpCodeBNE},  // rs < rt
+	"bgt":  {OpCodeSLT, true, OpCodeBNE},   // rt < rs
+	"ble":  {OpCodeSLT, true, OpCodeBEQ},   // !(rt < rs)
+	"bge":  {OpCodeSLT, false, OpCodeBEQ},  // !(rs < rt)
+	"bltu": {OpCodeSLTU, false, OpCodeBNE}, // unsigned forms
+	"bgtu": {OpCodeSLTU, true, OpCodeBNE},
+	"bleu": {OpCodeSLTU, true, OpCodeBEQ},
+	"bgeu": {OpCodeSLTU, false, OpCodeBEQ},
+}
+
+const regAT = 1 // assembler temporary
+
+// hasDelaySlot reports whether the last instruction s assembles to is a
+// branch or jump.
+func hasDelaySlot(s *asmStmt) bool {
+	switch s.name {
+	case "b", "bal", "beqz", "bnez":
+		return true
+	}
+	if _, ok := asmCompareBranches[s.name]; ok {
+		return true
+	}
+	switch asmInstructions[s.name].format {
+	case fmtBranch1, fmtBranch2, fmtJump, fmtJALR, fmtBranchCC:
+		return true
+	case fmtRs:
+		return s.name == "jr" || s.name == "jr.hb"
+	}
+	return false
+}
+
+// instruction runs pass 1 on an instruction: it works out how many words
+// the instruction assembles to and places it.
+func (a *assembler) instruction(s *asmStmt) *asmPosError {
+	if a.cur != a.text {
+		return posErrorf(s.col, "instruction %s outside .text", s.name)
+	}
+	_, native := asmInstructions[s.name]
+	_, compare := asmCompareBranches[s.name]
+
+	words := uint32(1)
+	switch {
+	case s.name == "li":
+		if err := wantOperands(s, 2); err != nil {
+			return err
+		}
+		// the short forms are only used if the value is already known
+		words = 2
+		if v, err := a.passOneValue(s.ops[1], false); err == nil && liWords(v) == 1 {
+			words = 1
+		}
+	case s.name == "la", compare:
+		words = 2
+	case s.name == "div" || s.name == "divu":
+		if len(s.ops) == 3 {
+			words = 2
+		}
+	case native && asmInstructions[s.name].format == fmtMem:
+		if len(s.ops) == 2 && s.ops[1].kind == operandExpr {
+			words = 2 // lui $at, %hi(label); op rt, %lo(label)($at)
+		}
+	case native:
+	case s.name == "nop", s.name == "move", s.name == "b", s.name == "bal", s.name == "beqz",
+		s.name == "bnez", s.name == "not", s.name == "neg", s.name == "negu":
+	default:
+		return posErrorf(s.col, "unknown instruction %s", s.name)
+	}
+	if s.reorder && hasDelaySlot(s) {
+		words++
+	}
+	a.place(s, 4, 4*words)
+	return nil
+}
+
+// liWords returns how many instructions li needs to load v.
+func liWords(v int64) int {
+	if v >= -0x8000 && v <= 0xFFFF || v&0xFFFF == 0 {
+		return 1
+	}
+	return 2
+}
+
+// encode runs pass 2 on an instruction and returns its machine words.
+func (a *assembler) encode(s *asmStmt) ([]uint32, *asmPosError) {
+	e := &encoder{a: a, s: s, pc: s.section.addr + s.offset}
+	e.expand()
+	if e.err != nil {
+		return nil, e.err
+	}
+	if s.reorder && hasDelaySlot(s) {
+		e.words = append(e.words, 0) // nop
+	}
+	return e.words, nil
+}
+
+// encoder assembles the words of one statement. After the first error
+// further calls do nothing.
+type encoder struct {
+	a     *assembler
+	s     *asmStmt
+	pc    uint32 // address of the next word
+	words []uint32
+	err   *asmPosError
+}
+
+func (e *encoder) fail(err *asmPosError) {
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+func (e *encoder) add(w uint32) {
+	e.words = append(e.words, w)
+	e.pc += 4
+}
+
+// operands checks that the statement has one of the given operand counts.
+func (e *encoder) operands(counts ...int) bool {
+	for _, n := range counts {
+		if len(e.s.ops) == n {
+			return true
+		}
+	}
+	e.fail(posErrorf(e.s.col, "%s: wrong number of operands (%d)", e.s.name, len(e.s.ops)))
+	return false
+}
+
+// reg returns operand i, which must be a register.
+func (e *encoder) reg(i int) uint32 {
+	op := e.s.ops[i]
+	if op.kind != operandReg {
+		e.fail(posErrorf(op.col, "%s: operand %d must be a register", e.s.name, i+1))
+	}
+	return uint32(op.reg)
+}
+
+// fpr returns operand i, which must be a floating-point register.
+func (e *encoder) fpr(i int) uint32 {
+	op := e.s.ops[i]
+	if op.kind != operandFPReg {
+		e.fail(posErrorf(op.col, "%s: operand %d must be a floating-point register", e.s.name, i+1))
+	}
+	return uint32(op.reg)
+}
+
+// fcc returns operand i, which must be a condition code $fccN.
+func (e *encoder) fcc(i int) uint32 {
+	op := e.s.ops[i]
+	if op.kind != operandFCC {
+		e.fail(posErrorf(op.col, "%s: operand %d must be a condition code", e.s.name, i+1))
+	}
+	return uint32(op.reg)
+}
+
+// copReg returns operand i, which must be a numbered register $N of a
+// coprocessor.
+func (e *encoder) copReg(i int) uint32 {
+	op := e.s.ops[i]
+	if op.kind != operandReg {
+		e.fail(posErrorf(op.col, "%s: operand %d must be a coprocessor register", e.s.name, i+1))
+	}
+	return uint32(op.reg)
+}
+
+// reloc returns the value of operand i, which must be an expression and
+// may refer to an address only known at link time.
+func (e *encoder) reloc(i int) relocValue {
+	op := e.s.ops[i]
+	if op.kind != operandExpr {
+		e.fail(posErrorf(op.col, "%s: operand %d must be an expression", e.s.name, i+1))
+		return relocValue{}
+	}
+	rv, err := e.a.evalReloc(op.expr)
+	if err != nil {
+		e.fail(err)
+	}
+	return rv
+}
+
+// value returns the value of operand i, which must be a constant expression.
+func (e *encoder) value(i int) int64 {
+	rv := e.reloc(i)
+	if rv.sym != "" {
+		e.fail(posErrorf(e.s.ops[i].col, "%s: operand %d must be a constant", e.s.name, i+1))
+	}
+	return rv.addend
+}
+
+// relocate records that the next word needs a relocation of typ against
+// sym, unless sym is empty.
+func (e *encoder) relocate(typ elf.R_MIPS, sym string) {
+	if sym != "" {
+		e.a.addReloc(e.s.section, e.pc-e.s.section.addr, typ, sym)
+	}
+}
+
+// hiLo handles %hi(x) and %lo(x) where x is an address only known at link
+// time: it records the relocation and returns the field holding the addend.
+func (e *encoder) hiLo(x expr) (field uint32, ok bool) {
+	u, isUnary := x.(unaryExpr)
+	if !isUnary || u.op != "%hi" && u.op != "%lo" {
+		return 0, false
+	}
+	rv, err := e.a.evalReloc(u.x)
+	if err != nil || rv.sym == "" {
+		return 0, false
+	}
+	if u.op == "%hi" {
+		e.relocate(elf.R_MIPS_HI16, rv.sym)
+		return hi16(rv.addend), true
+	}
+	e.relocate(elf.R_MIPS_LO16, rv.sym)
+	return uint32(rv.addend) & 0xFFFF, true
+}
+
+// hi16 is %hi(v): the upper half of v, adjusted for a sign-extended lower half.
+func hi16(v int64) uint32 {
+	return uint32((v+0x8000)>>16) & 0xFFFF
+}
+
+// imm returns operand i as a 16-bit field, checking it lies in [lo, hi].
+func (e *encoder) imm(i int, lo, hi int64) uint32 {
+	if field, ok := e.hiLo(e.s.ops[i].expr); ok {
+		return field
+	}
+	v := e.value(i)
+	if v < lo || v > hi {
+		e.fail(posErrorf(e.s.ops[i].col, "%s: immediate %d out of range [%d, %d]", e.s.name, v, lo, hi))
+	}
+	return uint32(v) & 0xFFFF
+}
+
+func (e *encoder) simm(i int) uint32 { return e.imm(i, -0x8000, 0x7FFF) }
+func (e *encoder) uimm(i int) uint32 { return e.imm(i, 0, 0xFFFF) }
+
+// mem returns the offset and base register of operand i, which must be offset(base).
+func (e *encoder) mem(i int) (offset, base uint32) {
+	op := e.s.ops[i]
+	if op.kind != operandMem {
+		e.fail(posErrorf(op.col, "%s: operand %d must be offset(base)", e.s.name, i+1))
+		return 0, 0
+	}
+	if op.expr == nil {
+		return 0, uint32(op.reg)
+	}
+	if field, ok := e.hiLo(op.expr); ok {
+		return field, uint32(op.reg)
+	}
+	rv, err := e.a.evalReloc(op.expr)
+	if err != nil {
+		e.fail(err)
+	}
+	if rv.sym != "" {
+		e.fail(posErrorf(op.col, "%s: offset must be a constant or %%lo()", e.s.name))
+	}
+	v := rv.addend
+	if v < -0x8000 || v > 0x7FFF {
+		e.fail(posErrorf(op.col, "%s: offset %d out of range", e.s.name, v))
+	}
+	return uint32(v) & 0xFFFF, uint32(op.reg)
+}
+
+// branch returns the offset field of a branch at e.pc to the label in operand i.
+func (e *encoder) branch(i int) uint32 {
+	rv := e.reloc(i)
+	switch {
+	case rv.sym != "" && rv.sym != e.s.section.name:
+		// another section or an undefined symbol: resolved by the linker
+		e.relocate(elf.R_MIPS_PC16, rv.sym)
+		return uint32((rv.addend-4)>>2) & 0xFFFF
+	case rv.sym == "" && e.a.object:
+		e.fail(posErrorf(e.s.ops[i].col, "%s: branch target must be a label", e.s.name))
+	}
+	target := rv.addend
+	delta := target - int64(e.pc) - 4
+	if delta&3 != 0 || delta < -0x20000 || delta > 0x1FFFC {
+		e.fail(posErrorf(e.s.ops[i].col, "%s: branch target 0x%x out of range", e.s.name, target))
+	}
+	return uint32(delta>>2) & 0xFFFF
+}
+
+// loadAddress emits lui base, %hi(rv) followed by the I-type op
+// rt, %lo(rv)(base), relocating both if rv is only known at link time.
+func (e *encoder) loadAddress(op, base, rt uint32, rv relocValue) {
+	e.relocate(elf.R_MIPS_HI16, rv.sym)
+	e.add(iWord(iType(OPCodeLUI), 0, base, hi16(rv.addend)))
+	e.relocate(elf.R_MIPS_LO16, rv.sym)
+	e.add(iWord(op, base, rt, uint32(rv.addend)))
+}
+
+// rType builds an R-type word.
+func rType(base, rs, rt, rd, sa uint32) uint32 {
+	return base | rs<<21 | rt<<16 | rd<<11 | sa<<6
+}
+
+// iWord builds an I-type word.
+func iWord(base, rs, rt, imm uint32) uint32 {
+	return base | rs<<21 | rt<<16 | imm&0xFFFF
+}
+
+// expand assembles the statement, expanding pseudo-instructions.
+func (e *encoder) expand() {
+	s := e.s
+	if spec, ok := asmInstructions[s.name]; ok && !(spec.format == fmtRsRt && len(s.ops) == 3) {
+		e.native(spec)
+		return
+	}
+	if c, ok := asmCompareBranches[s.name]; ok {
+		if !e.operands(3) {
+			return
+		}
+		rs, rt := e.reg(0), e.reg(1)
+		if c.swap {
+			rs, rt = rt, rs
+		}
+		e.add(rType(special(c.slt), rs, rt, regAT, 0))
+		e.add(iWord(iType(c.branch), regAT, 0, e.branch(2)))
+		return
+	}
+
+	switch s.name {
+	case "nop":
+		if e.operands(0) {
+			e.add(0)
+		}
+	case "move":
+		if e.operands(2) {
+			e.add(rType(special(OpCodeADDU), e.reg(1), 0, e.reg(0), 0))
+		}
+	case "not":
+		if e.operands(2) {
+			e.add(rType(special(OpCodeNOR), e.reg(1), 0, e.reg(0), 0))
+		}
+	case "neg", "negu":
+		if e.operands(2) {
+			op := OpCodeSUB
+			if s.name == "negu" {
+				op = OpCodeSUBU
+			}
+			e.add(rType(special(op), 0, e.reg(1), e.reg(0), 0))
+		}
+	case "div", "divu":
+		// div rd, rs, rt: divide and move the quotient to rd
+		op := OpCodeDIV
+		if s.name == "divu" {
+			op = OpCodeDIVU
+		}
+		e.add(rType(special(op), e.reg(1), e.reg(2), 0, 0))
+		e.add(rType(special(OpCodeMFLO), 0, 0, e.reg(0), 0))
+
+	case "li":
+		rt := e.reg(0)
+		rv := e.reloc(1)
+		v := rv.addend
+		if v < -0x80000000 || v > 0xFFFFFFFF {
+			e.fail(posErrorf(s.ops[1].col, "li: value %d does not fit in 32 bits", v))
+		}
+		switch {
+		case rv.sym != "": // an address, as la
+			e.loadAddress(iType(OpCodeADDIU), rt, rt, rv)
+		case s.size == 8: // sized before v was known
+			e.add(iWord(iType(OPCodeLUI), 0, rt, uint32(v>>16)))
+			e.add(iWord(iType(OpCodeORI), rt, rt, uint32(v)))
+		case v >= -0x8000 && v <= 0x7FFF:
+			e.add(iWord(iType(OpCodeADDIU), 0, rt, uint32(v)))
+		case v >= 0 && v <= 0xFFFF:
+			e.add(iWord(iType(OpCodeORI), 0, rt, uint32(v)))
+		default:
+			e.add(iWord(iType(OPCodeLUI), 0, rt, uint32(v>>16)))
+		}
+	case "la":
+		if e.operands(2) {
+			rt := e.reg(0)
+			e.loadAddress(iType(OpCodeADDIU), rt, rt, e.reloc(1))
+		}
+
+	case "b":
+		if e.operands(1) {
+			e.add(iWord(iType(OpCodeBEQ), 0, 0, e.branch(0)))
+		}
+	case "bal":
+		if e.operands(1) {
+			e.add(iWord(regimm(REGIMM_BGEZAL), 0, 0, e.branch(0)))
+		}
+	case "beqz", "bnez":
+		if e.operands(2) {
+			op := OpCodeBEQ
+			if s.name == "bnez" {
+				op = OpCodeBNE
+			}
+			e.add(iWord(iType(op), e.reg(0), 0, e.branch(1)))
+		}
+	}
+}
+
+// native assembles a native instruction.
+func (e *encoder) native(spec insnSpec) {
+	s := e.s
+	w := spec.word
+	switch spec.format {
+	case fmtNone:
+		if e.operands(0) {
+			e.add(w)
+		}
+	case fmtR3:
+		if e.operands(3) {
+			e.add(rType(w, e.reg(1), e.reg(2), e.reg(0), 0))
+		}
+	case fmtShift:
+		if e.operands(3) {
+			e.add(rType(w, 0, e.reg(1), e.reg(0), e.imm(2, 0, 31)))
+		}
+	case fmtShiftV:
+		if e.operands(3) {
+			e.add(rType(w, e.reg(2), e.reg(1), e.reg(0), 0))
+		}
+	case fmtRsRt:
+		if e.operands(2) {
+			e.add(rType(w, e.reg(0), e.reg(1), 0, 0))
+		}
+	case fmtRd:
+		if e.operands(1) {
+			e.add(rType(w, 0, 0, e.reg(0), 0))
+		}
+	case fmtRs:
+		if e.operands(1) {
+			e.add(rType(w, e.reg(0), 0, 0, 0))
+		}
+	case fmtJALR:
+		if e.operands(1, 2) {
+			if len(s.ops) == 1 {
+				e.add(rType(w, e.reg(0), 0, 31, 0))
+			} else {
+				e.add(rType(w, e.reg(1), 0, e.reg(0), 0))
+			}
+		}
+	case fmtCOP0:
+		if e.operands(2, 3) {
+			sel := uint32(0)
+			if len(s.ops) == 3 {
+				sel = e.imm(2, 0, 7)
+			}
+			e.add(rType(w, 0, e.reg(0), e.reg(1), 0) | sel)
+		}
+
+	case fmtRdRs:
+		if e.operands(2) {
+			rd := e.reg(0)
+			e.add(rType(w, e.reg(1), rd, rd, 0)) // rt must equal rd
+		}
+	case fmtRdRt:
+		if e.operands(2) {
+			e.add(rType(w, 0, e.reg(1), e.reg(0), 0))
+		}
+	case fmtRt:
+		if e.operands(0, 1) {
+			rt := uint32(0)
+			if len(s.ops) == 1 {
+				rt = e.reg(0)
+			}
+			e.add(rType(w, 0, rt, 0, 0))
+		}
+	case fmtRDHWR:
+		if e.operands(2) {
+			e.add(rType(w, 0, e.reg(0), e.copReg(1), 0))
+		}
+	case fmtBitField:
+		if !e.operands(4) {
+			return
+		}
+		pos := int64(e.imm(2, 0, 31))
+		size := e.value(3)
+		if size < 1 || pos+size > 32 {
+			e.fail(posErrorf(s.ops[3].col, "%s: size %d out of range", s.name, size))
+		}
+		msb := uint32(pos+size-1) & 31 // ins
+		if s.name == "ext" {
+			msb = uint32(size-1) & 31
+		}
+		e.add(rType(w, e.reg(1), e.reg(0), msb, uint32(pos)))
+	case fmtMovCC:
+		if e.operands(3) {
+			e.add(rType(w, e.reg(1), e.fcc(2)<<2, e.reg(0), 0))
+		}
+	case fmtCopMove:
+		if e.operands(2) {
+			e.add(rType(w, 0, e.reg(0), e.copReg(1), 0))
+		}
+	case fmtCOP2:
+		if e.operands(1) {
+			v := e.value(0)
+			if v < 0 || v > 0x1FFFFFF {
+				e.fail(posErrorf(s.ops[0].col, "%s: operation 0x%x out of range", s.name, v))
+			}
+			e.add(w | uint32(v)&0x1FFFFFF)
+		}
+	case fmtFPUMove:
+		if e.operands(2) {
+			e.add(rType(w, 0, e.reg(0), e.fpr(1), 0))
+		}
+	case fmtFP3:
+		if e.operands(3) {
+			e.add(rType(w, 0, e.fpr(2), e.fpr(1), e.fpr(0)))
+		}
+	case fmtFP2:
+		if e.operands(2) {
+			e.add(rType(w, 0, 0, e.fpr(1), e.fpr(0)))
+		}
+	case fmtFP4:
+		if e.operands(4) {
+			e.add(rType(w, e.fpr(1), e.fpr(3), e.fpr(2), e.fpr(0)))
+		}
+	case fmtFPMovR:
+		if e.operands(3) {
+			e.add(rType(w, 0, e.reg(2), e.fpr(1), e.fpr(0)))
+		}
+	case fmtFPMovCC:
+		if e.operands(3) {
+			e.add(rType(w, 0, e.fcc(2)<<2, e.fpr(1), e.fpr(0)))
+		}
+	case fmtFPCmp:
+		if e.operands(2, 3) {
+			cc, first := uint32(0), 0
+			if len(s.ops) == 3 {
+				cc, first = e.fcc(0), 1
+			}
+			e.add(rType(w, 0, e.fpr(first+1), e.fpr(first), cc<<2))
+		}
+	case fmtFPUIndexed:
+		if !e.operands(2) {
+			return
+		}
+		op := s.ops[1]
+		if op.kind != operandIndexed {
+			e.fail(posErrorf(op.col, "%s: operand 2 must be index(base)", s.name))
+		}
+		if w&8 != 0 { // stores read fs
+			e.add(rType(w, uint32(op.reg), uint32(op.index), e.fpr(0), 0))
+		} else {
+			e.add(rType(w, uint32(op.reg), uint32(op.index), 0, e.fpr(0)))
+		}
+
+	case fmtArithI:
+		if e.operands(3) {
+			e.add(iWord(w, e.reg(1), e.reg(0), e.simm(2)))
+		}
+	case fmtTrapI:
+		if e.operands(2) {
+			e.add(iWord(w, e.reg(0), 0, e.simm(1)))
+		}
+	case fmtLogicI:
+		if e.operands(3) {
+			e.add(iWord(w, e.reg(1), e.reg(0), e.uimm(2)))
+		}
+	case fmtLUI:
+		if e.operands(2) {
+			e.add(iWord(w, 0, e.reg(0), e.imm(1, -0x8000, 0xFFFF)))
+		}
+	case fmtMem:
+		if !e.operands(2) {
+			return
+		}
+		rt := e.reg(0)
+		if s.ops[1].kind == operandExpr {
+			// op rt, label
+			e.loadAddress(w, regAT, rt, e.reloc(1))
+			return
+		}
+		offset, base := e.mem(1)
+		e.add(iWord(w, base, rt, offset))
+	case fmtCache:
+		if e.operands(2) {
+			op := e.imm(0, 0, 31)
+			offset, base := e.mem(1)
+			e.add(iWord(w, base, op, offset))
+		}
+	case fmtAddr:
+		if e.operands(1) {
+			offset, base := e.mem(0)
+			e.add(iWord(w, base, 0, offset))
+		}
+	case fmtFPUMem:
+		if e.operands(2) {
+			ft := e.fpr(0)
+			offset, base := e.mem(1)
+			e.add(iWord(w, base, ft, offset))
+		}
+	case fmtCopMem:
+		if e.operands(2) {
+			rt := e.copReg(0)
+			offset, base := e.mem(1)
+			e.add(iWord(w, base, rt, offset))
+		}
+
+	case fmtBranch2:
+		if e.operands(3) {
+			e.add(iWord(w, e.reg(0), e.reg(1), e.branch(2)))
+		}
+	case fmtBranch1:
+		if e.operands(2) {
+			e.add(iWord(w, e.reg(0), 0, e.branch(1)))
+		}
+	case fmtBranchCC:
+		if e.operands(1, 2) {
+			if len(s.ops) == 1 {
+				e.add(iWord(w, 0, 0, e.branch(0)))
+			} else {
+				e.add(iWord(w, 0, e.fcc(0)<<2, e.branch(1)))
+			}
+		}
+	case fmtJump:
+		if !e.operands(1) {
+			return
+		}
+		rv := e.reloc(0)
+		target := rv.addend
+		if rv.sym != "" {
+			if target&3 != 0 {
+				e.fail(posErrorf(s.ops[0].col, "%s: misaligned jump target", s.name))
+			}
+			e.relocate(elf.R_MIPS_26, rv.sym)
+			e.add(w | uint32(target)>>2&0x3FFFFFF)
+			return
+		}
+		if target&3 != 0 || target < 0 || target > 0xFFFFFFFF || uint32(target)&0xF0000000 != (e.pc+4)&0xF0000000 {
+			e.fail(posErrorf(s.ops[0].col, "%s: jump target 0x%x not reachable", s.name, target))
+		}
+		e.add(w | uint32(target)>>2&0x3FFFFFF)
+	}
+}
@@ -0,0 +1,451 @@
+package mips32
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// abiRegNames are the o32 ABI names of the general-purpose registers.
+var abiRegNames = [32]string{
+	"zero", "at", "v0", "v1", "a0", "a1", "a2", "a3",
+	"t0", "t1", "t2", "t3", "t4", "t5", "t6", "t7",
+	"s0", "s1", "s2", "s3", "s4", "s5", "s6", "s7",
+	"t8", "t9", "k0", "k1", "gp", "sp", "fp", "ra",
+}
+
+// parseRegister parses a register name without the leading '$': a number
+// 0-31, an ABI name or s8, the alternative name of fp.
+func parseRegister(name string) (uint8, bool) {
+	if n, err := strconv.Atoi(name); err == nil {
+		return uint8(n), n >= 0 && n < 32
+	}
+	if name == "s8" {
+		return 30, true
+	}
+	for i, r := range abiRegNames {
+		if r == name {
+			return uint8(i), true
+		}
+	}
+	return 0, false
+}
+
+// parseFPURegister parses a floating-point register fN or a condition code
+// fccN, without the leading '$'.
+func parseFPURegister(name string) (r uint8, cc bool, ok bool) {
+	digits, cc := strings.CutPrefix(name, "fcc")
+	if !cc {
+		if digits, ok = strings.CutPrefix(name, "f"); !ok {
+			return 0, false, false
+		}
+	}
+	n, err := strconv.Atoi(digits)
+	if err != nil || n < 0 || cc && n > 7 || n > 31 {
+		return 0, false, false
+	}
+	return uint8(n), cc, true
+}
+
+// token kinds
+const (
+	tokEOF = iota
+	tokIdent
+	tokReg
+	tokNum
+	tokString
+	tokPunct
+)
+
+// token is a lexical element of a source line. col is 1-based.
+type token struct {
+	kind int
+	text string // identifier, register name, punctuation or unquoted string
+	num  int64
+	col  int
+}
+
+// lexLine splits a source line into tokens, dropping the comment.
+func lexLine(line string) ([]token, *asmPosError) {
+	var toks []token
+	for i := 0; i < len(line); {
+		c := line[i]
+		col := i + 1
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			return toks, nil
+		case isIdentStart(c) || c == '$':
+			j := i + 1
+			for j < len(line) && isIdentChar(line[j]) {
+				j++
+			}
+			if c == '$' {
+				toks = append(toks, token{kind: tokReg, text: line[i+1 : j], col: col})
+			} else {
+				toks = append(toks, token{kind: tokIdent, text: line[i:j], col: col})
+			}
+			i = j
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(line) && isIdentChar(line[j]) {
+				j++
+			}
+			n, err := strconv.ParseUint(line[i:j], 0, 64)
+			if err != nil {
+				return nil, posErrorf(col, "invalid number %q", line[i:j])
+			}
+			toks = append(toks, token{kind: tokNum, num: int64(n), text: line[i:j], col: col})
+			i = j
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(line) && line[j] != c {
+				if line[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(line) {
+				return nil, posErrorf(col, "unterminated literal")
+			}
+			s, err := strconv.Unquote(`"` + line[i+1:j] + `"`)
+			if err != nil {
+				return nil, posErrorf(col, "invalid literal %s", line[i:j+1])
+			}
+			if c == '"' {
+				toks = append(toks, token{kind: tokString, text: s, col: col})
+			} else {
+				if len(s) != 1 {
+					return nil, posErrorf(col, "invalid character literal %s", line[i:j+1])
+				}
+				toks = append(toks, token{kind: tokNum, num: int64(s[0]), text: line[i : j+1], col: col})
+			}
+			i = j + 1
+		case c == '<' || c == '>':
+			if i+1 >= len(line) || line[i+1] != c {
+				return nil, posErrorf(col, "unexpected %q", c)
+			}
+			toks = append(toks, token{kind: tokPunct, text: line[i : i+2], col: col})
+			i += 2
+		case strings.IndexByte(",:()+-*/%&|^~", c) >= 0:
+			toks = append(toks, token{kind: tokPunct, text: line[i : i+1], col: col})
+			i++
+		default:
+			return nil, posErrorf(col, "unexpected %q", c)
+		}
+	}
+	return toks, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
+
+// asmPosError is an error at a column of the line being assembled.
+type asmPosError struct {
+	col int
+	msg string
+}
+
+func posErrorf(col int, format string, args ...any) *asmPosError {
+	return &asmPosError{col: col, msg: fmt.Sprintf(format, args...)}
+}
+
+// expr is an expression in an operand or directive.
+type expr interface {
+	// eval returns the value of the expression, looking symbols up with sym.
+	eval(sym func(name string, col int) (int64, *asmPosError)) (int64, *asmPosError)
+}
+
+type numExpr int64
+
+type symExpr struct {
+	name string
+	col  int
+}
+
+type unaryExpr struct {
+	op  string // "-", "~", "+", "%hi" or "%lo"
+	x   expr
+	col int
+}
+
+type binaryExpr struct {
+	op   string
+	x, y expr
+	col  int
+}
+
+func (e numExpr) eval(func(string, int) (int64, *asmPosError)) (int64, *asmPosError) {
+	return int64(e), nil
+}
+
+func (e symExpr) eval(sym func(string, int) (int64, *asmPosError)) (int64, *asmPosError) {
+	return sym(e.name, e.col)
+}
+
+func (e unaryExpr) eval(sym func(string, int) (int64, *asmPosError)) (int64, *asmPosError) {
+	x, err := e.x.eval(sym)
+	if err != nil {
+		return 0, err
+	}
+	switch e.op {
+	case "-":
+		return -x, nil
+	case "~":
+		return ^x, nil
+	case "%hi":
+		// paired with a sign-extended %lo, as in lui/addiu
+		return (x + 0x8000) >> 16 & 0xFFFF, nil
+	case "%lo":
+		return int64(int16(x)), nil
+	}
+	return x, nil
+}
+
+func (e binaryExpr) eval(sym func(string, int) (int64, *asmPosError)) (int64, *asmPosError) {
+	x, err := e.x.eval(sym)
+	if err != nil {
+		return 0, err
+	}
+	y, err := e.y.eval(sym)
+	if err != nil {
+		return 0, err
+	}
+	switch e.op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/", "%":
+		if y == 0 {
+			return 0, posErrorf(e.col, "division by zero")
+		}
+		if e.op == "/" {
+			return x / y, nil
+		}
+		return x % y, nil
+	case "<<":
+		return x << (y & 63), nil
+	case ">>":
+		return x >> (y & 63), nil
+	case "&":
+		return x & y, nil
+	case "|":
+		return x | y, nil
+	case "^":
+		return x ^ y, nil
+	}
+	return 0, posErrorf(e.col, "unknown operator %q", e.op)
+}
+
+// binary operator precedences, as in Go
+var binaryPrec = map[string]int{
+	"*": 5, "/": 5, "%": 5, "<<": 5, ">>": 5, "&": 5,
+	"+": 4, "-": 4, "|": 4, "^": 4,
+}
+
+// operand kinds
+const (
+	operandReg  = iota // $reg
+	operandExpr        // expression
+	operandMem         // expr($reg) or ($reg)
+	operandString
+	operandFPReg   // $fN
+	operandFCC     // $fccN
+	operandIndexed // $index($reg)
+)
+
+// operand is a parsed instruction or directive argument.
+type operand struct {
+	kind  int
+	reg   uint8
+	index uint8 // index register of operandIndexed
+	expr  expr  // nil for ($reg)
+	str   string
+	col   int
+}
+
+// lineParser parses the tokens of one line.
+type lineParser struct {
+	toks []token
+	pos  int
+	end  int // column just past the line, for errors at its end
+}
+
+func (p *lineParser) peek() token {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return token{kind: tokEOF, col: p.end}
+}
+
+func (p *lineParser) next() token {
+	t := p.peek()
+	if p.pos < len(p.toks) {
+		p.pos++
+	}
+	return t
+}
+
+func (p *lineParser) isPunct(s string) bool {
+	t := p.peek()
+	return t.kind == tokPunct && t.text == s
+}
+
+// operands parses the comma-separated operands up to the end of the line.
+func (p *lineParser) operands() ([]operand, *asmPosError) {
+	var ops []operand
+	if p.peek().kind == tokEOF {
+		return nil, nil
+	}
+	for {
+		op, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+		if p.peek().kind == tokEOF {
+			return ops, nil
+		}
+		if !p.isPunct(",") {
+			return nil, posErrorf(p.peek().col, "expected , or end of line")
+		}
+		p.next()
+	}
+}
+
+func (p *lineParser) operand() (operand, *asmPosError) {
+	t := p.peek()
+	switch {
+	case t.kind == tokReg:
+		p.next()
+		r, ok := parseRegister(t.text)
+		if !ok {
+			fr, cc, ok := parseFPURegister(t.text)
+			switch {
+			case !ok:
+				return operand{}, posErrorf(t.col, "unknown register $%s", t.text)
+			case cc:
+				return operand{kind: operandFCC, reg: fr, col: t.col}, nil
+			}
+			return operand{kind: operandFPReg, reg: fr, col: t.col}, nil
+		}
+		if p.isPunct("(") {
+			base, err := p.baseRegister()
+			return operand{kind: operandIndexed, reg: base, index: r, col: t.col}, err
+		}
+		return operand{kind: operandReg, reg: r, col: t.col}, nil
+	case t.kind == tokString:
+		p.next()
+		return operand{kind: operandString, str: t.text, col: t.col}, nil
+	case t.kind == tokPunct && t.text == "(" && p.pos+1 < len(p.toks) && p.toks[p.pos+1].kind == tokReg:
+		r, err := p.baseRegister()
+		return operand{kind: operandMem, reg: r, col: t.col}, err
+	}
+
+	e, err := p.expr(0)
+	if err != nil {
+		return operand{}, err
+	}
+	if p.isPunct("(") {
+		r, err := p.baseRegister()
+		return operand{kind: operandMem, reg: r, expr: e, col: t.col}, err
+	}
+	return operand{kind: operandExpr, expr: e, col: t.col}, nil
+}
+
+// baseRegister parses ($reg).
+func (p *lineParser) baseRegister() (uint8, *asmPosError) {
+	p.next() // (
+	t := p.next()
+	if t.kind != tokReg {
+		return 0, posErrorf(t.col, "expected base register")
+	}
+	r, ok := parseRegister(t.text)
+	if !ok {
+		return 0, posErrorf(t.col, "unknown register $%s", t.text)
+	}
+	if !p.isPunct(")") {
+		return 0, posErrorf(p.peek().col, "expected )")
+	}
+	p.next()
+	return r, nil
+}
+
+// expr parses a binary expression whose operators bind tighter than prec.
+func (p *lineParser) expr(prec int) (expr, *asmPosError) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		opPrec, ok := binaryPrec[t.text]
+		if t.kind != tokPunct || !ok || opPrec <= prec {
+			return x, nil
+		}
+		p.next()
+		y, err := p.expr(opPrec)
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpr{op: t.text, x: x, y: y, col: t.col}
+	}
+}
+
+func (p *lineParser) unary() (expr, *asmPosError) {
+	t := p.next()
+	switch t.kind {
+	case tokNum:
+		return numExpr(t.num), nil
+	case tokIdent:
+		return symExpr{name: t.text, col: t.col}, nil
+	case tokPunct:
+		switch t.text {
+		case "-", "+", "~":
+			x, err := p.unary()
+			return unaryExpr{op: t.text, x: x, col: t.col}, err
+		case "%":
+			// %hi(expr) and %lo(expr)
+			name := p.next()
+			if name.kind != tokIdent || name.text != "hi" && name.text != "lo" || !p.isPunct("(") {
+				return nil, posErrorf(t.col, "expected %%hi( or %%lo(")
+			}
+			x, err := p.unary()
+			return unaryExpr{op: "%" + name.text, x: x, col: t.col}, err
+		case "(":
+			x, err := p.expr(0)
+			if err != nil {
+				return nil, err
+			}
+			if !p.isPunct(")") {
+				return nil, posErrorf(p.peek().col, "expected )")
+			}
+			p.next()
+			return x, nil
+		}
+	case tokEOF:
+		return nil, posErrorf(t.col, "missing operand")
+	}
+	return nil, posErrorf(t.col, "unexpected %s", t.describe())
+}
+
+func (t token) describe() string {
+	switch t.kind {
+	case tokReg:
+		return "register $" + t.text
+	case tokString:
+		return "string"
+	case tokEOF:
+		return "end of line"
+	}
+	return strconv.Quote(t.text)
+}
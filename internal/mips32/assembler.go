@@ -1 +1,512 @@
 package mips32
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Layout gives the addresses the sections produced by Assemble are placed at.
+type Layout struct {
+	TextAddr uint32
+	DataAddr uint32
+}
+
+// MARSLayout places .text and .data at the default addresses of the MARS and
+// SPIM simulators.
+var MARSLayout = Layout{TextAddr: 0x00400000, DataAddr: 0x10010000}
+
+// Section is assembled code or data to be loaded at Addr.
+type Section struct {
+	Name string
+	Addr uint32
+	Data []byte
+}
+
+// Image is an assembled program.
+type Image struct {
+	Text, Data Section
+	Symbols    map[string]uint32 // labels and constants
+
+	labels map[string]bool // which symbols are labels
+	entry  string          // entry symbol chosen by Link, if any
+}
+
+// Load copies the sections of img into mem.
+func (img *Image) Load(mem *Memory) error {
+	for _, s := range []Section{img.Text, img.Data} {
+		if !mem.StoreBytes(s.Addr, s.Data) {
+			return fmt.Errorf("%s 0x%08x-0x%08x does not fit in %d bytes of memory",
+				s.Name, s.Addr, uint64(s.Addr)+uint64(len(s.Data)), len(mem.Data))
+		}
+	}
+	return nil
+}
+
+// Entry returns the address execution starts at: the entry symbol given to
+// Link, else the __start or main label, or the start of .text if there is
+// neither.
+func (img *Image) Entry() uint32 {
+	if img.entry != "" {
+		return img.Symbols[img.entry]
+	}
+	for _, name := range []string{"__start", "main"} {
+		if addr, ok := img.Symbols[name]; ok {
+			return addr
+		}
+	}
+	return img.Text.Addr
+}
+
+// End returns the address just past the data section, where the heap can start.
+func (img *Image) End() uint32 {
+	return img.Data.Addr + uint32(len(img.Data.Data))
+}
+
+// TextSymbols returns the labels in .text as function symbols, each
+// extending to the next label.
+func (img *Image) TextSymbols() *SymbolTable {
+	end := img.Text.Addr + uint32(len(img.Text.Data))
+	var syms []Symbol
+	for name, addr := range img.Symbols {
+		if addr >= img.Text.Addr && addr < end && img.labels[name] {
+			syms = append(syms, Symbol{Name: name, Addr: addr})
+		}
+	}
+	sort.Slice(syms, func(i, j int) bool { return syms[i].Addr < syms[j].Addr })
+	for i := range syms {
+		next := end
+		if i+1 < len(syms) {
+			next = syms[i+1].Addr
+		}
+		syms[i].Size = next - syms[i].Addr
+	}
+	return NewSymbolTable(syms)
+}
+
+// AsmError is an error at a position in assembly source.
+type AsmError struct {
+	File      string
+	Line, Col int    // 1-based
+	Msg       string // what went wrong
+	Source    string // the offending source line
+}
+
+func (e *AsmError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Col, e.Msg)
+}
+
+// AsmErrors lists every error found while assembling a file.
+type AsmErrors []*AsmError
+
+func (l AsmErrors) Error() string {
+	msgs := make([]string, len(l))
+	for i, e := range l {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Assemble assembles the MIPS32 source src, read from file, into an image
+// whose .text and .data sections are placed as given by layout.
+//
+// Besides the native instructions the assembler accepts the directives
+// .text, .data, .word, .half, .byte, .ascii, .asciiz, .space, .align,
+// .globl, .equ and .set, and the pseudo-instructions nop, move, li, la, b,
+// bal, beqz, bnez, blt, bgt, ble, bge and their unsigned forms, not, neg,
+// negu, three-operand div/divu and loads and stores from a label. As with
+// the GNU assembler, a nop is placed in the delay slot of every branch and
+// jump unless .set noreorder is in effect.
+func Assemble(file string, src []byte, layout Layout) (*Image, error) {
+	a := newAssembler(file, src, layout)
+	if err := a.run(); err != nil {
+		return nil, err
+	}
+
+	img := &Image{
+		Text:    Section{Name: ".text", Addr: a.text.addr, Data: a.text.data},
+		Data:    Section{Name: ".data", Addr: a.data.addr, Data: a.data.data},
+		Symbols: make(map[string]uint32, len(a.symbols)),
+		labels:  make(map[string]bool),
+	}
+	for name, sym := range a.symbols {
+		img.Symbols[name] = uint32(a.symbolValue(sym))
+		img.labels[name] = sym.label
+	}
+	return img, nil
+}
+
+func newAssembler(file string, src []byte, layout Layout) *assembler {
+	a := &assembler{
+		file:    file,
+		lines:   strings.Split(string(src), "\n"),
+		text:    &asmSection{name: ".text", addr: layout.TextAddr, align: 4},
+		data:    &asmSection{name: ".data", addr: layout.DataAddr, align: 4},
+		symbols: make(map[string]*asmSymbol),
+		globals: make(map[string]bool),
+		reorder: true,
+	}
+	a.cur = a.text
+	return a
+}
+
+// run assembles the source in two passes.
+func (a *assembler) run() error {
+	// pass 1: parse, size every statement and define labels
+	for i, line := range a.lines {
+		a.line = i + 1
+		if err := a.parseLine(line); err != nil {
+			a.errorAt(err)
+		}
+	}
+	a.bindLabels()
+
+	// pass 2: encode with every symbol known
+	a.text.data = make([]byte, a.text.offset)
+	a.data.data = make([]byte, a.data.offset)
+	for _, s := range a.stmts {
+		a.line = s.line
+		if err := a.emit(s); err != nil {
+			a.errorAt(err)
+		}
+	}
+	if len(a.errs) > 0 {
+		sort.SliceStable(a.errs, func(i, j int) bool { return a.errs[i].Line < a.errs[j].Line })
+		return a.errs
+	}
+	return nil
+}
+
+// asmSection accumulates the contents of a section.
+type asmSection struct {
+	name   string
+	addr   uint32
+	offset uint32 // location counter during pass 1
+	align  uint32 // largest alignment requested
+	data   []byte
+	relocs []Reloc // object mode only
+}
+
+// asmSymbol is a label or a constant.
+type asmSymbol struct {
+	label   bool
+	section *asmSection // nil until a label is bound
+	value   int64       // offset within section, or the constant
+	line    int
+}
+
+// asmStmt is a directive or instruction placed by pass 1.
+type asmStmt struct {
+	line    int
+	col     int
+	name    string
+	ops     []operand
+	section *asmSection
+	offset  uint32
+	size    uint32
+	reorder bool
+}
+
+type assembler struct {
+	file  string
+	lines []string
+	line  int // current line, 1-based
+
+	text, data *asmSection
+	cur        *asmSection
+	symbols    map[string]*asmSymbol
+	pending    []token // labels waiting for the next statement's address
+	stmts      []*asmStmt
+	reorder    bool // fill delay slots with nop
+	errs       AsmErrors
+
+	// object mode: symbols may be undefined and addresses are relocated later
+	object  bool
+	globals map[string]bool // names declared .globl
+	externs map[string]bool // undefined names used
+}
+
+func (a *assembler) errorAt(err *asmPosError) {
+	a.errs = append(a.errs, &AsmError{
+		File:   a.file,
+		Line:   a.line,
+		Col:    err.col,
+		Msg:    err.msg,
+		Source: strings.TrimRight(a.lines[a.line-1], "\r"),
+	})
+}
+
+// parseLine runs pass 1 on one source line.
+func (a *assembler) parseLine(line string) *asmPosError {
+	toks, err := lexLine(line)
+	if err != nil {
+		return err
+	}
+	p := &lineParser{toks: toks, end: len(line) + 1}
+
+	for len(p.toks)-p.pos >= 2 && p.peek().kind == tokIdent && p.toks[p.pos+1].kind == tokPunct && p.toks[p.pos+1].text == ":" {
+		label := p.next()
+		p.next()
+		if _, dup := a.symbols[label.text]; dup {
+			return posErrorf(label.col, "%s redefined (previous definition on line %d)", label.text, a.symbols[label.text].line)
+		}
+		a.symbols[label.text] = &asmSymbol{label: true, line: a.line}
+		a.pending = append(a.pending, label)
+	}
+	if p.peek().kind == tokEOF {
+		return nil
+	}
+
+	name := p.next()
+	if name.kind != tokIdent {
+		return posErrorf(name.col, "expected instruction or directive, found %s", name.describe())
+	}
+	ops, err := p.operands()
+	if err != nil {
+		return err
+	}
+	s := &asmStmt{line: a.line, col: name.col, name: strings.ToLower(name.text), ops: ops, reorder: a.reorder}
+	if strings.HasPrefix(s.name, ".") {
+		return a.directive(s)
+	}
+	return a.instruction(s)
+}
+
+// place binds the pending labels to the current location after aligning it
+// to align bytes, and records s as occupying size bytes there.
+func (a *assembler) place(s *asmStmt, align, size uint32) {
+	sec := a.cur
+	sec.offset = (sec.offset + align - 1) &^ (align - 1)
+	sec.align = max(sec.align, align)
+	a.bindLabels()
+	s.section, s.offset, s.size = sec, sec.offset, size
+	sec.offset += size
+	a.stmts = append(a.stmts, s)
+}
+
+// bindLabels defines the pending labels at the current location.
+func (a *assembler) bindLabels() {
+	for _, l := range a.pending {
+		sym := a.symbols[l.text]
+		sym.section, sym.value = a.cur, int64(a.cur.offset)
+	}
+	a.pending = a.pending[:0]
+}
+
+// directive runs pass 1 on a directive.
+func (a *assembler) directive(s *asmStmt) *asmPosError {
+	switch s.name {
+	case ".text", ".data":
+		if err := wantOperands(s, 0); err != nil {
+			return err
+		}
+		a.bindLabels()
+		a.cur = a.text
+		if s.name == ".data" {
+			a.cur = a.data
+		}
+
+	case ".globl", ".global":
+		for _, op := range s.ops {
+			sym, ok := op.expr.(symExpr)
+			if !ok || op.kind != operandExpr {
+				return posErrorf(op.col, "%s expects symbol names", s.name)
+			}
+			a.globals[sym.name] = true
+		}
+
+	case ".set":
+		if len(s.ops) == 1 {
+			sym, ok := s.ops[0].expr.(symExpr)
+			switch {
+			case !ok:
+			case sym.name == "reorder":
+				a.reorder = true
+				return nil
+			case sym.name == "noreorder":
+				a.reorder = false
+				return nil
+			case sym.name == "at", sym.name == "noat", sym.name == "macro", sym.name == "nomacro":
+				return nil
+			}
+			return posErrorf(s.ops[0].col, "unknown .set option")
+		}
+		return a.constant(s)
+
+	case ".equ", ".eqv":
+		return a.constant(s)
+
+	case ".align":
+		if err := wantOperands(s, 1); err != nil {
+			return err
+		}
+		n, err := a.passOneValue(s.ops[0], false)
+		if err != nil {
+			return err
+		}
+		if n < 0 || n > 16 {
+			return posErrorf(s.ops[0].col, ".align %d out of range 0-16", n)
+		}
+		a.place(s, 1<<n, 0)
+
+	case ".space":
+		if err := wantOperands(s, 1); err != nil {
+			return err
+		}
+		n, err := a.passOneValue(s.ops[0], false)
+		if err != nil {
+			return err
+		}
+		if n < 0 || n > 1<<28 {
+			return posErrorf(s.ops[0].col, ".space %d out of range", n)
+		}
+		a.place(s, 1, uint32(n))
+
+	case ".word", ".half", ".byte":
+		width := dataWidth(s.name)
+		for _, op := range s.ops {
+			if op.kind != operandExpr {
+				return posErrorf(op.col, "%s expects expressions", s.name)
+			}
+		}
+		a.place(s, width, width*uint32(len(s.ops)))
+
+	case ".ascii", ".asciiz":
+		size := uint32(0)
+		for _, op := range s.ops {
+			if op.kind != operandString {
+				return posErrorf(op.col, "%s expects strings", s.name)
+			}
+			size += uint32(len(op.str))
+			if s.name == ".asciiz" {
+				size++
+			}
+		}
+		a.place(s, 1, size)
+
+	default:
+		return posErrorf(s.col, "unknown directive %s", s.name)
+	}
+	return nil
+}
+
+// constant handles .equ name, expr and its .set and .eqv spellings.
+func (a *assembler) constant(s *asmStmt) *asmPosError {
+	if err := wantOperands(s, 2); err != nil {
+		return err
+	}
+	sym, ok := s.ops[0].expr.(symExpr)
+	if !ok || s.ops[0].kind != operandExpr {
+		return posErrorf(s.ops[0].col, "%s expects a symbol name", s.name)
+	}
+	if prev, dup := a.symbols[sym.name]; dup {
+		return posErrorf(sym.col, "%s redefined (previous definition on line %d)", sym.name, prev.line)
+	}
+	v, err := a.passOneValue(s.ops[1], true)
+	if err != nil {
+		return err
+	}
+	a.symbols[sym.name] = &asmSymbol{value: v, line: a.line}
+	return nil
+}
+
+// passOneValue evaluates op during pass 1, where only symbols defined on
+// earlier lines are known. Labels are only allowed if labels is set.
+func (a *assembler) passOneValue(op operand, labels bool) (int64, *asmPosError) {
+	if op.kind != operandExpr {
+		return 0, posErrorf(op.col, "expected an expression")
+	}
+	return op.expr.eval(func(name string, col int) (int64, *asmPosError) {
+		sym, ok := a.symbols[name]
+		if !ok || sym.line >= a.line || sym.label && (sym.section == nil || !labels) {
+			return 0, posErrorf(col, "%s must be a constant defined before use", name)
+		}
+		return a.symbolValue(sym), nil
+	})
+}
+
+func (a *assembler) symbolValue(sym *asmSymbol) int64 {
+	if sym.label {
+		return int64(sym.section.addr) + sym.value
+	}
+	return sym.value
+}
+
+// lookup resolves a symbol during pass 2.
+func (a *assembler) lookup(name string, col int) (int64, *asmPosError) {
+	sym, ok := a.symbols[name]
+	if !ok {
+		return 0, posErrorf(col, "undefined symbol %s", name)
+	}
+	return a.symbolValue(sym), nil
+}
+
+func wantOperands(s *asmStmt, n int) *asmPosError {
+	if len(s.ops) != n {
+		return posErrorf(s.col, "%s expects %d operand(s), found %d", s.name, n, len(s.ops))
+	}
+	return nil
+}
+
+func dataWidth(directive string) uint32 {
+	switch directive {
+	case ".word":
+		return 4
+	case ".half":
+		return 2
+	}
+	return 1
+}
+
+// emit runs pass 2 on s, writing its bytes into its section.
+func (a *assembler) emit(s *asmStmt) *asmPosError {
+	buf := s.section.data[s.offset : s.offset+s.size]
+	switch s.name {
+	case ".word", ".half", ".byte":
+		width := dataWidth(s.name)
+		for i, op := range s.ops {
+			rv, err := a.evalReloc(op.expr)
+			if err != nil {
+				return err
+			}
+			v := rv.addend
+			if rv.sym != "" {
+				if width != 4 {
+					return posErrorf(op.col, "%s cannot hold an address", s.name)
+				}
+				a.addReloc(s.section, s.offset+uint32(4*i), elf.R_MIPS_32, rv.sym)
+			}
+			bits := 8 * width
+			if v < -(1<<(bits-1)) || v >= 1<<bits {
+				return posErrorf(op.col, "value %d does not fit in %s", v, s.name)
+			}
+			for b := uint32(0); b < width; b++ {
+				buf[i*int(width)+int(b)] = byte(v >> (8 * (width - 1 - b)))
+			}
+		}
+	case ".ascii", ".asciiz":
+		for _, op := range s.ops {
+			n := copy(buf, op.str)
+			if s.name == ".asciiz" {
+				n++ // already zero
+			}
+			buf = buf[n:]
+		}
+	case ".align", ".space":
+		// zero filled
+	default:
+		words, err := a.encode(s)
+		if err != nil {
+			return err
+		}
+		if uint32(len(words))*4 != s.size {
+			return posErrorf(s.col, "internal error: %s assembled to %d words, sized %d bytes", s.name, len(words), s.size)
+		}
+		for i, w := range words {
+			binary.BigEndian.PutUint32(buf[4*i:], w)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package mips32
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var testLayout = Layout{TextAddr: 0, DataAddr: 0x1000}
+
+// assemble assembles src with testLayout and returns the text section as words.
+func assemble(t *testing.T, src string) (*Image, []uint32) {
+	t.Helper()
+	img, err := Assemble("test.s", []byte(src), testLayout)
+	if err != nil {
+		t.Fatalf("Assemble:\n%v", err)
+	}
+	words := make([]uint32, len(img.Text.Data)/4)
+	for i := range words {
+		words[i] = binary.BigEndian.Uint32(img.Text.Data[4*i:])
+	}
+	return img, words
+}
+
+func TestAssembleInstructions(t *testing.T) {
+	tests := []struct {
+		src  string
+		want uint32
+	}{
+		{"add $t0, $t1, $t2", 0x012A4020},
+		{"addu $v0, $a0, $a1", 0x00851021},
+		{"addi $t0, $t1, 5", 0x21280005},
+		{"addiu $v0, $zero, 5", 0x24020005},
+		{"addiu $a0, $v0, -1", 0x2444FFFF},
+		{"or $s0, $v0, $zero", 0x00408025},
+		{"sll $t0, $t1, 4", 0x00094100},
+		{"srav $t0, $t1, $t2", 0x01494007},
+		{"mult $a0, $a1", 0x00850018},
+		{"mflo $v0", 0x00001012},
+		{"lui $a0, 0x1001", 0x3C041001},
+		{"ori $a0, $a0, 0xFFFF", 0x3484FFFF},
+		{"lw $t5, 0x100($zero)", 0x8C0D0100},
+		{"sb $t0, 0x101($zero)", 0xA0080101},
+		{"lw $ra, -4($sp)", 0x8FBFFFFC},
+		{"sw $s8, ($sp)", 0xAFBE0000},
+		{"lw $t0, (2+2)*2($29)", 0x8FA80008},
+		{"cache 0x15, 0($a0)", 0xBC950000},
+		{"jr $ra", 0x03E00008},
+		{"jalr $t9", 0x0320F809},
+		{"mfc0 $t0, $12", 0x40086000},
+		{"mtc0 $t0, $16, 1", 0x40888001},
+		{"eret", 0x42000018},
+		{"syscall", 0x0000000C},
+		{"nop", 0x00000000},
+		{"move $a0, $s0", 0x02002021},
+		{"not $t0, $t1", 0x01204027},
+		{"li $t0, -2", 0x2408FFFE},
+		{"li $t0, 0xFFFF", 0x3408FFFF},
+		{"li $t0, 0x10010000", 0x3C081001},
+		{"li $t0, 'A' + 1", 0x24080042},
+	}
+	for _, tt := range tests {
+		_, words := assemble(t, ".set noreorder\n"+tt.src)
+		if len(words) != 1 || words[0] != tt.want {
+			t.Errorf("%s = %08x, want %08x", tt.src, words, tt.want)
+		}
+	}
+}
+
+func TestAssembleBranchesAndPseudoInstructions(t *testing.T) {
+	_, words := assemble(t, `
+		.set noreorder
+main:	li $t0, 0x12345678     # lui + ori
+loop:	addiu $t0, $t0, -1
+		bnez $t0, loop
+		nop
+		blt $a0, $a1, done     # slt $at + bne
+		nop
+		jal main
+		nop
+done:	b loop
+		nop
+`)
+	want := []uint32{
+		0x3C081234, // lui $t0, 0x1234
+		0x35085678, // ori $t0, $t0, 0x5678
+		0x2508FFFF, // addiu $t0, $t0, -1
+		0x1500FFFE, // bne $t0, $zero, loop
+		0x00000000,
+		0x0085082A, // slt $at, $a0, $a1
+		0x14200003, // bne $at, $zero, done
+		0x00000000,
+		0x0C000000, // jal main
+		0x00000000,
+		0x1000FFF7, // beq $zero, $zero, loop
+		0x00000000,
+	}
+	if len(words) != len(want) {
+		t.Fatalf("assembled %d words, want %d", len(words), len(want))
+	}
+	for i := range want {
+		if words[i] != want[i] {
+			t.Errorf("word %d = %08x, want %08x", i, words[i], want[i])
+		}
+	}
+}
+
+func TestAssembleFillsDelaySlots(t *testing.T) {
+	img, words := assemble(t, `
+start:	beq $a0, $a1, end
+		addiu $v0, $v0, 1
+		la $t0, value
+		lw $t1, value
+end:	jr $ra
+		.data
+		.byte 1
+value:	.word 0x8000
+`)
+	want := []uint32{
+		0x10850006, // beq $a0, $a1, end
+		0x00000000, // nop in the delay slot
+		0x24420001,
+		0x3C080000, // lui $t0, %hi(value)
+		0x25081004, // addiu $t0, $t0, %lo(value)
+		0x3C010000, // lui $at, %hi(value)
+		0x8C291004, // lw $t1, %lo(value)($at)
+		0x03E00008, // jr $ra
+		0x00000000,
+	}
+	if len(words) != len(want) {
+		t.Fatalf("assembled %08x, want %08x", words, want)
+	}
+	for i := range want {
+		if words[i] != want[i] {
+			t.Errorf("word %d = %08x, want %08x", i, words[i], want[i])
+		}
+	}
+	if img.Symbols["end"] != 0x1C || img.Symbols["value"] != 0x1004 {
+		t.Errorf("end = 0x%x, value = 0x%x, want 0x1c and 0x1004", img.Symbols["end"], img.Symbols["value"])
+	}
+}
+
+func TestAssembleData(t *testing.T) {
+	img, _ := assemble(t, `
+		.equ SIZE, 3
+		.data
+bytes:	.byte 1, -1, 'a'
+half:	.half 0x1234          # aligned to 2
+word:	.word bytes, SIZE*2   # aligned to 4
+msg:	.asciiz "hi\n"
+		.ascii "ab"
+		.align 3
+buf:	.space SIZE
+end:
+`)
+	want := []byte{
+		0x01, 0xFF, 'a', 0x00, 0x12, 0x34, 0x00, 0x00,
+		0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x06,
+		'h', 'i', '\n', 0x00, 'a', 'b', 0x00, 0x00,
+		0x00, 0x00, 0x00,
+	}
+	if !bytes.Equal(img.Data.Data, want) {
+		t.Errorf(".data = % x\nwant    % x", img.Data.Data, want)
+	}
+	for name, addr := range map[string]uint32{"half": 0x1004, "word": 0x1008, "msg": 0x1010, "buf": 0x1018, "end": 0x101B, "SIZE": 3} {
+		if got := img.Symbols[name]; got != addr {
+			t.Errorf("%s = 0x%x, want 0x%x", name, got, addr)
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	src := `main:	addiu $t0, $t0, 1
+	addiu $t0, $t9x, 1
+	frob $t0
+	addi $t0, $t0, 40000
+	beq $t0, $t1, nowhere
+main:	.word 1
+`
+	_, err := Assemble("prog.s", []byte(src), testLayout)
+	var errs AsmErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Assemble error = %v, want AsmErrors", err)
+	}
+	want := []string{
+		"prog.s:2:13: unknown register $t9x",
+		"prog.s:3:2: unknown instruction frob",
+		"prog.s:4:17: addi: immediate 40000 out of range [-32768, 32767]",
+		"prog.s:5:16: undefined symbol nowhere",
+		"prog.s:6:1: main redefined (previous definition on line 1)",
+	}
+	if got := err.Error(); got != strings.Join(want, "\n") {
+		t.Errorf("errors:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+	if errs[0].Source != "\taddiu $t0, $t9x, 1" {
+		t.Errorf("Source = %q, want the offending line", errs[0].Source)
+	}
+}
+
+func TestAssembledProgramRuns(t *testing.T) {
+	img, _ := assemble(t, `
+# print the sum of a table of words
+		.text
+main:	la $t0, table
+		li $t1, 0              # sum
+		li $t2, COUNT
+next:	lw $t3, 0($t0)
+		add $t1, $t1, $t3
+		addiu $t0, $t0, 4
+		addiu $t2, $t2, -1
+		bgtz $t2, next
+		li $v0, 1
+		move $a0, $t1
+		syscall
+		li $v0, 4
+		la $a0, nl
+		syscall
+		li $v0, 10
+		syscall
+
+		.data
+		.equ COUNT, 5
+table:	.word 1, 2, 3, 4, 5
+nl:		.asciiz "\n"
+`)
+	mem := NewMemory(0x2000)
+	if err := img.Load(mem); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	var out bytes.Buffer
+	cpu := NewCPU(mem)
+	cpu.PC = img.Symbols["main"]
+	NewSPIM(mem, strings.NewReader(""), &out, &out, 0x1800).Install(cpu)
+	res, _ := cpu.Run(context.Background())
+
+	if res.Reason != StopExit || out.String() != "15\n" {
+		t.Errorf("Run = %v with output %q, want exit and \"15\\n\"", res, out.String())
+	}
+}
+
+func TestImageEntryAndTextSymbols(t *testing.T) {
+	img, err := Assemble("test.s", []byte(`
+		.equ N, 4
+helper:	jr $ra
+main:	jal helper
+		.data
+value:	.word N
+`), MARSLayout)
+	if err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+	if got := img.Entry(); got != 0x00400008 {
+		t.Errorf("Entry = 0x%08x, want main at 0x00400008", got)
+	}
+	if got := img.End(); got != 0x10010004 {
+		t.Errorf("End = 0x%08x, want 0x10010004", got)
+	}
+	syms := img.TextSymbols()
+	if sym, _ := syms.Lookup(0x00400004); sym.Name != "helper" {
+		t.Errorf("symbol at 0x00400004 = %q, want helper", sym.Name)
+	}
+	if sym, _ := syms.Lookup(0x0040000C); sym.Name != "main" {
+		t.Errorf("symbol at 0x0040000c = %q, want main", sym.Name)
+	}
+}
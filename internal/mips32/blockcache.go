@@ -0,0 +1,185 @@
+package mips32
+
+// maxBlockLen bounds the number of instructions decoded into a single block.
+const maxBlockLen = 64
+
+// pageWords is the number of instruction slots in a memory page.
+const pageWords = 1 << (memPageShift - 2)
+
+// decoded instruction flags
+const (
+	opStore    uint8 = 1 << iota // writes memory and may modify already decoded code
+	opSyncCP0                    // observes Count/Random, which must be exact beforehand
+	opEndBlock                   // may change interrupt state or is a compact branch, the block ends after it
+	opControl                    // control transfer, the block ends after its delay slot
+)
+
+// instruction formats stored in a decodedOp
+const (
+	kindR uint8 = iota
+	kindI
+	kindJ
+	kindCOP0
+	kindR6
+)
+
+// decodedOp is an instruction decoded once and kept for re-execution.
+// The instruction is stored by value so blocks hold no pointers and
+// executing it needs neither an allocation nor an interface call.
+type decodedOp struct {
+	word  uint32
+	kind  uint8
+	flags uint8
+	r     RTypeInstruction
+	i     ITypeInstruction
+	j     JTypeInstruction
+	c     COP0Instruction
+	r6    r6Instruction
+}
+
+// decodeOp decodes word following the same rules as DecodeInstructionFor.
+func decodeOp(word uint32, isa ISA) decodedOp {
+	if isa == ISAR6 {
+		if ri, ok := decodeR6(word); ok {
+			op := decodedOp{word: word, kind: kindR6, r6: ri}
+			if ri.isBranch() {
+				op.flags = opEndBlock // compact, the block ends with no delay slot
+			}
+			return op
+		}
+	}
+	op := decodedOp{word: word, flags: classifyInstruction(word)}
+	switch opcode := word >> 26; {
+	case opcode == 0x10:
+		op.kind, op.c = kindCOP0, decodeCOP0(word)
+	case opcode == 0x0:
+		op.kind, op.r = kindR, decodeRType(word)
+	case opcode == 0x2 || opcode == 0x3 || opcode == 0x1D:
+		op.kind, op.j = kindJ, decodeJType(word)
+	default:
+		op.kind, op.i = kindI, decodeIType(word)
+	}
+	return op
+}
+
+// execute runs the decoded instruction.
+func (op *decodedOp) execute(cpu *CPU) (Flow, uint32) {
+	switch op.kind {
+	case kindR:
+		return op.r.Execute(cpu)
+	case kindI:
+		return op.i.Execute(cpu)
+	case kindJ:
+		return op.j.Execute(cpu)
+	case kindR6:
+		return op.r6.Execute(cpu)
+	default:
+		return op.c.Execute(cpu)
+	}
+}
+
+// decodedBlock is a straight-line run of instructions decoded from a single page.
+// It ends after the delay slot of the first control transfer, after an instruction
+// that may unmask an interrupt, at the end of the page, or after maxBlockLen instructions.
+type decodedBlock struct {
+	start uint32
+	ops   []decodedOp
+}
+
+// blockPage holds the blocks decoded from one page, valid as long as the
+// page's store generation is unchanged.
+type blockPage struct {
+	gen    uint32
+	blocks [pageWords]*decodedBlock
+}
+
+// blockCache maps physical addresses to decoded blocks.
+type blockCache struct {
+	isa   ISA // the release the blocks are decoded for
+	pages []*blockPage
+}
+
+// lookup returns the block starting at addr, decoding it on a miss.
+// It returns nil if no instruction can be fetched at addr.
+func (bc *blockCache) lookup(mem *Memory, addr uint32) *decodedBlock {
+	if len(bc.pages) != len(mem.pageGen) {
+		bc.pages = make([]*blockPage, len(mem.pageGen))
+	}
+
+	pn := addr >> memPageShift
+	if addr%4 != 0 || int(pn) >= len(bc.pages) {
+		return nil
+	}
+
+	gen := mem.pageGen[pn].Load()
+	page := bc.pages[pn]
+	if page == nil || page.gen != gen {
+		// first use, or the page was written since it was decoded
+		page = &blockPage{gen: gen}
+		bc.pages[pn] = page
+	}
+
+	slot := (addr >> 2) & (pageWords - 1)
+	if blk := page.blocks[slot]; blk != nil {
+		return blk
+	}
+
+	blk := decodeBlock(mem, addr, bc.isa)
+	if blk != nil {
+		page.blocks[slot] = blk
+	}
+	return blk
+}
+
+// decodeBlock decodes the block starting at addr.
+func decodeBlock(mem *Memory, addr uint32, isa ISA) *decodedBlock {
+	var ops [maxBlockLen]decodedOp
+	n := 0
+	pageEnd := uint64(addr>>memPageShift+1) << memPageShift
+	delaySlot := false
+
+	for a := uint64(addr); a < pageEnd && n < maxBlockLen; a += 4 {
+		// instructions are only fetched from RAM, never from devices
+		if !mem.isAddressInRange(uint32(a) + 3) {
+			break
+		}
+		word, ok := mem.LoadWord(uint32(a))
+		if !ok {
+			break
+		}
+
+		ops[n] = decodeOp(word, isa)
+		n++
+
+		if delaySlot || ops[n-1].flags&opEndBlock != 0 {
+			break
+		}
+		delaySlot = ops[n-1].flags&opControl != 0
+	}
+
+	if n == 0 {
+		return nil
+	}
+	return &decodedBlock{start: addr, ops: append([]decodedOp(nil), ops[:n]...)}
+}
+
+// classifyInstruction returns the decoded-op flags for an instruction word.
+func classifyInstruction(word uint32) uint8 {
+	switch OpCode(word >> 26) {
+	case 0x0:
+		switch OpCode(word & 0x3F) {
+		case OpCodeJR, OpCodeJALR:
+			return opControl
+		case OpCodeSYSCALL, OpCodeBREAK:
+			return opEndBlock // an embedder may change memory or state
+		}
+	case OpCodeREGIMM, OpCodeBEQ, OpCodeBNE, OpCodeBLEZ, OpCodeBGTZ,
+		OpCodeBEQL, OpCodeBNEL, OpCodeBLEZL, OpCodeBGTZL, OpCodeJ, OpCodeJAL, OpCodeJALX:
+		return opControl
+	case OpCodeSB, OpCodeSH, OpCodeSW, OpCodeSC, OpCodeSD:
+		return opStore
+	case OpCode(OpCodeCOP0):
+		return opSyncCP0 | opEndBlock
+	}
+	return 0
+}
@@ -0,0 +1,351 @@
+package mips32
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// CacheConfig describes the geometry of an L1 cache.
+type CacheConfig struct {
+	Size     int // total size in bytes
+	Ways     int // associativity (1-8)
+	LineSize int // bytes per line (4-128)
+}
+
+// CacheStats counts the accesses seen by a cache.
+type CacheStats struct {
+	Hits       uint64
+	Misses     uint64
+	Writebacks uint64 // dirty lines written back on eviction or by CACHE
+	Uncached   uint64 // accesses that bypassed the cache (kseg1, C=2)
+}
+
+// Accesses returns the number of cached accesses.
+func (s CacheStats) Accesses() uint64 {
+	return s.Hits + s.Misses
+}
+
+// HitRate returns the fraction of cached accesses that hit.
+func (s CacheStats) HitRate() float64 {
+	if s.Accesses() == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Accesses())
+}
+
+func (s CacheStats) String() string {
+	return fmt.Sprintf("%d accesses, %d hits, %d misses (%.2f%% hit rate), %d writebacks, %d uncached",
+		s.Accesses(), s.Hits, s.Misses, 100*s.HitRate(), s.Writebacks, s.Uncached)
+}
+
+// Cache models the tags of a write-back, write-allocate, LRU set-associative
+// cache. It holds no data: memory stays the single source of truth and the
+// model only tracks which lines would be resident.
+type Cache struct {
+	cfg       CacheConfig
+	sets      int
+	lineShift uint
+	lines     []cacheLine // sets*ways entries, grouped by set
+	clock     uint64
+
+	Stats CacheStats
+}
+
+type cacheLine struct {
+	addr  uint32 // physical address of the line
+	valid bool
+	dirty bool
+	used  uint64 // clock value of the last access, for LRU
+}
+
+// NewCache creates a cache with geometry cfg. The geometry must be
+// representable in Config1: power-of-two line size and sets per way.
+func NewCache(cfg CacheConfig) (*Cache, error) {
+	if cfg.LineSize < 4 || cfg.LineSize > 128 || bits.OnesCount(uint(cfg.LineSize)) != 1 {
+		return nil, fmt.Errorf("invalid cache line size %d", cfg.LineSize)
+	}
+	if cfg.Ways < 1 || cfg.Ways > 8 {
+		return nil, fmt.Errorf("invalid cache associativity %d", cfg.Ways)
+	}
+	// Config1 can describe 32 to 4096 (64 << 6) sets per way
+	sets := cfg.Size / (cfg.Ways * cfg.LineSize)
+	if sets*cfg.Ways*cfg.LineSize != cfg.Size || sets < 32 || sets > 4096 || bits.OnesCount(uint(sets)) != 1 {
+		return nil, fmt.Errorf("invalid cache size %d for %d ways of %d-byte lines", cfg.Size, cfg.Ways, cfg.LineSize)
+	}
+
+	return &Cache{
+		cfg:       cfg,
+		sets:      sets,
+		lineShift: uint(bits.TrailingZeros(uint(cfg.LineSize))),
+		lines:     make([]cacheLine, sets*cfg.Ways),
+	}, nil
+}
+
+// Config returns the geometry of the cache.
+func (c *Cache) Config() CacheConfig {
+	return c.cfg
+}
+
+// config1Fields encodes the geometry as the S/L/A fields of Config1.
+func (c *Cache) config1Fields() (s, l, a uint32) {
+	if c == nil {
+		return 0, 0, 0 // L=0: no cache
+	}
+	s = uint32(bits.TrailingZeros(uint(c.sets)) - 6) // 64 << S sets per way
+	if c.sets == 32 {
+		s = 7
+	}
+	return s, uint32(c.lineShift - 1), uint32(c.cfg.Ways - 1)
+}
+
+// set returns the lines of the set paddr maps to.
+func (c *Cache) set(paddr uint32) []cacheLine {
+	i := int(paddr>>c.lineShift) & (c.sets - 1)
+	return c.lines[i*c.cfg.Ways : (i+1)*c.cfg.Ways]
+}
+
+// find returns the line holding paddr, or nil.
+func (c *Cache) find(paddr uint32) *cacheLine {
+	addr := paddr &^ (uint32(c.cfg.LineSize) - 1)
+	set := c.set(paddr)
+	for i := range set {
+		if set[i].valid && set[i].addr == addr {
+			return &set[i]
+		}
+	}
+	return nil
+}
+
+// access records a cached access to paddr and reports whether it hit.
+// A miss allocates the line, evicting the least recently used way.
+func (c *Cache) access(paddr uint32, write bool) bool {
+	c.clock++
+	if l := c.find(paddr); l != nil {
+		c.Stats.Hits++
+		l.used = c.clock
+		l.dirty = l.dirty || write
+		return true
+	}
+
+	c.Stats.Misses++
+	c.fill(paddr, write)
+	return false
+}
+
+// fill loads the line holding paddr, replacing an invalid or the LRU way.
+func (c *Cache) fill(paddr uint32, dirty bool) {
+	set := c.set(paddr)
+	victim := &set[0]
+	for i := range set {
+		if !set[i].valid {
+			victim = &set[i]
+			break
+		}
+		if set[i].used < victim.used {
+			victim = &set[i]
+		}
+	}
+	c.evict(victim)
+	*victim = cacheLine{
+		addr:  paddr &^ (uint32(c.cfg.LineSize) - 1),
+		valid: true,
+		dirty: dirty,
+		used:  c.clock,
+	}
+}
+
+// evict drops l, writing it back first if it is dirty.
+func (c *Cache) evict(l *cacheLine) {
+	if l.valid && l.dirty {
+		c.Stats.Writebacks++
+	}
+	l.valid, l.dirty = false, false
+}
+
+// indexLine returns the line selected by an index CACHE operation: the set
+// comes from the usual index bits of addr and the way from the bits above them.
+func (c *Cache) indexLine(addr uint32) *cacheLine {
+	set := c.set(addr)
+	way := int(addr>>c.lineShift) / c.sets % c.cfg.Ways
+	return &set[way]
+}
+
+// TagLo layout used by Index Load Tag / Index Store Tag (4K-style):
+// PTagLo [31:10] holds PA[31:10], V is bit 7 and D is bit 6.
+const (
+	tagLoAddrMask uint32 = 0xFFFFFC00
+	tagLoValid    uint32 = 1 << 7
+	tagLoDirty    uint32 = 1 << 6
+)
+
+// CACHE instruction operations (op[4:2])
+const (
+	cacheIndexInvalidate  = 0 // I: invalidate; D: writeback and invalidate
+	cacheIndexLoadTag     = 1
+	cacheIndexStoreTag    = 2
+	cacheHitInvalidate    = 4
+	cacheFill             = 5 // I: fill; D: hit writeback and invalidate
+	cacheHitWriteback     = 6
+	cacheFetchAndLock     = 7
+	cacheSelectInstr      = 0 // op[1:0]
+	cacheSelectData       = 1
+	cacheOpSelectMask     = 0x3
+	cacheOpOperationShift = 2
+)
+
+// operate performs CACHE operation op (op[4:2]) on the line addressed by
+// vaddr (index operations) or paddr (hit operations). isData selects the
+// D-cache variants of the operations that differ between I and D.
+func (c *Cache) operate(op int, vaddr, paddr uint32, isData bool, tagLo *uint32) {
+	switch op {
+	case cacheIndexInvalidate:
+		l := c.indexLine(vaddr)
+		if !isData {
+			l.dirty = false // the I-cache is never dirty
+		}
+		c.evict(l)
+
+	case cacheIndexLoadTag:
+		l := c.indexLine(vaddr)
+		t := l.addr & tagLoAddrMask
+		if l.valid {
+			t |= tagLoValid
+		}
+		if l.dirty {
+			t |= tagLoDirty
+		}
+		*tagLo = t
+
+	case cacheIndexStoreTag:
+		l := c.indexLine(vaddr)
+		lineMask := uint32(c.cfg.LineSize) - 1
+		l.addr = (*tagLo & tagLoAddrMask) | (vaddr & ^tagLoAddrMask &^ lineMask)
+		l.valid = *tagLo&tagLoValid != 0
+		l.dirty = l.valid && *tagLo&tagLoDirty != 0
+
+	case cacheHitInvalidate:
+		if l := c.find(paddr); l != nil {
+			l.valid, l.dirty = false, false
+		}
+
+	case cacheFill:
+		if isData {
+			// Hit Writeback Invalidate
+			if l := c.find(paddr); l != nil {
+				c.evict(l)
+			}
+			return
+		}
+		if c.find(paddr) == nil {
+			c.clock++
+			c.fill(paddr, false)
+		}
+
+	case cacheHitWriteback:
+		if l := c.find(paddr); l != nil && l.dirty {
+			c.Stats.Writebacks++
+			l.dirty = false
+		}
+
+	case cacheFetchAndLock:
+		// locking is not modeled; behave like a fill
+		if c.find(paddr) == nil {
+			c.clock++
+			c.fill(paddr, false)
+		}
+	}
+}
+
+// cacheAttribute returns the physical address and cacheability of vaddr:
+// kseg0 follows Config0.K0, kseg1 is uncached, and mapped segments use the C
+// bits of the matching TLB entry. Addresses without a valid TLB mapping are
+// treated as identity-mapped and cacheable per K0.
+func (c *COP0) cacheAttribute(vaddr uint32) (paddr uint32, cached bool) {
+	switch {
+	case vaddr >= 0x80000000 && vaddr < 0xA0000000:
+		return vaddr & 0x1FFFFFFF, cacheable(uint8(c.config0 & 0x7))
+	case vaddr >= 0xA0000000 && vaddr < 0xC0000000:
+		return vaddr & 0x1FFFFFFF, false
+	}
+
+	asid := uint8(c.entryHi & 0xFF)
+	for i := range c.tlb {
+		e := &c.tlb[i]
+		mask := e.Mask | 0x1FFF
+		if (vaddr&^mask) != (e.VPN2&^mask) || !(e.G || e.ASID == asid) {
+			continue
+		}
+		oddBit := (mask + 1) >> 1
+		pfn, attr, valid := e.PFN0, e.C0, e.V0
+		if vaddr&oddBit != 0 {
+			pfn, attr, valid = e.PFN1, e.C1, e.V1
+		}
+		if !valid {
+			break
+		}
+		return pfn<<12 | vaddr&(oddBit-1), cacheable(attr)
+	}
+	return vaddr, cacheable(uint8(c.config0 & 0x7))
+}
+
+// cacheable reports whether cache coherency attribute attr is a cached one.
+func cacheable(attr uint8) bool {
+	return attr != 2 && attr != 7 // 2: uncached, 7: uncached accelerated
+}
+
+// setCaches describes the I- and D-caches in Config1.
+func (c *COP0) setCaches(icache, dcache *Cache) {
+	is, il, ia := icache.config1Fields()
+	ds, dl, da := dcache.config1Fields()
+	c.config1 &^= 0x1FFFF80 // IS, IL, IA, DS, DL, DA: [24:7]
+	c.config1 |= is<<22 | il<<19 | ia<<16 | ds<<13 | dl<<10 | da<<7
+}
+
+// SetCaches enables the L1 cache models; either may be nil to model no cache.
+func (cpu *CPU) SetCaches(icache, dcache *Cache) {
+	cpu.icache, cpu.dcache = icache, dcache
+	cpu.cp0.setCaches(icache, dcache)
+}
+
+// ICache returns the instruction cache model, or nil.
+func (cpu *CPU) ICache() *Cache { return cpu.icache }
+
+// DCache returns the data cache model, or nil.
+func (cpu *CPU) DCache() *Cache { return cpu.dcache }
+
+// fetchAccess records an instruction fetch from vaddr in the I-cache.
+func (cpu *CPU) fetchAccess(vaddr uint32) {
+	paddr, cached := cpu.cp0.cacheAttribute(vaddr)
+	if !cached {
+		cpu.icache.Stats.Uncached++
+		return
+	}
+	cpu.icache.access(paddr, false)
+}
+
+// dcacheAccess records a data access to vaddr in the D-cache.
+func (cpu *CPU) dcacheAccess(vaddr uint32, write bool) {
+	paddr, cached := cpu.cp0.cacheAttribute(vaddr)
+	if !cached {
+		cpu.dcache.Stats.Uncached++
+		return
+	}
+	cpu.dcache.access(paddr, write)
+}
+
+// cacheOp executes CACHE op on vaddr.
+func (cpu *CPU) cacheOp(op uint8, vaddr uint32) {
+	var c *Cache
+	isData := false
+	switch op & cacheOpSelectMask {
+	case cacheSelectInstr:
+		c = cpu.icache
+	case cacheSelectData:
+		c, isData = cpu.dcache, true
+	}
+	if c == nil {
+		return // secondary/tertiary caches, or no cache configured
+	}
+	paddr, _ := cpu.cp0.cacheAttribute(vaddr)
+	c.operate(int(op>>cacheOpOperationShift), vaddr, paddr, isData, &cpu.cp0.tagLo)
+}
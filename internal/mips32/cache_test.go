@@ -0,0 +1,158 @@
+package mips32
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNewCacheConfig1(t *testing.T) {
+	ic, err := NewCache(CacheConfig{Size: 16 << 10, Ways: 4, LineSize: 32})
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	dc, err := NewCache(CacheConfig{Size: 2 << 10, Ways: 2, LineSize: 32})
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+
+	cpu := NewCPU(NewMemory(0x1000))
+	cpu.SetCaches(ic, dc)
+	c1 := cpu.GetCP0Reg(cp0RegConfig, 1)
+
+	// 16K/4/32: 128 sets (IS=1), 32-byte lines (IL=4), 4 ways (IA=3)
+	// 2K/2/32: 32 sets (DS=7), 32-byte lines (DL=4), 2 ways (DA=1)
+	for _, f := range []struct {
+		name      string
+		got, want uint32
+	}{
+		{"IS", c1 >> 22 & 7, 1},
+		{"IL", c1 >> 19 & 7, 4},
+		{"IA", c1 >> 16 & 7, 3},
+		{"DS", c1 >> 13 & 7, 7},
+		{"DL", c1 >> 10 & 7, 4},
+		{"DA", c1 >> 7 & 7, 1},
+	} {
+		if f.got != f.want {
+			t.Errorf("Config1.%s = %d, want %d", f.name, f.got, f.want)
+		}
+	}
+
+	for _, cfg := range []CacheConfig{
+		{Size: 16 << 10, Ways: 4, LineSize: 24},
+		{Size: 16 << 10, Ways: 9, LineSize: 32},
+		{Size: 1 << 10, Ways: 4, LineSize: 32}, // 8 sets
+		{Size: 12 << 10, Ways: 4, LineSize: 32},
+		{Size: 256 << 10, Ways: 1, LineSize: 32}, // 8192 sets, which S cannot encode
+	} {
+		if _, err := NewCache(cfg); err == nil {
+			t.Errorf("NewCache(%+v) succeeded, want error", cfg)
+		}
+	}
+
+	// the largest geometry Config1 can describe
+	big, err := NewCache(CacheConfig{Size: 128 << 10, Ways: 1, LineSize: 32})
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	if s, _, _ := big.config1Fields(); s != 6 {
+		t.Errorf("Config1.S for 4096 sets = %d, want 6", s)
+	}
+}
+
+func TestCacheLRU(t *testing.T) {
+	// 2 ways, 32 sets of 16-byte lines: addresses 512 bytes apart share a set
+	c, err := NewCache(CacheConfig{Size: 1 << 10, Ways: 2, LineSize: 16})
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+
+	c.access(0x000, true)  // miss, A
+	c.access(0x200, false) // miss, B
+	c.access(0x004, false) // hit, A is now most recent
+	c.access(0x400, false) // miss, evicts B
+	c.access(0x404, false) // hit
+	c.access(0x200, false) // miss, evicts dirty A
+
+	want := CacheStats{Hits: 2, Misses: 4, Writebacks: 1}
+	if c.Stats != want {
+		t.Errorf("stats = %+v, want %+v", c.Stats, want)
+	}
+}
+
+func TestCacheAttribute(t *testing.T) {
+	c := NewCOP0(16)
+
+	if pa, cached := c.cacheAttribute(0x80001000); pa != 0x1000 || !cached {
+		t.Errorf("kseg0 = (0x%x, %v), want (0x1000, true)", pa, cached)
+	}
+	if pa, cached := c.cacheAttribute(0xA0001000); pa != 0x1000 || cached {
+		t.Errorf("kseg1 = (0x%x, %v), want (0x1000, false)", pa, cached)
+	}
+
+	c.Write(cp0RegConfig, 0, 2) // K0 = uncached
+	if _, cached := c.cacheAttribute(0x80001000); cached {
+		t.Errorf("kseg0 cached with K0=2")
+	}
+
+	// map 0x00400000 (even) to PFN 0x10 uncached, 0x00401000 (odd) to PFN 0x11 cacheable
+	c.Write(cp0RegEntryHi, 0, 0x00400000)
+	c.Write(cp0RegEntryLo0, 0, 0x10<<6|2<<3|1<<1|1)
+	c.Write(cp0RegEntryLo1, 0, 0x11<<6|3<<3|1<<1|1)
+	c.Write(cp0RegIndex, 0, 0)
+	c.TLBWI()
+
+	if pa, cached := c.cacheAttribute(0x00400010); pa != 0x10010 || cached {
+		t.Errorf("even page = (0x%x, %v), want (0x10010, false)", pa, cached)
+	}
+	if pa, cached := c.cacheAttribute(0x00401010); pa != 0x11010 || !cached {
+		t.Errorf("odd page = (0x%x, %v), want (0x11010, true)", pa, cached)
+	}
+}
+
+func TestCacheInstruction(t *testing.T) {
+	mem := NewMemory(0x2000)
+	for i, w := range []uint32{
+		0x8C080100, // lw $t0, 0x100($zero)   miss
+		0x8C090104, // lw $t1, 0x104($zero)   hit
+		0xBC110100, // cache Hit_Invalidate_D, 0x100($zero)
+		0x8C080100, // lw $t0, 0x100($zero)   miss again
+		reservedInstr,
+	} {
+		mem.StoreWord(uint32(i*4), w)
+	}
+	cpu := NewCPU(mem)
+	ic, _ := NewCache(CacheConfig{Size: 4 << 10, Ways: 2, LineSize: 16})
+	dc, _ := NewCache(CacheConfig{Size: 4 << 10, Ways: 2, LineSize: 16})
+	cpu.SetCaches(ic, dc)
+	cpu.Run(context.Background())
+
+	if want := (CacheStats{Hits: 1, Misses: 2}); dc.Stats != want {
+		t.Errorf("D-cache stats = %+v, want %+v", dc.Stats, want)
+	}
+	// five fetches from one 16-byte line and the next
+	if want := (CacheStats{Hits: 3, Misses: 2}); ic.Stats != want {
+		t.Errorf("I-cache stats = %+v, want %+v", ic.Stats, want)
+	}
+}
+
+func TestCacheInstructionUserMode(t *testing.T) {
+	for _, tt := range []struct {
+		status uint32
+		exc    uint32
+	}{
+		{2 << 3, excCpU},          // user mode
+		{2<<3 | statusCU0, excRI}, // CU0 makes CACHE usable
+		{0, excRI},                // kernel mode
+	} {
+		mem := NewMemory(0x2000)
+		mem.StoreWord(0, 0xBC110100) // cache Hit_Invalidate_D, 0x100($zero)
+		mem.StoreWord(4, reservedInstr)
+		cpu := NewCPU(mem)
+		cpu.cp0.Write(cp0RegStatus, 0, tt.status)
+		cpu.Run(context.Background())
+
+		if exc := cpu.GetCP0Reg(cp0RegCause, 0) >> 2 & 0x1F; exc != tt.exc {
+			t.Errorf("Status 0x%x: ExcCode = %d, want %d", tt.status, exc, tt.exc)
+		}
+	}
+}
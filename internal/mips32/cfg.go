@@ -0,0 +1,146 @@
+package mips32
+
+import "fmt"
+
+// EdgeKind is how control reaches a basic block.
+type EdgeKind uint8
+
+const (
+	EdgeFallthrough EdgeKind = iota // into the next block
+	EdgeTaken                       // conditional branch taken
+	EdgeJump                        // unconditional branch or jump
+)
+
+func (k EdgeKind) String() string {
+	switch k {
+	case EdgeFallthrough:
+		return "fallthrough"
+	case EdgeTaken:
+		return "taken"
+	case EdgeJump:
+		return "jump"
+	}
+	return fmt.Sprintf("EdgeKind(%d)", k)
+}
+
+// Edge leads to the basic block starting at To.
+type Edge struct {
+	To   uint32
+	Kind EdgeKind
+}
+
+// BasicBlock is a run of instructions entered only at the first one. A
+// block ending with a branch or jump includes its delay slot.
+type BasicBlock struct {
+	Start uint32
+	Insts []Inst
+	Succs []Edge // within the function
+}
+
+// End returns the address just past the block.
+func (b *BasicBlock) End() uint32 {
+	last := b.Insts[len(b.Insts)-1]
+	return last.Addr + last.Size()
+}
+
+// Terminator returns the branch or jump ending the block, if any.
+func (b *BasicBlock) Terminator() (Inst, bool) {
+	if n := len(b.Insts); n >= 2 && b.Insts[n-2].endsBlock() && b.Insts[n-2].HasDelaySlot() {
+		return b.Insts[n-2], true
+	}
+	if n := len(b.Insts); b.Insts[n-1].endsBlock() && !b.Insts[n-1].HasDelaySlot() {
+		return b.Insts[n-1], true // eret, MIPS16e branches and compact jumps
+	}
+	return Inst{}, false
+}
+
+// CFG is the control-flow graph of a function.
+type CFG struct {
+	Func   Symbol        // Size covers the instructions analysed
+	Blocks []*BasicBlock // ordered by address, the entry first
+}
+
+// funcEnd returns the end of fn: its size if known, else the next function
+// or the end of the listing.
+func (l *Listing) funcEnd(fn Symbol) uint32 {
+	last := l.Insts[len(l.Insts)-1]
+	end := last.Addr + last.Size()
+	if fn.Size != 0 {
+		return min(end, fn.Addr+fn.Size)
+	}
+	for _, s := range l.Funcs.Symbols() {
+		if s.Addr > fn.Addr {
+			return min(end, s.Addr)
+		}
+	}
+	return end
+}
+
+// CFG splits the function fn into basic blocks.
+func (l *Listing) CFG(fn Symbol) (*CFG, error) {
+	if !l.contains(fn.Addr) {
+		return nil, fmt.Errorf("function %s at 0x%08x is outside the listing", fn.Name, fn.Addr)
+	}
+	end := l.funcEnd(fn)
+	from, _ := l.index(fn.Addr)
+	to, _ := l.index(end)
+	insts := l.Insts[from:to]
+	fn.Size = end - fn.Addr
+	inFunc := func(addr uint32) bool { return addr >= fn.Addr && addr < end && l.contains(addr) }
+
+	// blocks start at the entry, at branch targets and after delay slots,
+	// but never at a delay slot
+	leaders := map[uint32]bool{fn.Addr: true}
+	for i, inst := range insts {
+		if t, ok := inst.Target(); ok && inst.endsBlock() && inFunc(t) {
+			leaders[t] = true
+		}
+		next := i + 1
+		if inst.HasDelaySlot() {
+			next++
+		}
+		if inst.endsBlock() && next < len(insts) {
+			leaders[insts[next].Addr] = true
+		}
+	}
+	for i, inst := range insts {
+		if i > 0 && insts[i-1].HasDelaySlot() {
+			delete(leaders, inst.Addr)
+		}
+	}
+
+	g := &CFG{Func: fn}
+	for _, inst := range insts {
+		if leaders[inst.Addr] {
+			g.Blocks = append(g.Blocks, &BasicBlock{Start: inst.Addr})
+		}
+		b := g.Blocks[len(g.Blocks)-1]
+		b.Insts = append(b.Insts, inst)
+	}
+
+	for _, b := range g.Blocks {
+		next := Edge{To: b.End(), Kind: EdgeFallthrough}
+		term, ok := b.Terminator()
+		if !ok {
+			if inFunc(b.End()) {
+				b.Succs = append(b.Succs, next)
+			}
+			continue
+		}
+		target, direct := term.Target()
+		switch term.Control() {
+		case ControlBranch:
+			if direct && inFunc(target) {
+				b.Succs = append(b.Succs, Edge{To: target, Kind: EdgeTaken})
+			}
+			if inFunc(b.End()) {
+				b.Succs = append(b.Succs, next)
+			}
+		case ControlJump:
+			if direct && inFunc(target) {
+				b.Succs = append(b.Succs, Edge{To: target, Kind: EdgeJump})
+			}
+		}
+	}
+	return g, nil
+}
@@ -0,0 +1,78 @@
+package mips32
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestCFG(t *testing.T) {
+	img, _ := assemble(t, `
+		.set noreorder
+f:		beq $a0, $zero, out
+		nop
+loop:	addiu $a0, $a0, -1
+		bnez $a0, loop
+		nop
+		jal g
+		nop
+out:	jr $ra
+		nop
+g:		jr $ra
+		nop
+`)
+	funcs := NewSymbolTable([]Symbol{{Name: "f", Addr: 0}, {Name: "g", Addr: 0x24}})
+	l := NewListing(img.Text.Data, 0, binary.BigEndian, funcs, nil)
+	g, err := l.CFG(Symbol{Name: "f", Addr: 0})
+	if err != nil {
+		t.Fatalf("CFG: %v", err)
+	}
+	if g.Func.Size != 0x24 {
+		t.Errorf("Func.Size = 0x%x, want 0x24 (up to g)", g.Func.Size)
+	}
+
+	type block struct {
+		start, end uint32
+		succs      []Edge
+	}
+	want := []block{
+		{0x00, 0x08, []Edge{{0x1c, EdgeTaken}, {0x08, EdgeFallthrough}}},
+		{0x08, 0x14, []Edge{{0x08, EdgeTaken}, {0x14, EdgeFallthrough}}},
+		{0x14, 0x1c, []Edge{{0x1c, EdgeFallthrough}}}, // calls do not end blocks
+		{0x1c, 0x24, nil},
+	}
+	var got []block
+	for _, b := range g.Blocks {
+		got = append(got, block{b.Start, b.End(), b.Succs})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("blocks = %+v, want %+v", got, want)
+	}
+	if term, ok := g.Blocks[3].Terminator(); !ok || term.Control() != ControlReturn {
+		t.Errorf("Terminator = %v, %v, want jr $ra", term, ok)
+	}
+}
+
+func TestInstControl(t *testing.T) {
+	tests := []struct {
+		word  uint32
+		want  Control
+		delay bool
+	}{
+		{0x01094020, ControlNone, false},        // add
+		{0x10000003, ControlJump, true},         // b
+		{0x11090003, ControlBranch, true},       // beq $t0, $t1
+		{0x04110003, ControlCall, true},         // bal
+		{0x0C000010, ControlCall, true},         // jal
+		{0x0320F809, ControlIndirectCall, true}, // jalr $t9
+		{0x03E00008, ControlReturn, true},       // jr $ra
+		{0x03200008, ControlIndirect, true},     // jr $t9
+		{0x42000018, ControlReturn, false},      // eret
+	}
+	for _, tt := range tests {
+		inst := Decode(tt.word, 0)
+		if got := inst.Control(); got != tt.want || inst.HasDelaySlot() != tt.delay {
+			t.Errorf("%v: Control = %d, HasDelaySlot = %v, want %d, %v", inst, got, inst.HasDelaySlot(), tt.want, tt.delay)
+		}
+	}
+}
@@ -3,7 +3,8 @@ package mips32
 // COP0 implements a simplified but comprehensive MIPS32r1/r2 CP0 inspired by QEMU.
 // It models key registers, TLB operations, exceptions/interrupts, and a timer.
 // The API is self-contained; the CPU can call into it to handle system control ops.
-// All registers are 32-bit. Some fields are approximated for practicality in this repo.
+// All registers are 32-bit except XContext, which is 64-bit on a MIPS64 CPU.
+// Some fields are approximated for practicality in this repo.
 
 type COP0 struct {
 	// TLB state
@@ -38,9 +39,13 @@ type COP0 struct {
 	watchLo uint32 // 18, sel0
 	watchHi uint32 // 19, sel0
 
-	xcontext uint32 // 20, sel0 (optional, r2+); kept for completeness
+	xcontext uint64 // 20, sel0 (MIPS64); kept for completeness on MIPS32
+
+	tagLo uint32 // 28, sel0/sel2 (ITagLo/DTagLo, shared)
 
 	errorepc uint32 // 30, sel0
+
+	mips64 bool // 64-bit CPU: XContext and the XTLB refill vector are used
 }
 
 // TLBEntry models a two-page (even/odd) MIPS TLB entry.
@@ -84,23 +89,25 @@ const (
 	cp0RegWatchLo  = 18
 	cp0RegWatchHi  = 19
 	cp0RegXContext = 20
+	cp0RegTagLo    = 28
 	cp0RegErrorEPC = 30
 )
 
 // Cause ExcCode for exceptions (subset)
 const (
-	excInt  = 0  // Interrupt
-	excMod  = 1  // TLB modification
-	excTLBL = 2  // TLB load/fetch
-	excTLBS = 3  // TLB store
-	excAdEL = 4  // Address error load/fetch
-	excAdES = 5  // Address error store
-	excSys  = 8  // Syscall
-	excBp   = 9  // Breakpoint
-	excRI   = 10 // Reserved instruction
-	excCpU  = 11 // Coprocessor unusable
-	excOv   = 12 // Arithmetic overflow
-	excTr   = 13 // Trap
+	excInt   = 0  // Interrupt
+	excMod   = 1  // TLB modification
+	excTLBL  = 2  // TLB load/fetch
+	excTLBS  = 3  // TLB store
+	excAdEL  = 4  // Address error load/fetch
+	excAdES  = 5  // Address error store
+	excSys   = 8  // Syscall
+	excBp    = 9  // Breakpoint
+	excRI    = 10 // Reserved instruction
+	excCpU   = 11 // Coprocessor unusable
+	excOv    = 12 // Arithmetic overflow
+	excTr    = 13 // Trap
+	excWatch = 23 // Reference to WatchHi/WatchLo address
 )
 
 // Status/Cause bit helpers
@@ -109,15 +116,45 @@ const (
 	statusEXL uint32 = 1 << 1
 	statusERL uint32 = 1 << 2
 
+	// KSU at [4:3] selects the mode outside of exceptions: 0 kernel, 1 supervisor, 2 user
+	statusKSUMask uint32 = 3 << 3
+	statusKSUSup  uint32 = 1 << 3
+
+	// CU0 makes the privileged CP0 instructions usable outside kernel mode
+	statusCU0 uint32 = 1 << 28
+
+	// 64-bit addressing enables for kernel, supervisor and user mode (MIPS64)
+	statusUX uint32 = 1 << 5
+	statusSX uint32 = 1 << 6
+	statusKX uint32 = 1 << 7
+
 	// Interrupt mask bits IM[7:0] at [15:8]
 	statusIMShift = 8
 
 	causeBD uint32 = 1 << 31
 	causeTI uint32 = 1 << 30 // Timer interrupt
 	causeIV uint32 = 1 << 23 // Interrupt vector select
+	causeWP uint32 = 1 << 22 // Watch exception deferred while EXL or ERL was set
 
 	// IP bits at [15:8]
 	causeIPShift = 8
+
+	config0ARShift        = 10 // architecture release at [12:10]: 1 for R2, 2 for R6
+	config0ARMask  uint32 = 7 << config0ARShift
+	config0ATShift        = 13 // architecture type at [14:13]: 0 for MIPS32, 2 for MIPS64
+	config0ATMask  uint32 = 3 << config0ATShift
+
+	// Context: PTEBase at [31:23], BadVPN2 (VA[31:13]) at [22:4]
+	contextBadVPN2Mask uint32 = 0x7FFFF0
+
+	// XContext: PTEBase at [63:33], R (VA[63:62]) at [32:31], BadVPN2 (VA[39:13]) at [30:4]
+	xcontextPTEBaseMask uint64 = 0xFFFFFFFE00000000
+
+	config1CA uint32 = 1 << 2 // MIPS16e implemented
+
+	// EBase: CPUNum at [9:0], exception base at [29:12]
+	ebaseCPUNumMask uint32 = 0x3FF
+	ebaseBaseMask   uint32 = 0xFFFFF000
 )
 
 // NewCOP0 creates a new CP0 with a TLB of the given size.
@@ -150,12 +187,27 @@ func NewCOP0(tlbSize int) *COP0 {
 	// We'll set MMs (TLB entries - 1) in MMU size.
 	mmuSize := uint32(tlbSize - 1)
 	c.config1 = (mmuSize & 0xF) << 25 // Note: actual field is [25:22]; we place starting at 25 and ignore other fields.
+	c.config1 |= config1CA
 
 	// Count/Compare zeroed.
 
 	return c
 }
 
+// setRelease reports isa in Config0.AR and AT. Release 6 drops MIPS16e.
+func (c *COP0) setRelease(isa ISA) {
+	ar, at, ca := uint32(1), uint32(0), config1CA
+	switch isa {
+	case ISAR6:
+		ar, ca = 2, 0
+	case ISAMIPS64:
+		at = 2
+	}
+	c.config0 = c.config0&^(config0ARMask|config0ATMask) | ar<<config0ARShift | at<<config0ATShift
+	c.config1 = c.config1&^config1CA | ca
+	c.mips64 = isa == ISAMIPS64
+}
+
 // Read returns the value of CP0 register (reg,sel).
 func (c *COP0) Read(reg, sel int) uint32 {
 	switch reg {
@@ -243,7 +295,11 @@ func (c *COP0) Read(reg, sel int) uint32 {
 		}
 	case cp0RegXContext:
 		if sel == 0 {
-			return c.xcontext
+			return uint32(c.xcontext)
+		}
+	case cp0RegTagLo:
+		if sel == 0 || sel == 2 {
+			return c.tagLo
 		}
 	case cp0RegErrorEPC:
 		if sel == 0 {
@@ -346,6 +402,10 @@ func (c *COP0) Write(reg, sel int, val uint32) {
 			} else {
 				c.cause &^= causeIV
 			}
+			// WP is cleared by software once the deferred watch was handled
+			if (val & causeWP) == 0 {
+				c.cause &^= causeWP
+			}
 			// Update SW IP0/IP1
 			sw := (val >> causeIPShift) & 0x3
 			c.cause &^= 0x3 << causeIPShift
@@ -360,15 +420,15 @@ func (c *COP0) Write(reg, sel int, val uint32) {
 		case 0:
 			// PRId is RO; ignore writes
 		case 1:
-			// EBase writable partially; we'll accept full for simplicity
-			c.ebase = val
+			// EBase writable partially; we'll accept full for simplicity,
+			// except CPUNum [9:0] which is read-only
+			c.ebase = (val &^ ebaseCPUNumMask) | (c.ebase & ebaseCPUNumMask)
 		}
 	case cp0RegConfig:
 		switch sel {
 		case 0:
-			// Config0 partially writable; accept K0 (2:0); preserve M bit if set
-			m := c.config0 & (1 << 31)
-			c.config0 = m | (val & 0x7)
+			// Config0 partially writable; accept K0 (2:0), the other fields are read-only
+			c.config0 = c.config0&^0x7 | val&0x7
 		case 1:
 			// Config1 RO in our model
 		}
@@ -382,11 +442,18 @@ func (c *COP0) Write(reg, sel int, val uint32) {
 		}
 	case cp0RegWatchHi:
 		if sel == 0 {
-			c.watchHi = val
+			// G, ASID and Mask are writable; the I/R/W status bits are
+			// write-one-to-clear; M is 0 since there is a single pair
+			status := c.watchHi & watchHiStatusMask &^ val
+			c.watchHi = val&(watchHiG|watchHiASIDMask|watchHiMaskMask) | status
 		}
 	case cp0RegXContext:
 		if sel == 0 {
-			c.xcontext = val
+			c.xcontext = uint64(int32(val))
+		}
+	case cp0RegTagLo:
+		if sel == 0 || sel == 2 {
+			c.tagLo = val
 		}
 	case cp0RegErrorEPC:
 		if sel == 0 {
@@ -395,6 +462,35 @@ func (c *COP0) Write(reg, sel int, val uint32) {
 	}
 }
 
+// Read64 returns the value of CP0 register (reg,sel) as DMFC0 sees it:
+// XContext in full, the 32-bit registers sign-extended.
+func (c *COP0) Read64(reg, sel int) uint64 {
+	if reg == cp0RegXContext && sel == 0 {
+		return c.xcontext
+	}
+	return uint64(int32(c.Read(reg, sel)))
+}
+
+// Write64 sets CP0 register (reg,sel) from DMTC0. Only PTEBase of XContext
+// is writable, the 32-bit registers take the low word.
+func (c *COP0) Write64(reg, sel int, val uint64) {
+	if reg == cp0RegXContext && sel == 0 {
+		c.xcontext = c.xcontext&^xcontextPTEBaseMask | val&xcontextPTEBaseMask
+		return
+	}
+	c.Write(reg, sel, uint32(val))
+}
+
+// SetCPUNum sets EBase.CPUNum, which identifies the core in a multi-core system.
+func (c *COP0) SetCPUNum(n int) {
+	c.ebase = (c.ebase &^ ebaseCPUNumMask) | (uint32(n) & ebaseCPUNumMask)
+}
+
+// CPUNum returns EBase.CPUNum.
+func (c *COP0) CPUNum() int {
+	return int(c.ebase & ebaseCPUNumMask)
+}
+
 // Step should be called periodically (e.g., per instruction) to update Random.
 func (c *COP0) Step() {
 	if c.tlbSize <= 0 {
@@ -413,18 +509,59 @@ func (c *COP0) Step() {
 	}
 }
 
-// Tick adds cycles to Count and asserts timer interrupt when Count==Compare and Compare!=0.
+// StepN is equivalent to calling Step n times.
+func (c *COP0) StepN(n uint32) {
+	if n == 0 || c.tlbSize <= 0 {
+		return
+	}
+	// the first step brings Random back into [wired..tlbSize-1]
+	c.Step()
+	n--
+
+	rmax := uint32(c.tlbSize - 1)
+	if c.wired > rmax {
+		return
+	}
+	if c.random-c.wired >= n {
+		c.random -= n // no wrap-around
+		return
+	}
+	span := rmax - c.wired + 1
+	pos := (c.random - c.wired + span - n%span) % span
+	c.random = c.wired + pos
+}
+
+// Tick adds cycles to Count and asserts timer interrupt when Count reaches Compare and Compare!=0.
 func (c *COP0) Tick(cycles uint32) {
 	prev := c.count
 	c.count += cycles
-	_ = prev
-	if c.compare != 0 && c.count == c.compare {
+	// Count passed Compare somewhere in (prev, prev+cycles]
+	if c.compare != 0 && cycles != 0 && c.compare-prev-1 < cycles {
 		c.cause |= causeTI
 		// Set IP7
 		c.cause |= 1 << (causeIPShift + 7)
 	}
 }
 
+// CyclesUntilTimer returns how many cycles remain until Count reaches Compare,
+// or 0 if the timer is disabled.
+func (c *COP0) CyclesUntilTimer() uint32 {
+	if c.compare == 0 {
+		return 0
+	}
+	d := c.compare - c.count
+	if d == 0 {
+		d = ^uint32(0) // just matched; next match after a full wrap
+	}
+	return d
+}
+
+// advance accounts for n retired instructions of one cycle each.
+func (c *COP0) advance(n uint32) {
+	c.Tick(n)
+	c.StepN(n)
+}
+
 // SetHWInterrupt sets pending state of a hardware interrupt line [2..6] (IP2..IP6).
 func (c *COP0) SetHWInterrupt(line int, pending bool) {
 	if line < 2 || line > 6 {
@@ -463,42 +600,102 @@ func (c *COP0) PendingInterrupt() bool {
 }
 
 // RaiseException sets Cause.ExcCode, EPC/BD, sets EXL, and returns the exception vector address.
-// If inDelaySlot is true, EPC gets pc-4 and BD=1.
+// If inDelaySlot is true, EPC gets pc-4 and BD=1. EPC and BD are left alone if EXL was already set.
 func (c *COP0) RaiseException(excCode uint8, pc uint32, inDelaySlot bool) uint32 {
 	// Set ExcCode in Cause [6:2]
 	c.cause &^= 0x7C
 	c.cause |= uint32(excCode&0x1F) << 2
 
-	if inDelaySlot {
-		c.cause |= causeBD
-		c.epc = pc - 4
-	} else {
-		c.cause &^= causeBD
-		c.epc = pc
+	// EPC and BD are only updated if no exception is being handled already
+	if c.status&statusEXL == 0 {
+		if inDelaySlot {
+			c.cause |= causeBD
+			c.epc = pc - 4
+		} else {
+			c.cause &^= causeBD
+			c.epc = pc
+		}
 	}
 
 	// Set EXL
 	c.status |= statusEXL
 
 	// Choose vector: if IV=1 and excCode==Interrupt, use special offset 0x200
-	// Use BEV=1 path by default (boot vectors at 0xBFC0_0180 / 0xBFC0_0200)
-	baseBEV := uint32(0xBFC00000)
-	vec := baseBEV + 0x180
+	base, _ := c.vectorBase()
 	if excCode == excInt && (c.cause&causeIV) != 0 {
-		vec = baseBEV + 0x200
+		return base + 0x200
 	}
+	return base + 0x180
+}
 
+// vectorBase returns the base of the exception vectors and whether they
+// are the boot vectors.
+func (c *COP0) vectorBase() (base uint32, boot bool) {
 	// If user wants normal vectors (BEV=0), assume 0x8000_0180/0x8000_0200 via EBase
 	// We'll infer BEV from whether ebase has high bit set and not in boot ROM area.
 	// For simplicity, if ebase >= 0x80000000 and < 0xBFC00000, use it as base.
-	if c.ebase >= 0x80000000 && c.ebase < 0xBFC00000 {
-		vec = c.ebase + 0x180
-		if excCode == excInt && (c.cause&causeIV) != 0 {
-			vec = c.ebase + 0x200
-		}
+	if base := c.ebase & ebaseBaseMask; base >= 0x80000000 && base < 0xBFC00000 {
+		return base, false
+	}
+	// Use BEV=1 path by default (boot vectors at 0xBFC0_0180 / 0xBFC0_0200)
+	return 0xBFC00000, true
+}
+
+// TLBRefill raises a TLB refill exception (excTLBL or excTLBS) for vaddr.
+// It records the address in BadVAddr, Context, XContext and EntryHi and
+// returns the refill vector: base+0x000, base+0x080 (XTLB) when the current
+// mode uses 64-bit addresses, or the general vector if EXL was already set.
+// The CPU does not translate addresses through a TLB, so it never raises
+// refills itself; TLBRefill is for embedders that model a TLB.
+func (c *COP0) TLBRefill(excCode uint8, vaddr, pc uint32, inDelaySlot bool) uint32 {
+	// the mode is that of the faulting access, before EXL is set
+	nested, xtlb := c.status&statusEXL != 0, c.addressing64()
+
+	va := uint64(int32(vaddr))
+	c.badVAddr = vaddr
+	c.context = c.context&^contextBadVPN2Mask | vaddr>>13<<4&contextBadVPN2Mask
+	c.xcontext = c.xcontext&xcontextPTEBaseMask | va>>62<<31 | va>>13&(1<<27-1)<<4
+	c.entryHi = vaddr&0xFFFFE000 | c.entryHi&0xFF
+
+	vec := c.RaiseException(excCode, pc, inDelaySlot)
+	if nested {
+		return vec
 	}
 
-	return vec
+	base, boot := c.vectorBase()
+	if boot {
+		// BEV=1 puts the refill vectors at 0xBFC00200, before the general
+		// vector at 0xBFC00380. The general vector here is 0xBFC00180, so
+		// a boot handler there has 0x80 bytes before it runs into the
+		// refill vector.
+		base += 0x200
+	}
+	if xtlb {
+		return base + 0x080
+	}
+	return base
+}
+
+// cop0Usable reports whether privileged instructions may run: in kernel
+// mode, or with Status.CU0 set.
+func (c *COP0) cop0Usable() bool {
+	return c.status&(statusEXL|statusERL) != 0 || c.status&statusKSUMask == 0 || c.status&statusCU0 != 0
+}
+
+// addressing64 reports whether the current mode uses 64-bit addresses,
+// as set by Status.KX, SX or UX.
+func (c *COP0) addressing64() bool {
+	if !c.mips64 {
+		return false
+	}
+	switch {
+	case c.status&(statusEXL|statusERL) != 0 || c.status&statusKSUMask == 0:
+		return c.status&statusKX != 0
+	case c.status&statusKSUMask == statusKSUSup:
+		return c.status&statusSX != 0
+	default:
+		return c.status&statusUX != 0
+	}
 }
 
 // ERET returns the next PC and clears EXL/ERL accordingly. Also clears BD.
@@ -546,7 +743,7 @@ func (c *COP0) TLBR() {
 	c.pageMask = e.Mask & 0x01FFE000
 
 	// EntryLo0
-	lo0 := (e.PFN0 & 0xFFFFFC0) // PFN[25:6]
+	lo0 := (e.PFN0 & 0xFFFFF) << 6 // PFN[25:6]
 	lo0 |= uint32(e.C0&0x7) << 3
 	if e.D0 {
 		lo0 |= 1 << 2
@@ -560,7 +757,7 @@ func (c *COP0) TLBR() {
 	c.entryLo0 = lo0
 
 	// EntryLo1
-	lo1 := e.PFN1 & 0xFFFFFC0
+	lo1 := (e.PFN1 & 0xFFFFF) << 6
 	lo1 |= uint32(e.C1&0x7) << 3
 	if e.D1 {
 		lo1 |= 1 << 2
@@ -612,14 +809,14 @@ func (c *COP0) writeTLBAt(idx int) {
 
 	// Decode EntryLo0
 	lo0 := c.entryLo0 & 0x3FFFFFFF
-	e.PFN0 = (lo0 >> 6) & 0xFFFFFC0
+	e.PFN0 = (lo0 >> 6) & 0xFFFFF
 	e.C0 = uint8((lo0 >> 3) & 0x7)
 	e.D0 = (lo0 & (1 << 2)) != 0
 	e.V0 = (lo0 & (1 << 1)) != 0
 
 	// Decode EntryLo1
 	lo1 := c.entryLo1 & 0x3FFFFFFF
-	e.PFN1 = (lo1 >> 6) & 0xFFFFFC0
+	e.PFN1 = (lo1 >> 6) & 0xFFFFF
 	e.C1 = uint8((lo1 >> 3) & 0x7)
 	e.D1 = (lo1 & (1 << 2)) != 0
 	e.V1 = (lo1 & (1 << 1)) != 0
@@ -92,12 +92,12 @@ func TestCOP0Integration(t *testing.T) {
 		t.Errorf("Failed to decode as COP0Instruction for ERET")
 	}
 
-	nextPC, _ := cop0Instr3.Execute(cpu)
-	if nextPC == nil || *nextPC != 0x80001000 {
-		if nextPC == nil {
-			t.Errorf("ERET failed: nextPC is nil")
+	flow, nextPC := cop0Instr3.Execute(cpu)
+	if flow != FlowJump || nextPC != 0x80001000 {
+		if flow != FlowJump {
+			t.Errorf("ERET failed: flow is %d, expected FlowJump", flow)
 		} else {
-			t.Errorf("ERET failed: got PC 0x%x, expected 0x80001000", *nextPC)
+			t.Errorf("ERET failed: got PC 0x%x, expected 0x80001000", nextPC)
 		}
 	}
 
@@ -1,6 +1,7 @@
 package mips32
 
 import (
+	"context"
 	"log"
 	"sync/atomic"
 )
@@ -8,20 +9,47 @@ import (
 const cop0TlbSize int = 16
 
 type CPU struct {
-	registers [32]uint32
+	registers [32]uint64 // sign-extended 32-bit values unless the CPU is 64-bit
 	LO        int32
 	HI        int32
+	loUpper   int32 // upper halves of LO and HI on a 64-bit CPU
+	hiUpper   int32
 	PC        uint32
 	Memory    *Memory
 	running   atomic.Bool
 
 	cp0     *COP0
 	inDelay bool // Indicates if the CPU is in a delay slot
+
+	branchPending bool   // the next instruction is a delay slot
+	branchTarget  uint32 // where to go once the delay slot has executed
+
+	mips16 bool   // ISA mode: PC points at MIPS16e code
+	jumpPC uint32 // the MIPS16e jump whose delay slot is running, for EPC
+
+	isa    ISA        // instruction set release, see SetISA
+	blocks blockCache // decoded instructions keyed by physical address
+
+	extIRQ    atomic.Uint32 // hardware interrupt lines raised by devices, bit n = IPn
+	extSynced uint32        // lines last copied into Cause
+
+	profiler *Profiler // optional, nil when profiling is disabled
+
+	icache, dcache *Cache  // optional L1 cache models
+	timing         *Timing // optional pipeline timing model
+
+	hooks *Hooks // optional embedder callbacks
+
+	stop     atomic.Uint32 // StopReason of the current or last run
+	exitCode int           // for StopExit
+	exc      ExceptionInfo // for StopException and StopBreakpoint
+	limit    uint64        // instruction limit, 0 if none
+	retired  uint64        // instructions executed
 }
 
 func NewCPU(mem *Memory) *CPU {
 	return &CPU{
-		registers: [32]uint32{},
+		registers: [32]uint64{},
 		PC:        0,
 		Memory:    mem,
 		running:   atomic.Bool{},
@@ -30,67 +58,260 @@ func NewCPU(mem *Memory) *CPU {
 	}
 }
 
-// Run starts the CPU execution loop.
-// It fetches, decodes, and executes instructions until stopped.
-func (cpu *CPU) Run() {
-	if cpu.running.Load() {
-		log.Fatal("CPU already running")
-		return
+// Run executes pre-decoded blocks of instructions until the CPU stops or
+// ctx is done, and tells why it stopped. It fails if the CPU is already running.
+func (cpu *CPU) Run(ctx context.Context) (Result, error) {
+	if !cpu.start() {
+		return Result{}, ErrRunning
 	}
 
-	cpu.running.Store(true)
+	if done := ctx.Done(); done != nil {
+		finished := make(chan struct{})
+		defer close(finished)
+		go func() {
+			select {
+			case <-done:
+				cpu.halt(StopCanceled)
+			case <-finished:
+			}
+		}()
+	}
 
+	start := cpu.retired
 	for cpu.running.Load() {
-		instr, ok := cpu.Memory.LoadWord(cpu.PC)
-		if !ok {
-			// Address error on instruction fetch
-			cpu.SetBadVAddr(cpu.PC)
-			vec := cpu.cp0.RaiseException(excAdEL, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			log.Printf("CPU exception at PC 0x%x, jumping to vector 0x%x", cpu.PC, vec)
-			cpu.handleException(excAdEL)
-			continue
+		n := cpu.blockLimit()
+		if n == 0 {
+			break
 		}
+		cpu.retired += uint64(cpu.runBlock(n))
+	}
+	return cpu.result(start), nil
+}
+
+// Step executes a single instruction, or takes a pending interrupt. The
+// result's Reason is StopNone if the CPU can continue. Step does nothing
+// if the CPU is running.
+func (cpu *CPU) Step() Result {
+	if !cpu.start() {
+		return Result{Reason: StopReason(cpu.stop.Load())}
+	}
+	start := cpu.retired
+	if n := cpu.blockLimit(); n != 0 {
+		cpu.retired += uint64(cpu.runBlock(1))
+	}
+	cpu.running.Store(false)
+	return cpu.result(start)
+}
+
+// runSlice executes blocks until at least budget instructions have run or the
+// CPU stops, and returns the number of instructions executed.
+func (cpu *CPU) runSlice(budget int) int {
+	executed := 0
+	for executed < budget && cpu.running.Load() {
+		n := cpu.blockLimit()
+		if n == 0 {
+			break
+		}
+		n = cpu.runBlock(n)
+		cpu.retired += uint64(n)
+		executed += n
+	}
+	return executed
+}
+
+// runBlock executes at most limit instructions from the decoded block at PC
+// until control leaves the block, and returns how many instructions were executed.
+// Exceptions and interrupts are taken at the exact instruction boundary; Count
+// and Random are brought up to date before any instruction that can observe them.
+func (cpu *CPU) runBlock(limit int) int {
+	cpu.syncInterrupts()
+
+	// check pending interrupts
+	if cpu.cp0.PendingInterrupt() {
+		// RaiseException returns the exception vector/next PC
+		cpu.PC = cpu.cp0.RaiseException(excInt, cpu.excPC(cpu.branchPending), cpu.branchPending)
+		cpu.mips16 = false
+		cpu.inDelay = false
+		cpu.branchPending = false
+		return 0
+	}
+	if cpu.cp0.deferredWatch() {
+		cpu.inDelay = cpu.branchPending
+		cpu.raiseException(excWatch)
+		return 0
+	}
+	if cpu.mips16 {
+		return cpu.runMIPS16(limit)
+	}
 
-		// advance the COP0 per-instruction/cycle
-		cpu.cp0.Tick(1)
-		cpu.cp0.Step() // Update Random register per instruction
+	blk := cpu.blocks.lookup(cpu.Memory, cpu.PC)
+	if blk == nil {
+		// Address error on instruction fetch
+		cpu.inDelay = cpu.branchPending
+		cpu.addressError(excAdEL, cpu.PC)
+		return 0
+	}
 
-		// check pending interrupts
-		if cpu.cp0.PendingInterrupt() {
-			// RaiseException should return the exception vector/next PC
-			vec := cpu.cp0.RaiseException(excInt, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
+	// stop right where Count reaches Compare so the timer interrupt is
+	// taken before the next instruction
+	n := min(len(blk.ops), limit)
+	if d := cpu.cp0.CyclesUntilTimer(); d != 0 && d < uint32(n) {
+		n = int(d)
+	}
+
+	gen := cpu.Memory.generation(blk.start)
+	profiler := cpu.profiler
+	timing := cpu.timing
+	watch := cpu.cp0.watchArmed() // watch registers only change through COP0, which ends a block
+	hooks := cpu.hooks
+	if hooks != nil && hooks.Instruction == nil && hooks.Memory == nil {
+		hooks = nil // the remaining hooks are called by the instructions themselves
+	}
+	plain := profiler == nil && cpu.icache == nil && timing == nil && !watch && hooks == nil // no per-instruction bookkeeping
+	executed, synced := 0, 0
+
+	for executed < n {
+		op := &blk.ops[executed]
+		executed++
+
+		// fast path: plain instruction outside a delay slot
+		if op.flags == 0 && !cpu.branchPending && plain {
+			if flow, _ := op.execute(cpu); flow != FlowNext {
+				break // exception, PC already points at the vector
+			}
+			cpu.PC += 4
 			continue
 		}
 
-		// we're decoding the instruction
-		dInstr := DecodeInstruction(instr)
+		if hooks != nil && hooks.Instruction != nil && hooks.Instruction(cpu.PC, op.word) {
+			cpu.Stop()
+			executed-- // the instruction has not run
+			break
+		}
 
-		// and we're executing it.
-		newPC, delaySlot := dInstr.Execute(cpu)
+		// advance the COP0 per-instruction/cycle, lazily unless the
+		// instruction can observe Count/Random
+		if op.flags&opSyncCP0 != 0 && timing == nil {
+			cpu.cp0.advance(uint32(executed - synced))
+			synced = executed
+		}
 
-		// set the delay slot flag
-		cpu.inDelay = delaySlot
+		if profiler != nil {
+			profiler.retire(cpu.PC, op.word)
+		}
+		var iMisses, dMisses uint64
+		if timing != nil {
+			iMisses, dMisses = misses(cpu.icache), misses(cpu.dcache)
+		}
+		if cpu.icache != nil {
+			cpu.fetchAccess(cpu.PC)
+		}
+
+		// the instruction is in a delay slot if the previous one branched
+		inDelay, delayTarget := cpu.branchPending, cpu.branchTarget
+		cpu.inDelay = inDelay
+		cpu.branchPending = false
+
+		if watch {
+			if cpu.checkWatch(cpu.PC, watchLoI) {
+				break
+			}
+			if vaddr, kind := memoryReference(cpu, op); kind != 0 && cpu.checkWatch(vaddr, kind) {
+				break
+			}
+		}
 
-		// check if we have a new PC from the instruction
-		if newPC != nil {
-			// set the PC to the new value
-			cpu.PC = *newPC
-		} else {
-			// otherwise, we just continue to the next instruction sequentially
+		pc := cpu.PC
+		flow, target := op.execute(cpu)
+		cpu.inDelay = false
+
+		switch flow {
+		case FlowNext:
+			if inDelay {
+				cpu.jumpTo(delayTarget)
+			} else {
+				cpu.PC += 4
+			}
+		case FlowBranch:
+			// execute the delay slot first, then go to target
+			cpu.branchPending = true
+			cpu.branchTarget = target
 			cpu.PC += 4
+		case FlowJump:
+			cpu.jumpTo(target)
+		case FlowException:
+			// PC already points at the exception vector
 		}
 
+		if timing != nil {
+			// Count follows the modelled cycles, so it is kept exact
+			taken := flow != FlowException && cpu.PC != pc+4
+			cycles := timing.issue(op, taken, misses(cpu.icache)-iMisses, misses(cpu.dcache)-dMisses)
+			cpu.cp0.advance(uint32(cycles))
+			synced = executed
+			if cpu.cp0.PendingInterrupt() {
+				break // the stalls made the timer fire
+			}
+		}
+
+		if cpu.PC != pc+4 || cpu.mips16 {
+			break // branch taken, jump, exception or switch to MIPS16e
+		}
+		if hooks != nil && !cpu.running.Load() {
+			break // stopped by a hook
+		}
+		if op.flags&opStore != 0 && cpu.Memory.generation(blk.start) != gen {
+			break // the block modified its own code
+		}
+	}
+
+	cpu.cp0.advance(uint32(executed - synced))
+	return executed
+}
+
+// SetInterrupt raises or clears hardware interrupt line [2..6] (IP2..IP6).
+// Unlike COP0.SetHWInterrupt it may be called from any goroutine, e.g. by a
+// device owned by another core; the CPU picks the change up before its next block.
+func (cpu *CPU) SetInterrupt(line int, pending bool) {
+	if line < 2 || line > 6 {
+		return
+	}
+	bit := uint32(1) << line
+	for {
+		old := cpu.extIRQ.Load()
+		lines := old &^ bit
+		if pending {
+			lines |= bit
+		}
+		if cpu.extIRQ.CompareAndSwap(old, lines) {
+			return
+		}
 	}
+}
 
+// syncInterrupts copies the lines set through SetInterrupt into Cause.
+func (cpu *CPU) syncInterrupts() {
+	lines := cpu.extIRQ.Load()
+	if lines == cpu.extSynced {
+		return
+	}
+	for line := 2; line <= 6; line++ {
+		bit := uint32(1) << line
+		if (lines^cpu.extSynced)&bit != 0 {
+			cpu.cp0.SetHWInterrupt(line, lines&bit != 0)
+		}
+	}
+	cpu.extSynced = lines
 }
 
 // Stop halts the CPU execution loop.
 func (cpu *CPU) Stop() {
-	cpu.running.Store(false)
+	cpu.halt(StopHalted)
+}
+
+// CPUNum returns the core number from EBase.CPUNum.
+func (cpu *CPU) CPUNum() int {
+	return cpu.cp0.CPUNum()
 }
 
 // GetReg returns the value of register n (0-31)
@@ -98,15 +319,27 @@ func (cpu *CPU) GetReg(n uint8) uint32 {
 	if n < 0 || n > 31 {
 		return 0
 	}
-	return cpu.registers[n]
+	return uint32(cpu.registers[n])
 }
 
-// SetReg sets the value of register n (0-31), except $0 which is always zero
+// SetReg sets the value of register n (0-31), except $0 which is always zero.
+// As on MIPS64, the 32-bit value is sign-extended.
 func (cpu *CPU) SetReg(n uint8, val uint32) {
-	if n < 0 || n > 31 {
-		return
+	cpu.SetReg64(n, uint64(int32(val)))
+}
+
+// GetReg64 returns the 64-bit value of register n (0-31).
+func (cpu *CPU) GetReg64(n uint8) uint64 {
+	if n > 31 {
+		return 0
 	}
-	if n == 0 {
+	return cpu.registers[n]
+}
+
+// SetReg64 sets the 64-bit value of register n (0-31), except $0 which is
+// always zero.
+func (cpu *CPU) SetReg64(n uint8, val uint64) {
+	if n == 0 || n > 31 {
 		return // $0 is always zero
 	}
 	cpu.registers[n] = val
@@ -127,10 +360,86 @@ func (cpu *CPU) SetBadVAddr(addr uint32) {
 	cpu.cp0.badVAddr = addr
 }
 
+// jumpTo continues execution at target, whose low bit selects MIPS16e.
+func (cpu *CPU) jumpTo(target uint32) {
+	if cpu.isa == ISAR6 {
+		cpu.PC = target // no MIPS16e: an odd target faults on fetch
+		return
+	}
+	cpu.mips16 = target&1 != 0
+	cpu.PC = target &^ 1
+}
+
+// SetISA selects the instruction set release the CPU executes, and reports
+// it in the AR field of Config. Code already decoded is dropped, and the
+// registers are truncated to 32 bits unless isa is ISAMIPS64.
+func (cpu *CPU) SetISA(isa ISA) {
+	if isa != ISAMIPS64 {
+		for i := range cpu.registers {
+			cpu.registers[i] = uint64(int32(cpu.registers[i]))
+		}
+		cpu.setHILO(cpu.HI, cpu.LO)
+	}
+	cpu.isa = isa
+	cpu.blocks = blockCache{isa: isa}
+	cpu.cp0.setRelease(isa)
+}
+
+// ISA returns the instruction set release the CPU executes.
+func (cpu *CPU) ISA() ISA {
+	return cpu.isa
+}
+
+// SetPC sets the address of the next instruction, which is MIPS16e code if
+// the low bit of pc is set.
+func (cpu *CPU) SetPC(pc uint32) {
+	cpu.jumpTo(pc)
+}
+
+// MIPS16 reports whether the CPU executes MIPS16e code.
+func (cpu *CPU) MIPS16() bool {
+	return cpu.mips16
+}
+
+// excPC returns the address of the instruction at PC as reported in EPC:
+// in MIPS16e mode its low bit is set, and in a delay slot it is the jump
+// plus 4, so that EPC points at the jump whatever its size.
+func (cpu *CPU) excPC(inDelay bool) uint32 {
+	if !cpu.mips16 {
+		return cpu.PC
+	}
+	if inDelay {
+		return (cpu.jumpPC + 4) | 1
+	}
+	return cpu.PC | 1
+}
+
+// raiseException delivers exception exc for the instruction at PC and
+// redirects execution to the exception vector, in MIPS32 mode.
+func (cpu *CPU) raiseException(exc uint8) (Flow, uint32) {
+	vec := cpu.cp0.RaiseException(exc, cpu.excPC(cpu.inDelay), cpu.inDelay)
+	cpu.PC = vec
+	cpu.mips16 = false
+	cpu.inDelay = false
+	cpu.branchPending = false
+	cpu.handleException(int(exc))
+	return FlowException, vec
+}
+
+// addressError raises an address error exception (AdEL/AdES) for vaddr.
+func (cpu *CPU) addressError(exc uint8, vaddr uint32) (Flow, uint32) {
+	cpu.SetBadVAddr(vaddr)
+	return cpu.raiseException(exc)
+}
+
 // handleException processes the exception with the given code.
 // The exception has already been raised via cp0.RaiseException() and PC is set to vector.
 // This function handles any additional CPU-specific logic or logging.
 func (cpu *CPU) handleException(exc int) {
+	if h := cpu.hooks; h != nil && h.Exception != nil && h.Exception(uint8(exc), cpu.cp0.epc, cpu.cp0.badVAddr) {
+		cpu.Stop()
+	}
+
 	switch exc {
 	case excInt:
 		// Interrupt - handled by exception vector, execution continues
@@ -140,31 +449,31 @@ func (cpu *CPU) handleException(exc int) {
 		// TLB modification exception (write to read-only page)
 		log.Printf("TLB Modification exception at PC 0x%x, BadVAddr 0x%x",
 			cpu.cp0.epc, cpu.cp0.badVAddr)
-		cpu.Stop()
+		cpu.haltOnException(StopException)
 
 	case excTLBL:
 		// TLB exception on load/instruction fetch
 		log.Printf("TLB Load exception at PC 0x%x, BadVAddr 0x%x",
 			cpu.cp0.epc, cpu.cp0.badVAddr)
-		cpu.Stop()
+		cpu.haltOnException(StopException)
 
 	case excTLBS:
 		// TLB exception on store
 		log.Printf("TLB Store exception at PC 0x%x, BadVAddr 0x%x",
 			cpu.cp0.epc, cpu.cp0.badVAddr)
-		cpu.Stop()
+		cpu.haltOnException(StopException)
 
 	case excAdEL:
 		// Address error on load/instruction fetch (misaligned or invalid address)
 		log.Printf("Address Error Load exception at PC 0x%x, BadVAddr 0x%x",
 			cpu.cp0.epc, cpu.cp0.badVAddr)
-		cpu.Stop()
+		cpu.haltOnException(StopException)
 
 	case excAdES:
 		// Address error on store (misaligned or invalid address)
 		log.Printf("Address Error Store exception at PC 0x%x, BadVAddr 0x%x",
 			cpu.cp0.epc, cpu.cp0.badVAddr)
-		cpu.Stop()
+		cpu.haltOnException(StopException)
 
 	case excSys:
 		// Syscall exception - could be handled by OS/handler at vector
@@ -174,31 +483,35 @@ func (cpu *CPU) handleException(exc int) {
 	case excBp:
 		// Breakpoint exception
 		log.Printf("Breakpoint exception at PC 0x%x", cpu.cp0.epc)
-		cpu.Stop()
+		cpu.haltOnException(StopBreakpoint)
 
 	case excRI:
 		// Reserved instruction exception
 		log.Printf("Reserved Instruction exception at PC 0x%x", cpu.cp0.epc)
-		cpu.Stop()
+		cpu.haltOnException(StopException)
 
 	case excCpU:
 		// Coprocessor unusable exception
 		log.Printf("Coprocessor Unusable exception at PC 0x%x", cpu.cp0.epc)
-		cpu.Stop()
+		cpu.haltOnException(StopException)
 
 	case excOv:
 		// Arithmetic overflow exception
 		log.Printf("Arithmetic Overflow exception at PC 0x%x", cpu.cp0.epc)
-		cpu.Stop()
+		cpu.haltOnException(StopException)
 
 	case excTr:
 		// Trap exception
 		log.Printf("Trap exception at PC 0x%x", cpu.cp0.epc)
-		cpu.Stop()
+		cpu.haltOnException(StopException)
+
+	case excWatch:
+		// Watchpoint hit - handled by the guest's debugger at the vector
+		log.Printf("Watch exception at PC 0x%x", cpu.cp0.epc)
 
 	default:
 		// Unknown exception code
 		log.Printf("Unknown exception %d at PC 0x%x", exc, cpu.PC)
-		cpu.Stop()
+		cpu.haltOnException(StopException)
 	}
 }
@@ -0,0 +1,160 @@
+package mips32
+
+import (
+	"context"
+	"testing"
+)
+
+// reservedInstr is an unimplemented opcode; executing it raises RI which stops the CPU.
+const reservedInstr = uint32(0xFC000000)
+
+// runProgram loads words at address 0 and runs them until the CPU stops.
+func runProgram(t *testing.T, words ...uint32) *CPU {
+	t.Helper()
+	mem := NewMemory(0x2000)
+	for i, w := range words {
+		mem.StoreWord(uint32(i*4), w)
+	}
+	cpu := NewCPU(mem)
+	cpu.Run(context.Background())
+	return cpu
+}
+
+func TestRunExecutesDelaySlot(t *testing.T) {
+	cpu := runProgram(t,
+		0x0C000004, // jal 0x10
+		0x24080005, // addiu $t0, $zero, 5 (delay slot)
+		reservedInstr,
+		0x00000000,
+		0x01004825, // 0x10: or $t1, $t0, $zero
+		reservedInstr,
+	)
+
+	if got := cpu.GetReg(9); got != 5 {
+		t.Errorf("$t1 = %d, want 5 (delay slot must run before the jump target)", got)
+	}
+	if got := cpu.GetReg(31); got != 8 {
+		t.Errorf("$ra = 0x%x, want 0x8", got)
+	}
+}
+
+func TestRunBranches(t *testing.T) {
+	cpu := runProgram(t,
+		0x24080003, // addiu $t0, $zero, 3
+		0x25290001, // loop: addiu $t1, $t1, 1
+		0x2508FFFF, // addiu $t0, $t0, -1
+		0x1500FFFD, // bne $t0, $zero, loop
+		0x254A0001, // addiu $t2, $t2, 1 (delay slot, runs every iteration)
+		0x51600001, // beql $t3, $zero, +1 -> taken
+		0x256B0001, // addiu $t3, $t3, 1 (delay slot, runs because taken)
+		0x51600001, // beql $t3, $zero, +1 -> not taken, delay slot nullified
+		0x256B0001, // addiu $t3, $t3, 1 (nullified)
+		reservedInstr,
+	)
+
+	if got := cpu.GetReg(9); got != 3 {
+		t.Errorf("$t1 = %d, want 3", got)
+	}
+	if got := cpu.GetReg(10); got != 3 {
+		t.Errorf("$t2 = %d, want 3", got)
+	}
+	if got := cpu.GetReg(11); got != 1 {
+		t.Errorf("$t3 = %d, want 1", got)
+	}
+}
+
+func TestRunSelfModifyingCode(t *testing.T) {
+	cpu := runProgram(t,
+		0x24080007, // addiu $t0, $zero, 7
+		0x8C090010, // lw $t1, 0x10($zero)  (the addiu $t2 below)
+		0xAC090014, // sw $t1, 0x14($zero)  (overwrite the reserved instruction)
+		0x00000000, // nop
+		0x254A0001, // 0x10: addiu $t2, $t2, 1
+		reservedInstr,
+		reservedInstr,
+	)
+
+	if got := cpu.GetReg(10); got != 2 {
+		t.Errorf("$t2 = %d, want 2 (patched instruction must be executed)", got)
+	}
+}
+
+func TestExceptionInDelaySlotIsPrecise(t *testing.T) {
+	mem := NewMemory(0x2000)
+	words := []uint32{
+		0x3C087FFF, // lui $t0, 0x7fff
+		0x3508FFFF, // ori $t0, $t0, 0xffff
+		0x10000004, // beq $zero, $zero, +4
+		0x21080001, // addi $t0, $t0, 1 (delay slot, overflows)
+	}
+	for i, w := range words {
+		mem.StoreWord(uint32(i*4), w)
+	}
+	cpu := NewCPU(mem)
+	cpu.Run(context.Background())
+
+	if cpu.cp0.epc != 0x8 {
+		t.Errorf("EPC = 0x%x, want 0x8 (the branch)", cpu.cp0.epc)
+	}
+	if cpu.cp0.cause&causeBD == 0 {
+		t.Errorf("Cause.BD not set for an exception in a delay slot")
+	}
+	if code := (cpu.cp0.cause >> 2) & 0x1F; code != excOv {
+		t.Errorf("ExcCode = %d, want %d", code, excOv)
+	}
+}
+
+func TestCOP0StepN(t *testing.T) {
+	for _, n := range []uint32{0, 1, 5, 15, 16, 17, 100} {
+		a, b := NewCOP0(16), NewCOP0(16)
+		a.Write(cp0RegWired, 0, 3)
+		b.Write(cp0RegWired, 0, 3)
+		for i := uint32(0); i < n; i++ {
+			a.Step()
+		}
+		b.StepN(n)
+		if a.random != b.random {
+			t.Errorf("StepN(%d): Random = %d, want %d", n, b.random, a.random)
+		}
+	}
+}
+
+func TestTimerInterruptBetweenBlocks(t *testing.T) {
+	c := NewCOP0(16)
+	c.compare = 10
+	c.Tick(9)
+	if c.cause&causeTI != 0 {
+		t.Fatalf("timer fired early")
+	}
+	if d := c.CyclesUntilTimer(); d != 1 {
+		t.Errorf("CyclesUntilTimer = %d, want 1", d)
+	}
+	c.Tick(5)
+	if c.cause&causeTI == 0 {
+		t.Errorf("timer did not fire when Count passed Compare")
+	}
+}
+
+func BenchmarkRunLoop(b *testing.B) {
+	const iters = 1 << 15
+	mem := NewMemory(0x1000)
+	for i, w := range []uint32{
+		0x34080000 | iters, // ori $t0, $zero, iters
+		0x25290001,         // loop: addiu $t1, $t1, 1
+		0x254A0001,         // addiu $t2, $t2, 1
+		0x256B0001,         // addiu $t3, $t3, 1
+		0x2508FFFF,         // addiu $t0, $t0, -1
+		0x1500FFFB,         // bne $t0, $zero, loop
+		0x00000000,         // nop
+		reservedInstr,
+	} {
+		mem.StoreWord(uint32(i*4), w)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cpu := NewCPU(mem)
+		cpu.Run(context.Background())
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*iters*6), "ns/instr")
+}
@@ -1 +1,507 @@
 package mips32
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ArgKind is the kind of an instruction operand.
+type ArgKind uint8
+
+const (
+	ArgReg     ArgKind = iota // general-purpose register Reg
+	ArgImm                    // signed immediate Imm, shown in decimal
+	ArgUImm                   // unsigned immediate Imm, shown in hex
+	ArgMem                    // memory operand Imm(Reg)
+	ArgTarget                 // branch or jump target address Imm
+	ArgCP0Reg                 // coprocessor 0 register Reg
+	ArgCopReg                 // register Reg of another coprocessor or hardware register
+	ArgFPReg                  // floating-point register Reg
+	ArgFCC                    // floating-point condition code Reg
+	ArgIndexed                // memory operand Imm(Reg) with index register Imm
+)
+
+func (k ArgKind) String() string {
+	switch k {
+	case ArgReg:
+		return "reg"
+	case ArgImm:
+		return "imm"
+	case ArgUImm:
+		return "uimm"
+	case ArgMem:
+		return "mem"
+	case ArgTarget:
+		return "target"
+	case ArgCP0Reg:
+		return "cp0reg"
+	case ArgCopReg:
+		return "copreg"
+	case ArgFPReg:
+		return "fpreg"
+	case ArgFCC:
+		return "fcc"
+	case ArgIndexed:
+		return "indexed"
+	}
+	return fmt.Sprintf("ArgKind(%d)", k)
+}
+
+// Arg is an operand of a decoded instruction.
+type Arg struct {
+	Kind ArgKind
+	Reg  uint8
+	Imm  int64
+}
+
+// Inst is an instruction decoded for display.
+type Inst struct {
+	Addr uint32
+	Word uint32
+	Op   string // mnemonic, empty if Word is not a known instruction
+	Args []Arg
+
+	format insnFormat
+	size   uint8 // for MIPS16e: 2 or 4 bytes
+}
+
+// RegNames selects how registers are printed.
+type RegNames uint8
+
+const (
+	ABIRegs     RegNames = iota // $t0, $sp
+	NumericRegs                 // $8, $29
+)
+
+// RegName returns the name of general-purpose register r, without the '$'.
+func RegName(r uint8, names RegNames) string {
+	if names == ABIRegs {
+		return abiRegNames[r&31]
+	}
+	return strconv.Itoa(int(r & 31))
+}
+
+// decodeEntry is a native instruction as seen by the decoder: the word
+// matches if word&mask == spec.word.
+type decodeEntry struct {
+	name string
+	spec insnSpec
+	mask uint32
+}
+
+// decodeTable holds the entries of asmInstructions.
+var decodeTable = newDecodeTable(asmInstructions)
+
+// newDecodeTable arranges specs by primary opcode, the most specific mask first.
+func newDecodeTable(specs map[string]insnSpec) (table [64][]decodeEntry) {
+	for name, spec := range specs {
+		op := spec.word >> 26
+		table[op] = append(table[op], decodeEntry{name: name, spec: spec, mask: decodeMask(spec)})
+	}
+	for _, entries := range table {
+		sort.Slice(entries, func(i, j int) bool {
+			a, b := bits.OnesCount32(entries[i].mask), bits.OnesCount32(entries[j].mask)
+			return a > b || a == b && entries[i].name < entries[j].name
+		})
+	}
+	return table
+}
+
+// decodeMask returns the bits of a word that identify the instruction spec:
+// everything but its operand fields.
+func decodeMask(spec insnSpec) uint32 {
+	op := spec.word >> 26
+	switch spec.format {
+	case fmtNone:
+		switch {
+		case op == 0 || op == uint32(OpCodeSPECIAL2):
+			return 0xFC00003F // SPECIAL with a code field
+		case spec.word == cop0Op(COP0Funct_WAIT):
+			return 0xFE00003F
+		}
+		return 0xFFFFFFFF
+	case fmtR3, fmtShiftV, fmtRdRs:
+		return 0xFC0007FF
+	case fmtShift, fmtFP3, fmtFPMovR:
+		return 0xFFE0003F
+	case fmtRsRt:
+		if funct := spec.word & 0x3F; op == 0 && funct >= 0x30 && funct <= 0x36 {
+			return 0xFC00003F // traps have a code field
+		}
+		return 0xFC00FFFF
+	case fmtRdRt, fmtRDHWR, fmtCopMove, fmtFPUMove:
+		return 0xFFE007FF
+	case fmtRt:
+		return 0xFFE0FFFF
+	case fmtBitField, fmtFP4:
+		return 0xFC00003F
+	case fmtMovCC:
+		return 0xFC0307FF
+	case fmtCOP2:
+		return 0xFE000000
+	case fmtFP2:
+		return 0xFFFF003F
+	case fmtFPMovCC:
+		return 0xFFE3003F
+	case fmtFPCmp:
+		return 0xFFE000FF
+	case fmtFPUIndexed:
+		if spec.word&8 != 0 {
+			return 0xFC0007FF // stores, fd zero
+		}
+		return 0xFC00F83F // loads, fs zero
+	case fmtBranchCC:
+		return 0xFFE30000
+	case fmtRd:
+		return 0xFFFF07FF
+	case fmtRs:
+		return 0xFC1FFFFF
+	case fmtJALR:
+		return 0xFC1F07FF
+	case fmtLUI:
+		return 0xFFE00000
+	case fmtBranch1, fmtTrapI, fmtAddr:
+		return 0xFC1F0000
+	case fmtCOP0:
+		return 0xFFE007F8
+	}
+	return 0xFC000000
+}
+
+// Decode decodes the instruction word found at addr.
+func Decode(word, addr uint32) Inst {
+	inst := Inst{Addr: addr, Word: word}
+	if word == 0 {
+		inst.Op = "nop" // sll $zero, $zero, 0
+		return inst
+	}
+	for _, e := range decodeTable[word>>26] {
+		if word&e.mask == e.spec.word {
+			inst.Op, inst.format = e.name, e.spec.format
+			inst.Args = decodeArgs(e.spec.format, word, addr)
+			return inst
+		}
+	}
+	return inst
+}
+
+// decodeArgs extracts the operands of an instruction of format f.
+func decodeArgs(f insnFormat, word, addr uint32) []Arg {
+	rs, rt, rd := uint8(word>>21&31), uint8(word>>16&31), uint8(word>>11&31)
+	sa, cc := uint8(word>>6&31), uint8(word>>18&7)
+	reg := func(r uint8) Arg { return Arg{Kind: ArgReg, Reg: r} }
+	fpr := func(r uint8) Arg { return Arg{Kind: ArgFPReg, Reg: r} }
+	fcc := Arg{Kind: ArgFCC, Reg: cc}
+	imm := int64(int16(word))
+	branch := Arg{Kind: ArgTarget, Imm: int64(addr + 4 + uint32(imm<<2))}
+
+	switch f {
+	case fmtNone:
+		code := word >> 6 & 0xFFFFF
+		if word>>26 == uint32(OpCodeCOP0) {
+			code &= 0x7FFFF // wait
+		}
+		if code != 0 {
+			return []Arg{{Kind: ArgUImm, Imm: int64(code)}}
+		}
+		return nil
+	case fmtR3:
+		return []Arg{reg(rd), reg(rs), reg(rt)}
+	case fmtShift:
+		return []Arg{reg(rd), reg(rt), {Kind: ArgImm, Imm: int64(word >> 6 & 31)}}
+	case fmtShiftV:
+		return []Arg{reg(rd), reg(rt), reg(rs)}
+	case fmtRsRt:
+		return []Arg{reg(rs), reg(rt)}
+	case fmtRd:
+		return []Arg{reg(rd)}
+	case fmtRs:
+		return []Arg{reg(rs)}
+	case fmtJALR:
+		if rd == 31 {
+			return []Arg{reg(rs)}
+		}
+		return []Arg{reg(rd), reg(rs)}
+	case fmtArithI:
+		return []Arg{reg(rt), reg(rs), {Kind: ArgImm, Imm: imm}}
+	case fmtLogicI:
+		return []Arg{reg(rt), reg(rs), {Kind: ArgUImm, Imm: int64(word & 0xFFFF)}}
+	case fmtLUI:
+		return []Arg{reg(rt), {Kind: ArgUImm, Imm: int64(word & 0xFFFF)}}
+	case fmtMem:
+		return []Arg{reg(rt), {Kind: ArgMem, Reg: rs, Imm: imm}}
+	case fmtCache:
+		return []Arg{{Kind: ArgUImm, Imm: int64(rt)}, {Kind: ArgMem, Reg: rs, Imm: imm}}
+	case fmtBranch2:
+		return []Arg{reg(rs), reg(rt), branch}
+	case fmtBranch1:
+		return []Arg{reg(rs), branch}
+	case fmtJump:
+		// target = (PC+4)[31:28] || instr_index || 00
+		return []Arg{{Kind: ArgTarget, Imm: int64((addr+4)&0xF0000000 | word&0x3FFFFFF<<2)}}
+	case fmtCOP0:
+		args := []Arg{reg(rt), {Kind: ArgCP0Reg, Reg: rd}}
+		if sel := word & 7; sel != 0 {
+			args = append(args, Arg{Kind: ArgImm, Imm: int64(sel)})
+		}
+		return args
+	case fmtTrapI:
+		return []Arg{reg(rs), {Kind: ArgImm, Imm: imm}}
+	case fmtRdRs:
+		return []Arg{reg(rd), reg(rs)}
+	case fmtRdRt:
+		return []Arg{reg(rd), reg(rt)}
+	case fmtRt:
+		if rt == 0 {
+			return nil
+		}
+		return []Arg{reg(rt)}
+	case fmtRDHWR, fmtCopMove:
+		return []Arg{reg(rt), {Kind: ArgCopReg, Reg: rd}}
+	case fmtBitField:
+		size := int64(rd) + 1 // ext: msbd is size-1
+		if word&0x3F == 0x04 {
+			size -= int64(sa) // ins: msb is pos+size-1
+		}
+		return []Arg{reg(rt), reg(rs), {Kind: ArgImm, Imm: int64(sa)}, {Kind: ArgImm, Imm: size}}
+	case fmtAddr:
+		return []Arg{{Kind: ArgMem, Reg: rs, Imm: imm}}
+	case fmtMovCC:
+		return []Arg{reg(rd), reg(rs), fcc}
+	case fmtCopMem:
+		return []Arg{{Kind: ArgCopReg, Reg: rt}, {Kind: ArgMem, Reg: rs, Imm: imm}}
+	case fmtCOP2:
+		return []Arg{{Kind: ArgUImm, Imm: int64(word & 0x1FFFFFF)}}
+	case fmtFPUMove:
+		return []Arg{reg(rt), fpr(rd)}
+	case fmtFPUMem:
+		return []Arg{fpr(rt), {Kind: ArgMem, Reg: rs, Imm: imm}}
+	case fmtFPUIndexed:
+		fr := sa // loads write fd
+		if word&8 != 0 {
+			fr = rd // stores read fs
+		}
+		return []Arg{fpr(fr), {Kind: ArgIndexed, Reg: rs, Imm: int64(rt)}}
+	case fmtFP3:
+		return []Arg{fpr(sa), fpr(rd), fpr(rt)}
+	case fmtFP2:
+		return []Arg{fpr(sa), fpr(rd)}
+	case fmtFP4:
+		return []Arg{fpr(sa), fpr(rs), fpr(rd), fpr(rt)}
+	case fmtFPMovR:
+		return []Arg{fpr(sa), fpr(rd), reg(rt)}
+	case fmtFPMovCC:
+		return []Arg{fpr(sa), fpr(rd), fcc}
+	case fmtFPCmp:
+		fcc.Reg = uint8(word >> 8 & 7)
+		if fcc.Reg == 0 {
+			return []Arg{fpr(rd), fpr(rt)}
+		}
+		return []Arg{fcc, fpr(rd), fpr(rt)}
+	case fmtBranchCC:
+		if cc == 0 {
+			return []Arg{branch}
+		}
+		return []Arg{fcc, branch}
+	}
+	return nil
+}
+
+// String formats the instruction with ABI register names.
+func (inst Inst) String() string {
+	return inst.Format(ABIRegs)
+}
+
+// Format formats the instruction in assembler syntax. Words that are not
+// instructions are shown as .word directives.
+func (inst Inst) Format(names RegNames) string {
+	if inst.Op == "" && inst.Size() == 2 {
+		return fmt.Sprintf(".half 0x%04x", inst.Word)
+	}
+	if inst.Op == "" {
+		return fmt.Sprintf(".word 0x%08x", inst.Word)
+	}
+	if len(inst.Args) == 0 {
+		return inst.Op
+	}
+	args := make([]string, len(inst.Args))
+	for i, a := range inst.Args {
+		args[i] = a.Format(names)
+	}
+	return inst.Op + " " + strings.Join(args, ", ")
+}
+
+// Format formats the operand in assembler syntax.
+func (a Arg) Format(names RegNames) string {
+	switch a.Kind {
+	case ArgReg:
+		return "$" + RegName(a.Reg, names)
+	case ArgImm:
+		return strconv.FormatInt(a.Imm, 10)
+	case ArgUImm:
+		return fmt.Sprintf("0x%x", a.Imm)
+	case ArgMem:
+		return fmt.Sprintf("%d($%s)", a.Imm, RegName(a.Reg, names))
+	case ArgTarget:
+		return fmt.Sprintf("0x%08x", uint32(a.Imm))
+	case ArgCP0Reg, ArgCopReg:
+		return fmt.Sprintf("$%d", a.Reg)
+	case ArgFPReg:
+		return fmt.Sprintf("$f%d", a.Reg)
+	case ArgFCC:
+		return fmt.Sprintf("$fcc%d", a.Reg)
+	case ArgIndexed:
+		return fmt.Sprintf("$%s($%s)", RegName(uint8(a.Imm), names), RegName(a.Reg, names))
+	}
+	return "?"
+}
+
+// Target returns the target of a branch or of a J or JAL jump.
+func (inst Inst) Target() (uint32, bool) {
+	if inst.format == fmtMIPS16 {
+		switch inst.control16() {
+		case ControlBranch, ControlJump, ControlCall:
+			return uint32(inst.Args[len(inst.Args)-1].Imm), true
+		}
+		return 0, false
+	}
+	switch inst.format {
+	case fmtBranch1, fmtBranch2, fmtJump, fmtBranchCC, fmtCompact:
+		return uint32(inst.Args[len(inst.Args)-1].Imm), true
+	}
+	return 0, false
+}
+
+// dest returns the general-purpose register the instruction writes, if any.
+func (inst Inst) dest() (uint8, bool) {
+	rt, rd := uint8(inst.Word>>16&31), uint8(inst.Word>>11&31)
+	switch inst.format {
+	case fmtMIPS16:
+		return inst.dest16()
+	case fmtR3, fmtShift, fmtShiftV, fmtRd, fmtJALR, fmtRdRs, fmtMovCC:
+		return rd, rd != 0
+	case fmtRdRt:
+		return rd, inst.Op != "wrpgpr" && rd != 0
+	case fmtArithI, fmtLogicI, fmtLUI, fmtRt, fmtRDHWR, fmtBitField:
+		return rt, rt != 0
+	case fmtMem:
+		switch inst.Op {
+		case "sb", "sh", "sw", "swl", "swr", "sd":
+			return 0, false
+		}
+		return rt, rt != 0
+	case fmtCopMove, fmtFPUMove:
+		return rt, inst.Op[1] == 'f' && rt != 0 // mfc1, cfc2, mfhc1...
+	case fmtCOP0:
+		return rt, (inst.Op == "mfc0" || inst.Op == "dmfc0") && rt != 0
+	case fmtBranch1:
+		if strings.Contains(inst.Op, "al") {
+			return 31, true
+		}
+	case fmtJump:
+		return 31, inst.Op == "jal" || inst.Op == "jalx"
+	case fmtCompact, fmtJIC:
+		return 31, strings.HasSuffix(inst.Op, "alc") // balc, jialc, beqzalc...
+	case fmtPCRel:
+		return inst.Args[0].Reg, inst.Args[0].Reg != 0
+	}
+	return 0, false
+}
+
+// Control is how an instruction affects control flow.
+type Control uint8
+
+const (
+	ControlNone         Control = iota // execution continues with the next instruction
+	ControlBranch                      // conditional branch to Target
+	ControlJump                        // unconditional branch or jump to Target
+	ControlCall                        // call of Target, possibly conditional
+	ControlIndirectCall                // call through a register (jalr)
+	ControlReturn                      // jr $ra or eret
+	ControlIndirect                    // jump through another register
+)
+
+// Control classifies the instruction's effect on control flow.
+func (inst Inst) Control() Control {
+	rs, rt := inst.Word>>21&31, inst.Word>>16&31
+	switch inst.format {
+	case fmtMIPS16:
+		return inst.control16()
+	case fmtBranch2:
+		if rs == rt && (inst.Op == "beq" || inst.Op == "beql") {
+			return ControlJump // b
+		}
+		return ControlBranch
+	case fmtBranch1:
+		switch {
+		case strings.Contains(inst.Op, "al"):
+			return ControlCall
+		case rs == 0 && (inst.Op == "bgez" || inst.Op == "blez"):
+			return ControlJump
+		}
+		return ControlBranch
+	case fmtBranchCC:
+		return ControlBranch
+	case fmtJump:
+		if inst.Op == "jal" || inst.Op == "jalx" {
+			return ControlCall
+		}
+		return ControlJump
+	case fmtCompact:
+		switch {
+		case inst.Op == "bc":
+			return ControlJump
+		case strings.HasSuffix(inst.Op, "alc"):
+			return ControlCall
+		}
+		return ControlBranch
+	case fmtJIC:
+		switch {
+		case inst.Op == "jialc":
+			return ControlIndirectCall
+		case rt == 31 && inst.Args[1].Imm == 0:
+			return ControlReturn
+		}
+		return ControlIndirect
+	case fmtJALR:
+		return ControlIndirectCall
+	case fmtRs:
+		if inst.Op != "jr" && inst.Op != "jr.hb" {
+			return ControlNone
+		}
+		if rs == 31 {
+			return ControlReturn
+		}
+		return ControlIndirect
+	case fmtNone:
+		if inst.Op == "eret" || inst.Op == "deret" {
+			return ControlReturn
+		}
+	}
+	return ControlNone
+}
+
+// HasDelaySlot reports whether the instruction is a branch or jump whose
+// following instruction executes before control is transferred.
+func (inst Inst) HasDelaySlot() bool {
+	switch inst.format {
+	case fmtMIPS16:
+		return inst.hasDelaySlot16()
+	case fmtCompact, fmtJIC:
+		return false
+	}
+	return inst.Control() != ControlNone && inst.Op != "eret" && inst.Op != "deret"
+}
+
+// endsBlock reports whether no instruction after the delay slot of inst
+// runs next without a branch to it.
+func (inst Inst) endsBlock() bool {
+	switch inst.Control() {
+	case ControlBranch, ControlJump, ControlReturn, ControlIndirect:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package mips32
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// sampleLines returns source lines exercising the syntax of a native instruction.
+func sampleLines(name string, f insnFormat) []string {
+	switch f {
+	case fmtNone:
+		return []string{name}
+	case fmtR3, fmtShiftV:
+		return []string{name + " $t0, $s1, $a2"}
+	case fmtShift:
+		return []string{name + " $t0, $s1, 7"}
+	case fmtRsRt:
+		return []string{name + " $s1, $a2"}
+	case fmtRd:
+		return []string{name + " $t0"}
+	case fmtRs:
+		return []string{name + " $s1"}
+	case fmtJALR:
+		return []string{name + " $t9", name + " $t0, $t9"}
+	case fmtArithI:
+		return []string{name + " $t0, $s1, -12"}
+	case fmtLogicI:
+		return []string{name + " $t0, $s1, 0xbeef"}
+	case fmtLUI:
+		return []string{name + " $t0, 0x1234"}
+	case fmtMem:
+		return []string{name + " $t0, -8($sp)"}
+	case fmtCache:
+		return []string{name + " 0x14, 16($a0)"}
+	case fmtBranch2:
+		return []string{name + " $s1, $a2, 0x00000040"}
+	case fmtBranch1:
+		return []string{name + " $s1, 0x00000020"}
+	case fmtJump:
+		return []string{name + " 0x00000100"}
+	case fmtCOP0:
+		return []string{name + " $t0, $12", name + " $t0, $16, 1"}
+	case fmtTrapI:
+		return []string{name + " $s1, -12"}
+	case fmtRdRs, fmtRdRt:
+		return []string{name + " $t0, $s1"}
+	case fmtRt:
+		return []string{name, name + " $t0"}
+	case fmtRDHWR, fmtCopMove:
+		return []string{name + " $t0, $29"}
+	case fmtBitField:
+		return []string{name + " $t0, $s1, 4, 8", name + " $t0, $s1, 0, 32"}
+	case fmtAddr:
+		return []string{name + " -8($sp)"}
+	case fmtMovCC:
+		return []string{name + " $t0, $s1, $fcc0", name + " $t0, $s1, $fcc7"}
+	case fmtCopMem:
+		return []string{name + " $3, -8($sp)"}
+	case fmtCOP2:
+		return []string{name + " 0x1234567"}
+	case fmtFPUMove:
+		return []string{name + " $t0, $f31"}
+	case fmtFPUMem:
+		return []string{name + " $f2, -8($sp)"}
+	case fmtFPUIndexed:
+		return []string{name + " $f2, $t0($a0)"}
+	case fmtFP3:
+		return []string{name + " $f0, $f2, $f4"}
+	case fmtFP2:
+		return []string{name + " $f0, $f2"}
+	case fmtFP4:
+		return []string{name + " $f0, $f2, $f4, $f6"}
+	case fmtFPMovR:
+		return []string{name + " $f0, $f2, $a2"}
+	case fmtFPMovCC:
+		return []string{name + " $f0, $f2, $fcc3"}
+	case fmtFPCmp:
+		return []string{name + " $f2, $f4", name + " $fcc1, $f2, $f4"}
+	case fmtBranchCC:
+		return []string{name + " 0x00000020", name + " $fcc5, 0x00000020"}
+	}
+	panic(fmt.Sprintf("no sample for format %d", f))
+}
+
+func TestDecodeRoundTrip(t *testing.T) {
+	names := make([]string, 0, len(asmInstructions))
+	for name := range asmInstructions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, line := range sampleLines(name, asmInstructions[name].format) {
+			_, words := assemble(t, ".set noreorder\n"+line)
+			if len(words) != 1 {
+				t.Fatalf("%q assembled to %d words", line, len(words))
+			}
+			inst := Decode(words[0], 0)
+			if got := inst.String(); got != line {
+				t.Errorf("Decode(0x%08x) = %q, want %q", words[0], got, line)
+				continue
+			}
+			// and back again
+			if _, again := assemble(t, ".set noreorder\n"+inst.String()); again[0] != words[0] {
+				t.Errorf("%q reassembled to 0x%08x, want 0x%08x", inst, again[0], words[0])
+			}
+		}
+	}
+}
+
+func TestDecodeFormat(t *testing.T) {
+	tests := []struct {
+		word, addr uint32
+		names      RegNames
+		want       string
+	}{
+		{0x012A4021, 0, ABIRegs, "addu $t0, $t1, $t2"},
+		{0x012A4021, 0, NumericRegs, "addu $8, $9, $10"},
+		{0x8FBF001C, 0, NumericRegs, "lw $31, 28($29)"},
+		{0x00000000, 0, ABIRegs, "nop"},
+		{0x0000000C, 0, ABIRegs, "syscall"},
+		{0x0007000D, 0, ABIRegs, "break 0x1c00"},
+		{0x1000FFFF, 0x400010, ABIRegs, "beq $zero, $zero, 0x00400010"},
+		{0x0C100004, 0x400000, ABIRegs, "jal 0x00400010"},
+		{0x42000018, 0, ABIRegs, "eret"},
+		{0x7C00003F, 0, ABIRegs, ".word 0x7c00003f"}, // reserved SPECIAL3 funct
+		{0x18010001, 0, ABIRegs, ".word 0x18010001"}, // blez with rt != 0
+
+		// one of each Release 2, SPECIAL2, SPECIAL3, COP1 and COP2 class
+		{0x002410C2, 0, ABIRegs, "rotr $v0, $a0, 3"},
+		{0x03E00408, 0, ABIRegs, "jr.hb $ra"},
+		{0x00851001, 0, ABIRegs, "movt $v0, $a0, $fcc1"},
+		{0x0000040F, 0, ABIRegs, "sync 0x10"},
+		{0x048C0005, 0, ABIRegs, "teqi $a0, 5"},
+		{0x041F0010, 0, ABIRegs, "synci 16($zero)"},
+		{0x70851002, 0, ABIRegs, "mul $v0, $a0, $a1"},
+		{0x00841001, 0, ABIRegs, "movf $v0, $a0, $fcc1"},
+		{0x70850000, 0, ABIRegs, "madd $a0, $a1"},
+		{0x70821020, 0, ABIRegs, "clz $v0, $a0"},
+		{0x7000003F, 0, ABIRegs, "sdbbp"},
+		{0x7C8220C0, 0, ABIRegs, "ext $v0, $a0, 3, 5"},
+		{0x7C8239C4, 0, ABIRegs, "ins $v0, $a0, 7, 1"},
+		{0x7C041420, 0, ABIRegs, "seb $v0, $a0"},
+		{0x7C0410A0, 0, ABIRegs, "wsbh $v0, $a0"},
+		{0x7C03E83B, 0, ABIRegs, "rdhwr $v1, $29"},
+		{0xCC9E0000, 0, ABIRegs, "pref 0x1e, 0($a0)"},
+		{0x8C820003, 0, ABIRegs, "lw $v0, 3($a0)"},
+		{0x88820003, 0, ABIRegs, "lwl $v0, 3($a0)"},
+		{0x42000020, 0, ABIRegs, "wait"},
+		{0x4200001F, 0, ABIRegs, "deret"},
+		{0x41606000, 0, ABIRegs, "di"},
+		{0x41626020, 0, ABIRegs, "ei $v0"},
+		{0x44020000, 0, ABIRegs, "mfc1 $v0, $f0"},
+		{0x44C2F800, 0, ABIRegs, "ctc1 $v0, $31"},
+		{0x46041000, 0, ABIRegs, "add.s $f0, $f2, $f4"},
+		{0x46801021, 0, ABIRegs, "cvt.d.w $f0, $f2"},
+		{0x4624103C, 0, ABIRegs, "c.lt.d $f2, $f4"},
+		{0x4624113C, 0, ABIRegs, "c.lt.d $fcc1, $f2, $f4"},
+		{0x46201011, 0, ABIRegs, "movf.d $f0, $f2, $fcc0"},
+		{0x45010003, 0, ABIRegs, "bc1t 0x00000010"},
+		{0x45050003, 0, ABIRegs, "bc1t $fcc1, 0x00000010"},
+		{0xC7A00008, 0, ABIRegs, "lwc1 $f0, 8($sp)"},
+		{0xF7A20010, 0, ABIRegs, "sdc1 $f2, 16($sp)"},
+		{0x4C462021, 0, ABIRegs, "madd.d $f0, $f2, $f4, $f6"},
+		{0x4C850000, 0, ABIRegs, "lwxc1 $f0, $a1($a0)"},
+		{0x4C851008, 0, ABIRegs, "swxc1 $f2, $a1($a0)"},
+		{0x48020800, 0, ABIRegs, "mfc2 $v0, $1"},
+		{0xC8830004, 0, ABIRegs, "lwc2 $3, 4($a0)"},
+		{0x4A000001, 0, ABIRegs, "cop2 0x1"},
+		{0x46000000 | 0x3F<<21, 0, ABIRegs, ".word 0x47e00000"}, // reserved COP1 fmt
+	}
+	for _, tt := range tests {
+		if got := Decode(tt.word, tt.addr).Format(tt.names); got != tt.want {
+			t.Errorf("Decode(0x%08x, 0x%x).Format(%d) = %q, want %q", tt.word, tt.addr, tt.names, got, tt.want)
+		}
+	}
+}
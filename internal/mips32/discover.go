@@ -0,0 +1,117 @@
+package mips32
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// prologueWindow is how far after the stack adjustment a prologue may save $ra.
+const prologueWindow = 8
+
+// decodeCode decodes code, read with order and starting at addr, following
+// the rules of isa.
+func decodeCode(code []byte, addr uint32, order binary.ByteOrder, isa ISA) []Inst {
+	insts := make([]Inst, 0, len(code)/4)
+	for off := 0; off+4 <= len(code); off += 4 {
+		insts = append(insts, DecodeISA(order.Uint32(code[off:]), addr+uint32(off), isa))
+	}
+	return insts
+}
+
+// DiscoverFuncs finds the functions of code without symbols, read with order
+// and starting at addr. Functions start at entry, at JAL targets, after a
+// return or jump and its delay slot unless a branch leads there, and at
+// prologues that lower $sp and save $ra following any control transfer,
+// such as a call that does not return. Padding nops are skipped. The
+// functions are named sub_XXXXXXXX and extend to the next one.
+func DiscoverFuncs(code []byte, addr uint32, order binary.ByteOrder, entry uint32) []Symbol {
+	return DiscoverFuncsISA(code, addr, order, entry, ISAR2)
+}
+
+// DiscoverFuncsISA is DiscoverFuncs for code of the given release.
+func DiscoverFuncsISA(code []byte, addr uint32, order binary.ByteOrder, entry uint32, isa ISA) []Symbol {
+	insts := decodeCode(code, addr, order, isa)
+	end := addr + uint32(len(insts))*4
+	inCode := func(a uint32) bool { return a >= addr && a < end && a&3 == 0 }
+
+	starts := make(map[uint32]bool)
+	// branch targets are inside a function, except where a function
+	// restarts itself with a backward jump, as Go code does after growing
+	// its stack
+	branchTargets := make(map[uint32]bool)
+	if inCode(entry) {
+		starts[entry] = true
+	}
+	for _, inst := range insts {
+		t, ok := inst.Target()
+		if !ok || !inCode(t) {
+			continue
+		}
+		if inst.Op == "jal" || inst.Op == "balc" {
+			starts[t] = true
+		} else if t > inst.Addr || inst.Control() == ControlBranch {
+			branchTargets[t] = true
+		}
+	}
+
+	// after skipping padding, whether the code before insts[i] ends with
+	// a control transfer that satisfies cond, delay slot included; a nop
+	// that a branch leads to is code, not padding
+	after := func(i int, cond func(Inst) bool) bool {
+		k := i - 1
+		for k >= 0 && insts[k].Word == 0 {
+			if branchTargets[insts[k].Addr] {
+				return false
+			}
+			k--
+		}
+		return k < 0 || k > 0 && cond(insts[k-1]) || cond(insts[k]) && (i >= k+2 || !insts[k].HasDelaySlot())
+	}
+	for i, inst := range insts {
+		if inst.Word == 0 || inst.Op == "" {
+			continue
+		}
+		if !branchTargets[inst.Addr] && after(i, leavesFunc) {
+			starts[inst.Addr] = true
+		}
+		if isStackAlloc(inst) && after(i, func(prev Inst) bool { return prev.HasDelaySlot() || prev.Op == "" }) {
+			for _, next := range insts[i+1 : min(i+1+prologueWindow, len(insts))] {
+				if isSaveRA(next) {
+					starts[inst.Addr] = true
+					break
+				}
+			}
+		}
+	}
+
+	syms := make([]Symbol, 0, len(starts))
+	for a := range starts {
+		syms = append(syms, Symbol{Name: fmt.Sprintf("sub_%08x", a), Addr: a})
+	}
+	sort.Slice(syms, func(i, j int) bool { return syms[i].Addr < syms[j].Addr })
+	for i := range syms {
+		next := end
+		if i+1 < len(syms) {
+			next = syms[i+1].Addr
+		}
+		syms[i].Size = next - syms[i].Addr
+	}
+	return syms
+}
+
+// leavesFunc reports whether inst never falls through: a return or jump.
+func leavesFunc(inst Inst) bool {
+	c := inst.Control()
+	return (c == ControlReturn || c == ControlJump) && inst.Op != "eret"
+}
+
+// isStackAlloc reports whether inst is addiu $sp, $sp, -N.
+func isStackAlloc(inst Inst) bool {
+	return inst.Op == "addiu" && inst.Word>>21&31 == 29 && inst.Word>>16&31 == 29 && int16(inst.Word) < 0
+}
+
+// isSaveRA reports whether inst is sw $ra, N($sp).
+func isSaveRA(inst Inst) bool {
+	return inst.Op == "sw" && inst.Word>>21&31 == 29 && inst.Word>>16&31 == 31
+}
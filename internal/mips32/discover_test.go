@@ -0,0 +1,54 @@
+package mips32
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDiscoverFuncs(t *testing.T) {
+	img, _ := assemble(t, `
+	.set noreorder
+main:	addiu $sp, $sp, -8
+	sw $ra, 4($sp)
+	jal leaf
+	nop
+	la $t9, framed
+	jalr $t9
+	nop
+	jal fatal
+	nop
+noret:	addiu $sp, $sp, -16	# only reached after a call that does not return
+	sw $ra, 12($sp)
+	jr $ra
+	addiu $sp, $sp, 16
+leaf:	beqz $a0, skip
+	nop
+	jr $ra
+	nop
+skip:	li $v0, 1		# after a return, but a branch leads here
+	jr $ra
+	nop
+	nop			# padding
+framed:	li $v0, 2		# only called through a register
+	jr $ra
+	nop
+fatal:	break
+`)
+
+	got := DiscoverFuncs(img.Text.Data, 0, binary.BigEndian, 0)
+	var want []Symbol
+	names := []string{"main", "noret", "leaf", "framed", "fatal"}
+	for i, name := range names {
+		addr := img.Symbols[name]
+		end := uint32(len(img.Text.Data))
+		if i+1 < len(names) {
+			end = img.Symbols[names[i+1]]
+		}
+		want = append(want, Symbol{Name: fmt.Sprintf("sub_%08x", addr), Addr: addr, Size: end - addr})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DiscoverFuncs =\n%v\nwant\n%v", got, want)
+	}
+}
@@ -0,0 +1,165 @@
+package mips32
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io"
+)
+
+// efMIPSArch32 is the e_flags value for MIPS32 Release 1 code.
+const efMIPSArch32 = 0x50000000
+
+// elfPageSize is the alignment of the segments of an executable.
+const elfPageSize = 0x1000
+
+// elfSection is a section of an ELF32 file being written.
+type elfSection struct {
+	name    string
+	typ     elf.SectionType
+	flags   elf.SectionFlag
+	addr    uint32
+	align   uint32
+	link    uint32
+	info    uint32
+	entsize uint32
+	data    []byte
+}
+
+// writeELF32 writes a big-endian MIPS ELF32 file of type typ holding
+// sections, which follow the null section, and a .shstrtab. In an
+// executable every non-empty SHF_ALLOC section is loaded by its own PT_LOAD
+// segment.
+func writeELF32(w io.Writer, typ elf.Type, entry uint32, sections []elfSection) error {
+	shstrtab := []byte{0}
+	sections = append(sections, elfSection{name: ".shstrtab", typ: elf.SHT_STRTAB, align: 1})
+	names := make([]uint32, len(sections))
+	for i, s := range sections {
+		names[i] = uint32(len(shstrtab))
+		shstrtab = append(append(shstrtab, s.name...), 0)
+	}
+	sections[len(sections)-1].data = shstrtab
+
+	var loads []int
+	if typ == elf.ET_EXEC {
+		for i, s := range sections {
+			if s.flags&elf.SHF_ALLOC != 0 && len(s.data) > 0 {
+				loads = append(loads, i)
+			}
+		}
+	}
+
+	// place the contents after the headers; a loaded section's offset must
+	// match its address modulo the page size
+	const ehsize, phentsize, shentsize = 52, 32, 40
+	offset := uint32(ehsize + phentsize*len(loads))
+	offsets := make([]uint32, len(sections))
+	for i, s := range sections {
+		if s.flags&elf.SHF_ALLOC != 0 && typ == elf.ET_EXEC {
+			offset += (s.addr - offset) % elfPageSize
+		} else if s.align > 1 {
+			offset = (offset + s.align - 1) &^ (s.align - 1)
+		}
+		offsets[i] = offset
+		offset += uint32(len(s.data))
+	}
+	shoff := (offset + 3) &^ 3
+
+	var b bytes.Buffer
+	hdr := elf.Header32{
+		Type:      uint16(typ),
+		Machine:   uint16(elf.EM_MIPS),
+		Version:   uint32(elf.EV_CURRENT),
+		Entry:     entry,
+		Shoff:     shoff,
+		Flags:     efMIPSArch32,
+		Ehsize:    ehsize,
+		Shentsize: shentsize,
+		Shnum:     uint16(len(sections) + 1),
+		Shstrndx:  uint16(len(sections)),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2MSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	if len(loads) > 0 {
+		hdr.Phoff, hdr.Phentsize, hdr.Phnum = ehsize, phentsize, uint16(len(loads))
+	}
+	binary.Write(&b, binary.BigEndian, hdr)
+
+	for _, i := range loads {
+		s := sections[i]
+		flags := elf.PF_R
+		if s.flags&elf.SHF_WRITE != 0 {
+			flags |= elf.PF_W
+		}
+		if s.flags&elf.SHF_EXECINSTR != 0 {
+			flags |= elf.PF_X
+		}
+		binary.Write(&b, binary.BigEndian, elf.Prog32{
+			Type:   uint32(elf.PT_LOAD),
+			Off:    offsets[i],
+			Vaddr:  s.addr,
+			Paddr:  s.addr,
+			Filesz: uint32(len(s.data)),
+			Memsz:  uint32(len(s.data)),
+			Flags:  uint32(flags),
+			Align:  elfPageSize,
+		})
+	}
+
+	for i, s := range sections {
+		b.Write(make([]byte, offsets[i]-uint32(b.Len())))
+		b.Write(s.data)
+	}
+	b.Write(make([]byte, shoff-uint32(b.Len())))
+
+	binary.Write(&b, binary.BigEndian, elf.Section32{})
+	for i, s := range sections {
+		binary.Write(&b, binary.BigEndian, elf.Section32{
+			Name:      names[i],
+			Type:      uint32(s.typ),
+			Flags:     uint32(s.flags),
+			Addr:      s.addr,
+			Off:       offsets[i],
+			Size:      uint32(len(s.data)),
+			Link:      s.link,
+			Info:      s.info,
+			Addralign: max(s.align, 1),
+			Entsize:   s.entsize,
+		})
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// elfSymtab builds the contents of .symtab and .strtab.
+type elfSymtab struct {
+	syms   bytes.Buffer
+	strtab []byte
+	count  uint32
+}
+
+func newELFSymtab() *elfSymtab {
+	t := &elfSymtab{strtab: []byte{0}}
+	t.add("", 0, 0, 0, 0) // the null symbol
+	return t
+}
+
+// add appends a symbol and returns its index.
+func (t *elfSymtab) add(name string, value, size uint32, info byte, shndx elf.SectionIndex) uint32 {
+	var nameOff uint32
+	if name != "" {
+		nameOff = uint32(len(t.strtab))
+		t.strtab = append(append(t.strtab, name...), 0)
+	}
+	binary.Write(&t.syms, binary.BigEndian, elf.Sym32{
+		Name:  nameOff,
+		Value: value,
+		Size:  size,
+		Info:  info,
+		Shndx: uint16(shndx),
+	})
+	t.count++
+	return t.count - 1
+}
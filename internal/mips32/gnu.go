@@ -0,0 +1,166 @@
+package mips32
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// gnuRegNames are the o32 register names GNU objdump prints, without '$'.
+var gnuRegNames = [32]string{
+	"zero", "at", "v0", "v1", "a0", "a1", "a2", "a3",
+	"t0", "t1", "t2", "t3", "t4", "t5", "t6", "t7",
+	"s0", "s1", "s2", "s3", "s4", "s5", "s6", "s7",
+	"t8", "t9", "k0", "k1", "gp", "sp", "s8", "ra",
+}
+
+// gnuCP0Names are the MIPS32 coprocessor 0 register names of GNU objdump,
+// for select 0.
+var gnuCP0Names = [32]string{
+	"c0_index", "c0_random", "c0_entrylo0", "c0_entrylo1",
+	"c0_context", "c0_pagemask", "c0_wired", "$7",
+	"c0_badvaddr", "c0_count", "c0_entryhi", "c0_compare",
+	"c0_status", "c0_cause", "c0_epc", "c0_prid",
+	"c0_config", "c0_lladdr", "c0_watchlo", "c0_watchhi",
+	"c0_xcontext", "$21", "$22", "c0_debug",
+	"c0_depc", "c0_perfcnt", "c0_errctl", "c0_cacheerr",
+	"c0_taglo", "c0_taghi", "c0_errorepc", "c0_desave",
+}
+
+// gnuCP0SelNames name the MIPS32 coprocessor 0 registers with a non-zero
+// select, indexed by reg<<3 | sel.
+var gnuCP0SelNames = map[uint32]string{
+	16<<3 | 1: "c0_config1",
+	16<<3 | 2: "c0_config2",
+	16<<3 | 3: "c0_config3",
+	18<<3 | 1: "c0_watchlo,1",
+	19<<3 | 1: "c0_watchhi,1",
+	25<<3 | 1: "c0_perfcnt,1",
+	26<<3 | 1: "c0_errctl,1",
+	27<<3 | 1: "c0_cacheerr,1",
+	28<<3 | 1: "c0_datalo",
+	29<<3 | 1: "c0_datahi",
+}
+
+// gnuZeroBranches are the aliases of the branches comparing with $zero.
+var gnuZeroBranches = map[string]string{
+	"beq":  "beqz",
+	"bne":  "bnez",
+	"beql": "beqzl",
+	"bnel": "bnezl",
+}
+
+// GNU formats the instruction as GNU objdump -d does: the mnemonic, a tab
+// and the operands separated by commas, with the aliases nop, move, b, bal,
+// beqz, bnez, li, negu and not. target formats branch and jump targets, and
+// the addresses used by PC-relative MIPS16e instructions.
+func (inst Inst) GNU(target func(addr uint32) string) string {
+	w := inst.Word
+	rs, rt, rd := w>>21&31, w>>16&31, w>>11&31
+	reg := func(r uint32) string { return gnuRegNames[r] }
+	hex := func(v uint32) string { return fmt.Sprintf("0x%x", v) }
+	op := func(name string, args ...string) string {
+		if len(args) == 0 {
+			return name
+		}
+		return name + "\t" + strings.Join(args, ",")
+	}
+	if inst.Op == "" && inst.Size() == 2 {
+		return op(".short", hex(w))
+	}
+	if inst.Op == "" {
+		return op(".word", hex(w))
+	}
+	addr, _ := inst.Target()
+
+	switch {
+	case inst.format == fmtMIPS16:
+		// no aliases, the operands are shown as decoded
+	case w == 0:
+		return "nop"
+	case w == 0x40:
+		return "ssnop"
+	case w == 0xC0:
+		return "ehb"
+	case (inst.Op == "addu" || inst.Op == "daddu" || inst.Op == "or") && rt == 0:
+		return op("move", reg(rd), reg(rs))
+	case inst.Op == "subu" && rs == 0:
+		return op("negu", reg(rd), reg(rt))
+	case inst.Op == "dsubu" && rs == 0:
+		return op("dnegu", reg(rd), reg(rt))
+	case inst.Op == "sub" && rs == 0:
+		return op("neg", reg(rd), reg(rt))
+	case inst.Op == "nor" && rt == 0:
+		return op("not", reg(rd), reg(rs))
+	case inst.Op == "beq" && rs == 0 && rt == 0, inst.Op == "bgez" && rs == 0:
+		return op("b", target(addr))
+	case inst.Op == "bgezal" && rs == 0:
+		return op("bal", target(addr))
+	case gnuZeroBranches[inst.Op] != "" && rt == 0:
+		return op(gnuZeroBranches[inst.Op], reg(rs), target(addr))
+	case inst.Op == "addiu" && rs == 0:
+		return op("li", reg(rt), strconv.Itoa(int(int16(w))))
+	case inst.Op == "ori" && rs == 0:
+		return op("li", reg(rt), hex(w&0xFFFF))
+	case inst.format == fmtRsRt && (inst.Op == "div" || inst.Op == "divu" || inst.Op == "ddiv" || inst.Op == "ddivu"):
+		return op(inst.Op, "zero", reg(rs), reg(rt))
+	case inst.Op == "break":
+		if code2 := w >> 6 & 0x3FF; code2 != 0 {
+			return op("break", hex(w>>16&0x3FF), hex(code2))
+		} else if code := w >> 16 & 0x3FF; code != 0 {
+			return op("break", hex(code))
+		}
+		return "break"
+	case inst.Op == "sync":
+		if stype := w >> 6 & 31; stype != 0 {
+			return op("sync", hex(stype))
+		}
+		return "sync"
+	case inst.format == fmtRsRt && inst.Op[0] == 't':
+		if code := w >> 6 & 0x3FF; code != 0 {
+			return op(inst.Op, reg(rs), reg(rt), hex(code))
+		}
+		return op(inst.Op, reg(rs), reg(rt))
+	case inst.format == fmtCOP0:
+		sel := w & 7
+		if sel == 0 {
+			return op(inst.Op, reg(rt), gnuCP0Names[rd])
+		}
+		if name, ok := gnuCP0SelNames[rd<<3|sel]; ok {
+			return op(inst.Op, reg(rt), name)
+		}
+		return op(inst.Op, reg(rt), fmt.Sprintf("$%d", rd), strconv.Itoa(int(sel)))
+	}
+
+	args := make([]string, len(inst.Args))
+	for i, a := range inst.Args {
+		switch a.Kind {
+		case ArgReg:
+			args[i] = reg(uint32(a.Reg))
+		case ArgImm:
+			if inst.format == fmtShift || inst.format == fmtBitField {
+				args[i] = hex(uint32(a.Imm))
+			} else {
+				args[i] = strconv.FormatInt(a.Imm, 10)
+			}
+		case ArgUImm:
+			args[i] = hex(uint32(a.Imm))
+		case ArgMem:
+			args[i] = fmt.Sprintf("%d(%s)", a.Imm, reg(uint32(a.Reg)))
+		case ArgTarget:
+			args[i] = target(uint32(a.Imm))
+		case ArgCP0Reg, ArgCopReg:
+			args[i] = fmt.Sprintf("$%d", a.Reg)
+		case ArgFPReg:
+			args[i] = fmt.Sprintf("$f%d", a.Reg)
+		case ArgFCC:
+			args[i] = fmt.Sprintf("$fcc%d", a.Reg)
+		case ArgIndexed:
+			args[i] = fmt.Sprintf("%s(%s)", reg(uint32(a.Imm)), reg(uint32(a.Reg)))
+		}
+	}
+	if inst.Op == "cop2" {
+		return op("c2", args...)
+	}
+	return op(inst.Op, args...)
+}
@@ -0,0 +1,82 @@
+package mips32
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGNU(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"nop", "nop"},
+		{"sll $zero, $zero, 1", "ssnop"},
+		{"sll $v0, $v0, 2", "sll\tv0,v0,0x2"},
+		{"addu $t0, $t1, $t2", "addu\tt0,t1,t2"},
+		{"addu $a0, $v0, $zero", "move\ta0,v0"},
+		{"or $a0, $v0, $zero", "move\ta0,v0"},
+		{"subu $v0, $zero, $a1", "negu\tv0,a1"},
+		{"nor $v0, $a1, $zero", "not\tv0,a1"},
+		{"addiu $sp, $sp, -32", "addiu\tsp,sp,-32"},
+		{"addiu $v0, $zero, -1", "li\tv0,-1"},
+		{"ori $v0, $zero, 0x8000", "li\tv0,0x8000"},
+		{"ori $a0, $a0, 0x8000", "ori\ta0,a0,0x8000"},
+		{"sltiu $v0, $v0, 1", "sltiu\tv0,v0,1"},
+		{"lui $gp, 5", "lui\tgp,0x5"},
+		{"lw $ra, 28($sp)", "lw\tra,28(sp)"},
+		{"sw $fp, -8($s8)", "sw\ts8,-8(s8)"},
+		{"mult $a0, $a1", "mult\ta0,a1"},
+		{"div $a0, $a1", "div\tzero,a0,a1"},
+		{"teq $a1, $zero", "teq\ta1,zero"},
+		{"mfhi $v0", "mfhi\tv0"},
+		{"jr $ra", "jr\tra"},
+		{"jalr $t9", "jalr\tt9"},
+		{"jalr $v0, $t9", "jalr\tv0,t9"},
+		{"beq $zero, $zero, 0x40", "b\t40"},
+		{"bgez $zero, 0x40", "b\t40"},
+		{"bgezal $zero, 0x40", "bal\t40"},
+		{"beq $t0, $zero, 0x40", "beqz\tt0,40"},
+		{"bnel $t0, $zero, 0x40", "bnezl\tt0,40"},
+		{"beq $zero, $t0, 0x40", "beq\tzero,t0,40"},
+		{"bne $a0, $a1, 0x40", "bne\ta0,a1,40"},
+		{"blez $a0, 0x40", "blez\ta0,40"},
+		{"jal 0x100", "jal\t100"},
+		{"syscall", "syscall"},
+		{"break", "break"},
+		{"mfc0 $t0, $12", "mfc0\tt0,c0_status"},
+		{"mtc0 $zero, $16, 1", "mtc0\tzero,c0_config1"},
+		{"mfc0 $t0, $12, 5", "mfc0\tt0,$12,5"},
+		{"cache 0x14, 16($a0)", "cache\t0x14,16(a0)"},
+		{"eret", "eret"},
+		{"ext $v0, $a0, 3, 5", "ext\tv0,a0,0x3,0x5"},
+		{"rdhwr $v1, $29", "rdhwr\tv1,$29"},
+		{"lwc1 $f0, 8($sp)", "lwc1\t$f0,8(sp)"},
+		{"c.lt.d $fcc1, $f2, $f4", "c.lt.d\t$fcc1,$f2,$f4"},
+		{"lwxc1 $f0, $a1($a0)", "lwxc1\t$f0,a1(a0)"},
+		{"bc1t 0x40", "bc1t\t40"},
+		{"di", "di"},
+		{"cop2 0x12", "c2\t0x12"},
+	}
+	target := func(addr uint32) string { return fmt.Sprintf("%x", addr) }
+	for _, tt := range tests {
+		_, words := assemble(t, ".set noreorder\n"+tt.src)
+		if got := Decode(words[0], 0).GNU(target); got != tt.want {
+			t.Errorf("%s: GNU = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+
+	extra := []struct {
+		word uint32
+		want string
+	}{
+		{0x0007000D, "break\t0x7"},
+		{0x000701CD, "break\t0x7,0x7"},
+		{0x00A001F4, "teq\ta1,zero,0x7"},
+		{0x7C00003F, ".word\t0x7c00003f"},
+	}
+	for _, tt := range extra {
+		if got := Decode(tt.word, 0).GNU(target); got != tt.want {
+			t.Errorf("0x%08x: GNU = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package mips32
+
+// Hooks are callbacks an embedder can install with SetHooks. Any of them
+// may be nil. Hooks run on the goroutine executing the CPU.
+type Hooks struct {
+	// Instruction is called before each instruction executes. Returning
+	// true stops the CPU before the instruction executes.
+	Instruction func(pc, word uint32) (stop bool)
+
+	// Exception is called when an exception is raised, once EPC, Cause and
+	// the vector are set up. Returning true stops the CPU. Returning false
+	// does not keep the CPU running after an exception it halts on.
+	Exception func(code uint8, epc, badVAddr uint32) (stop bool)
+
+	// Memory is called after each data load or store with the accessed
+	// value. Returning true stops the CPU after the instruction.
+	Memory func(addr uint32, size int, value uint32, write bool) (stop bool)
+
+	// Syscall is called on SYSCALL. Returning true marks the call as
+	// serviced: no Syscall exception is raised and execution continues.
+	Syscall func() (handled bool)
+}
+
+// SetHooks installs h; the zero Hooks removes all hooks. Instruction and
+// Memory hooks move execution off the fast path.
+func (cpu *CPU) SetHooks(h Hooks) {
+	if h.Instruction == nil && h.Exception == nil && h.Memory == nil && h.Syscall == nil {
+		cpu.hooks = nil
+		return
+	}
+	cpu.hooks = &h
+}
+
+// dataAccess reports a completed data load or store to the D-cache model
+// and the Memory hook, if any.
+func (cpu *CPU) dataAccess(vaddr uint32, size int, value uint32, write bool) {
+	if cpu.dcache != nil {
+		cpu.dcacheAccess(vaddr, write)
+	}
+	if h := cpu.hooks; h != nil && h.Memory != nil && h.Memory(vaddr, size, value, write) {
+		cpu.Stop()
+	}
+}
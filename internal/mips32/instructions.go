@@ -1,6 +1,9 @@
 package mips32
 
-import "awesomeVM/internal/utils"
+import (
+	"awesomeVM/internal/utils"
+	"math/bits"
+)
 
 type OpCode uint8
 
@@ -24,86 +27,139 @@ type OpCode uint8
 
 const (
 	// R-Type funct codes
-	OpCodeADD  OpCode = 0x20
-	OpCodeADDU OpCode = 0x28
-	OpCodeAND  OpCode = 0x24
-	// OpCodeDADD   OpCode = 0x2C MIPS64
-	// OpCodeDADDU  OpCode = 0x2D MIPS64
-	//OpCodeDDIV   OpCode = 0x1E MIPS64
-	// OpCodeDDIVU  OpCode = 0x1F MIPS64
-	OpCodeDIV  OpCode = 0x1A
-	OpCodeDIVU OpCode = 0x1B
-	//OpCodeDMULT  OpCode = 0x1C MIPS64
-	//OpCodeDMULTU OpCode = 0x1D MIPS64
-	//OpCodeDSLL   OpCode = 0x38 MIPS64
-	//OpCodeDSLL32 OpCode = 0x3C MIPS64
-	//OpCodeDSLLV  OpCode = 0x14 MIPS64
-	//OpCodeDSRA   OpCode = 0x3B MIPS64
-	//OpCodeDSRA32 OpCode = 0x3F MIPS64
-	//OpCodeDSRAV  OpCode = 0x17 MIPS64
-	//OpCodeDSRL   OpCode = 0x3A MIPS64
-	//OpCodeDSRL32 OpCode = 0x3E MIPS64
-	//OpCodeDSRLV  OpCode = 0x16 MIPS64
-	//OpCodeDSUB   OpCode = 0x2E MIPS64
-	//OpCodeDSUBU  OpCode = 0x2F MIPS64
-	OpCodeJALR  OpCode = 0x09
-	OpCodeJR    OpCode = 0x08
-	OpCodeMFHI  OpCode = 0x10
-	OpCodeMFLO  OpCode = 0x12
-	OpCodeMOVN  OpCode = 0x0B
-	OpCodeMOVZ  OpCode = 0x0A
-	OpCodeMTHI  OpCode = 0x11
-	OpCodeMTLO  OpCode = 0x13
-	OpCodeMULT  OpCode = 0x18
-	OpCodeMULTU OpCode = 0x19
-	OpCodeNOR   OpCode = 0x27
-	OpCodeOR    OpCode = 0x25
-	OpCodeSLL   OpCode = 0x00
-	OpCodeSLLV  OpCode = 0x04
-	OpCodeSLT   OpCode = 0x2A
-	OpCodeSLTU  OpCode = 0x2B
-	OpCodeSRA   OpCode = 0x03
-	OpCodeSRAV  OpCode = 0x07
-	OpCodeSRL   OpCode = 0x02
-	OpCodeSRLV  OpCode = 0x06
-	OpCodeSUB   OpCode = 0x22
-	OpCodeSUBU  OpCode = 0x23
-	OpCodeTEQ   OpCode = 0x34
-	OpCodeTGE   OpCode = 0x30
-	OpCodeTGEU  OpCode = 0x31
-	OpCodeTLT   OpCode = 0x32
-	OpCodeTLTU  OpCode = 0x33
-	OpCodeTNE   OpCode = 0x36
-	OpCodeXOR   OpCode = 0x26
+	OpCodeADD     OpCode = 0x20
+	OpCodeADDU    OpCode = 0x21
+	OpCodeAND     OpCode = 0x24
+	OpCodeDADD    OpCode = 0x2C // MIPS64
+	OpCodeDADDU   OpCode = 0x2D // MIPS64
+	OpCodeDDIV    OpCode = 0x1E // MIPS64
+	OpCodeDDIVU   OpCode = 0x1F // MIPS64
+	OpCodeDIV     OpCode = 0x1A
+	OpCodeDIVU    OpCode = 0x1B
+	OpCodeDMULT   OpCode = 0x1C // MIPS64
+	OpCodeDMULTU  OpCode = 0x1D // MIPS64
+	OpCodeDSLL    OpCode = 0x38 // MIPS64
+	OpCodeDSLL32  OpCode = 0x3C // MIPS64
+	OpCodeDSLLV   OpCode = 0x14 // MIPS64
+	OpCodeDSRA    OpCode = 0x3B // MIPS64
+	OpCodeDSRA32  OpCode = 0x3F // MIPS64
+	OpCodeDSRAV   OpCode = 0x17 // MIPS64
+	OpCodeDSRL    OpCode = 0x3A // MIPS64
+	OpCodeDSRL32  OpCode = 0x3E // MIPS64
+	OpCodeDSRLV   OpCode = 0x16 // MIPS64
+	OpCodeDSUB    OpCode = 0x2E // MIPS64
+	OpCodeDSUBU   OpCode = 0x2F // MIPS64
+	OpCodeJALR    OpCode = 0x09
+	OpCodeJR      OpCode = 0x08
+	OpCodeMFHI    OpCode = 0x10
+	OpCodeMFLO    OpCode = 0x12
+	OpCodeMOVN    OpCode = 0x0B
+	OpCodeMOVZ    OpCode = 0x0A
+	OpCodeMTHI    OpCode = 0x11
+	OpCodeMTLO    OpCode = 0x13
+	OpCodeMULT    OpCode = 0x18
+	OpCodeMULTU   OpCode = 0x19
+	OpCodeNOR     OpCode = 0x27
+	OpCodeOR      OpCode = 0x25
+	OpCodeSLL     OpCode = 0x00
+	OpCodeSLLV    OpCode = 0x04
+	OpCodeSLT     OpCode = 0x2A
+	OpCodeSLTU    OpCode = 0x2B
+	OpCodeSRA     OpCode = 0x03
+	OpCodeSRAV    OpCode = 0x07
+	OpCodeSRL     OpCode = 0x02
+	OpCodeSRLV    OpCode = 0x06
+	OpCodeSUB     OpCode = 0x22
+	OpCodeSUBU    OpCode = 0x23
+	OpCodeSYNC    OpCode = 0x0F
+	OpCodeSYSCALL OpCode = 0x0C
+	OpCodeBREAK   OpCode = 0x0D
+	OpCodeTEQ     OpCode = 0x34
+	OpCodeTGE     OpCode = 0x30
+	OpCodeTGEU    OpCode = 0x31
+	OpCodeTLT     OpCode = 0x32
+	OpCodeTLTU    OpCode = 0x33
+	OpCodeTNE     OpCode = 0x36
+	OpCodeXOR     OpCode = 0x26
 
 	// I-Type opcodes
-	OpCodeADDI  OpCode = 0x8
-	OpCodeADDIU OpCode = 0x9
-	OpCodeANDI  OpCode = 0xC
-	//OpCodeDADDI  OpCode = 0x18 MIPS64
-	//OpCodeDADDIU OpCode = 0x19 MIPS64
-	OpCodeLB    OpCode = 0x20
-	OpCodeLBU   OpCode = 0x24
-	OpCodeLH    OpCode = 0x21
-	OpCodeLHU   OpCode = 0x25
-	OPCodeLUI   OpCode = 0xF
-	OpCodeLW    OpCode = 0x23
-	OpCodeLWU   OpCode = 0x27
-	OpCodeORI   OpCode = 0xD
-	OpCodeSB    OpCode = 0x28
-	OpCodeSH    OpCode = 0x29
-	OpCodeSLTI  OpCode = 0xA
-	OpCodeSLTIU OpCode = 0xB
-	OpCodeSW    OpCode = 0x2B
-	OpCodeXORI  OpCode = 0xE
-	// OpCodeLL   OpCode = 0x30 // for atomic operations
-	// OpCodeSC   OpCode = 0x38 // for atomic operations
-	// OpCodeLWC1 OpCode = 0x31 // for floating point
-	// OpCodeSWC1 OpCode = 0x39 // for floating point
+	OpCodeADDI   OpCode = 0x8
+	OpCodeADDIU  OpCode = 0x9
+	OpCodeANDI   OpCode = 0xC
+	OpCodeDADDI  OpCode = 0x18 // MIPS64
+	OpCodeDADDIU OpCode = 0x19 // MIPS64
+	OpCodeLB     OpCode = 0x20
+	OpCodeLBU    OpCode = 0x24
+	OpCodeLH     OpCode = 0x21
+	OpCodeLHU    OpCode = 0x25
+	OPCodeLUI    OpCode = 0xF
+	OpCodeLW     OpCode = 0x23
+	OpCodeLWU    OpCode = 0x27
+	OpCodeLD     OpCode = 0x37 // MIPS64
+	OpCodeSD     OpCode = 0x3F // MIPS64
+	OpCodeORI    OpCode = 0xD
+	OpCodeSB     OpCode = 0x28
+	OpCodeSH     OpCode = 0x29
+	OpCodeSLTI   OpCode = 0xA
+	OpCodeSLTIU  OpCode = 0xB
+	OpCodeSW     OpCode = 0x2B
+	OpCodeXORI   OpCode = 0xE
+	OpCodeLL     OpCode = 0x30 // for atomic operations
+	OpCodeSC     OpCode = 0x38 // for atomic operations
+	OpCodeCACHE  OpCode = 0x2F
+	OpCodePREF   OpCode = 0x33
+	OpCodeLWL    OpCode = 0x22 // unaligned accesses
+	OpCodeLWR    OpCode = 0x26
+	OpCodeSWL    OpCode = 0x2A
+	OpCodeSWR    OpCode = 0x2E
+	OpCodeLWC1   OpCode = 0x31 // for floating point
+	OpCodeLDC1   OpCode = 0x35
+	OpCodeSWC1   OpCode = 0x39
+	OpCodeSDC1   OpCode = 0x3D
+	OpCodeLWC2   OpCode = 0x32 // for coprocessor 2
+	OpCodeLDC2   OpCode = 0x36
+	OpCodeSWC2   OpCode = 0x3A
+	OpCodeSDC2   OpCode = 0x3E
+
+	// Opcodes whose funct or rs field selects the operation
+	OpCodeCOP1     OpCode = 0x11
+	OpCodeCOP2     OpCode = 0x12
+	OpCodeCOP1X    OpCode = 0x13
+	OpCodeSPECIAL2 OpCode = 0x1C
+	OpCodeSPECIAL3 OpCode = 0x1F
+
+	// Branch opcodes (I-Type)
+	OpCodeREGIMM OpCode = 0x1 // rt selects the operation, see REGIMM_*
+	OpCodeBEQ    OpCode = 0x4
+	OpCodeBNE    OpCode = 0x5
+	OpCodeBLEZ   OpCode = 0x6
+	OpCodeBGTZ   OpCode = 0x7
+	OpCodeBEQL   OpCode = 0x14
+	OpCodeBNEL   OpCode = 0x15
+	OpCodeBLEZL  OpCode = 0x16
+	OpCodeBGTZL  OpCode = 0x17
 
 	// J-Type opcodes
-	OpCodeJ   OpCode = 0x2
-	OpCodeJAL OpCode = 0x3
+	OpCodeJ    OpCode = 0x2
+	OpCodeJAL  OpCode = 0x3
+	OpCodeJALX OpCode = 0x1D // JAL switching to MIPS16e
+
+	// REGIMM rt codes
+	REGIMM_BLTZ    uint8 = 0x00 // Branch on Less Than Zero
+	REGIMM_BGEZ    uint8 = 0x01 // Branch on Greater Than or Equal to Zero
+	REGIMM_BLTZL   uint8 = 0x02 // BLTZ Likely
+	REGIMM_BGEZL   uint8 = 0x03 // BGEZ Likely
+	REGIMM_BLTZAL  uint8 = 0x10 // BLTZ And Link
+	REGIMM_BGEZAL  uint8 = 0x11 // BGEZ And Link
+	REGIMM_BLTZALL uint8 = 0x12 // BLTZAL Likely
+	REGIMM_BGEZALL uint8 = 0x13 // BGEZAL Likely
+	REGIMM_TGEI    uint8 = 0x08 // Trap if Greater or Equal Immediate
+	REGIMM_TGEIU   uint8 = 0x09
+	REGIMM_TLTI    uint8 = 0x0A
+	REGIMM_TLTIU   uint8 = 0x0B
+	REGIMM_TEQI    uint8 = 0x0C
+	REGIMM_TNEI    uint8 = 0x0E
+	REGIMM_SYNCI   uint8 = 0x1F // Synchronize Caches to Make Instruction Writes Effective
 
 	// COP0 related opcodes
 	OpCodeCOP0 uint8 = 0x10
@@ -112,19 +168,33 @@ const (
 	// COP0 functions
 	COP0Funct_MFC0  uint8 = 0x00 // Move From CP0
 	COP0Funct_MTC0  uint8 = 0x04 // Move To CP0
+	COP0Funct_DMFC0 uint8 = 0x01 // Doubleword Move From CP0 (MIPS64)
+	COP0Funct_DMTC0 uint8 = 0x05 // Doubleword Move To CP0 (MIPS64)
 	COP0Funct_ERET  uint8 = 0x18 // Exception Return
 	COP0Funct_TLBP  uint8 = 0x08 // TLB Probe
 	COP0Funct_TLBR  uint8 = 0x01 // TLB Read
 	COP0Funct_TLBWI uint8 = 0x02 // TLB Write Indexed
 	COP0Funct_TLBWR uint8 = 0x06 // TLB Write Random
+	COP0Funct_DERET uint8 = 0x1F // Debug Exception Return
+	COP0Funct_WAIT  uint8 = 0x20 // Enter Standby Mode
+)
+
+// Flow tells the CPU how execution continues after an instruction.
+type Flow uint8
+
+const (
+	FlowNext      Flow = iota // continue with the next sequential instruction
+	FlowBranch                // continue at target once the delay slot has executed
+	FlowJump                  // continue at target immediately (ERET, nullified delay slots)
+	FlowException             // an exception was raised, PC already holds the vector
 )
 
 // instructions here
 type Instruction interface {
 	// Execute executes the instruction on the given CPU.
-	// It returns a pointer to the new program counter (PC) if it was changed,
-	// or nil if the PC should just advance to the next instruction.
-	Execute(cpu *CPU) (nextPC *uint32, delaySlot bool)
+	// It reports how execution continues; target is only meaningful for
+	// FlowBranch and FlowJump.
+	Execute(cpu *CPU) (flow Flow, target uint32)
 	Decode(instr uint32) Instruction
 }
 
@@ -135,7 +205,7 @@ type Instruction interface {
 // DecodeInstruction decodes a 32-bit MIPS instruction into its corresponding Instruction type.
 // R is opcode = 0
 // I is opcode != 0, excluding 2 and 3, since:
-// J is opcode = 2 or 3
+// J is opcode = 2, 3 or 0x1D
 // COP0 is opcode = 0x10 (16 in decimal)
 func DecodeInstruction(instr uint32) Instruction {
 	opcode := (instr >> 26) & 0x3F
@@ -146,7 +216,7 @@ func DecodeInstruction(instr uint32) Instruction {
 		result = &COP0Instruction{}
 	} else if opcode == 0x0 {
 		result = &RTypeInstruction{}
-	} else if opcode == 0x2 || opcode == 0x3 {
+	} else if opcode == 0x2 || opcode == 0x3 || opcode == 0x1D {
 		result = &JTypeInstruction{}
 	} else {
 		result = &ITypeInstruction{}
@@ -155,6 +225,19 @@ func DecodeInstruction(instr uint32) Instruction {
 	return result.Decode(instr)
 }
 
+// DecodeInstructionFor decodes instr like DecodeInstruction, following the
+// rules of isa: with ISAR6, the encodings Release 6 reassigned decode to
+// their new instructions and those of removed instructions raise a
+// Reserved Instruction exception.
+func DecodeInstructionFor(instr uint32, isa ISA) Instruction {
+	if isa == ISAR6 {
+		if ri, ok := decodeR6(instr); ok {
+			return &ri
+		}
+	}
+	return DecodeInstruction(instr)
+}
+
 type RTypeInstruction struct {
 	Opcode uint8 // 6 bits
 	Rs     uint8 // 5 bits
@@ -165,7 +248,13 @@ type RTypeInstruction struct {
 }
 
 func (ri RTypeInstruction) Decode(instr uint32) Instruction {
-	return &RTypeInstruction{
+	r := decodeRType(instr)
+	return &r
+}
+
+// decodeRType extracts the R-Type fields of instr.
+func decodeRType(instr uint32) RTypeInstruction {
+	return RTypeInstruction{
 		Opcode: uint8((instr >> 26) & 0x3F),
 		Rs:     uint8((instr >> 21) & 0x1F),
 		Rt:     uint8((instr >> 16) & 0x1F),
@@ -175,11 +264,16 @@ func (ri RTypeInstruction) Decode(instr uint32) Instruction {
 	}
 }
 
-func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
+func (ri *RTypeInstruction) Execute(cpu *CPU) (flow Flow, target uint32) {
 
 	// we want to convert Funct to OpCode type
 	funct := OpCode(ri.Funct)
 
+	// the doubleword instructions are reserved on a 32-bit CPU
+	if ri.doubleword() && !cpu.is64() {
+		return cpu.raiseException(excRI)
+	}
+
 	// Funct is the opCode
 	switch funct {
 	// ADD rd, rs, rt
@@ -199,15 +293,12 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		// Check for overflow
 		if utils.CheckAdditionOverflow(rsVal, rtVal, temp) {
 			// Overflow occurred
-			vec := cpu.cp0.RaiseException(excOv, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.raiseException(excOv)
 		}
 
 		cpu.SetReg(ri.Rd, uint32(temp))
 
-		return nil, false
+		return FlowNext, 0
 
 	// ADDU rd, rs, rt
 	// if (NotWordValue(GPR[rs]) or NotWordValue(GPR[rt])) then UndefinedResult() endif
@@ -218,16 +309,16 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		rtVal := cpu.GetReg(ri.Rt)
 		temp := rsVal + rtVal
 		cpu.SetReg(ri.Rd, temp)
-		return nil, false
+		return FlowNext, 0
 
 	// AND rd, rs, rt
 	// GPR[rd] ← GPR[rs] and GPR[rt]
 	case OpCodeAND:
-		rsVal := cpu.GetReg(ri.Rs)
-		rtVal := cpu.GetReg(ri.Rt)
+		rsVal := cpu.GetReg64(ri.Rs)
+		rtVal := cpu.GetReg64(ri.Rt)
 		temp := rsVal & rtVal
-		cpu.SetReg(ri.Rd, temp)
-		return nil, false
+		cpu.SetReg64(ri.Rd, temp)
+		return FlowNext, 0
 
 	//	DIV rs, rt
 	//	if (NotWordValue(GPR[rs]) or NotWordValue(GPR[rt])) then UndefinedResult() endif
@@ -241,15 +332,13 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		rtVal := int32(cpu.GetReg(ri.Rt))
 
 		if rtVal == 0 {
-			cpu.LO = 0
-			cpu.HI = 0
+			cpu.setHILO(0, 0)
 			// no 0 divisor
-			return nil, false
+			return FlowNext, 0
 		}
 
-		cpu.LO = rsVal / rtVal // Quotient
-		cpu.HI = rsVal % rtVal // Remainder
-		return nil, false
+		cpu.setHILO(rsVal%rtVal, rsVal/rtVal) // Remainder, Quotient
+		return FlowNext, 0
 
 	// DIVU rs, rt
 	//	if (NotWordValue(GPR[rs]) or NotWordValue(GPR[rt])) then UndefinedResult() endif
@@ -263,15 +352,13 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		rtVal := cpu.GetReg(ri.Rt)
 
 		if rtVal == 0 {
-			cpu.LO = 0
-			cpu.HI = 0
+			cpu.setHILO(0, 0)
 			// no 0 divisor
-			return nil, false
+			return FlowNext, 0
 		}
 
-		cpu.LO = int32(rsVal / rtVal) // Quotient
-		cpu.HI = int32(rsVal % rtVal) // Remainder
-		return nil, false
+		cpu.setHILO(int32(rsVal%rtVal), int32(rsVal/rtVal)) // Remainder, Quotient
+		return FlowNext, 0
 
 	// JALR rs
 	// JALR rd, rs
@@ -293,67 +380,75 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		// Jump target
 		newPC := rsVal
 
-		// true => we enter a delay slot
-		return &newPC, true
+		// the jump happens after the delay slot
+		return FlowBranch, newPC
 
 	// JR rs
 	// I: temp ← GPR[rs]
 	// I+ 1 :PC ← temp
 	case OpCodeJR:
 		rsVal := cpu.GetReg(ri.Rs)
-		return &rsVal, true
+		return FlowBranch, rsVal
 
 	// MFHI rd
 	// GPR[rd] ← HI
 	case OpCodeMFHI:
+		if cpu.is64() {
+			cpu.SetReg64(ri.Rd, cpu.hi64())
+			return FlowNext, 0
+		}
 		cpu.SetReg(ri.Rd, uint32(cpu.HI))
-		return nil, false
+		return FlowNext, 0
 
 	// MFLO rd
 	// GPR[rd] ← LO
 	case OpCodeMFLO:
+		if cpu.is64() {
+			cpu.SetReg64(ri.Rd, cpu.lo64())
+			return FlowNext, 0
+		}
 		cpu.SetReg(ri.Rd, uint32(cpu.LO))
-		return nil, false
+		return FlowNext, 0
 
 	// MOVN rd, rs, rt
 	// if GPR[rt] ≠ 0 then
 	// 	GPR[rd] ← GPR[rs]
 	// endif
 	case OpCodeMOVN:
-		rtVal := cpu.GetReg(ri.Rt)
+		rtVal := cpu.GetReg64(ri.Rt)
 		if rtVal != 0 {
-			rsVal := cpu.GetReg(ri.Rs)
-			cpu.SetReg(ri.Rd, rsVal)
+			rsVal := cpu.GetReg64(ri.Rs)
+			cpu.SetReg64(ri.Rd, rsVal)
 		}
-		return nil, false
+		return FlowNext, 0
 
 	// MOVZ rd, rs, rt
 	// if GPR[rt] = 0 then
 	// 	GPR[rd] ← GPR[rs]
 	// endif
 	case OpCodeMOVZ:
-		rtVal := cpu.GetReg(ri.Rt)
+		rtVal := cpu.GetReg64(ri.Rt)
 		if rtVal == 0 {
-			rsVal := cpu.GetReg(ri.Rs)
-			cpu.SetReg(ri.Rd, rsVal)
+			rsVal := cpu.GetReg64(ri.Rs)
+			cpu.SetReg64(ri.Rd, rsVal)
 		}
-		return nil, false
+		return FlowNext, 0
 
 	// MTHI rs
 	// I- 2 :, I- 1 :HI ← undefined
 	// I: HI ← GPR[rs]
 	case OpCodeMTHI:
-		rsVal := cpu.GetReg(ri.Rs)
-		cpu.HI = int32(rsVal)
-		return nil, false
+		rsVal := cpu.GetReg64(ri.Rs)
+		cpu.HI, cpu.hiUpper = int32(rsVal), int32(rsVal>>32)
+		return FlowNext, 0
 
 	// MTLO rs
 	// I- 2 :, I- 1 :LO ← undefined
 	// I: LO ← GPR[rs]
 	case OpCodeMTLO:
-		rsVal := cpu.GetReg(ri.Rs)
-		cpu.LO = int32(rsVal)
-		return nil, false
+		rsVal := cpu.GetReg64(ri.Rs)
+		cpu.LO, cpu.loUpper = int32(rsVal), int32(rsVal>>32)
+		return FlowNext, 0
 
 	// MULT rs, rt
 	// if (NotWordValue(GPR[rs]) or NotWordValue(GPR[rt])) then UndefinedResult() endif
@@ -367,9 +462,8 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		rsVal := int32(cpu.GetReg(ri.Rs))
 		rtVal := int32(cpu.GetReg(ri.Rt))
 		prod := int64(rsVal) * int64(rtVal)
-		cpu.LO = int32(prod & 0xFFFFFFFF)         // Low 32 bits
-		cpu.HI = int32((prod >> 32) & 0xFFFFFFFF) // High 32 bits
-		return nil, false
+		cpu.setHILO(int32(prod>>32), int32(prod)) // High, Low 32 bits
+		return FlowNext, 0
 
 	// MULTU rs, rt
 	// if (NotWordValue(GPR[rs]) or NotWordValue(GPR[rt])) then UndefinedResult() endif
@@ -383,27 +477,26 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		rsVal := cpu.GetReg(ri.Rs)
 		rtVal := cpu.GetReg(ri.Rt)
 		prod := uint64(rsVal) * uint64(rtVal)
-		cpu.LO = int32(prod & 0xFFFFFFFF)         // Low 32 bits
-		cpu.HI = int32((prod >> 32) & 0xFFFFFFFF) // High 32 bits
-		return nil, false
+		cpu.setHILO(int32(prod>>32), int32(prod)) // High, Low 32 bits
+		return FlowNext, 0
 
 	// NOR rd, rs, rt
 	// GPR[rd] ← GPR[rs] nor GPR[rt]
 	case OpCodeNOR:
-		rsVal := cpu.GetReg(ri.Rs)
-		rtVal := cpu.GetReg(ri.Rt)
+		rsVal := cpu.GetReg64(ri.Rs)
+		rtVal := cpu.GetReg64(ri.Rt)
 		temp := ^(rsVal | rtVal)
-		cpu.SetReg(ri.Rd, temp)
-		return nil, false
+		cpu.SetReg64(ri.Rd, temp)
+		return FlowNext, 0
 
 	// OR rd, rs, rt
 	// GPR[rd] ← GPR[rs] or GPR[rt]
 	case OpCodeOR:
-		rsVal := cpu.GetReg(ri.Rs)
-		rtVal := cpu.GetReg(ri.Rt)
+		rsVal := cpu.GetReg64(ri.Rs)
+		rtVal := cpu.GetReg64(ri.Rt)
 		temp := rsVal | rtVal
-		cpu.SetReg(ri.Rd, temp)
-		return nil, false
+		cpu.SetReg64(ri.Rd, temp)
+		return FlowNext, 0
 
 	// SLL rd, rt, sa
 	// s ← sa
@@ -414,7 +507,7 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		s := ri.Shamt
 		temp := rtVal << s
 		cpu.SetReg(ri.Rd, temp)
-		return nil, false
+		return FlowNext, 0
 
 	// SLLV rd, rt, rs
 	// s ← GP[rs]4..0
@@ -425,7 +518,7 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		s := rsVal & 0xF
 		temp := cpu.GetReg(ri.Rt) << s
 		cpu.SetReg(ri.Rd, temp)
-		return nil, false
+		return FlowNext, 0
 
 	// SLT rd, rs, rt
 	// if GPR[rs] < GPR[rt] then
@@ -434,8 +527,8 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 	//	GPR[rd] ← 0GPRLEN
 	// endif
 	case OpCodeSLT:
-		rtVal := int32(cpu.GetReg(ri.Rt))
-		rsVal := int32(cpu.GetReg(ri.Rs))
+		rtVal := int64(cpu.GetReg64(ri.Rt))
+		rsVal := int64(cpu.GetReg64(ri.Rs))
 
 		if rsVal < rtVal {
 			cpu.SetReg(ri.Rd, 1)
@@ -443,7 +536,7 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 			cpu.SetReg(ri.Rd, 0)
 		}
 
-		return nil, false
+		return FlowNext, 0
 
 	// SLTU rd, rs, rt
 	// if (0 || GPR[rs]) < (0 || GPR[rt]) then
@@ -452,8 +545,8 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 	//	GPR[rd] ← 0GPRLEN
 	// endif
 	case OpCodeSLTU:
-		rtVal := cpu.GetReg(ri.Rt)
-		rsVal := cpu.GetReg(ri.Rs)
+		rtVal := cpu.GetReg64(ri.Rt)
+		rsVal := cpu.GetReg64(ri.Rs)
 
 		if rsVal < rtVal {
 			cpu.SetReg(ri.Rd, 1)
@@ -461,7 +554,7 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 			cpu.SetReg(ri.Rd, 0)
 		}
 
-		return nil, false
+		return FlowNext, 0
 
 	// SRA rd, rt, sa
 	// if (NotWordValue(GPR[rt])) then UndefinedResult() endif
@@ -475,7 +568,7 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		s := uint(ri.Shamt & 0x1F)
 		temp := uint32(int32(rtVal) >> s)
 		cpu.SetReg(ri.Rd, temp)
-		return nil, false
+		return FlowNext, 0
 
 	// SRAV rd, rt, rs
 	// if (NotWordValue(GPR[rt])) then UndefinedResult() endif
@@ -488,7 +581,7 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		s := uint(rsVal & 0x1F)
 		temp := uint32(int32(cpu.GetReg(ri.Rt)) >> s)
 		cpu.SetReg(ri.Rd, temp)
-		return nil, false
+		return FlowNext, 0
 
 	// SRL rd, rt, sa
 	// if (NotWordValue(GPR[rt])) then UndefinedResult() endif
@@ -500,7 +593,7 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		s := uint(ri.Shamt & 0x1F)
 		temp := rtVal >> s
 		cpu.SetReg(ri.Rd, temp)
-		return nil, false
+		return FlowNext, 0
 
 	// SRAV rd, rt, rs
 	// if (NotWordValue(GPR[rt])) then UndefinedResult() endif
@@ -512,7 +605,7 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		s := rsVal & 0x1F
 		temp := cpu.GetReg(ri.Rt) >> s
 		cpu.SetReg(ri.Rd, temp)
-		return nil, false
+		return FlowNext, 0
 
 	// SUB rd, rs, rt
 	// if (NotWordValue(GPR[rs]) or NotWordValue(GPR[rt])) then UndefinedResult() endif
@@ -530,14 +623,11 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		// Check for overflow
 		if utils.CheckSubtractionOverflow(rsVal, rtVal, temp) {
 			// Overflow occurred
-			vec := cpu.cp0.RaiseException(excOv, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.raiseException(excOv)
 		}
 
 		cpu.SetReg(ri.Rd, uint32(temp))
-		return nil, false
+		return FlowNext, 0
 
 	// SUBU rd, rs, rt
 	// if (NotWordValue(GPR[rs]) or NotWordValue(GPR[rt])) then UndefinedResult() endif
@@ -548,113 +638,257 @@ func (ri RTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		rtVal := cpu.GetReg(ri.Rt)
 		temp := rsVal - rtVal
 		cpu.SetReg(ri.Rd, temp)
-		return nil, false
+		return FlowNext, 0
+
+	// SYNC stype
+	// SyncOperation(stype)
+	// Every store is globally visible once it returns, so there is nothing to order.
+	case OpCodeSYNC:
+		return FlowNext, 0
 
 	// TEQ rs, rt
 	// if GPR[rs] = GPR[rt] then
 	//	SignalException(Trap)
 	// endif
 	case OpCodeTEQ:
-		rsVal := cpu.GetReg(ri.Rs)
-		rtVal := cpu.GetReg(ri.Rt)
+		rsVal := cpu.GetReg64(ri.Rs)
+		rtVal := cpu.GetReg64(ri.Rt)
 		if rsVal == rtVal {
-			vec := cpu.cp0.RaiseException(excTr, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.raiseException(excTr)
 		}
-		return nil, false
+		return FlowNext, 0
 
 	// TGE rs, rt
 	// if GPR[rs] ≥ GPR[rt] then
 	//	SignalException(Trap)
 	// endif
 	case OpCodeTGE:
-		rsVal := int32(cpu.GetReg(ri.Rs))
-		rtVal := int32(cpu.GetReg(ri.Rt))
+		rsVal := int64(cpu.GetReg64(ri.Rs))
+		rtVal := int64(cpu.GetReg64(ri.Rt))
 		if rsVal >= rtVal {
-			vec := cpu.cp0.RaiseException(excTr, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.raiseException(excTr)
 		}
-		return nil, false
+		return FlowNext, 0
 
 	// TGEU rs, rt
 	// if (0 || GPR[rs]) ≥ (0 || sign_extend(immediate)) then
 	//	SignalException(Trap)
 	// endif
 	case OpCodeTGEU:
-		rsVal := cpu.GetReg(ri.Rs)
-		rtVal := cpu.GetReg(ri.Rt)
+		rsVal := cpu.GetReg64(ri.Rs)
+		rtVal := cpu.GetReg64(ri.Rt)
 		if rsVal >= rtVal {
-			vec := cpu.cp0.RaiseException(excTr, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.raiseException(excTr)
 		}
-		return nil, false
+		return FlowNext, 0
 
 	// TLT rs, rt
 	// if GPR[rs] < GPR[rt] then
 	//	SignalException(Trap)
 	// endif
 	case OpCodeTLT:
-		rsVal := int32(cpu.GetReg(ri.Rs))
-		rtVal := int32(cpu.GetReg(ri.Rt))
+		rsVal := int64(cpu.GetReg64(ri.Rs))
+		rtVal := int64(cpu.GetReg64(ri.Rt))
 		if rsVal < rtVal {
-			vec := cpu.cp0.RaiseException(excTr, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.raiseException(excTr)
 		}
-		return nil, false
+		return FlowNext, 0
 
 	// TLTU rs, rt
 	// if (0 || GPR[rs]) < (0 || sign_extend(immediate)) then
 	//	SignalException(Trap)
 	// endif
 	case OpCodeTLTU:
-		rsVal := cpu.GetReg(ri.Rs)
-		rtVal := cpu.GetReg(ri.Rt)
+		rsVal := cpu.GetReg64(ri.Rs)
+		rtVal := cpu.GetReg64(ri.Rt)
 		if rsVal < rtVal {
-			vec := cpu.cp0.RaiseException(excTr, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.raiseException(excTr)
 		}
-		return nil, false
+		return FlowNext, 0
 
 	// TNE rs, rt
 	// if GPR[rs] ≠ GPR[rt] then
 	//	SignalException(Trap)
 	// endif
 	case OpCodeTNE:
-		rsVal := cpu.GetReg(ri.Rs)
-		rtVal := cpu.GetReg(ri.Rt)
+		rsVal := cpu.GetReg64(ri.Rs)
+		rtVal := cpu.GetReg64(ri.Rt)
 		if rsVal != rtVal {
-			vec := cpu.cp0.RaiseException(excTr, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.raiseException(excTr)
 		}
-		return nil, false
+		return FlowNext, 0
+
+	// SYSCALL
+	// SignalException(SystemCall)
+	case OpCodeSYSCALL:
+		if h := cpu.hooks; h != nil && h.Syscall != nil && h.Syscall() {
+			return FlowNext, 0 // serviced by the embedder
+		}
+		return cpu.raiseException(excSys)
+
+	// BREAK
+	// SignalException(Breakpoint)
+	case OpCodeBREAK:
+		return cpu.raiseException(excBp)
 
 	// XOR rd, rs, rt
 	// GPR[rd] ← GPR[rs] xor GPR[rt]
 	case OpCodeXOR:
-		rsVal := cpu.GetReg(ri.Rs)
-		rtVal := cpu.GetReg(ri.Rt)
+		rsVal := cpu.GetReg64(ri.Rs)
+		rtVal := cpu.GetReg64(ri.Rt)
 		temp := rsVal ^ rtVal
-		cpu.SetReg(ri.Rd, temp)
-		return nil, false
+		cpu.SetReg64(ri.Rd, temp)
+		return FlowNext, 0
+
+	// DADD rd, rs, rt (MIPS64)
+	// temp ← (GPR[rs]63||GPR[rs]) + (GPR[rt]63||GPR[rt])
+	// if (temp64 ≠ temp63) then
+	//	SignalException(IntegerOverflow)
+	// else
+	//	GPR[rd] ← temp63..0
+	// endif
+	case OpCodeDADD:
+		rsVal := int64(cpu.GetReg64(ri.Rs))
+		rtVal := int64(cpu.GetReg64(ri.Rt))
+		temp := rsVal + rtVal
+		if (rsVal >= 0) == (rtVal >= 0) && (temp >= 0) != (rsVal >= 0) {
+			return cpu.raiseException(excOv)
+		}
+		cpu.SetReg64(ri.Rd, uint64(temp))
+		return FlowNext, 0
+
+	// DADDU rd, rs, rt (MIPS64)
+	// GPR[rd] ← GPR[rs] + GPR[rt]
+	case OpCodeDADDU:
+		cpu.SetReg64(ri.Rd, cpu.GetReg64(ri.Rs)+cpu.GetReg64(ri.Rt))
+		return FlowNext, 0
+
+	// DSUB rd, rs, rt (MIPS64)
+	// temp ← (GPR[rs]63||GPR[rs]) - (GPR[rt]63||GPR[rt])
+	// if (temp64 ≠ temp63) then
+	//	SignalException(IntegerOverflow)
+	// else
+	//	GPR[rd] ← temp63..0
+	// endif
+	case OpCodeDSUB:
+		rsVal := int64(cpu.GetReg64(ri.Rs))
+		rtVal := int64(cpu.GetReg64(ri.Rt))
+		temp := rsVal - rtVal
+		if (rsVal >= 0) != (rtVal >= 0) && (temp >= 0) != (rsVal >= 0) {
+			return cpu.raiseException(excOv)
+		}
+		cpu.SetReg64(ri.Rd, uint64(temp))
+		return FlowNext, 0
+
+	// DSUBU rd, rs, rt (MIPS64)
+	// GPR[rd] ← GPR[rs] - GPR[rt]
+	case OpCodeDSUBU:
+		cpu.SetReg64(ri.Rd, cpu.GetReg64(ri.Rs)-cpu.GetReg64(ri.Rt))
+		return FlowNext, 0
+
+	// DSLL rd, rt, sa / DSLL32 rd, rt, sa (MIPS64)
+	// s ← 0 || sa, or 1 || sa for DSLL32
+	// GPR[rd] ← GPR[rt](63-s)..0 || 0s
+	case OpCodeDSLL, OpCodeDSLL32:
+		cpu.SetReg64(ri.Rd, cpu.GetReg64(ri.Rt)<<ri.shift64())
+		return FlowNext, 0
+
+	// DSRL rd, rt, sa / DSRL32 rd, rt, sa (MIPS64)
+	// s ← 0 || sa, or 1 || sa for DSRL32
+	// GPR[rd] ← 0s || GPR[rt]63..s
+	case OpCodeDSRL, OpCodeDSRL32:
+		cpu.SetReg64(ri.Rd, cpu.GetReg64(ri.Rt)>>ri.shift64())
+		return FlowNext, 0
+
+	// DSRA rd, rt, sa / DSRA32 rd, rt, sa (MIPS64)
+	// s ← 0 || sa, or 1 || sa for DSRA32
+	// GPR[rd] ← (GPR[rt]63)s || GPR[rt]63..s
+	case OpCodeDSRA, OpCodeDSRA32:
+		cpu.SetReg64(ri.Rd, uint64(int64(cpu.GetReg64(ri.Rt))>>ri.shift64()))
+		return FlowNext, 0
+
+	// DSLLV rd, rt, rs (MIPS64)
+	// s ← GPR[rs]5..0
+	// GPR[rd] ← GPR[rt](63-s)..0 || 0s
+	case OpCodeDSLLV:
+		s := cpu.GetReg64(ri.Rs) & 0x3F
+		cpu.SetReg64(ri.Rd, cpu.GetReg64(ri.Rt)<<s)
+		return FlowNext, 0
+
+	// DSRLV rd, rt, rs (MIPS64)
+	// s ← GPR[rs]5..0
+	// GPR[rd] ← 0s || GPR[rt]63..s
+	case OpCodeDSRLV:
+		s := cpu.GetReg64(ri.Rs) & 0x3F
+		cpu.SetReg64(ri.Rd, cpu.GetReg64(ri.Rt)>>s)
+		return FlowNext, 0
+
+	// DSRAV rd, rt, rs (MIPS64)
+	// s ← GPR[rs]5..0
+	// GPR[rd] ← (GPR[rt]63)s || GPR[rt]63..s
+	case OpCodeDSRAV:
+		s := cpu.GetReg64(ri.Rs) & 0x3F
+		cpu.SetReg64(ri.Rd, uint64(int64(cpu.GetReg64(ri.Rt))>>s))
+		return FlowNext, 0
+
+	// DMULT rs, rt (MIPS64)
+	// prod ← GPR[rs] * GPR[rt]
+	// LO ← prod63..0
+	// HI ← prod127..64
+	case OpCodeDMULT:
+		rsVal := cpu.GetReg64(ri.Rs)
+		rtVal := cpu.GetReg64(ri.Rt)
+		hi, lo := bits.Mul64(rsVal, rtVal)
+		// correct the unsigned high half for negative operands
+		if int64(rsVal) < 0 {
+			hi -= rtVal
+		}
+		if int64(rtVal) < 0 {
+			hi -= rsVal
+		}
+		cpu.setHILO64(hi, lo)
+		return FlowNext, 0
+
+	// DMULTU rs, rt (MIPS64)
+	// prod ← (0 || GPR[rs]) * (0 || GPR[rt])
+	// LO ← prod63..0
+	// HI ← prod127..64
+	case OpCodeDMULTU:
+		hi, lo := bits.Mul64(cpu.GetReg64(ri.Rs), cpu.GetReg64(ri.Rt))
+		cpu.setHILO64(hi, lo)
+		return FlowNext, 0
+
+	// DDIV rs, rt (MIPS64)
+	// LO ← GPR[rs] div GPR[rt]
+	// HI ← GPR[rs] mod GPR[rt]
+	case OpCodeDDIV:
+		rsVal := int64(cpu.GetReg64(ri.Rs))
+		rtVal := int64(cpu.GetReg64(ri.Rt))
+		if rtVal == 0 {
+			cpu.setHILO64(0, 0)
+			// no 0 divisor
+			return FlowNext, 0
+		}
+		cpu.setHILO64(uint64(rsVal%rtVal), uint64(rsVal/rtVal))
+		return FlowNext, 0
+
+	// DDIVU rs, rt (MIPS64)
+	// LO ← (0 || GPR[rs]) div (0 || GPR[rt])
+	// HI ← (0 || GPR[rs]) mod (0 || GPR[rt])
+	case OpCodeDDIVU:
+		rsVal := cpu.GetReg64(ri.Rs)
+		rtVal := cpu.GetReg64(ri.Rt)
+		if rtVal == 0 {
+			cpu.setHILO64(0, 0)
+			// no 0 divisor
+			return FlowNext, 0
+		}
+		cpu.setHILO64(rsVal%rtVal, rsVal/rtVal)
+		return FlowNext, 0
 
 	default:
 		// Unknown/unsupported opcode -> treat as reserved instruction (ISA exception)
-		vec := cpu.cp0.RaiseException(excRI, cpu.PC, cpu.inDelay)
-		cpu.PC = vec
-		cpu.inDelay = false
-		return nil, false
+		return cpu.raiseException(excRI)
 	}
 
 }
@@ -667,7 +901,13 @@ type ITypeInstruction struct {
 }
 
 func (ii ITypeInstruction) Decode(instr uint32) Instruction {
-	return &ITypeInstruction{
+	i := decodeIType(instr)
+	return &i
+}
+
+// decodeIType extracts the I-Type fields of instr.
+func decodeIType(instr uint32) ITypeInstruction {
+	return ITypeInstruction{
 		Opcode:    uint8((instr >> 26) & 0x3F),
 		Rs:        uint8((instr >> 21) & 0x1F),
 		Rt:        uint8((instr >> 16) & 0x1F),
@@ -675,7 +915,12 @@ func (ii ITypeInstruction) Decode(instr uint32) Instruction {
 	}
 }
 
-func (ii *ITypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
+func (ii *ITypeInstruction) Execute(cpu *CPU) (flow Flow, target uint32) {
+	// the doubleword instructions are reserved on a 32-bit CPU
+	if ii.doubleword() && !cpu.is64() {
+		return cpu.raiseException(excRI)
+	}
+
 	switch OpCode(ii.Opcode) {
 
 	// ADDI rt, rs, immediate
@@ -695,14 +940,11 @@ func (ii *ITypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		// Check for overflow
 		if utils.CheckAdditionOverflow(rsVal, immVal, temp) {
 			// Overflow occurred
-			vec := cpu.cp0.RaiseException(excOv, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.raiseException(excOv)
 		}
 
 		cpu.SetReg(ii.Rt, uint32(temp))
-		return nil, false
+		return FlowNext, 0
 
 	// ADDIU rt, rs, immediate
 	// if (NotWordValue(GPR[rs]) or NotWordValue(sign_extend(immediate))) then UndefinedResult() endif
@@ -713,16 +955,80 @@ func (ii *ITypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		immVal := uint32(int16(ii.Immediate)) // sign-extend immediate
 		temp := rsVal + immVal
 		cpu.SetReg(ii.Rt, temp)
-		return nil, false
+		return FlowNext, 0
 
 	// ANDI rt, rs, immediate
 	// GPR[rt] ← GPR[rs] and zero_extend(immediate)
 	case OpCodeANDI:
-		rsVal := cpu.GetReg(ii.Rs)
-		immVal := uint32(ii.Immediate) // zero-extend immediate
+		rsVal := cpu.GetReg64(ii.Rs)
+		immVal := uint64(ii.Immediate) // zero-extend immediate
 		temp := rsVal & immVal
-		cpu.SetReg(ii.Rt, temp)
-		return nil, false
+		cpu.SetReg64(ii.Rt, temp)
+		return FlowNext, 0
+
+	// BEQ rs, rt, offset
+	// I: target_offset ← sign_extend(offset || 0^2)
+	// 	condition ← (GPR[rs] = GPR[rt])
+	// I+1: if condition then
+	// 	PC ← PC + target_offset
+	// endif
+	case OpCodeBEQ, OpCodeBEQL:
+		taken := cpu.GetReg64(ii.Rs) == cpu.GetReg64(ii.Rt)
+		return branch(cpu, taken, OpCode(ii.Opcode) == OpCodeBEQL, ii.Immediate)
+
+	// BNE rs, rt, offset
+	// I: target_offset ← sign_extend(offset || 0^2)
+	// 	condition ← (GPR[rs] ≠ GPR[rt])
+	// I+1: if condition then
+	// 	PC ← PC + target_offset
+	// endif
+	case OpCodeBNE, OpCodeBNEL:
+		taken := cpu.GetReg64(ii.Rs) != cpu.GetReg64(ii.Rt)
+		return branch(cpu, taken, OpCode(ii.Opcode) == OpCodeBNEL, ii.Immediate)
+
+	// BLEZ rs, offset
+	// I: target_offset ← sign_extend(offset || 0^2)
+	// 	condition ← GPR[rs] ≤ 0^GPRLEN
+	// I+1: if condition then
+	// 	PC ← PC + target_offset
+	// endif
+	case OpCodeBLEZ, OpCodeBLEZL:
+		taken := int64(cpu.GetReg64(ii.Rs)) <= 0
+		return branch(cpu, taken, OpCode(ii.Opcode) == OpCodeBLEZL, ii.Immediate)
+
+	// BGTZ rs, offset
+	// I: target_offset ← sign_extend(offset || 0^2)
+	// 	condition ← GPR[rs] > 0^GPRLEN
+	// I+1: if condition then
+	// 	PC ← PC + target_offset
+	// endif
+	case OpCodeBGTZ, OpCodeBGTZL:
+		taken := int64(cpu.GetReg64(ii.Rs)) > 0
+		return branch(cpu, taken, OpCode(ii.Opcode) == OpCodeBGTZL, ii.Immediate)
+
+	// BLTZ/BGEZ[AL][L] rs, offset
+	// I: target_offset ← sign_extend(offset || 0^2)
+	// 	condition ← GPR[rs] < 0^GPRLEN (BLTZ) or GPR[rs] ≥ 0^GPRLEN (BGEZ)
+	// 	GPR[31] ← PC + 8 (AL forms, regardless of the condition)
+	// I+1: if condition then
+	// 	PC ← PC + target_offset
+	// endif
+	case OpCodeREGIMM:
+		rsVal := int64(cpu.GetReg64(ii.Rs))
+		switch ii.Rt {
+		case REGIMM_BLTZ, REGIMM_BLTZL, REGIMM_BLTZAL, REGIMM_BLTZALL:
+			if ii.Rt&0x10 != 0 {
+				cpu.SetReg(31, cpu.PC+8)
+			}
+			return branch(cpu, rsVal < 0, ii.Rt&0x2 != 0, ii.Immediate)
+		case REGIMM_BGEZ, REGIMM_BGEZL, REGIMM_BGEZAL, REGIMM_BGEZALL:
+			if ii.Rt&0x10 != 0 {
+				cpu.SetReg(31, cpu.PC+8)
+			}
+			return branch(cpu, rsVal >= 0, ii.Rt&0x2 != 0, ii.Immediate)
+		default:
+			return cpu.raiseException(excRI)
+		}
 
 	// LB rt, offset(rs)
 	// vAddr ← sign_extend(offset) + GPR[base]
@@ -736,17 +1042,15 @@ func (ii *ITypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		offset := int32(int16(ii.Immediate))
 		addr := base + offset
 
-		b, ok := cpu.Memory.LoadWord(uint32(addr))
+		b, ok := cpu.Memory.LoadByte(uint32(addr))
 		if !ok {
-			vec := cpu.cp0.RaiseException(excAdEL, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.addressError(excAdEL, uint32(addr))
 		}
+		cpu.dataAccess(uint32(addr), 1, uint32(b), false)
 
 		// sign extend byte → 32 bits
 		cpu.SetReg(ii.Rt, uint32(int8(b)))
-		return nil, false
+		return FlowNext, 0
 
 	// LBU rt, offset(rs)
 	// vAddr ← sign_extend(offset) + GPR[base]
@@ -760,17 +1064,15 @@ func (ii *ITypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		offset := int32(int16(ii.Immediate))
 		addr := base + offset
 
-		b, ok := cpu.Memory.LoadWord(uint32(addr))
+		b, ok := cpu.Memory.LoadByte(uint32(addr))
 		if !ok {
-			vec := cpu.cp0.RaiseException(excAdEL, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.addressError(excAdEL, uint32(addr))
 		}
+		cpu.dataAccess(uint32(addr), 1, uint32(b), false)
 
 		// zero extend byte → 32 bits
 		cpu.SetReg(ii.Rt, uint32(b))
-		return nil, false
+		return FlowNext, 0
 
 	// LH rt, offset(rs)
 	// vAddr ← sign_extend(offset) + GPR[base]
@@ -785,22 +1087,17 @@ func (ii *ITypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		addr := base + offset
 
 		if addr%2 != 0 {
-			vec := cpu.cp0.RaiseException(excAdEL, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.addressError(excAdEL, uint32(addr))
 		}
 
-		h, ok := cpu.Memory.LoadWord(uint32(addr))
+		h, ok := cpu.Memory.LoadHalf(uint32(addr))
 		if !ok {
-			vec := cpu.cp0.RaiseException(excAdEL, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.addressError(excAdEL, uint32(addr))
 		}
+		cpu.dataAccess(uint32(addr), 2, uint32(h), false)
 
 		cpu.SetReg(ii.Rt, uint32(int16(h)))
-		return nil, false
+		return FlowNext, 0
 
 	// LHU rt, offset(rs)
 	// vAddr ← sign_extend(offset) + GPR[base]
@@ -815,22 +1112,17 @@ func (ii *ITypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		addr := base + offset
 
 		if addr%2 != 0 {
-			vec := cpu.cp0.RaiseException(excAdEL, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.addressError(excAdEL, uint32(addr))
 		}
 
-		h, ok := cpu.Memory.LoadWord(uint32(addr))
+		h, ok := cpu.Memory.LoadHalf(uint32(addr))
 		if !ok {
-			vec := cpu.cp0.RaiseException(excAdEL, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.addressError(excAdEL, uint32(addr))
 		}
+		cpu.dataAccess(uint32(addr), 2, uint32(h), false)
 
-		cpu.SetReg(ii.Rt, h)
-		return nil, false
+		cpu.SetReg(ii.Rt, uint32(h))
+		return FlowNext, 0
 
 	// LUI rt, immediate
 	// GPR[rt] ← immediate || 16b'0
@@ -838,7 +1130,7 @@ func (ii *ITypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		immVal := uint32(ii.Immediate)
 		temp := immVal << 16
 		cpu.SetReg(ii.Rt, temp)
-		return nil, false
+		return FlowNext, 0
 
 	// LW rt, offset(rs)
 	// vAddr ← sign_extend(offset) + GPR[base]
@@ -852,60 +1144,119 @@ func (ii *ITypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		addr := base + offset
 
 		if addr%4 != 0 {
-			vec := cpu.cp0.RaiseException(excAdEL, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.addressError(excAdEL, uint32(addr))
 		}
 
 		w, ok := cpu.Memory.LoadWord(uint32(addr))
 		if !ok {
-			vec := cpu.cp0.RaiseException(excAdEL, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.addressError(excAdEL, uint32(addr))
 		}
+		cpu.dataAccess(uint32(addr), 4, w, false)
 
 		cpu.SetReg(ii.Rt, w)
-		return nil, false
-
-		// LWU rt, offset(rs)
-		// vAddr ← sign_extend(offset) + GPR[base]
-		// (pAddr, uncached) ← AddressTranslation(vAddr, DATA, LOAD)
-		// pAddr ← pAddr(pSize-1..2) || (pAddr1..0 xor ReverseEndian^2)
-		// memword ← LoadMemory(uncached, WORD, pAddr, vAddr, DATA)
-		// GPR[rt] ← zero_extend(memword31..0)
+		return FlowNext, 0
+
+	// LWU rt, offset(rs)
+	// vAddr ← sign_extend(offset) + GPR[base]
+	// (pAddr, uncached) ← AddressTranslation(vAddr, DATA, LOAD)
+	// pAddr ← pAddr(pSize-1..2) || (pAddr1..0 xor ReverseEndian^2)
+	// memword ← LoadMemory(uncached, WORD, pAddr, vAddr, DATA)
+	// GPR[rt] ← zero_extend(memword31..0)
 	case OpCodeLWU:
 		base := int32(cpu.GetReg(ii.Rs))
 		offset := int32(int16(ii.Immediate))
 		addr := base + offset
 
 		if addr%4 != 0 {
-			vec := cpu.cp0.RaiseException(excAdEL, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.addressError(excAdEL, uint32(addr))
 		}
 
 		w, ok := cpu.Memory.LoadWord(uint32(addr))
 		if !ok {
-			vec := cpu.cp0.RaiseException(excAdEL, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.addressError(excAdEL, uint32(addr))
 		}
+		cpu.dataAccess(uint32(addr), 4, w, false)
 
+		if cpu.is64() {
+			cpu.SetReg64(ii.Rt, uint64(w))
+			return FlowNext, 0
+		}
 		cpu.SetReg(ii.Rt, w)
-		return nil, false
+		return FlowNext, 0
+
+	// LL rt, offset(base)
+	// vAddr ← sign_extend(offset) + GPR[base]
+	// if vAddr1..0 ≠ 0^2 then SignalException(AddressError) endif
+	// (pAddr, CCA) ← AddressTranslation (vAddr, DATA, LOAD)
+	// memword ← LoadMemory (CCA, WORD, pAddr, vAddr, DATA)
+	// GPR[rt] ← memword
+	// LLbit ← 1
+	case OpCodeLL:
+		base := int32(cpu.GetReg(ii.Rs))
+		offset := int32(int16(ii.Immediate))
+		addr := base + offset
+
+		if addr%4 != 0 {
+			return cpu.addressError(excAdEL, uint32(addr))
+		}
+
+		w, ok := cpu.Memory.LoadLinked(cpu.CPUNum(), uint32(addr))
+		if !ok {
+			return cpu.addressError(excAdEL, uint32(addr))
+		}
+		cpu.dataAccess(uint32(addr), 4, w, false)
+
+		cpu.cp0.lladdr = uint32(addr) >> 4 // LLAddr holds PAddr[35:4]
+		cpu.SetReg(ii.Rt, w)
+		return FlowNext, 0
+
+	// SC rt, offset(base)
+	// vAddr ← sign_extend(offset) + GPR[base]
+	// if vAddr1..0 ≠ 0^2 then SignalException(AddressError) endif
+	// (pAddr, CCA) ← AddressTranslation (vAddr, DATA, STORE)
+	// dataword ← GPR[rt]
+	// if LLbit then
+	// 	StoreMemory (CCA, WORD, dataword, pAddr, vAddr, DATA)
+	// endif
+	// GPR[rt] ← 0^31 || LLbit
+	case OpCodeSC:
+		base := int32(cpu.GetReg(ii.Rs))
+		offset := int32(int16(ii.Immediate))
+		addr := base + offset
+
+		if addr%4 != 0 || !cpu.Memory.isAddressInRange(uint32(addr)+3) {
+			return cpu.addressError(excAdES, uint32(addr))
+		}
+
+		w := cpu.GetReg(ii.Rt)
+		if cpu.Memory.StoreConditional(cpu.CPUNum(), uint32(addr), w) {
+			cpu.dataAccess(uint32(addr), 4, w, true)
+			cpu.SetReg(ii.Rt, 1)
+		} else {
+			cpu.SetReg(ii.Rt, 0)
+		}
+		return FlowNext, 0
+
+	// CACHE op, offset(base)
+	// vAddr ← GPR[base] + sign_extend(offset)
+	// (pAddr, uncached) ← AddressTranslation(vAddr, DataReadReference)
+	// CacheOp(op, vAddr, pAddr)
+	case OpCodeCACHE:
+		if !cpu.cp0.cop0Usable() {
+			return cpu.raiseException(excCpU)
+		}
+		addr := cpu.GetReg(ii.Rs) + uint32(int32(int16(ii.Immediate)))
+		cpu.cacheOp(ii.Rt, addr)
+		return FlowNext, 0
 
 	// ORI rt, rs, immediate
 	// GPR[rt] ← GPR[rs] or zero_extend(immediate)
 	case OpCodeORI:
-		rsVal := cpu.GetReg(ii.Rs)
-		immVal := uint32(ii.Immediate) // zero-extend immediate
+		rsVal := cpu.GetReg64(ii.Rs)
+		immVal := uint64(ii.Immediate) // zero-extend immediate
 		temp := rsVal | immVal
-		cpu.SetReg(ii.Rt, temp)
-		return nil, false
+		cpu.SetReg64(ii.Rt, temp)
+		return FlowNext, 0
 
 	// SB rt, offset(rs)
 	// vAddr ← sign_extend(offset) + GPR[base]
@@ -920,15 +1271,13 @@ func (ii *ITypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 
 		b := byte(cpu.GetReg(ii.Rt) & 0xFF)
 
-		ok := cpu.Memory.StoreWord(uint32(addr), uint32(b))
+		ok := cpu.Memory.StoreByte(uint32(addr), b)
 		if !ok {
-			vec := cpu.cp0.RaiseException(excAdES, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.addressError(excAdES, uint32(addr))
 		}
+		cpu.dataAccess(uint32(addr), 1, uint32(b), true)
 
-		return nil, false
+		return FlowNext, 0
 
 	// SH rt, offset(rs)
 	// vAddr ← sign_extend(offset) + GPR[base]
@@ -942,23 +1291,18 @@ func (ii *ITypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		addr := base + offset
 
 		if addr%2 != 0 {
-			vec := cpu.cp0.RaiseException(excAdES, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.addressError(excAdES, uint32(addr))
 		}
 
 		h := cpu.GetReg(ii.Rt) & 0xFFFF
 
-		ok := cpu.Memory.StoreWord(uint32(addr), h)
+		ok := cpu.Memory.StoreHalf(uint32(addr), uint16(h))
 		if !ok {
-			vec := cpu.cp0.RaiseException(excAdES, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.addressError(excAdES, uint32(addr))
 		}
+		cpu.dataAccess(uint32(addr), 2, h, true)
 
-		return nil, false
+		return FlowNext, 0
 
 	// SLTI rt, rs, immediate
 	// if GPR[rs] < sign_extend(immediate) then
@@ -967,8 +1311,8 @@ func (ii *ITypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 	//	GPR[rt] ← 0GPRLEN
 	// endif
 	case OpCodeSLTI:
-		rsVal := int32(cpu.GetReg(ii.Rs))
-		immVal := int32(int16(ii.Immediate)) // sign-extend immediate
+		rsVal := int64(cpu.GetReg64(ii.Rs))
+		immVal := int64(int16(ii.Immediate)) // sign-extend immediate
 
 		if rsVal < immVal {
 			cpu.SetReg(ii.Rt, 1)
@@ -976,7 +1320,7 @@ func (ii *ITypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 			cpu.SetReg(ii.Rt, 0)
 		}
 
-		return nil, false
+		return FlowNext, 0
 
 	// SLTIU rt, rs, immediate
 	// if (0 || GPR[rs]) < (0 || sign_extend(immediate)) then
@@ -985,8 +1329,8 @@ func (ii *ITypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 	//	GPR[rt] ← 0GPRLEN
 	// endif
 	case OpCodeSLTIU:
-		rsVal := cpu.GetReg(ii.Rs)
-		immVal := uint32(int16(ii.Immediate)) // sign-extend immediate
+		rsVal := cpu.GetReg64(ii.Rs)
+		immVal := uint64(int16(ii.Immediate)) // sign-extend immediate
 
 		if rsVal < immVal {
 			cpu.SetReg(ii.Rt, 1)
@@ -994,7 +1338,7 @@ func (ii *ITypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 			cpu.SetReg(ii.Rt, 0)
 		}
 
-		return nil, false
+		return FlowNext, 0
 
 	// SW rt, offset(rs)
 	// vAddr ← sign_extend(offset) + GPR[base]
@@ -1007,39 +1351,108 @@ func (ii *ITypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		addr := base + offset
 
 		if addr%4 != 0 {
-			vec := cpu.cp0.RaiseException(excAdES, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.addressError(excAdES, uint32(addr))
 		}
 
 		w := cpu.GetReg(ii.Rt)
 
 		ok := cpu.Memory.StoreWord(uint32(addr), w)
 		if !ok {
-			vec := cpu.cp0.RaiseException(excAdES, cpu.PC, cpu.inDelay)
-			cpu.PC = vec
-			cpu.inDelay = false
-			return nil, false
+			return cpu.addressError(excAdES, uint32(addr))
 		}
+		cpu.dataAccess(uint32(addr), 4, w, true)
 
-		return nil, false
+		return FlowNext, 0
 
 	// XORI rt, rs, immediate
 	// GPR[rt] ← GPR[rs] xor zero_extend(immediate)
 	case OpCodeXORI:
-		rsVal := cpu.GetReg(ii.Rs)
-		immVal := uint32(ii.Immediate) // zero-extend immediate
+		rsVal := cpu.GetReg64(ii.Rs)
+		immVal := uint64(ii.Immediate) // zero-extend immediate
 		temp := rsVal ^ immVal
-		cpu.SetReg(ii.Rt, temp)
-		return nil, false
+		cpu.SetReg64(ii.Rt, temp)
+		return FlowNext, 0
+
+	// DADDI rt, rs, immediate (MIPS64)
+	// temp ← (GPR[rs]63||GPR[rs]) + sign_extend(immediate)
+	// if (temp64 ≠ temp63) then
+	//	SignalException(IntegerOverflow)
+	// else
+	//	GPR[rt] ← temp63..0
+	// endif
+	case OpCodeDADDI:
+		rsVal := int64(cpu.GetReg64(ii.Rs))
+		immVal := int64(int16(ii.Immediate)) // sign-extend immediate
+		temp := rsVal + immVal
+		if (rsVal >= 0) == (immVal >= 0) && (temp >= 0) != (rsVal >= 0) {
+			return cpu.raiseException(excOv)
+		}
+		cpu.SetReg64(ii.Rt, uint64(temp))
+		return FlowNext, 0
+
+	// DADDIU rt, rs, immediate (MIPS64)
+	// GPR[rt] ← GPR[rs] + sign_extend(immediate)
+	case OpCodeDADDIU:
+		cpu.SetReg64(ii.Rt, cpu.GetReg64(ii.Rs)+uint64(int16(ii.Immediate)))
+		return FlowNext, 0
+
+	// LD rt, offset(base) (MIPS64)
+	// vAddr ← sign_extend(offset) + GPR[base]
+	// if vAddr2..0 ≠ 0^3 then SignalException(AddressError) endif
+	// (pAddr, uncached) ← AddressTranslation(vAddr, DATA, LOAD)
+	// memdoubleword ← LoadMemory(uncached, DOUBLEWORD, pAddr, vAddr, DATA)
+	// GPR[rt] ← memdoubleword
+	case OpCodeLD:
+		vaddr := cpu.address64(ii.Rs, ii.Immediate)
+		if vaddr%8 != 0 {
+			return cpu.addressError(excAdEL, uint32(vaddr))
+		}
+		addr := uint32(vaddr)
+		if !compat32(vaddr) {
+			// outside the compatibility segments: BadVAddr holds the low word
+			return cpu.addressError(excAdEL, addr)
+		}
+
+		// big-endian: the most significant word comes first
+		hi, okHi := cpu.Memory.LoadWord(addr)
+		lo, okLo := cpu.Memory.LoadWord(addr + 4)
+		if !okHi || !okLo {
+			return cpu.addressError(excAdEL, addr)
+		}
+		cpu.dataAccess(addr, 4, hi, false)
+		cpu.dataAccess(addr+4, 4, lo, false)
+
+		cpu.SetReg64(ii.Rt, uint64(hi)<<32|uint64(lo))
+		return FlowNext, 0
+
+	// SD rt, offset(base) (MIPS64)
+	// vAddr ← sign_extend(offset) + GPR[base]
+	// if vAddr2..0 ≠ 0^3 then SignalException(AddressError) endif
+	// (pAddr, uncached) ← AddressTranslation(vAddr, DATA, STORE)
+	// StoreMemory(uncached, DOUBLEWORD, pAddr, vAddr, DATA, GPR[rt])
+	case OpCodeSD:
+		vaddr := cpu.address64(ii.Rs, ii.Immediate)
+		if vaddr%8 != 0 {
+			return cpu.addressError(excAdES, uint32(vaddr))
+		}
+		addr := uint32(vaddr)
+		if !compat32(vaddr) {
+			// outside the compatibility segments: BadVAddr holds the low word
+			return cpu.addressError(excAdES, addr)
+		}
+
+		d := cpu.GetReg64(ii.Rt)
+		if !cpu.Memory.StoreWord(addr, uint32(d>>32)) || !cpu.Memory.StoreWord(addr+4, uint32(d)) {
+			return cpu.addressError(excAdES, addr)
+		}
+		cpu.dataAccess(addr, 4, uint32(d>>32), true)
+		cpu.dataAccess(addr+4, 4, uint32(d), true)
+
+		return FlowNext, 0
 
 	default:
 		// Unknown/unsupported opcode -> treat as reserved instruction (ISA exception)
-		vec := cpu.cp0.RaiseException(excRI, cpu.PC, cpu.inDelay)
-		cpu.PC = vec
-		cpu.inDelay = false
-		return nil, false
+		return cpu.raiseException(excRI)
 	}
 }
 
@@ -1049,13 +1462,19 @@ type JTypeInstruction struct {
 }
 
 func (ji JTypeInstruction) Decode(instr uint32) Instruction {
-	return &JTypeInstruction{
+	j := decodeJType(instr)
+	return &j
+}
+
+// decodeJType extracts the J-Type fields of instr.
+func decodeJType(instr uint32) JTypeInstruction {
+	return JTypeInstruction{
 		Opcode: uint8((instr >> 26) & 0x3F),
 		Addr:   instr & 0x3FFFFFF,
 	}
 }
 
-func (ji JTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
+func (ji *JTypeInstruction) Execute(cpu *CPU) (flow Flow, target uint32) {
 
 	switch OpCode(ji.Opcode) {
 
@@ -1065,7 +1484,7 @@ func (ji JTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 		// Calculate new PC, combining upper 4 bits of PC+4 with target address shifted left by 2
 		// to form a full 32-bit address
 		newPC := (cpu.PC+4)&0xF0000000 | (ji.Addr << 2)
-		return &newPC, true
+		return FlowBranch, newPC
 
 		// JAL target
 		// I: GPR[31] ← PC + 8
@@ -1077,14 +1496,19 @@ func (ji JTypeInstruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
 
 		// Calculate new PC
 		newPC := (cpu.PC+4)&0xF0000000 | (ji.Addr << 2)
-		return &newPC, true
+		return FlowBranch, newPC
+
+		// JALX target
+		// I: GPR[31] ← PC + 8
+		// I+ 1 :PC ← (PC + 4)(31..28) || target || 00, ISAMode ← 1
+	case OpCodeJALX:
+		cpu.SetReg(31, cpu.PC+8)
+		newPC := (cpu.PC+4)&0xF0000000 | (ji.Addr << 2)
+		return FlowBranch, newPC | 1
 
 	default:
 		// Unknown/unsupported opcode -> treat as reserved instruction (ISA exception)
-		vec := cpu.cp0.RaiseException(excRI, cpu.PC, cpu.inDelay)
-		cpu.PC = vec
-		cpu.inDelay = false
-		return nil, false
+		return cpu.raiseException(excRI)
 	}
 
 }
@@ -1100,6 +1524,12 @@ type COP0Instruction struct {
 }
 
 func (ci COP0Instruction) Decode(instr uint32) Instruction {
+	c := decodeCOP0(instr)
+	return &c
+}
+
+// decodeCOP0 extracts the COP0 instruction fields of instr.
+func decodeCOP0(instr uint32) COP0Instruction {
 	opcode := uint8((instr >> 26) & 0x3F)
 	rs := uint8((instr >> 21) & 0x1F)
 	rt := uint8((instr >> 16) & 0x1F)
@@ -1107,7 +1537,7 @@ func (ci COP0Instruction) Decode(instr uint32) Instruction {
 	sel := uint8(instr & 0x7)
 	funct := uint8(instr & 0x3F)
 
-	return &COP0Instruction{
+	return COP0Instruction{
 		Opcode: opcode,
 		Rs:     rs,
 		Rt:     rt,
@@ -1117,55 +1547,89 @@ func (ci COP0Instruction) Decode(instr uint32) Instruction {
 	}
 }
 
-func (ci COP0Instruction) Execute(cpu *CPU) (nextPC *uint32, delaySlot bool) {
+func (ci *COP0Instruction) Execute(cpu *CPU) (flow Flow, target uint32) {
 	switch ci.Rs {
 	case COP0Funct_MFC0:
 		// Move From CP0: rt = CP0[rd,sel]
 		val := cpu.GetCP0Reg(int(ci.Rd), int(ci.Sel))
 		cpu.SetReg(ci.Rt, val)
-		return nil, false
+		return FlowNext, 0
 
 	case COP0Funct_MTC0:
 		// Move To CP0: CP0[rd,sel] = rt
 		val := cpu.GetReg(ci.Rt)
 		cpu.SetCP0Reg(int(ci.Rd), int(ci.Sel), val)
-		return nil, false
+		return FlowNext, 0
+
+	case COP0Funct_DMFC0:
+		// Doubleword Move From CP0 (MIPS64): rt = CP0[rd,sel]
+		if !cpu.is64() {
+			return cpu.raiseException(excRI)
+		}
+		cpu.SetReg64(ci.Rt, cpu.cp0.Read64(int(ci.Rd), int(ci.Sel)))
+		return FlowNext, 0
+
+	case COP0Funct_DMTC0:
+		// Doubleword Move To CP0 (MIPS64): CP0[rd,sel] = rt
+		if !cpu.is64() {
+			return cpu.raiseException(excRI)
+		}
+		cpu.cp0.Write64(int(ci.Rd), int(ci.Sel), cpu.GetReg64(ci.Rt))
+		return FlowNext, 0
 
 	case 0x10:
 		// TLB and ERET instructions - check Funct field
 		switch ci.Funct {
 		case COP0Funct_ERET:
-			// Exception Return: PC = EPC or ErrorEPC
+			// Exception Return: PC = EPC or ErrorEPC, LLbit ← 0
 			nextPCVal := cpu.cp0.ERET()
-			return &nextPCVal, false
+			cpu.Memory.ClearReservation(cpu.CPUNum())
+			return FlowJump, nextPCVal
 
 		case COP0Funct_TLBP:
 			// TLB Probe: find entry matching EntryHi
 			cpu.cp0.TLBP()
-			return nil, false
+			return FlowNext, 0
 
 		case COP0Funct_TLBR:
 			// TLB Read: read entry at Index into EntryHi/EntryLo0/EntryLo1/PageMask
 			cpu.cp0.TLBR()
-			return nil, false
+			return FlowNext, 0
 
 		case COP0Funct_TLBWI:
 			// TLB Write Indexed: write entry from EntryHi/EntryLo0/EntryLo1/PageMask into TLB[Index]
 			cpu.cp0.TLBWI()
-			return nil, false
+			return FlowNext, 0
 
 		case COP0Funct_TLBWR:
 			// TLB Write Random: write entry into TLB[Random]
 			cpu.cp0.TLBWR()
-			return nil, false
+			return FlowNext, 0
 
 		default:
 			// Unknown TLB instruction
-			return nil, false
+			return FlowNext, 0
 		}
 
 	default:
 		// Unknown COP0 instruction
-		return nil, false
+		return FlowNext, 0
+	}
+}
+
+// branchTarget computes the destination of a PC-relative branch at pc.
+func branchTarget(pc uint32, offset uint16) uint32 {
+	return pc + 4 + uint32(int32(int16(offset))<<2)
+}
+
+// branch resolves a conditional branch. A branch that is not taken still
+// executes its delay slot, unless it is a "likely" branch which nullifies it.
+func branch(cpu *CPU, taken, likely bool, offset uint16) (Flow, uint32) {
+	if taken {
+		return FlowBranch, branchTarget(cpu.PC, offset)
+	}
+	if likely {
+		return FlowJump, cpu.PC + 8
 	}
+	return FlowBranch, cpu.PC + 8
 }
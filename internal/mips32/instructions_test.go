@@ -80,3 +80,61 @@ func TestDecodeJTypeInstruction(t *testing.T) {
 		t.Errorf("Address = 0x%X, want 0x10", jtype.Addr)
 	}
 }
+
+func TestByteAndHalfwordAccess(t *testing.T) {
+	cpu := runProgram(t,
+		0x2408FF80, // addiu $t0, $zero, -128
+		0xA0080101, // sb $t0, 0x101($zero)
+		0x80090101, // lb $t1, 0x101($zero)
+		0x900A0101, // lbu $t2, 0x101($zero)
+		0xA4080102, // sh $t0, 0x102($zero)
+		0x840B0102, // lh $t3, 0x102($zero)
+		0x940C0102, // lhu $t4, 0x102($zero)
+		0x8C0D0100, // lw $t5, 0x100($zero)
+		reservedInstr,
+	)
+
+	for _, tt := range []struct {
+		reg  uint8
+		want uint32
+	}{
+		{9, 0xFFFFFF80},  // lb sign-extends
+		{10, 0x00000080}, // lbu zero-extends
+		{11, 0xFFFFFF80}, // lh sign-extends
+		{12, 0x0000FF80}, // lhu zero-extends
+		{13, 0x0080FF80}, // only the addressed bytes were written
+	} {
+		if got := cpu.GetReg(tt.reg); got != tt.want {
+			t.Errorf("$%d = 0x%08x, want 0x%08x", tt.reg, got, tt.want)
+		}
+	}
+}
+
+// wordDevice is a device with a single read-write word.
+type wordDevice struct{ word uint32 }
+
+func (d *wordDevice) ReadWord(offset uint32) uint32         { return d.word }
+func (d *wordDevice) WriteWord(offset uint32, value uint32) { d.word = value }
+
+func TestByteAndHalfwordDeviceAccess(t *testing.T) {
+	mem := NewMemory(0x1000)
+	dev := &wordDevice{word: 0x11223344}
+	if err := mem.MapDevice(0x2000, 4, dev); err != nil {
+		t.Fatalf("MapDevice: %v", err)
+	}
+
+	if b, ok := mem.LoadByte(0x2001); !ok || b != 0x22 {
+		t.Errorf("LoadByte = 0x%x, %v, want 0x22", b, ok)
+	}
+	if h, ok := mem.LoadHalf(0x2002); !ok || h != 0x3344 {
+		t.Errorf("LoadHalf = 0x%x, %v, want 0x3344", h, ok)
+	}
+	mem.StoreByte(0x2000, 0xAA)
+	mem.StoreHalf(0x2002, 0xBBCC)
+	if dev.word != 0xAA22BBCC {
+		t.Errorf("device word = 0x%08x, want 0xaa22bbcc (only the addressed bytes change)", dev.word)
+	}
+	if _, ok := mem.LoadHalf(0x101); ok {
+		t.Errorf("LoadHalf of an odd address succeeded")
+	}
+}
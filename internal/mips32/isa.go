@@ -0,0 +1,32 @@
+package mips32
+
+// ISA is the instruction set a CPU executes.
+type ISA uint8
+
+const (
+	ISAR2     ISA = iota // MIPS32 Release 1 and 2, the default
+	ISAR6                // MIPS32 Release 6, which reassigns the encodings of removed instructions
+	ISAMIPS64            // MIPS64 Release 2: MIPS32 Release 2 with 64-bit registers and doubleword instructions
+)
+
+func (isa ISA) String() string {
+	switch isa {
+	case ISAR6:
+		return "r6"
+	case ISAMIPS64:
+		return "mips64"
+	}
+	return "r2"
+}
+
+// DecodeISA decodes the instruction word found at addr following the
+// rules of isa.
+func DecodeISA(word, addr uint32, isa ISA) Inst {
+	switch isa {
+	case ISAR6:
+		return decodeR6Inst(word, addr)
+	case ISAMIPS64:
+		return decodeMIPS64Inst(word, addr)
+	}
+	return Decode(word, addr)
+}
@@ -0,0 +1,224 @@
+package mips32
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Link combines objs into an executable image. The .text sections are
+// concatenated in order at layout.TextAddr and the .data sections at
+// layout.DataAddr, each aligned as its object requires. Execution starts at
+// the entry symbol, or as described by Image.Entry if entry is empty.
+//
+// The image's Symbols hold every global symbol, and the local labels whose
+// name is used by no other symbol.
+func Link(objs []*Object, layout Layout, entry string) (*Image, error) {
+	l := &linker{
+		objs:    objs,
+		bases:   make([]map[string]uint32, len(objs)),
+		globals: make(map[string]uint32),
+		defined: make(map[string]string),
+	}
+
+	// lay the sections out
+	text := &Section{Name: ".text", Addr: layout.TextAddr}
+	data := &Section{Name: ".data", Addr: layout.DataAddr}
+	for i, o := range objs {
+		l.bases[i] = map[string]uint32{
+			".text": text.Addr + l.place(text, &o.Text),
+			".data": data.Addr + l.place(data, &o.Data),
+		}
+	}
+	tEnd, dEnd := uint64(text.Addr)+uint64(len(text.Data)), uint64(data.Addr)+uint64(len(data.Data))
+	if tEnd > uint64(data.Addr) && dEnd > uint64(text.Addr) && len(text.Data) > 0 && len(data.Data) > 0 {
+		return nil, fmt.Errorf(".text 0x%08x-0x%08x overlaps .data 0x%08x-0x%08x", text.Addr, tEnd, data.Addr, dEnd)
+	}
+
+	// resolve the global symbols
+	for i, o := range objs {
+		for _, sym := range o.Symbols {
+			if !sym.Global || sym.Section == "" {
+				continue
+			}
+			if prev, dup := l.defined[sym.Name]; dup {
+				return nil, fmt.Errorf("%s defined in both %s and %s", sym.Name, prev, o.Name)
+			}
+			l.defined[sym.Name] = o.Name
+			l.globals[sym.Name] = l.bases[i][sym.Section] + sym.Value
+		}
+	}
+
+	for i, o := range objs {
+		for _, sec := range []*ObjSection{&o.Text, &o.Data} {
+			out := text
+			if sec.Name == ".data" {
+				out = data
+			}
+			if err := l.relocate(i, sec, out.Data[l.bases[i][sec.Name]-out.Addr:]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	img := &Image{
+		Text:    *text,
+		Data:    *data,
+		Symbols: make(map[string]uint32),
+		labels:  make(map[string]bool),
+		entry:   entry,
+	}
+	for name, addr := range l.globals {
+		img.Symbols[name] = addr
+		img.labels[name] = true
+	}
+	locals := make(map[string]int)
+	for _, o := range objs {
+		for _, sym := range o.Symbols {
+			if !sym.Global && sym.Section != "" {
+				locals[sym.Name]++
+			}
+		}
+	}
+	for i, o := range objs {
+		for _, sym := range o.Symbols {
+			if _, global := l.globals[sym.Name]; !sym.Global && sym.Section != "" && !global && locals[sym.Name] == 1 {
+				img.Symbols[sym.Name] = l.bases[i][sym.Section] + sym.Value
+				img.labels[sym.Name] = true
+			}
+		}
+	}
+	if _, ok := l.globals[entry]; entry != "" && !ok {
+		return nil, fmt.Errorf("entry symbol %s is not defined", entry)
+	}
+	return img, nil
+}
+
+// linker holds the state of Link.
+type linker struct {
+	objs    []*Object
+	bases   []map[string]uint32 // address of each section of each object
+	globals map[string]uint32
+	defined map[string]string // object defining each global
+}
+
+// place appends the contents of sec to out, aligned as sec requires, and
+// returns its offset in out.
+func (l *linker) place(out *Section, sec *ObjSection) uint32 {
+	align := max(sec.Align, 1)
+	off := (uint32(len(out.Data)) + align - 1) &^ (align - 1)
+	out.Data = append(out.Data, make([]byte, off-uint32(len(out.Data)))...)
+	out.Data = append(out.Data, sec.Data...)
+	return off
+}
+
+// symbol returns the address of name as seen from object i.
+func (l *linker) symbol(i int, name string) (uint32, error) {
+	if addr, ok := l.bases[i][name]; ok {
+		return addr, nil
+	}
+	o := l.objs[i]
+	j := sort.Search(len(o.Symbols), func(j int) bool { return o.Symbols[j].Name >= name })
+	if j < len(o.Symbols) && o.Symbols[j].Name == name && o.Symbols[j].Section != "" {
+		return l.bases[i][o.Symbols[j].Section] + o.Symbols[j].Value, nil
+	}
+	if addr, ok := l.globals[name]; ok {
+		return addr, nil
+	}
+	return 0, fmt.Errorf("%s: undefined reference to %s", o.Name, name)
+}
+
+// relocate applies the relocations of sec, a section of object i, to out,
+// its linked contents. The addends are read from sec.
+func (l *linker) relocate(i int, sec *ObjSection, out []byte) error {
+	o := l.objs[i]
+	base := l.bases[i][sec.Name]
+	for k, r := range sec.Relocs {
+		if r.Offset&3 != 0 || int(r.Offset)+4 > len(sec.Data) {
+			return fmt.Errorf("%s: %s relocation at %s+0x%x out of range", o.Name, r.Type, sec.Name, r.Offset)
+		}
+		s, err := l.symbol(i, r.Symbol)
+		if err != nil {
+			return err
+		}
+		p := base + r.Offset // address of the word
+		word := binary.BigEndian.Uint32(sec.Data[r.Offset:])
+
+		switch r.Type {
+		case elf.R_MIPS_NONE:
+		case elf.R_MIPS_32:
+			word += s
+		case elf.R_MIPS_26:
+			// target = (A << 2) + S, within the region of P+4
+			target := (word&0x3FFFFFF)<<2 + s
+			if target&0xF0000000 != (p+4)&0xF0000000 {
+				return fmt.Errorf("%s: jump to %s at 0x%08x cannot reach 0x%08x", o.Name, r.Symbol, p, target)
+			}
+			word = word&^0x3FFFFFF | target>>2&0x3FFFFFF
+		case elf.R_MIPS_HI16:
+			// AHL = (hi << 16) + (int16)lo, with lo from the matching LO16
+			ahl := word << 16
+			for _, lo := range sec.Relocs[k+1:] {
+				if lo.Type == elf.R_MIPS_LO16 && lo.Symbol == r.Symbol {
+					ahl += uint32(int16(binary.BigEndian.Uint32(sec.Data[lo.Offset:])))
+					break
+				}
+			}
+			word = word&^0xFFFF | (ahl+s+0x8000)>>16
+		case elf.R_MIPS_LO16:
+			word = word&^0xFFFF | (word+s)&0xFFFF
+		case elf.R_MIPS_PC16:
+			// (sign_extend(A << 2) + S - P) >> 2
+			delta := int64(int16(word))<<2 + int64(s) - int64(p)
+			if delta < -0x20000 || delta > 0x1FFFC || delta&3 != 0 {
+				return fmt.Errorf("%s: branch to %s at 0x%08x out of range", o.Name, r.Symbol, p)
+			}
+			word = word&^0xFFFF | uint32(delta>>2)&0xFFFF
+		default:
+			return fmt.Errorf("%s: unsupported relocation %s at %s+0x%x", o.Name, r.Type, sec.Name, r.Offset)
+		}
+		binary.BigEndian.PutUint32(out[r.Offset:], word)
+	}
+	return nil
+}
+
+// WriteELF writes img as a big-endian ELF32 executable that LoadELF can load.
+// Labels become function and object symbols extending to the next label.
+func (img *Image) WriteELF(w io.Writer) error {
+	symtab := newELFSymtab()
+	funcs := img.TextSymbols().Symbols()
+	for _, s := range funcs {
+		symtab.add(s.Name, s.Addr, s.Size, elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC), 1)
+	}
+	var objects []string
+	dataEnd := uint64(img.Data.Addr) + uint64(len(img.Data.Data))
+	for name, addr := range img.Symbols {
+		if img.labels[name] && addr >= img.Data.Addr && uint64(addr) < dataEnd {
+			objects = append(objects, name)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		a, b := img.Symbols[objects[i]], img.Symbols[objects[j]]
+		return a < b || a == b && objects[i] < objects[j]
+	})
+	for i, name := range objects {
+		// like the functions, objects extend to the next label
+		addr, end := img.Symbols[name], uint32(dataEnd)
+		for _, next := range objects[i+1:] {
+			if a := img.Symbols[next]; a > addr {
+				end = a
+				break
+			}
+		}
+		symtab.add(name, addr, end-addr, elf.ST_INFO(elf.STB_GLOBAL, elf.STT_OBJECT), 2)
+	}
+
+	return writeELF32(w, elf.ET_EXEC, img.Entry(), []elfSection{
+		{name: ".text", typ: elf.SHT_PROGBITS, flags: elf.SHF_ALLOC | elf.SHF_EXECINSTR, addr: img.Text.Addr, align: 4, data: img.Text.Data},
+		{name: ".data", typ: elf.SHT_PROGBITS, flags: elf.SHF_ALLOC | elf.SHF_WRITE, addr: img.Data.Addr, align: 4, data: img.Data.Data},
+		{name: ".symtab", typ: elf.SHT_SYMTAB, align: 4, link: 4, info: 1, entsize: 16, data: symtab.syms.Bytes()},
+		{name: ".strtab", typ: elf.SHT_STRTAB, align: 1, data: symtab.strtab},
+	})
+}
@@ -0,0 +1,215 @@
+package mips32
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssembleObjectRelocations(t *testing.T) {
+	obj, err := AssembleObject("test.s", []byte(`
+		.text
+		.globl main
+main:	la $a0, msg
+		jal print
+		beq $t0, $zero, done
+		b other
+done:	jr $ra
+		.data
+msg:	.asciiz "hi"
+		.align 2
+ptr:	.word main+4
+`))
+	if err != nil {
+		t.Fatalf("AssembleObject:\n%v", err)
+	}
+
+	wantText := []Reloc{
+		{0, elf.R_MIPS_HI16, ".data"},
+		{4, elf.R_MIPS_LO16, ".data"},
+		{8, elf.R_MIPS_26, "print"},
+		{24, elf.R_MIPS_PC16, "other"},
+	}
+	if !reflect.DeepEqual(obj.Text.Relocs, wantText) {
+		t.Errorf(".text relocations = %v, want %v", obj.Text.Relocs, wantText)
+	}
+	wantData := []Reloc{{4, elf.R_MIPS_32, ".text"}}
+	if !reflect.DeepEqual(obj.Data.Relocs, wantData) {
+		t.Errorf(".data relocations = %v, want %v", obj.Data.Relocs, wantData)
+	}
+
+	// the addends are held in the words
+	if w := binary.BigEndian.Uint32(obj.Text.Data[16:]); w != 0x11000003 {
+		t.Errorf("local beq = 0x%08x, want 0x11000003", w)
+	}
+	if w := binary.BigEndian.Uint32(obj.Text.Data[24:]); w != 0x1000FFFF {
+		t.Errorf("b other = 0x%08x, want 0x1000FFFF", w)
+	}
+	if w := binary.BigEndian.Uint32(obj.Data.Data[4:]); w != 4 {
+		t.Errorf(".word main+4 = %d, want 4", w)
+	}
+
+	wantSyms := []ObjSymbol{
+		{Name: "done", Section: ".text", Value: 32},
+		{Name: "main", Section: ".text", Value: 0, Global: true},
+		{Name: "msg", Section: ".data", Value: 0},
+		{Name: "other", Global: true},
+		{Name: "print", Global: true},
+		{Name: "ptr", Section: ".data", Value: 4},
+	}
+	if !reflect.DeepEqual(obj.Symbols, wantSyms) {
+		t.Errorf("symbols = %v, want %v", obj.Symbols, wantSyms)
+	}
+}
+
+func TestAssembleObjectErrors(t *testing.T) {
+	_, err := AssembleObject("test.s", []byte(`
+		.text
+a:		li $t0, a+b
+		.word a*2
+		beq $t0, $zero, 16
+`))
+	want := []string{
+		"test.s:3:13: expression refers to both .text and b",
+		"test.s:4:9: expression cannot be relocated against .text",
+		"test.s:5:19: beq: branch target must be a label",
+	}
+	if err == nil || err.Error() != strings.Join(want, "\n") {
+		t.Errorf("AssembleObject error =\n%v\nwant\n%s", err, strings.Join(want, "\n"))
+	}
+}
+
+// writeObject assembles src and stores it as an ELF object in dir.
+func writeObject(t *testing.T, dir, name, src string) string {
+	t.Helper()
+	obj, err := AssembleObject(name, []byte(src))
+	if err != nil {
+		t.Fatalf("AssembleObject:\n%v", err)
+	}
+	var b bytes.Buffer
+	if err := obj.WriteELF(&b); err != nil {
+		t.Fatalf("WriteELF: %v", err)
+	}
+	path := filepath.Join(dir, strings.TrimSuffix(name, ".s")+".o")
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLinkedProgramRuns(t *testing.T) {
+	dir := t.TempDir()
+	mainObj := writeObject(t, dir, "main.s", `
+		.globl main
+		.text
+main:	la $a0, greeting
+		jal puts
+		lw $a0, count
+		jal putint
+		lw $t0, table
+		jalr $t0
+		b exit
+		.data
+greeting: .asciiz "count = "
+`)
+	libObj := writeObject(t, dir, "lib.s", `
+		.globl puts, putint, newline, exit, count, table
+		.text
+puts:	li $v0, 4
+		syscall
+		jr $ra
+putint:	li $v0, 1
+		syscall
+		jr $ra
+newline: li $v0, 11
+		li $a0, 10
+		syscall
+		jr $ra
+exit:	li $v0, 10
+		syscall
+		.data
+count:	.word 42
+table:	.word newline
+`)
+
+	var objs []*Object
+	for _, path := range []string{mainObj, libObj} {
+		obj, err := ReadObject(path)
+		if err != nil {
+			t.Fatalf("ReadObject: %v", err)
+		}
+		objs = append(objs, obj)
+	}
+	img, err := Link(objs, Layout{TextAddr: 0x1000, DataAddr: 0x4000}, "")
+	if err != nil {
+		t.Fatalf("Link: %v", err)
+	}
+	exe := filepath.Join(dir, "a.out")
+	var b bytes.Buffer
+	if err := img.WriteELF(&b); err != nil {
+		t.Fatalf("WriteELF: %v", err)
+	}
+	if err := os.WriteFile(exe, b.Bytes(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	mem := NewMemory(0x8000)
+	prog, err := LoadELF(mem, exe)
+	if err != nil {
+		t.Fatalf("LoadELF: %v", err)
+	}
+	if prog.Entry != 0x1000 {
+		t.Errorf("Entry = 0x%x, want 0x1000", prog.Entry)
+	}
+	if sym, ok := prog.Symbols.Lookup(img.Symbols["putint"] + 4); !ok || sym.Name != "putint" {
+		t.Errorf("Lookup(putint+4) = %v, %v, want putint", sym, ok)
+	}
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if sym, ok := ELFObjects(f).Lookup(img.Symbols["greeting"] + 2); !ok || sym.Name != "greeting" {
+		t.Errorf("ELFObjects.Lookup(greeting+2) = %v, %v, want greeting", sym, ok)
+	}
+
+	var out bytes.Buffer
+	cpu := NewCPU(mem)
+	cpu.PC = prog.Entry
+	NewSPIM(mem, strings.NewReader(""), &out, &out, prog.End).Install(cpu)
+	res, _ := cpu.Run(context.Background())
+	if res.Reason != StopExit || out.String() != "count = 42\n" {
+		t.Errorf("Run = %v with output %q, want exit and \"count = 42\\n\"", res, out.String())
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	object := func(src string) *Object {
+		obj, err := AssembleObject("x.s", []byte(src))
+		if err != nil {
+			t.Fatalf("AssembleObject:\n%v", err)
+		}
+		return obj
+	}
+	tests := []struct {
+		objs  []*Object
+		entry string
+		want  string
+	}{
+		{[]*Object{object("jal missing")}, "", "x.s: undefined reference to missing"},
+		{[]*Object{object(".globl f\nf: nop"), object(".globl f\nf: nop")}, "", "f defined in both x.s and x.s"},
+		{[]*Object{object("start: nop")}, "start", "entry symbol start is not defined"},
+	}
+	for _, tt := range tests {
+		_, err := Link(tt.objs, testLayout, tt.entry)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("Link error = %v, want %s", err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package mips32
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Listing is the disassembly of a block of code prepared for display:
+// branch and jump targets are named after the function containing them,
+// targets outside every function get synthetic loc_ labels, and addresses
+// built by lui/addiu, lui/ori or lui/load sequences are recovered.
+type Listing struct {
+	Insts   []Inst
+	Funcs   *SymbolTable      // function symbols, may be empty
+	Objects *SymbolTable      // data symbols, may be empty
+	Labels  map[uint32]string // synthetic labels of unnamed targets
+	Refs    map[uint32]uint32 // addresses recovered at the instruction completing them
+}
+
+// NewListing disassembles code, read with order and starting at addr. The
+// MIPS16e functions of funcs are decoded as such.
+func NewListing(code []byte, addr uint32, order binary.ByteOrder, funcs, objects *SymbolTable) *Listing {
+	return NewListingISA(code, addr, order, ISAR2, funcs, objects)
+}
+
+// NewListingISA is NewListing for code of the given release.
+func NewListingISA(code []byte, addr uint32, order binary.ByteOrder, isa ISA, funcs, objects *SymbolTable) *Listing {
+	l := &Listing{
+		Funcs:   funcs,
+		Objects: objects,
+		Labels:  make(map[uint32]string),
+		Refs:    make(map[uint32]uint32),
+	}
+	l.Insts = decodeFuncs(code, addr, order, isa, funcs)
+
+	targets := make(map[uint32]bool)
+	for _, inst := range l.Insts {
+		t, ok := inst.Target()
+		if !ok || !l.contains(t) {
+			continue
+		}
+		targets[t] = true
+		if _, named := funcs.Lookup(t); !named {
+			l.Labels[t] = fmt.Sprintf("loc_%08x", t)
+		}
+	}
+	l.recoverAddresses(targets)
+	return l
+}
+
+// decodeFuncs decodes code like decodeCode, except for the MIPS16e
+// functions of funcs. The MIPS32 code after one resumes at the next word.
+func decodeFuncs(code []byte, addr uint32, order binary.ByteOrder, isa ISA, funcs *SymbolTable) []Inst {
+	var insts []Inst
+	off, end := uint32(0), uint32(len(code))
+	syms := funcs.Symbols()
+	for i, fn := range syms {
+		if !fn.MIPS16 || fn.Addr < addr+off || fn.Addr-addr >= end {
+			continue
+		}
+		start := fn.Addr - addr
+		stop := end
+		if fn.Size != 0 {
+			stop = min(end, start+fn.Size)
+		} else if i+1 < len(syms) && syms[i+1].Addr-addr < end {
+			stop = syms[i+1].Addr - addr
+		}
+		stop = min(end, (stop+3)&^3)
+		insts = append(insts, decodeCode(code[off:start], addr+off, order, isa)...)
+		insts = append(insts, decodeMIPS16Code(code[start:stop], fn.Addr, order)...)
+		off = stop
+	}
+	return append(insts, decodeCode(code[off:], addr+off, order, isa)...)
+}
+
+// index returns the position in l.Insts of the instruction at addr.
+func (l *Listing) index(addr uint32) (int, bool) {
+	i := sort.Search(len(l.Insts), func(i int) bool { return l.Insts[i].Addr >= addr })
+	return i, i < len(l.Insts) && l.Insts[i].Addr == addr
+}
+
+// contains reports whether addr is the address of an instruction of l.
+func (l *Listing) contains(addr uint32) bool {
+	_, ok := l.index(addr)
+	return ok
+}
+
+// Func returns the function containing addr, if any. The listing headers
+// and the cross-references name code through it.
+func (l *Listing) Func(addr uint32) (Symbol, bool) {
+	return l.Funcs.Lookup(addr)
+}
+
+// FuncStart returns the function starting at addr, if any.
+func (l *Listing) FuncStart(addr uint32) (Symbol, bool) {
+	sym, ok := l.Func(addr)
+	return sym, ok && sym.Addr == addr
+}
+
+// inCode reports whether addr lies within the code of l.
+func (l *Listing) inCode(addr uint32) bool {
+	if len(l.Insts) == 0 {
+		return false
+	}
+	last := l.Insts[len(l.Insts)-1]
+	return addr >= l.Insts[0].Addr && uint64(addr) < uint64(last.Addr)+uint64(last.Size())
+}
+
+// Name returns the symbolic name of addr: a synthetic label, a data symbol,
+// or func or func+0x1c within the code of l, or "" if nothing is known
+// about it.
+func (l *Listing) Name(addr uint32) string {
+	if label, ok := l.Labels[addr]; ok {
+		return label
+	}
+	sym, ok := l.Objects.Lookup(addr)
+	if !ok && l.inCode(addr) {
+		sym, ok = l.Func(addr)
+	}
+	switch {
+	case !ok:
+		return ""
+	case addr == sym.Addr:
+		return sym.Name
+	}
+	return fmt.Sprintf("%s+0x%x", sym.Name, addr-sym.Addr)
+}
+
+// recoverAddresses finds the addresses assembled by lui and completed by
+// addiu, ori or a load or store. The upper halves are forgotten where
+// control flow may join: at functions, labels and branch targets.
+func (l *Listing) recoverAddresses(targets map[uint32]bool) {
+	var hi [32]uint32
+	var known [32]bool
+	for _, inst := range l.Insts {
+		if _, ok := l.FuncStart(inst.Addr); ok || targets[inst.Addr] {
+			known = [32]bool{}
+		}
+		rs := inst.Word >> 21 & 31
+		lo := inst.Word & 0xFFFF
+		switch {
+		case inst.format == fmtMIPS16:
+			// PC-relative addiu and lw carry the address they use
+			if n := len(inst.Args); n > 0 && inst.Args[n-1].Kind == ArgTarget && inst.Control() == ControlNone {
+				l.Refs[inst.Addr] = uint32(inst.Args[n-1].Imm)
+			}
+		case inst.format == fmtPCRel:
+			if inst.Args[1].Kind == ArgTarget { // addiupc, lwpc
+				l.Refs[inst.Addr] = uint32(inst.Args[1].Imm)
+			}
+		case !known[rs]:
+		case inst.Op == "addiu", inst.format == fmtMem:
+			l.Refs[inst.Addr] = hi[rs] + uint32(int16(lo))
+		case inst.Op == "ori":
+			l.Refs[inst.Addr] = hi[rs] | lo
+		}
+
+		if r, ok := inst.dest(); ok {
+			known[r] = inst.Op == "lui"
+			hi[r] = lo << 16
+		}
+	}
+}
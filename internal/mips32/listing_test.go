@@ -0,0 +1,117 @@
+package mips32
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestListing(t *testing.T) {
+	img, _ := assemble(t, `
+		.set noreorder
+f:		la $a0, msg
+		beq $a0, $zero, f
+		nop
+		jal 0x20
+		nop
+		lui $t0, 1
+		ori $t1, $t0, 4
+		lw $t3, 8($t0)		# 0x20: a target, where nothing is known
+		lui $t2, 1
+		lw $t3, 8($t2)
+		.data
+msg:	.asciiz "hi"
+`)
+	funcs := NewSymbolTable([]Symbol{{Name: "f", Addr: 0, Size: 0x18}})
+	objects := NewSymbolTable([]Symbol{{Name: "msg", Addr: 0x1000, Size: 3}})
+	l := NewListing(img.Text.Data, 0, binary.BigEndian, funcs, objects)
+
+	if len(l.Insts) != 11 || l.Insts[1].Op != "addiu" {
+		t.Fatalf("Insts = %v, want 11 starting with la", l.Insts)
+	}
+	if want := map[uint32]string{0x20: "loc_00000020"}; !reflect.DeepEqual(l.Labels, want) {
+		t.Errorf("Labels = %v, want %v", l.Labels, want)
+	}
+	if want := map[uint32]uint32{0x4: 0x1000, 0x1c: 0x10004, 0x28: 0x10008}; !reflect.DeepEqual(l.Refs, want) {
+		t.Errorf("Refs = %x, want %x", l.Refs, want)
+	}
+
+	names := []struct {
+		addr uint32
+		want string
+	}{
+		{0x0, "f"},
+		{0x8, "f+0x8"},
+		{0x20, "loc_00000020"},
+		{0x1000, "msg"},
+		{0x1002, "msg+0x2"},
+		{0x30, ""},
+	}
+	for _, tt := range names {
+		if got := l.Name(tt.addr); got != tt.want {
+			t.Errorf("Name(0x%x) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+	if sym, ok := l.FuncStart(0); !ok || sym.Name != "f" {
+		t.Errorf("FuncStart(0) = %v, %v, want f", sym, ok)
+	}
+	if _, ok := l.FuncStart(4); ok {
+		t.Errorf("FuncStart(4) found a function")
+	}
+}
+
+func TestListingMarkerSymbols(t *testing.T) {
+	code := make([]byte, 0x10)
+	funcs := NewSymbolTable([]Symbol{
+		{Name: "runtime.text", Addr: 0},
+		{Name: "main", Addr: 0, Size: 8},
+		{Name: "runtime.etext", Addr: 0x10},
+	})
+	objects := NewSymbolTable([]Symbol{{Name: "runtime.writeBarrier", Addr: 0x20000, Size: 4}})
+	l := NewListing(code, 0, binary.BigEndian, funcs, objects)
+
+	names := []struct {
+		addr uint32
+		want string
+	}{
+		{0x0, "main"}, // the sized function wins over the marker
+		{0x4, "main+0x4"},
+		{0xc, ""}, // runtime.text is a marker and does not extend past main
+		{0x10, ""},
+		{0x20000, "runtime.writeBarrier"},
+		{0x20002, "runtime.writeBarrier+0x2"},
+		{0x30000, ""},
+	}
+	for _, tt := range names {
+		if got := l.Name(tt.addr); got != tt.want {
+			t.Errorf("Name(0x%x) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+	if sym, ok := funcs.Lookup(0x10); !ok || sym.Name != "runtime.etext" {
+		t.Errorf("Lookup(0x10) = %v, %v, want runtime.etext", sym, ok)
+	}
+}
+
+func TestSymbolTableSectionEnds(t *testing.T) {
+	// .text is [0x100, 0x200): etext sits at its end
+	syms := []Symbol{{Name: "main", Addr: 0x100}, {Name: "etext", Addr: 0x200}, {Name: "other", Addr: 0x300}}
+	table := newSymbolTable(syms, []uint32{0x200, 0x200, 0})
+
+	lookups := []struct {
+		addr uint32
+		want string
+	}{
+		{0x100, "main"},
+		{0x1fc, "main"},
+		{0x200, "etext"},
+		{0x204, ""},
+		{0x2fc, ""},
+		{0x400, "other"}, // no section: up to the end of memory
+	}
+	for _, tt := range lookups {
+		sym, _ := table.Lookup(tt.addr)
+		if sym.Name != tt.want {
+			t.Errorf("Lookup(0x%x) = %q, want %q", tt.addr, sym.Name, tt.want)
+		}
+	}
+}
@@ -1 +1,219 @@
 package mips32
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Program describes an executable image that has been copied into guest memory.
+type Program struct {
+	Entry   uint32       // initial PC
+	Symbols *SymbolTable // function symbols, discovered if the image is stripped
+	End     uint32       // end of the highest loaded segment
+	MIPS64  bool         // ELF64 image, to run on a MIPS64 CPU
+}
+
+// LoadELF copies the PT_LOAD segments of a big-endian ELF32 MIPS executable into mem
+// and returns its entry point together with the function symbols found in .symtab/.dynsym,
+// or discovered in the executable segments if there are none.
+// Segment virtual addresses are used directly as memory offsets.
+// ELF64 images are accepted if they only use the 32-bit compatibility
+// segments, whose sign-extended addresses are truncated to 32 bits.
+func LoadELF(mem *Memory, path string) (*Program, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	is64 := f.Class == elf.ELFCLASS64
+	if f.Class != elf.ELFCLASS32 && !is64 {
+		return nil, fmt.Errorf("%s: not an ELF32 or ELF64 file", path)
+	}
+	if f.Machine != elf.EM_MIPS {
+		return nil, fmt.Errorf("%s: not a MIPS executable (machine %s)", path, f.Machine)
+	}
+	if f.ByteOrder != binary.BigEndian {
+		return nil, fmt.Errorf("%s: little-endian images are not supported", path)
+	}
+	if is64 && !compat32(f.Entry) {
+		return nil, fmt.Errorf("%s: entry point 0x%x is outside the 32-bit address space", path, f.Entry)
+	}
+	isa := ISAR2
+	if is64 {
+		isa = ISAMIPS64
+	}
+
+	symbols := ELFSymbols(f)
+	var discovered []Symbol
+	var end uint32
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD || prog.Memsz == 0 {
+			continue
+		}
+		if is64 && (!compat32(prog.Vaddr) || !compat32(prog.Vaddr+prog.Memsz-1)) {
+			return nil, fmt.Errorf("%s: segment at 0x%x is outside the 32-bit address space", path, prog.Vaddr)
+		}
+
+		// file-backed part, the remainder (.bss) is zero filled
+		data := make([]byte, prog.Memsz)
+		if _, err := prog.ReadAt(data[:prog.Filesz], 0); err != nil {
+			return nil, fmt.Errorf("%s: reading segment at 0x%08x: %w", path, prog.Vaddr, err)
+		}
+
+		if !mem.StoreBytes(uint32(prog.Vaddr), data) {
+			return nil, fmt.Errorf("%s: segment 0x%08x-0x%08x does not fit in %d bytes of memory",
+				path, prog.Vaddr, prog.Vaddr+prog.Memsz, len(mem.Data))
+		}
+		end = max(end, uint32(prog.Vaddr+prog.Memsz))
+
+		if len(symbols.Symbols()) == 0 && prog.Flags&elf.PF_X != 0 {
+			discovered = append(discovered, DiscoverFuncsISA(data[:prog.Filesz], uint32(prog.Vaddr), f.ByteOrder, uint32(f.Entry), isa)...)
+		}
+	}
+	if len(discovered) > 0 {
+		symbols = NewSymbolTable(discovered)
+	}
+
+	return &Program{
+		Entry:   uint32(f.Entry),
+		Symbols: symbols,
+		End:     end,
+		MIPS64:  is64,
+	}, nil
+}
+
+// compat32 reports whether the ELF64 address addr is a sign-extended 32-bit address.
+func compat32(addr uint64) bool {
+	return addr == uint64(int64(int32(addr)))
+}
+
+// Symbol is a named code address range.
+type Symbol struct {
+	Name   string
+	Addr   uint32
+	Size   uint32
+	MIPS16 bool // the function is MIPS16e code
+}
+
+// SymbolTable maps addresses to the function that contains them.
+type SymbolTable struct {
+	syms []Symbol // sorted by Addr, sized symbols after sizeless ones
+	ends []uint64 // exclusive end of each symbol
+}
+
+// NewSymbolTable builds a table from an unordered list of symbols.
+func NewSymbolTable(syms []Symbol) *SymbolTable {
+	return newSymbolTable(syms, nil)
+}
+
+// newSymbolTable builds a table from syms. limits, if not nil, holds the
+// end of the section of each symbol, or 0 if it is unknown.
+//
+// Symbols without a size extend up to the next symbol, or the end of their
+// section. End markers such as runtime.etext only contain their own
+// address: those at the address of a sized symbol, or at or after the end
+// of their section.
+func newSymbolTable(syms []Symbol, limits []uint32) *SymbolTable {
+	order := make([]int, len(syms))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := syms[order[i]], syms[order[j]]
+		return a.Addr < b.Addr || a.Addr == b.Addr && a.Size == 0 && b.Size != 0
+	})
+
+	t := &SymbolTable{syms: make([]Symbol, len(syms)), ends: make([]uint64, len(syms))}
+	for i, k := range order {
+		t.syms[i] = syms[k]
+	}
+	for i, s := range t.syms {
+		start := uint64(s.Addr)
+		limit := uint64(1 << 32)
+		if limits != nil && limits[order[i]] != 0 {
+			limit = uint64(limits[order[i]])
+		}
+		switch {
+		case s.Size != 0:
+			t.ends[i] = start + uint64(s.Size)
+		case start >= limit || i+1 < len(t.syms) && t.syms[i+1].Addr == s.Addr && t.syms[i+1].Size != 0:
+			t.ends[i] = start + 1 // an end marker
+		default:
+			t.ends[i] = limit
+			for _, next := range t.syms[i+1:] {
+				if next.Addr > s.Addr {
+					t.ends[i] = min(limit, uint64(next.Addr))
+					break
+				}
+			}
+		}
+	}
+	return t
+}
+
+// ELFSymbols collects the function symbols of f from .symtab, falling back to .dynsym.
+func ELFSymbols(f *elf.File) *SymbolTable {
+	return elfSymbols(f, elf.STT_FUNC)
+}
+
+// ELFObjects collects the data symbols of f from .symtab, falling back to .dynsym.
+func ELFObjects(f *elf.File) *SymbolTable {
+	return elfSymbols(f, elf.STT_OBJECT)
+}
+
+// stoMIPS16 is the st_other flag of MIPS16e function symbols.
+const stoMIPS16 = 0xF0
+
+func elfSymbols(f *elf.File, typ elf.SymType) *SymbolTable {
+	syms, err := f.Symbols()
+	if err != nil || len(syms) == 0 {
+		syms, _ = f.DynamicSymbols()
+	}
+
+	var out []Symbol
+	var limits []uint32
+	for _, s := range syms {
+		if elf.ST_TYPE(s.Info) != typ || s.Value == 0 {
+			continue
+		}
+		var limit uint32
+		if int(s.Section) < len(f.Sections) && s.Section != elf.SHN_UNDEF {
+			sec := f.Sections[s.Section]
+			limit = uint32(min(sec.Addr+sec.Size, 1<<32-1))
+		}
+		limits = append(limits, limit)
+		sym := Symbol{Name: s.Name, Addr: uint32(s.Value), Size: uint32(s.Size)}
+		if typ == elf.STT_FUNC && (s.Other&stoMIPS16 == stoMIPS16 || sym.Addr&1 != 0) {
+			sym.Addr &^= 1
+			sym.MIPS16 = true
+		}
+		out = append(out, sym)
+	}
+	return newSymbolTable(out, limits)
+}
+
+// Lookup returns the symbol containing addr. Symbols without a size extend
+// up to the next symbol; see newSymbolTable for the end markers.
+func (t *SymbolTable) Lookup(addr uint32) (Symbol, bool) {
+	if t == nil {
+		return Symbol{}, false
+	}
+
+	// first symbol starting after addr, the candidate is the one before it
+	i := sort.Search(len(t.syms), func(i int) bool { return t.syms[i].Addr > addr })
+	if i == 0 || uint64(addr) >= t.ends[i-1] {
+		return Symbol{}, false
+	}
+	return t.syms[i-1], true
+}
+
+// Symbols returns all symbols ordered by address.
+func (t *SymbolTable) Symbols() []Symbol {
+	if t == nil {
+		return nil
+	}
+	return t.syms
+}
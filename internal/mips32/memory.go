@@ -1,35 +1,363 @@
 package mips32
 
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// memPageShift sets the granularity (4KB) at which stores are tracked for
+// invalidating decoded instructions.
+const memPageShift = 12
+
+// Memory is the physical memory shared by all CPUs of a machine: RAM starting
+// at address 0, followed by memory-mapped devices placed above the end of RAM.
 type Memory struct {
 	Data []byte
+
+	// pageGen is bumped on every store into the corresponding page, so the
+	// CPU can tell whether instructions it decoded from that page are stale.
+	pageGen []atomic.Uint32
+
+	devices []mappedDevice
+
+	// While several cores run in parallel, every RAM access goes through mu:
+	// loads share it, stores and LL/SC hold it exclusively, so SC can
+	// check-and-store atomically. Otherwise stores only take it while an LL
+	// reservation is live.
+	mu     sync.RWMutex
+	shared atomic.Bool
+
+	// LL/SC reservations, indexed by CPU number and guarded by mu.
+	llActive atomic.Int32
+	llAddr   []uint32
+	llValid  []bool
+}
+
+// Device is a memory-mapped peripheral. Offsets are relative to the address
+// the device is mapped at. Devices may be accessed from several CPU goroutines.
+type Device interface {
+	ReadWord(offset uint32) uint32
+	WriteWord(offset uint32, value uint32)
+}
+
+type mappedDevice struct {
+	base, size uint32
+	dev        Device
 }
 
 func NewMemory(size uint32) *Memory {
 	return &Memory{
-		Data: make([]byte, size),
+		Data:    make([]byte, size),
+		pageGen: make([]atomic.Uint32, (uint64(size)+(1<<memPageShift)-1)>>memPageShift),
+	}
+}
+
+// SetShared tells m whether CPUs on other goroutines may access it at the
+// same time, as in a System running in Parallel.
+func (m *Memory) SetShared(shared bool) {
+	m.shared.Store(shared)
+}
+
+// rlock takes mu for a load if m is shared and reports whether it did.
+func (m *Memory) rlock() bool {
+	if !m.shared.Load() {
+		return false
 	}
+	m.mu.RLock()
+	return true
+}
+
+// lock takes mu for a store if m is shared or a reservation is live, and
+// reports whether it did.
+func (m *Memory) lock() bool {
+	if !m.shared.Load() && m.llActive.Load() == 0 {
+		return false
+	}
+	m.mu.Lock()
+	return true
+}
+
+// MapDevice places dev at [base, base+size). Devices live outside RAM so
+// ordinary memory accesses never pay for the device lookup.
+func (m *Memory) MapDevice(base, size uint32, dev Device) error {
+	end := uint64(base) + uint64(size)
+	if size == 0 || end > 1<<32 {
+		return fmt.Errorf("invalid device range 0x%08x+0x%x", base, size)
+	}
+	if uint64(base) < uint64(len(m.Data)) {
+		return fmt.Errorf("device at 0x%08x overlaps RAM (%d bytes)", base, len(m.Data))
+	}
+	for _, d := range m.devices {
+		if uint64(base) < uint64(d.base)+uint64(d.size) && uint64(d.base) < end {
+			return fmt.Errorf("device at 0x%08x overlaps device at 0x%08x", base, d.base)
+		}
+	}
+
+	m.devices = append(m.devices, mappedDevice{base: base, size: size, dev: dev})
+	return nil
 }
 
 func (m *Memory) LoadWord(address uint32) (word uint32, ok bool) {
-	if !m.isAligned(address) || !m.isAddressInRange(address+3) {
+	if !m.isAligned(address) {
 		return 0, false
 	}
+	if !m.isAddressInRange(address + 3) {
+		return m.loadDevice(address)
+	}
 
+	if m.rlock() {
+		defer m.mu.RUnlock()
+	}
+	return m.loadWord(address), true
+}
+
+func (m *Memory) loadWord(address uint32) uint32 {
 	return uint32(m.Data[address])<<24 |
 		uint32(m.Data[address+1])<<16 |
 		uint32(m.Data[address+2])<<8 |
-		uint32(m.Data[address+3]), true
+		uint32(m.Data[address+3])
 }
 
 func (m *Memory) StoreWord(address uint32, value uint32) (ok bool) {
-	if !m.isAligned(address) || !m.isAddressInRange(address+3) {
+	if !m.isAligned(address) {
 		return false
 	}
+	if !m.isAddressInRange(address + 3) {
+		return m.storeDevice(address, value)
+	}
+
+	if m.lock() {
+		defer m.mu.Unlock()
+		m.breakReservations(address, 4)
+	}
+	m.storeWord(address, value)
+	return true
+}
+
+// LoadHalf loads the big-endian halfword at the 2-byte aligned address.
+func (m *Memory) LoadHalf(address uint32) (half uint16, ok bool) {
+	if address%2 != 0 {
+		return 0, false
+	}
+	if !m.isAddressInRange(address + 1) {
+		w, ok := m.loadDevice(address &^ 3)
+		return uint16(w >> (16 - 8*(address&2))), ok
+	}
+	if m.rlock() {
+		defer m.mu.RUnlock()
+	}
+	return uint16(m.Data[address])<<8 | uint16(m.Data[address+1]), true
+}
 
+// LoadByte loads the byte at address.
+func (m *Memory) LoadByte(address uint32) (b byte, ok bool) {
+	if !m.isAddressInRange(address) {
+		w, ok := m.loadDevice(address &^ 3)
+		return byte(w >> (24 - 8*(address&3))), ok
+	}
+	if m.rlock() {
+		defer m.mu.RUnlock()
+	}
+	return m.Data[address], true
+}
+
+// StoreHalf stores the big-endian halfword at the 2-byte aligned address.
+func (m *Memory) StoreHalf(address uint32, value uint16) (ok bool) {
+	if address%2 != 0 {
+		return false
+	}
+	if !m.isAddressInRange(address + 1) {
+		shift := 16 - 8*(address&2)
+		return m.storeDevicePart(address, uint32(value)<<shift, 0xFFFF<<shift)
+	}
+
+	if m.lock() {
+		defer m.mu.Unlock()
+		m.breakReservations(address, 2)
+	}
+	m.storeHalf(address, value)
+	return true
+}
+
+func (m *Memory) storeHalf(address uint32, value uint16) {
+	m.Data[address] = byte(value >> 8)
+	m.Data[address+1] = byte(value)
+	m.pageGen[address>>memPageShift].Add(1)
+}
+
+// StoreByte stores b at address.
+func (m *Memory) StoreByte(address uint32, b byte) (ok bool) {
+	if !m.isAddressInRange(address) {
+		shift := 24 - 8*(address&3)
+		return m.storeDevicePart(address, uint32(b)<<shift, 0xFF<<shift)
+	}
+
+	if m.lock() {
+		defer m.mu.Unlock()
+		m.breakReservations(address, 1)
+	}
+	m.storeByte(address, b)
+	return true
+}
+
+func (m *Memory) storeByte(address uint32, b byte) {
+	m.Data[address] = b
+	m.pageGen[address>>memPageShift].Add(1)
+}
+
+func (m *Memory) storeWord(address uint32, value uint32) {
 	m.Data[address] = byte(value >> 24)
 	m.Data[address+1] = byte(value >> 16)
 	m.Data[address+2] = byte(value >> 8)
 	m.Data[address+3] = byte(value)
+	m.pageGen[address>>memPageShift].Add(1)
+}
+
+// StoreBytes copies data into memory starting at address.
+// It fails without writing anything if the range does not fit.
+func (m *Memory) StoreBytes(address uint32, data []byte) (ok bool) {
+	end := uint64(address) + uint64(len(data))
+	if end > uint64(len(m.Data)) {
+		return false
+	}
+
+	if len(data) == 0 {
+		return true
+	}
+	if m.lock() {
+		defer m.mu.Unlock()
+		m.breakReservations(address, uint32(len(data)))
+	}
+	copy(m.Data[address:], data)
+	m.Invalidate(address, uint32(len(data)))
+	return true
+}
+
+// Invalidate marks [address, address+size) as modified. Code that writes to
+// Data directly must call it so stale decoded instructions are dropped.
+func (m *Memory) Invalidate(address, size uint32) {
+	if size == 0 {
+		return
+	}
+	first := address >> memPageShift
+	last := uint32((uint64(address) + uint64(size) - 1) >> memPageShift)
+	for p := first; p <= last && int(p) < len(m.pageGen); p++ {
+		m.pageGen[p].Add(1)
+	}
+}
+
+// generation returns the store generation of the page containing address.
+func (m *Memory) generation(address uint32) uint32 {
+	return m.pageGen[address>>memPageShift].Load()
+}
+
+// LoadLinked reads the word at address and sets a reservation on it for CPU cpuNum.
+func (m *Memory) LoadLinked(cpuNum int, address uint32) (word uint32, ok bool) {
+	if !m.isAligned(address) || !m.isAddressInRange(address+3) {
+		return m.LoadWord(address)
+	}
+
+	// read under the lock so the load is ordered with other cores' SC
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for len(m.llValid) <= cpuNum {
+		m.llAddr = append(m.llAddr, 0)
+		m.llValid = append(m.llValid, false)
+	}
+	if !m.llValid[cpuNum] {
+		m.llActive.Add(1)
+	}
+	m.llAddr[cpuNum] = address
+	m.llValid[cpuNum] = true
+	return m.loadWord(address), true
+}
+
+// StoreConditional stores value at address if CPU cpuNum still holds a
+// reservation on it, and reports whether the store happened. The reservation
+// is consumed either way.
+func (m *Memory) StoreConditional(cpuNum int, address uint32, value uint32) (stored bool) {
+	if !m.isAligned(address) || !m.isAddressInRange(address+3) {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cpuNum >= len(m.llValid) || !m.llValid[cpuNum] || m.llAddr[cpuNum] != address {
+		m.clearReservation(cpuNum)
+		return false
+	}
+
+	m.breakReservations(address, 4)
+	m.storeWord(address, value)
+	return true
+}
+
+// ClearReservation drops the LL reservation of CPU cpuNum (ERET does this).
+func (m *Memory) ClearReservation(cpuNum int) {
+	if m.llActive.Load() == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clearReservation(cpuNum)
+}
+
+func (m *Memory) clearReservation(cpuNum int) {
+	if cpuNum < len(m.llValid) && m.llValid[cpuNum] {
+		m.llValid[cpuNum] = false
+		m.llActive.Add(-1)
+	}
+}
+
+// breakReservations invalidates every reservation on a word overlapping
+// [address, address+size). Callers hold mu.
+func (m *Memory) breakReservations(address, size uint32) {
+	for i, valid := range m.llValid {
+		if valid && uint64(m.llAddr[i])+4 > uint64(address) && uint64(m.llAddr[i]) < uint64(address)+uint64(size) {
+			m.clearReservation(i)
+		}
+	}
+}
+
+func (m *Memory) findDevice(address uint32) (*mappedDevice, bool) {
+	for i := range m.devices {
+		d := &m.devices[i]
+		if address >= d.base && address-d.base < d.size {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+func (m *Memory) loadDevice(address uint32) (uint32, bool) {
+	d, ok := m.findDevice(address)
+	if !ok {
+		return 0, false
+	}
+	return d.dev.ReadWord(address - d.base), true
+}
+
+func (m *Memory) storeDevice(address uint32, value uint32) bool {
+	d, ok := m.findDevice(address)
+	if !ok {
+		return false
+	}
+	d.dev.WriteWord(address-d.base, value)
+	return true
+}
+
+// storeDevicePart merges the bits of value selected by mask into the device
+// word containing address.
+func (m *Memory) storeDevicePart(address, value, mask uint32) bool {
+	d, ok := m.findDevice(address &^ 3)
+	if !ok {
+		return false
+	}
+	offset := address&^3 - d.base
+	d.dev.WriteWord(offset, d.dev.ReadWord(offset)&^mask|value&mask)
 	return true
 }
 
@@ -40,5 +368,5 @@ func (m *Memory) isAligned(address uint32) bool {
 
 // isAddressInRange checks if the address is within the memory bounds
 func (m *Memory) isAddressInRange(address uint32) bool {
-	return address+3 < uint32(len(m.Data))
+	return uint64(address) < uint64(len(m.Data))
 }
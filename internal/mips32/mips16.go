@@ -0,0 +1,550 @@
+package mips32
+
+import "encoding/binary"
+
+// mips16Regs maps the 3-bit register fields of MIPS16e to general-purpose
+// registers: $s0, $s1 and $v0-$a3.
+var mips16Regs = [8]uint8{16, 17, 2, 3, 4, 5, 6, 7}
+
+// MIPS16e major opcodes, bits 15-11 of the first halfword
+const (
+	m16ADDIUSP = 0x00
+	m16ADDIUPC = 0x01
+	m16B       = 0x02
+	m16JAL     = 0x03 // JAL and JALX, 32 bits
+	m16BEQZ    = 0x04
+	m16BNEZ    = 0x05
+	m16SHIFT   = 0x06
+	m16RRIA    = 0x08
+	m16ADDIU8  = 0x09
+	m16SLTI    = 0x0A
+	m16SLTIU   = 0x0B
+	m16I8      = 0x0C
+	m16LI      = 0x0D
+	m16CMPI    = 0x0E
+	m16LB      = 0x10
+	m16LH      = 0x11
+	m16LWSP    = 0x12
+	m16LW      = 0x13
+	m16LBU     = 0x14
+	m16LHU     = 0x15
+	m16LWPC    = 0x16
+	m16SB      = 0x18
+	m16SH      = 0x19
+	m16SWSP    = 0x1A
+	m16SW      = 0x1B
+	m16RRR     = 0x1C
+	m16RR      = 0x1D
+	m16EXTEND  = 0x1E // prefix widening the immediate of the next instruction
+)
+
+const (
+	regT8 = 24 // MIPS16e comparisons set $t8
+	regSP = 29
+	regRA = 31
+)
+
+// m16Loads maps the load and store opcodes to their mnemonic, MIPS32
+// opcode and the scale of their 5-bit offset.
+var m16Loads = map[uint16]struct {
+	name  string
+	op    OpCode
+	shift uint
+}{
+	m16LB:  {"lb", OpCodeLB, 0},
+	m16LH:  {"lh", OpCodeLH, 1},
+	m16LW:  {"lw", OpCodeLW, 2},
+	m16LBU: {"lbu", OpCodeLBU, 0},
+	m16LHU: {"lhu", OpCodeLHU, 1},
+	m16SB:  {"sb", OpCodeSB, 0},
+	m16SH:  {"sh", OpCodeSH, 1},
+	m16SW:  {"sw", OpCodeSW, 2},
+}
+
+// m16RRFuncts are the two-register operations of the RR major opcode whose
+// MIPS32 form is rd = rs op rt, by funct: the mnemonic and rd, rs, rt as
+// 'x' for rx, 'y' for ry, 't' for $t8 and '0' for $zero.
+var m16RRFuncts = map[uint16]struct {
+	name  string
+	funct OpCode
+	regs  string
+}{
+	0x02: {"slt", OpCodeSLT, "txy"},
+	0x03: {"sltu", OpCodeSLTU, "txy"},
+	0x0A: {"cmp", OpCodeXOR, "txy"},
+	0x0B: {"neg", OpCodeSUBU, "x0y"},
+	0x0C: {"and", OpCodeAND, "xxy"},
+	0x0D: {"or", OpCodeOR, "xxy"},
+	0x0E: {"xor", OpCodeXOR, "xxy"},
+	0x0F: {"not", OpCodeNOR, "xy0"},
+}
+
+// Size returns the length of the instruction in bytes: 4, or 2 for
+// non-extended MIPS16e instructions.
+func (inst Inst) Size() uint32 {
+	if inst.size == 0 {
+		return 4
+	}
+	return uint32(inst.size)
+}
+
+// IsMIPS16 reports whether the instruction was decoded as MIPS16e.
+func (inst Inst) IsMIPS16() bool {
+	return inst.format == fmtMIPS16
+}
+
+// DecodeMIPS16 decodes the MIPS16e instruction at the start of code, read
+// with order and found at addr: one halfword, or two for JAL, JALX and
+// extended instructions. PC-relative operands are shown as the address
+// they compute.
+func DecodeMIPS16(code []byte, addr uint32, order binary.ByteOrder) Inst {
+	if len(code) < 2 {
+		return Inst{Addr: addr, format: fmtMIPS16, size: 2}
+	}
+	hw := order.Uint16(code)
+	var next uint16
+	if len(code) >= 4 {
+		next = order.Uint16(code[2:])
+	} else if m16Long(hw) {
+		return Inst{Addr: addr, Word: uint32(hw), format: fmtMIPS16, size: 2}
+	}
+	inst, _, _ := decodeMIPS16(hw, next, addr)
+	return inst
+}
+
+// m16Long reports whether the instruction starting with hw takes two halfwords.
+func m16Long(hw uint16) bool {
+	op := hw >> 11
+	return op == m16JAL || op == m16EXTEND
+}
+
+// decodeMIPS16Code decodes code as MIPS16e, read with order and starting at addr.
+func decodeMIPS16Code(code []byte, addr uint32, order binary.ByteOrder) []Inst {
+	insts := make([]Inst, 0, len(code)/2)
+	for off := 0; off+2 <= len(code); {
+		inst := DecodeMIPS16(code[off:], addr+uint32(off), order)
+		insts = append(insts, inst)
+		off += int(inst.Size())
+	}
+	return insts
+}
+
+// decodeMIPS16 decodes the instruction starting with the halfword hw at
+// addr, next being the following halfword. Unless it is a control transfer
+// or uses the PC, SAVE/RESTORE, SEB or SEH, it also returns the MIPS32
+// instruction doing the same.
+func decodeMIPS16(hw, next uint16, addr uint32) (inst Inst, native uint32, hasNative bool) {
+	inst = Inst{Addr: addr, Word: uint32(hw), format: fmtMIPS16, size: 2}
+	op := hw >> 11
+	reg := func(r uint8) Arg { return Arg{Kind: ArgReg, Reg: r} }
+	imm := func(v int32) Arg { return Arg{Kind: ArgImm, Imm: int64(v)} }
+	target := func(t uint32) Arg { return Arg{Kind: ArgTarget, Imm: int64(t)} }
+	set := func(name string, args ...Arg) { inst.Op, inst.Args = name, args }
+
+	if op == m16JAL {
+		inst.Word, inst.size = uint32(hw)<<16|uint32(next), 4
+		index := uint32(hw&0x1F)<<21 | uint32(hw>>5&0x1F)<<16 | uint32(next)
+		name := "jal"
+		if hw&0x400 != 0 {
+			name = "jalx"
+		}
+		set(name, target((addr+4)&0xF0000000|index<<2))
+		return inst, 0, false
+	}
+
+	extended := op == m16EXTEND
+	var ext uint16
+	if extended {
+		ext, hw = hw&0x7FF, next
+		op = hw >> 11
+		inst.Word, inst.size = uint32(inst.Word)<<16|uint32(next), 4
+		if m16Long(hw) {
+			return inst, 0, false
+		}
+	}
+	rx, ry, rz := mips16Regs[hw>>8&7], mips16Regs[hw>>5&7], mips16Regs[hw>>2&7]
+	// the 16-bit immediate of an extended instruction
+	ext16 := int32(int16(ext&0x1F<<11 | ext>>5<<5 | hw&0x1F))
+	// uimm returns the unsigned immediate of the low bits of hw scaled by
+	// shift, or the extended immediate
+	uimm := func(bits, shift uint) int32 {
+		if extended {
+			return ext16
+		}
+		return int32(uint32(hw)&(1<<bits-1)) << shift
+	}
+	// simm returns the same as uimm for a signed immediate
+	simm := func(bits, shift uint) int32 {
+		if extended {
+			return ext16
+		}
+		return int32(uint32(hw)<<(32-bits)) >> (32 - bits) << shift
+	}
+	// branch returns the target of a branch with a signed offset in the
+	// low bits of hw
+	branch := func(bits uint) uint32 {
+		if extended {
+			return addr + 4 + uint32(ext16<<1)
+		}
+		return addr + 2 + uint32(simm(bits, 1))
+	}
+	iOp := func(o OpCode, rs, rt uint8, v int32) uint32 {
+		return iWord(iType(o), uint32(rs), uint32(rt), uint32(v))
+	}
+	rOp := func(f OpCode, rd, rs, rt uint8) uint32 {
+		return rType(special(f), uint32(rs), uint32(rt), uint32(rd), 0)
+	}
+	pcBase := addr &^ 3
+
+	switch op {
+	case m16ADDIUSP:
+		v := uimm(8, 2)
+		set("addiu", reg(rx), reg(regSP), imm(v))
+		return inst, iOp(OpCodeADDIU, regSP, rx, v), true
+	case m16ADDIUPC:
+		set("addiu", reg(rx), target(pcBase+uint32(uimm(8, 2))))
+	case m16B:
+		set("b", target(branch(11)))
+	case m16BEQZ:
+		set("beqz", reg(rx), target(branch(8)))
+	case m16BNEZ:
+		set("bnez", reg(rx), target(branch(8)))
+	case m16SHIFT:
+		sa := uint32(hw >> 2 & 7)
+		if extended {
+			sa = uint32(ext >> 6 & 31)
+		} else if sa == 0 {
+			sa = 8
+		}
+		f := map[uint16]OpCode{0: OpCodeSLL, 2: OpCodeSRL, 3: OpCodeSRA}
+		names := map[uint16]string{0: "sll", 2: "srl", 3: "sra"}
+		if name, ok := names[hw&3]; ok {
+			set(name, reg(rx), reg(ry), imm(int32(sa)))
+			return inst, rType(special(f[hw&3]), 0, uint32(ry), uint32(rx), sa), true
+		}
+	case m16RRIA:
+		if hw&0x10 == 0 {
+			v := simm(4, 0)
+			if extended {
+				v = int32(uint32(ext&0xF)<<28|uint32(ext>>4&0x7F)<<21|uint32(hw&0xF)<<17) >> 17
+			}
+			set("addiu", reg(ry), reg(rx), imm(v))
+			return inst, iOp(OpCodeADDIU, rx, ry, v), true
+		}
+	case m16ADDIU8:
+		v := simm(8, 0)
+		set("addiu", reg(rx), imm(v))
+		return inst, iOp(OpCodeADDIU, rx, rx, v), true
+	case m16SLTI, m16SLTIU:
+		name, o := "slti", OpCodeSLTI
+		if op == m16SLTIU {
+			name, o = "sltiu", OpCodeSLTIU
+		}
+		v := uimm(8, 0)
+		set(name, reg(rx), imm(v))
+		return inst, iOp(o, rx, regT8, v), true
+	case m16LI:
+		v := uimm(8, 0) & 0xFFFF
+		set("li", reg(rx), imm(v))
+		return inst, iOp(OpCodeORI, 0, rx, v), true
+	case m16CMPI:
+		v := uimm(8, 0) & 0xFFFF
+		set("cmpi", reg(rx), imm(v))
+		return inst, iOp(OpCodeXORI, rx, regT8, v), true
+	case m16LB, m16LH, m16LW, m16LBU, m16LHU, m16SB, m16SH, m16SW:
+		m := m16Loads[op]
+		v := uimm(5, m.shift)
+		set(m.name, reg(ry), Arg{Kind: ArgMem, Reg: rx, Imm: int64(v)})
+		return inst, iOp(m.op, rx, ry, v), true
+	case m16LWSP, m16SWSP:
+		name, o := "lw", OpCodeLW
+		if op == m16SWSP {
+			name, o = "sw", OpCodeSW
+		}
+		v := uimm(8, 2)
+		set(name, reg(rx), Arg{Kind: ArgMem, Reg: regSP, Imm: int64(v)})
+		return inst, iOp(o, regSP, rx, v), true
+	case m16LWPC:
+		set("lw", reg(rx), target(pcBase+uint32(uimm(8, 2))))
+	case m16I8:
+		return decodeMIPS16I8(inst, hw, ext, extended, branch(8))
+	case m16RRR:
+		if extended {
+			break
+		}
+		switch hw & 3 {
+		case 1:
+			set("addu", reg(rz), reg(rx), reg(ry))
+			return inst, rOp(OpCodeADDU, rz, rx, ry), true
+		case 3:
+			set("subu", reg(rz), reg(rx), reg(ry))
+			return inst, rOp(OpCodeSUBU, rz, rx, ry), true
+		}
+	case m16RR:
+		if !extended {
+			return decodeMIPS16RR(inst, hw, rx, ry)
+		}
+	}
+	return inst, 0, false
+}
+
+// decodeMIPS16I8 decodes the I8 major opcode, whose bits 10-8 select the
+// operation. target is where a BTEQZ or BTNEZ would branch to.
+func decodeMIPS16I8(inst Inst, hw, ext uint16, extended bool, target uint32) (Inst, uint32, bool) {
+	reg := func(r uint8) Arg { return Arg{Kind: ArgReg, Reg: r} }
+	ext16 := int32(int16(ext&0x1F<<11 | ext>>5<<5 | hw&0x1F))
+	switch hw >> 8 & 7 {
+	case 0:
+		inst.Op, inst.Args = "bteqz", []Arg{{Kind: ArgTarget, Imm: int64(target)}}
+	case 1:
+		inst.Op, inst.Args = "btnez", []Arg{{Kind: ArgTarget, Imm: int64(target)}}
+	case 2:
+		v := int32(hw&0xFF) << 2
+		if extended {
+			v = ext16
+		}
+		inst.Op, inst.Args = "sw", []Arg{reg(regRA), {Kind: ArgMem, Reg: regSP, Imm: int64(v)}}
+		return inst, iWord(iType(OpCodeSW), regSP, regRA, uint32(v)), true
+	case 3:
+		v := int32(int8(hw)) << 3
+		if extended {
+			v = ext16
+		}
+		inst.Op, inst.Args = "addiu", []Arg{reg(regSP), {Kind: ArgImm, Imm: int64(v)}}
+		return inst, iWord(iType(OpCodeADDIU), regSP, regSP, uint32(v)), true
+	case 4:
+		s := decodeSaveRestore(inst.Word, extended)
+		inst.Op, inst.Args = "restore", s.operands()
+		if s.save {
+			inst.Op = "save"
+		}
+	case 5:
+		if extended {
+			break
+		}
+		r32 := uint8(hw>>5&7 | hw>>3&3<<3)
+		rz := mips16Regs[hw&7]
+		if r32 == 0 && hw&7 == 0 {
+			inst.Op = "nop"
+		} else {
+			inst.Op, inst.Args = "move", []Arg{reg(r32), reg(rz)}
+		}
+		return inst, rType(special(OpCodeADDU), uint32(rz), 0, uint32(r32), 0), true
+	case 7:
+		if extended {
+			break
+		}
+		ry, r32 := mips16Regs[hw>>5&7], uint8(hw&0x1F)
+		inst.Op, inst.Args = "move", []Arg{reg(ry), reg(r32)}
+		return inst, rType(special(OpCodeADDU), uint32(r32), 0, uint32(ry), 0), true
+	}
+	return inst, 0, false
+}
+
+// decodeMIPS16RR decodes the RR major opcode, whose low five bits select
+// the operation.
+func decodeMIPS16RR(inst Inst, hw uint16, rx, ry uint8) (Inst, uint32, bool) {
+	reg := func(r uint8) Arg { return Arg{Kind: ArgReg, Reg: r} }
+	funct := hw & 0x1F
+	if f, ok := m16RRFuncts[funct]; ok {
+		pick := func(c byte) uint8 {
+			switch c {
+			case 'x':
+				return rx
+			case 'y':
+				return ry
+			case 't':
+				return regT8
+			}
+			return 0
+		}
+		inst.Op, inst.Args = f.name, []Arg{reg(rx), reg(ry)}
+		return inst, rType(special(f.funct), uint32(pick(f.regs[1])), uint32(pick(f.regs[2])), uint32(pick(f.regs[0])), 0), true
+	}
+
+	switch funct {
+	case 0x00: // jumps, selected by the ry field: nd, l, ra
+		name, ok := map[uint16]string{0: "jr", 1: "jr", 2: "jalr", 4: "jrc", 5: "jrc", 6: "jalrc"}[hw>>5&7]
+		if !ok {
+			break
+		}
+		target := rx
+		if hw>>5&1 != 0 {
+			target = regRA
+		}
+		inst.Op, inst.Args = name, []Arg{reg(target)}
+	case 0x01, 0x05:
+		code := uint32(hw >> 5 & 0x3F)
+		inst.Op = "sdbbp"
+		native := special2(0x3F) | code<<6
+		if funct == 0x05 {
+			inst.Op, native = "break", special(OpCodeBREAK)|code<<16
+		}
+		if code != 0 {
+			inst.Args = []Arg{{Kind: ArgUImm, Imm: int64(code)}}
+		}
+		return inst, native, true
+	case 0x04, 0x06, 0x07:
+		f := map[uint16]OpCode{0x04: OpCodeSLLV, 0x06: OpCodeSRLV, 0x07: OpCodeSRAV}[funct]
+		inst.Op, inst.Args = map[uint16]string{0x04: "sllv", 0x06: "srlv", 0x07: "srav"}[funct], []Arg{reg(ry), reg(rx)}
+		return inst, rType(special(f), uint32(rx), uint32(ry), uint32(ry), 0), true
+	case 0x10, 0x12:
+		inst.Op, inst.Args = "mfhi", []Arg{reg(rx)}
+		f := OpCodeMFHI
+		if funct == 0x12 {
+			inst.Op, f = "mflo", OpCodeMFLO
+		}
+		return inst, rType(special(f), 0, 0, uint32(rx), 0), true
+	case 0x11: // CNVT, selected by the ry field
+		switch hw >> 5 & 7 {
+		case 0:
+			inst.Op, inst.Args = "zeb", []Arg{reg(rx)}
+			return inst, iWord(iType(OpCodeANDI), uint32(rx), uint32(rx), 0xFF), true
+		case 1:
+			inst.Op, inst.Args = "zeh", []Arg{reg(rx)}
+			return inst, iWord(iType(OpCodeANDI), uint32(rx), uint32(rx), 0xFFFF), true
+		case 4:
+			inst.Op, inst.Args = "seb", []Arg{reg(rx)}
+		case 5:
+			inst.Op, inst.Args = "seh", []Arg{reg(rx)}
+		}
+	case 0x18, 0x19, 0x1A, 0x1B:
+		f := OpCodeMULT + OpCode(funct-0x18)
+		inst.Op, inst.Args = [...]string{"mult", "multu", "div", "divu"}[funct-0x18], []Arg{reg(rx), reg(ry)}
+		return inst, rType(special(f), uint32(rx), uint32(ry), 0, 0), true
+	}
+	return inst, 0, false
+}
+
+// saveRestore holds the fields of a SAVE or RESTORE instruction.
+type saveRestore struct {
+	save    bool
+	frame   uint32 // bytes the stack pointer moves by
+	ra      bool
+	s0, s1  bool
+	xsregs  int // $s2 onwards saved, 7 meaning $s2-$s7 and $s8
+	args    int // $a0 onwards stored in the caller's frame by SAVE
+	statics int // $a3 downwards saved with the other registers
+}
+
+// decodeSaveRestore decodes SAVE or RESTORE from word, the instruction
+// and its EXTEND prefix if extended.
+func decodeSaveRestore(word uint32, extended bool) saveRestore {
+	hw := uint16(word)
+	s := saveRestore{
+		save:  hw&0x80 != 0,
+		ra:    hw&0x40 != 0,
+		s0:    hw&0x20 != 0,
+		s1:    hw&0x10 != 0,
+		frame: uint32(hw&0xF) << 3,
+	}
+	if !extended {
+		if s.frame == 0 {
+			s.frame = 128
+		}
+		return s
+	}
+	ext := uint16(word >> 16)
+	s.frame |= uint32(ext>>4&0xF) << 7
+	s.xsregs = int(ext >> 8 & 7)
+	// the aregs field encodes how many argument registers are saved
+	// on each side, all of them being either
+	switch aregs := int(ext & 0xF); aregs {
+	case 0xE:
+		s.args = 4
+	case 0xB:
+		s.statics = 4
+	default:
+		s.args, s.statics = aregs>>2, aregs&3
+	}
+	return s
+}
+
+// savedRegs returns the registers SAVE pushes below the stack pointer, in
+// order of decreasing address.
+func (s saveRestore) savedRegs() []uint8 {
+	var regs []uint8
+	if s.ra {
+		regs = append(regs, regRA)
+	}
+	if s.xsregs == 7 {
+		regs = append(regs, 30)
+	}
+	for r := min(s.xsregs, 6); r > 0; r-- {
+		regs = append(regs, uint8(17+r)) // $s7 down to $s2
+	}
+	if s.s1 {
+		regs = append(regs, 17)
+	}
+	if s.s0 {
+		regs = append(regs, 16)
+	}
+	for i := 0; i < s.statics; i++ {
+		regs = append(regs, uint8(7-i)) // $a3 downwards
+	}
+	return regs
+}
+
+// operands returns the operands of the instruction for display: the argument
+// registers stored in the caller's frame, the frame size and the saved
+// registers.
+func (s saveRestore) operands() []Arg {
+	var args []Arg
+	if s.save {
+		for i := 0; i < s.args; i++ {
+			args = append(args, Arg{Kind: ArgReg, Reg: uint8(4 + i)})
+		}
+	}
+	args = append(args, Arg{Kind: ArgImm, Imm: int64(s.frame)})
+	for _, r := range s.savedRegs() {
+		args = append(args, Arg{Kind: ArgReg, Reg: r})
+	}
+	return args
+}
+
+// control16 classifies a MIPS16e instruction's effect on control flow.
+func (inst Inst) control16() Control {
+	switch inst.Op {
+	case "b":
+		return ControlJump
+	case "beqz", "bnez", "bteqz", "btnez":
+		return ControlBranch
+	case "jal", "jalx":
+		return ControlCall
+	case "jalr", "jalrc":
+		return ControlIndirectCall
+	case "jr", "jrc":
+		if inst.Args[0].Reg == regRA {
+			return ControlReturn
+		}
+		return ControlIndirect
+	}
+	return ControlNone
+}
+
+// dest16 returns the general-purpose register a MIPS16e instruction writes,
+// if any: comparisons write $t8, SAVE and RESTORE $sp.
+func (inst Inst) dest16() (uint8, bool) {
+	switch inst.Op {
+	case "", "nop", "sb", "sh", "sw", "b", "beqz", "bnez", "bteqz", "btnez",
+		"jr", "jrc", "break", "sdbbp", "mult", "multu", "div", "divu":
+		return 0, false
+	case "slt", "sltu", "slti", "sltiu", "cmp", "cmpi":
+		return regT8, true
+	case "jal", "jalx", "jalr", "jalrc":
+		return regRA, true
+	case "save", "restore":
+		return regSP, true
+	}
+	return inst.Args[0].Reg, true
+}
+
+// hasDelaySlot16 reports whether a MIPS16e instruction has a delay slot:
+// only the jumps do, the branches and the compact jumps do not.
+func (inst Inst) hasDelaySlot16() bool {
+	switch inst.Op {
+	case "jal", "jalx", "jr", "jalr":
+		return true
+	}
+	return false
+}
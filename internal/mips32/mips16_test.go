@@ -0,0 +1,176 @@
+package mips32
+
+import (
+	"context"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeMIPS16(t *testing.T) {
+	tests := []struct {
+		code []uint16
+		addr uint32
+		want string
+	}{
+		{[]uint16{0x6A05}, 0, "li $v0, 5"},
+		{[]uint16{0xF222, 0x6A14}, 0, "li $v0, 4660"},
+		{[]uint16{0x6500}, 0, "nop"},
+		{[]uint16{0x0104}, 0, "addiu $s1, $sp, 16"},
+		{[]uint16{0x63FF}, 0, "addiu $sp, -8"},
+		{[]uint16{0x5205}, 0, "slti $v0, 5"},
+		{[]uint16{0x1002}, 0x100, "b 0x00000106"},
+		{[]uint16{0x17FE}, 0x100, "b 0x000000fe"},
+		{[]uint16{0x2203}, 0x100, "beqz $v0, 0x00000108"},
+		{[]uint16{0x1A00, 0x0048}, 0x400000, "jal 0x00400120"},
+		{[]uint16{0x1E00, 0x0048}, 0x400000, "jalx 0x00400120"},
+		{[]uint16{0x3260}, 0, "sll $v0, $v1, 8"},
+		{[]uint16{0x326F}, 0, "sra $v0, $v1, 3"},
+		{[]uint16{0x9C42}, 0, "lw $v0, 8($a0)"},
+		{[]uint16{0x6201}, 0, "sw $ra, 4($sp)"},
+		{[]uint16{0xB201}, 0x102, "lw $v0, 0x00000104"},
+		{[]uint16{0xE271}, 0, "addu $a0, $v0, $v1"},
+		{[]uint16{0xEA6A}, 0, "cmp $v0, $v1"},
+		{[]uint16{0xEC91}, 0, "seb $a0"},
+		{[]uint16{0x671F}, 0, "move $s0, $ra"},
+		{[]uint16{0x65BA}, 0, "move $sp, $v0"},
+		{[]uint16{0xE820}, 0, "jr $ra"},
+		{[]uint16{0xE8A0}, 0, "jrc $ra"},
+		{[]uint16{0xEAC0}, 0, "jalrc $v0"},
+		{[]uint16{0xE805}, 0, "break"},
+		{[]uint16{0x64E1}, 0, "save 8, $ra, $s0"},
+		{[]uint16{0x6461}, 0, "restore 8, $ra, $s0"},
+		{[]uint16{0xF204, 0x64C2}, 0, "save $a0, 16, $ra, $s3, $s2"},
+		{[]uint16{0xE808}, 0, ".half 0xe808"},
+		{[]uint16{0xF000, 0xE271}, 0, ".word 0xf000e271"},
+	}
+	for _, tt := range tests {
+		code := make([]byte, 2*len(tt.code))
+		for i, hw := range tt.code {
+			binary.BigEndian.PutUint16(code[2*i:], hw)
+		}
+		inst := DecodeMIPS16(code, tt.addr, binary.BigEndian)
+		if got := inst.String(); got != tt.want {
+			t.Errorf("DecodeMIPS16(%04x) = %q, want %q", tt.code, got, tt.want)
+		}
+		if inst.Size() != uint32(len(code)) {
+			t.Errorf("DecodeMIPS16(%04x).Size() = %d, want %d", tt.code, inst.Size(), len(code))
+		}
+	}
+}
+
+// runMIPS16Program loads words at address 0 and the MIPS16e code at 0x100,
+// and runs them until the CPU stops.
+func runMIPS16Program(t *testing.T, words []uint32, code ...uint16) *CPU {
+	t.Helper()
+	mem := NewMemory(0x2000)
+	for i, w := range words {
+		mem.StoreWord(uint32(i*4), w)
+	}
+	for i, hw := range code {
+		mem.StoreHalf(0x100+uint32(i*2), hw)
+	}
+	cpu := NewCPU(mem)
+	cpu.Run(context.Background())
+	return cpu
+}
+
+func TestRunMIPS16(t *testing.T) {
+	cpu := runMIPS16Program(t,
+		[]uint32{
+			0x241D1000, // addiu $sp, $zero, 0x1000
+			0x74000040, // jalx 0x100
+			0x00000000, // nop
+			reservedInstr,
+		},
+		0x6A05,         // 0x100: li $v0, 5
+		0x6B07,         // li $v1, 7
+		0xE271,         // addu $a0, $v0, $v1
+		0x64E1,         // save 8, $ra, $s0
+		0x1800, 0x0048, // jal 0x120
+		0x6903,           // li $s1, 3 (delay slot)
+		0x6461,           // restore 8, $ra, $s0
+		0xE820,           // jr $ra
+		0x6500,           // nop (delay slot)
+		0, 0, 0, 0, 0, 0, // padding
+		0x7205,         // 0x120: cmpi $v0, 5
+		0x6001,         // bteqz 0x126
+		0x6D63,         // li $a1, 99 (skipped)
+		0xB602,         // lw $a2, 0x12c
+		0xE8A0,         // jrc $ra
+		0x6500,         // nop
+		0xDEAD, 0xBEEF, // 0x12c: literal
+	)
+
+	for _, r := range []struct {
+		reg  uint8
+		want uint32
+	}{{4, 12}, {5, 0}, {6, 0xDEADBEEF}, {17, 3}, {29, 0x1000}, {31, 0xC}} {
+		if got := cpu.GetReg(r.reg); got != r.want {
+			t.Errorf("$%s = 0x%x, want 0x%x", RegName(r.reg, ABIRegs), got, r.want)
+		}
+	}
+	if epc := cpu.GetCP0Reg(cp0RegEPC, 0); epc != 0xC {
+		t.Errorf("EPC = 0x%x, want 0xc (back in MIPS32 code)", epc)
+	}
+}
+
+func TestMIPS16ExceptionEPC(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    []uint16
+		wantEPC uint32
+		wantBD  bool
+	}{
+		{"break", []uint16{0x6500, 0xE805}, 0x103, false},
+		{"delay slot", []uint16{0xE820, 0xE805}, 0x101, true},
+	}
+	for _, tt := range tests {
+		cpu := runMIPS16Program(t, []uint32{0x74000040, 0, reservedInstr}, tt.code...)
+		if epc := cpu.GetCP0Reg(cp0RegEPC, 0); epc != tt.wantEPC {
+			t.Errorf("%s: EPC = 0x%x, want 0x%x", tt.name, epc, tt.wantEPC)
+		}
+		if bd := cpu.GetCP0Reg(cp0RegCause, 0)&causeBD != 0; bd != tt.wantBD {
+			t.Errorf("%s: Cause.BD = %v, want %v", tt.name, bd, tt.wantBD)
+		}
+		if cpu.MIPS16() {
+			t.Errorf("%s: still in MIPS16e mode at the exception vector", tt.name)
+		}
+	}
+}
+
+func TestListingMIPS16(t *testing.T) {
+	code := []byte{
+		0x74, 0x00, 0x00, 0x02, // 0x0: jalx 0x8
+		0x00, 0x00, 0x00, 0x00, // nop
+		0x22, 0x01, // 0x8: beqz $v0, 0xc
+		0x6A, 0x01, // li $v0, 1
+		0xE8, 0xA0, // 0xc: jrc $ra
+	}
+	funcs := NewSymbolTable([]Symbol{{Name: "main", Addr: 0, Size: 8}, {Name: "m16", Addr: 8, Size: 6, MIPS16: true}})
+	l := NewListing(code, 0, binary.BigEndian, funcs, nil)
+
+	var got []string
+	for _, inst := range l.Insts {
+		got = append(got, inst.String())
+	}
+	want := []string{"jalx 0x00000008", "nop", "beqz $v0, 0x0000000c", "li $v0, 1", "jrc $ra"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("listing = %q, want %q", got, want)
+	}
+
+	g, err := l.CFG(funcs.Symbols()[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var starts []uint32
+	for _, b := range g.Blocks {
+		starts = append(starts, b.Start)
+	}
+	if want := []uint32{0x8, 0xa, 0xc}; !reflect.DeepEqual(starts, want) {
+		t.Errorf("blocks start at %#x, want %#x", starts, want)
+	}
+	if succs := g.Blocks[0].Succs; len(succs) != 2 || succs[0] != (Edge{0xc, EdgeTaken}) || succs[1] != (Edge{0xa, EdgeFallthrough}) {
+		t.Errorf("successors of the entry = %v", succs)
+	}
+}
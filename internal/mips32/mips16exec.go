@@ -0,0 +1,225 @@
+package mips32
+
+// runMIPS16 executes at most limit MIPS16e instructions from PC until
+// control transfers or leaves MIPS16e mode, and returns how many were
+// executed. Unlike MIPS32 code, MIPS16e instructions are decoded each time
+// they run and are not seen by the cache, timing and watch models.
+func (cpu *CPU) runMIPS16(limit int) int {
+	n := limit
+	if d := cpu.cp0.CyclesUntilTimer(); d != 0 && d < uint32(n) {
+		n = int(d)
+	}
+
+	executed := 0
+	for executed < n {
+		pc := cpu.PC
+		inst, native, hasNative, ok := cpu.fetchMIPS16()
+		if !ok {
+			break // address error, PC points at the vector
+		}
+		if h := cpu.hooks; h != nil && h.Instruction != nil && h.Instruction(pc, inst.Word) {
+			cpu.Stop()
+			break
+		}
+		executed++
+		if cpu.profiler != nil {
+			cpu.profiler.retire(pc, profileWord(inst, native))
+		}
+
+		inDelay, delayTarget := cpu.branchPending, cpu.branchTarget
+		cpu.inDelay = inDelay
+		cpu.branchPending = false
+
+		flow, target := cpu.executeMIPS16(inst, native, hasNative)
+		cpu.inDelay = false
+		cpu.cp0.advance(1)
+
+		switch flow {
+		case FlowNext:
+			if inDelay {
+				cpu.jumpTo(delayTarget)
+			} else {
+				cpu.PC += inst.Size()
+			}
+		case FlowBranch:
+			cpu.branchPending = true
+			cpu.branchTarget = target
+			cpu.jumpPC = pc
+			cpu.PC += inst.Size()
+		case FlowJump:
+			cpu.jumpTo(target)
+		case FlowException:
+			// PC already points at the exception vector
+		}
+
+		if flow != FlowNext || inDelay || !cpu.running.Load() {
+			break
+		}
+	}
+	return executed
+}
+
+// fetchMIPS16 decodes the MIPS16e instruction at PC, raising an address
+// error if it cannot be fetched.
+func (cpu *CPU) fetchMIPS16() (inst Inst, native uint32, hasNative bool, ok bool) {
+	hw, ok := cpu.Memory.LoadHalf(cpu.PC)
+	if !ok || !cpu.Memory.isAddressInRange(cpu.PC+1) {
+		cpu.inDelay = cpu.branchPending
+		cpu.addressError(excAdEL, cpu.PC)
+		return Inst{}, 0, false, false
+	}
+	var next uint16
+	if m16Long(hw) {
+		if next, ok = cpu.Memory.LoadHalf(cpu.PC + 2); !ok || !cpu.Memory.isAddressInRange(cpu.PC+3) {
+			cpu.inDelay = cpu.branchPending
+			cpu.addressError(excAdEL, cpu.PC+2)
+			return Inst{}, 0, false, false
+		}
+	}
+	inst, native, hasNative = decodeMIPS16(hw, next, cpu.PC)
+	return inst, native, hasNative, true
+}
+
+// profileWord returns the MIPS32 word standing for inst in the profiler's
+// shadow stack: a JAL for calls, a JR $ra for returns.
+func profileWord(inst Inst, native uint32) uint32 {
+	switch inst.control16() {
+	case ControlCall:
+		return iType(OpCodeJAL)
+	case ControlIndirectCall:
+		return special(OpCodeJALR) | regRA<<11
+	case ControlReturn:
+		return special(OpCodeJR) | regRA<<21
+	}
+	return native
+}
+
+// executeMIPS16 runs inst, or native, its MIPS32 equivalent, if it has one.
+// Jump targets returned carry the ISA mode in their low bit.
+func (cpu *CPU) executeMIPS16(inst Inst, native uint32, hasNative bool) (Flow, uint32) {
+	if hasNative {
+		op := decodeOp(native, ISAR2)
+		return op.execute(cpu)
+	}
+
+	arg := func(i int) uint32 { return uint32(inst.Args[i].Imm) }
+	reg := func(i int) uint32 { return cpu.GetReg(inst.Args[i].Reg) }
+	branchIf := func(cond bool, i int) (Flow, uint32) {
+		if cond {
+			return FlowJump, arg(i) | 1
+		}
+		return FlowNext, 0
+	}
+
+	switch inst.Op {
+	case "b":
+		return FlowJump, arg(0) | 1
+	case "beqz":
+		return branchIf(reg(0) == 0, 1)
+	case "bnez":
+		return branchIf(reg(0) != 0, 1)
+	case "bteqz":
+		return branchIf(cpu.GetReg(regT8) == 0, 0)
+	case "btnez":
+		return branchIf(cpu.GetReg(regT8) != 0, 0)
+	case "jal":
+		cpu.SetReg(regRA, (cpu.PC+6)|1)
+		return FlowBranch, arg(0) | 1
+	case "jalx":
+		cpu.SetReg(regRA, (cpu.PC+6)|1)
+		return FlowBranch, arg(0)
+	case "jr":
+		return FlowBranch, reg(0)
+	case "jalr":
+		target := reg(0)
+		cpu.SetReg(regRA, (cpu.PC+4)|1)
+		return FlowBranch, target
+	case "jrc":
+		return FlowJump, reg(0)
+	case "jalrc":
+		target := reg(0)
+		cpu.SetReg(regRA, (cpu.PC+2)|1)
+		return FlowJump, target
+	case "addiu": // addiu rx, pc, imm
+		cpu.SetReg(inst.Args[0].Reg, arg(1))
+		return FlowNext, 0
+	case "lw": // lw rx, imm(pc)
+		w, ok := cpu.loadWord16(arg(1))
+		if !ok {
+			return FlowException, cpu.PC
+		}
+		cpu.SetReg(inst.Args[0].Reg, w)
+		return FlowNext, 0
+	case "seb":
+		cpu.SetReg(inst.Args[0].Reg, uint32(int8(reg(0))))
+		return FlowNext, 0
+	case "seh":
+		cpu.SetReg(inst.Args[0].Reg, uint32(int16(reg(0))))
+		return FlowNext, 0
+	case "save", "restore":
+		return cpu.saveRestore(decodeSaveRestore(inst.Word, inst.Size() == 4))
+	}
+	return cpu.raiseException(excRI)
+}
+
+// saveRestore executes SAVE or RESTORE: SAVE stores the argument registers
+// in the caller's frame and the saved registers below $sp before lowering
+// it by the frame size, RESTORE reloads the saved registers and raises $sp.
+func (cpu *CPU) saveRestore(s saveRestore) (Flow, uint32) {
+	sp := cpu.GetReg(regSP)
+	top := sp
+	if !s.save {
+		top = sp + s.frame
+	}
+	for i := 0; i < s.args; i++ {
+		if !cpu.storeWord16(sp+4*uint32(i), cpu.GetReg(uint8(4+i))) {
+			return FlowException, cpu.PC
+		}
+	}
+	for i, r := range s.savedRegs() {
+		addr := top - 4*uint32(i+1)
+		if s.save {
+			if !cpu.storeWord16(addr, cpu.GetReg(r)) {
+				return FlowException, cpu.PC
+			}
+			continue
+		}
+		w, ok := cpu.loadWord16(addr)
+		if !ok {
+			return FlowException, cpu.PC
+		}
+		cpu.SetReg(r, w)
+	}
+	if s.save {
+		cpu.SetReg(regSP, sp-s.frame)
+	} else {
+		cpu.SetReg(regSP, sp+s.frame)
+	}
+	return FlowNext, 0
+}
+
+// loadWord16 loads the word at addr for a MIPS16e instruction, raising an
+// address error if it cannot.
+func (cpu *CPU) loadWord16(addr uint32) (uint32, bool) {
+	w, ok := uint32(0), addr%4 == 0
+	if ok {
+		w, ok = cpu.Memory.LoadWord(addr)
+	}
+	if !ok {
+		cpu.addressError(excAdEL, addr)
+		return 0, false
+	}
+	cpu.dataAccess(addr, 4, w, false)
+	return w, true
+}
+
+// storeWord16 stores w at addr for a MIPS16e instruction, raising an
+// address error if it cannot.
+func (cpu *CPU) storeWord16(addr, w uint32) bool {
+	if addr%4 != 0 || !cpu.Memory.StoreWord(addr, w) {
+		cpu.addressError(excAdES, addr)
+		return false
+	}
+	cpu.dataAccess(addr, 4, w, true)
+	return true
+}
@@ -0,0 +1,109 @@
+package mips32
+
+// asm64Instructions are the MIPS64 instructions added to MIPS32, decoded
+// with ISAMIPS64 only.
+var asm64Instructions = map[string]insnSpec{
+	"dadd":   {fmtR3, special(OpCodeDADD)},
+	"daddu":  {fmtR3, special(OpCodeDADDU)},
+	"dsub":   {fmtR3, special(OpCodeDSUB)},
+	"dsubu":  {fmtR3, special(OpCodeDSUBU)},
+	"dsll":   {fmtShift, special(OpCodeDSLL)},
+	"dsrl":   {fmtShift, special(OpCodeDSRL)},
+	"dsra":   {fmtShift, special(OpCodeDSRA)},
+	"dsll32": {fmtShift, special(OpCodeDSLL32)},
+	"dsrl32": {fmtShift, special(OpCodeDSRL32)},
+	"dsra32": {fmtShift, special(OpCodeDSRA32)},
+	"dsllv":  {fmtShiftV, special(OpCodeDSLLV)},
+	"dsrlv":  {fmtShiftV, special(OpCodeDSRLV)},
+	"dsrav":  {fmtShiftV, special(OpCodeDSRAV)},
+	"dmult":  {fmtRsRt, special(OpCodeDMULT)},
+	"dmultu": {fmtRsRt, special(OpCodeDMULTU)},
+	"ddiv":   {fmtRsRt, special(OpCodeDDIV)},
+	"ddivu":  {fmtRsRt, special(OpCodeDDIVU)},
+	"daddi":  {fmtArithI, iType(OpCodeDADDI)},
+	"daddiu": {fmtArithI, iType(OpCodeDADDIU)},
+	"ld":     {fmtMem, iType(OpCodeLD)},
+	"sd":     {fmtMem, iType(OpCodeSD)},
+	"dmfc0":  {fmtCOP0, cop0(COP0Funct_DMFC0)},
+	"dmtc0":  {fmtCOP0, cop0(COP0Funct_DMTC0)},
+}
+
+// decode64Table holds the entries of asm64Instructions.
+var decode64Table = newDecodeTable(asm64Instructions)
+
+// decodeMIPS64Inst decodes word for display as MIPS64 code.
+func decodeMIPS64Inst(word, addr uint32) Inst {
+	for _, e := range decode64Table[word>>26] {
+		if word&e.mask == e.spec.word {
+			return Inst{Addr: addr, Word: word, Op: e.name, Args: decodeArgs(e.spec.format, word, addr), format: e.spec.format}
+		}
+	}
+	return Decode(word, addr)
+}
+
+// is64 reports whether the CPU has 64-bit registers and executes the
+// doubleword instructions.
+func (cpu *CPU) is64() bool {
+	return cpu.isa == ISAMIPS64
+}
+
+// doubleword reports whether the SPECIAL function is one of the MIPS64
+// doubleword arithmetic, shift or multiply/divide operations.
+func (ri *RTypeInstruction) doubleword() bool {
+	switch OpCode(ri.Funct) {
+	case OpCodeDADD, OpCodeDADDU, OpCodeDSUB, OpCodeDSUBU,
+		OpCodeDSLL, OpCodeDSRL, OpCodeDSRA, OpCodeDSLL32, OpCodeDSRL32, OpCodeDSRA32,
+		OpCodeDSLLV, OpCodeDSRLV, OpCodeDSRAV,
+		OpCodeDMULT, OpCodeDMULTU, OpCodeDDIV, OpCodeDDIVU:
+		return true
+	}
+	return false
+}
+
+// doubleword reports whether the opcode is DADDI, DADDIU, LD or SD.
+func (ii *ITypeInstruction) doubleword() bool {
+	switch OpCode(ii.Opcode) {
+	case OpCodeDADDI, OpCodeDADDIU, OpCodeLD, OpCodeSD:
+		return true
+	}
+	return false
+}
+
+// shift64 returns the shift amount of a doubleword shift, 32 more for the
+// ...32 forms.
+func (ri *RTypeInstruction) shift64() uint {
+	switch OpCode(ri.Funct) {
+	case OpCodeDSLL32, OpCodeDSRL32, OpCodeDSRA32:
+		return uint(ri.Shamt) + 32
+	}
+	return uint(ri.Shamt)
+}
+
+// setHILO sets HI and LO from a word operation, sign-extended to 64 bits.
+func (cpu *CPU) setHILO(hi, lo int32) {
+	cpu.HI, cpu.LO = hi, lo
+	cpu.hiUpper, cpu.loUpper = hi>>31, lo>>31
+}
+
+// setHILO64 sets HI and LO from a doubleword operation.
+func (cpu *CPU) setHILO64(hi, lo uint64) {
+	cpu.HI, cpu.hiUpper = int32(hi), int32(hi>>32)
+	cpu.LO, cpu.loUpper = int32(lo), int32(lo>>32)
+}
+
+// hi64 returns the 64-bit value of HI.
+func (cpu *CPU) hi64() uint64 {
+	return uint64(uint32(cpu.hiUpper))<<32 | uint64(uint32(cpu.HI))
+}
+
+// lo64 returns the 64-bit value of LO.
+func (cpu *CPU) lo64() uint64 {
+	return uint64(uint32(cpu.loUpper))<<32 | uint64(uint32(cpu.LO))
+}
+
+// address64 returns the virtual address of a doubleword access: GPR[base]
+// plus the sign-extended offset. Memory is only reachable through the
+// 32-bit compatibility segments, see compat32.
+func (cpu *CPU) address64(base uint8, offset uint16) uint64 {
+	return cpu.GetReg64(base) + uint64(int64(int16(offset)))
+}
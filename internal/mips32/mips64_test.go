@@ -0,0 +1,266 @@
+package mips32
+
+import (
+	"context"
+	"testing"
+)
+
+// stop64 ends the MIPS64 test programs: reservedInstr is SD on MIPS64.
+const stop64 = uint32(0xEC000000)
+
+// runMIPS64Program loads words at address 0 and runs them on a 64-bit CPU
+// until it stops.
+func runMIPS64Program(t *testing.T, words ...uint32) *CPU {
+	t.Helper()
+	mem := NewMemory(0x2000)
+	for i, w := range words {
+		mem.StoreWord(uint32(i*4), w)
+	}
+	cpu := NewCPU(mem)
+	cpu.SetISA(ISAMIPS64)
+	cpu.Run(context.Background())
+	return cpu
+}
+
+func TestRunMIPS64(t *testing.T) {
+	cpu := runMIPS64Program(t,
+		0x6408FFFF, // daddiu $t0, $zero, -1
+		0x0008483E, // dsrl32 $t1, $t0, 0
+		0x00095138, // dsll $t2, $t1, 4
+		0x01205821, // addu $t3, $t1, $zero
+		0x012A602D, // daddu $t4, $t1, $t2
+		0xFC0C0100, // sd $t4, 0x100($zero)
+		0xDC0D0100, // ld $t5, 0x100($zero)
+		0x9C0E0104, // lwu $t6, 0x104($zero)
+		0x8C0F0104, // lw $t7, 0x104($zero)
+		0x0129001D, // dmultu $t1, $t1
+		0x00008012, // mflo $s0
+		0x0108001C, // dmult $t0, $t0
+		0x00008810, // mfhi $s1
+		0x000C903F, // dsra32 $s2, $t4, 0
+		stop64,
+	)
+
+	for _, r := range []struct {
+		reg  uint8
+		want uint64
+	}{
+		{8, 0xFFFFFFFFFFFFFFFF},
+		{9, 0x00000000FFFFFFFF},
+		{10, 0x0000000FFFFFFFF0},
+		{11, 0xFFFFFFFFFFFFFFFF}, // 32-bit results are sign-extended
+		{12, 0x00000010FFFFFFEF},
+		{13, 0x00000010FFFFFFEF},
+		{14, 0x00000000FFFFFFEF},
+		{15, 0xFFFFFFFFFFFFFFEF},
+		{16, 0xFFFFFFFE00000001},
+		{17, 0},
+		{18, 0x10},
+	} {
+		if got := cpu.GetReg64(r.reg); got != r.want {
+			t.Errorf("$%s = 0x%x, want 0x%x", RegName(r.reg, ABIRegs), got, r.want)
+		}
+	}
+	if w, _ := cpu.Memory.LoadWord(0x100); w != 0x10 {
+		t.Errorf("word at 0x100 = 0x%x, want 0x10 (most significant word first)", w)
+	}
+	if epc := cpu.GetCP0Reg(cp0RegEPC, 0); epc != 0x38 {
+		t.Errorf("EPC = 0x%x, want 0x38", epc)
+	}
+	if at := cpu.GetCP0Reg(cp0RegConfig, 0) & config0ATMask >> config0ATShift; at != 2 {
+		t.Errorf("Config0.AT = %d, want 2", at)
+	}
+}
+
+func TestMIPS64Exceptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		words []uint32
+		exc   uint32
+		epc   uint32
+	}{
+		{"dadd overflow", []uint32{
+			0x3C087FFF, // lui $t0, 0x7fff
+			0x0008403C, // dsll32 $t0, $t0, 0
+			0x0108482C, // dadd $t1, $t0, $t0
+		}, excOv, 8},
+		{"misaligned ld", []uint32{
+			0xDC080104, // ld $t0, 0x104($zero)
+		}, excAdEL, 0},
+		{"reserved", []uint32{stop64}, excRI, 0},
+	}
+	for _, tt := range tests {
+		cpu := runMIPS64Program(t, tt.words...)
+		if exc := cpu.GetCP0Reg(cp0RegCause, 0) >> 2 & 0x1F; exc != tt.exc {
+			t.Errorf("%s: ExcCode = %d, want %d", tt.name, exc, tt.exc)
+		}
+		if epc := cpu.GetCP0Reg(cp0RegEPC, 0); epc != tt.epc {
+			t.Errorf("%s: EPC = 0x%x, want 0x%x", tt.name, epc, tt.epc)
+		}
+	}
+}
+
+func TestMIPS64AddressErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		words    []uint32
+		exc      uint32
+		epc      uint32
+		badVAddr uint32
+	}{
+		{"misaligned ld", []uint32{
+			0xDC080104, // ld $t0, 0x104($zero)
+		}, excAdEL, 0, 0x104},
+		{"misaligned sd", []uint32{
+			0xFC08010C, // sd $t0, 0x10c($zero)
+		}, excAdES, 0, 0x10C},
+		{"ld outside compatibility segments", []uint32{
+			0x64080001, // daddiu $t0, $zero, 1
+			0x0008403C, // dsll32 $t0, $t0, 0
+			0xDD090100, // ld $t1, 0x100($t0)
+		}, excAdEL, 8, 0x100},
+		{"sd outside compatibility segments", []uint32{
+			0x64080001, // daddiu $t0, $zero, 1
+			0x0008403C, // dsll32 $t0, $t0, 0
+			0xFD090100, // sd $t1, 0x100($t0)
+		}, excAdES, 8, 0x100},
+		{"misaligned outside compatibility segments", []uint32{
+			0x64080001, // daddiu $t0, $zero, 1
+			0x0008403C, // dsll32 $t0, $t0, 0
+			0xDD090102, // ld $t1, 0x102($t0)
+		}, excAdEL, 8, 0x102},
+	}
+	for _, tt := range tests {
+		cpu := runMIPS64Program(t, tt.words...)
+		if exc := cpu.GetCP0Reg(cp0RegCause, 0) >> 2 & 0x1F; exc != tt.exc {
+			t.Errorf("%s: ExcCode = %d, want %d", tt.name, exc, tt.exc)
+		}
+		if epc := cpu.GetCP0Reg(cp0RegEPC, 0); epc != tt.epc {
+			t.Errorf("%s: EPC = 0x%x, want 0x%x", tt.name, epc, tt.epc)
+		}
+		if bad := cpu.GetCP0Reg(cp0RegBadVAddr, 0); bad != tt.badVAddr {
+			t.Errorf("%s: BadVAddr = 0x%x, want 0x%x", tt.name, bad, tt.badVAddr)
+		}
+	}
+}
+
+func TestDoublewordReservedOnMIPS32(t *testing.T) {
+	for _, w := range []uint32{
+		0x6408FFFF, // daddiu $t0, $zero, -1
+		0x012A602D, // daddu $t4, $t1, $t2
+		0xDC0D0100, // ld $t5, 0x100($zero)
+		0x4033A000, // dmfc0 $s3, $20
+	} {
+		cpu := runProgram(t, w)
+		if exc := cpu.GetCP0Reg(cp0RegCause, 0) >> 2 & 0x1F; exc != excRI {
+			t.Errorf("%08x: ExcCode = %d, want %d (reserved on MIPS32)", w, exc, excRI)
+		}
+	}
+}
+
+func TestSetISATruncatesRegisters(t *testing.T) {
+	cpu := NewCPU(NewMemory(0x1000))
+	cpu.SetISA(ISAMIPS64)
+	cpu.SetReg64(8, 0x12345678_9ABCDEF0)
+	cpu.SetISA(ISAR2)
+	if got := cpu.GetReg64(8); got != 0xFFFFFFFF_9ABCDEF0 {
+		t.Errorf("$t0 = 0x%x, want 0xffffffff9abcdef0", got)
+	}
+}
+
+func TestTLBRefillVector(t *testing.T) {
+	c := NewCOP0(16)
+	c.setRelease(ISAMIPS64)
+	c.Write64(cp0RegXContext, 0, 0xFFFFFFFFFFFFFFFF)
+
+	if vec := c.TLBRefill(excTLBL, 0x80012345, 0x400, false); vec != 0x80000000 {
+		t.Errorf("refill vector = 0x%x, want 0x80000000", vec)
+	}
+	if got := c.Read(cp0RegContext, 0); got != 0x400090 {
+		t.Errorf("Context = 0x%x, want 0x400090", got)
+	}
+	if got := c.Read64(cp0RegXContext, 0); got != 0xFFFFFFFE00000000|0x1FFC00090 {
+		t.Errorf("XContext = 0x%x, want 0x%x", got, uint64(0xFFFFFFFE00000000|0x1FFC00090))
+	}
+	if got := c.Read(cp0RegEntryHi, 0); got != 0x80012000 {
+		t.Errorf("EntryHi = 0x%x, want 0x80012000", got)
+	}
+	if got := c.Read(cp0RegBadVAddr, 0); got != 0x80012345 {
+		t.Errorf("BadVAddr = 0x%x, want 0x80012345", got)
+	}
+
+	// the general vector while an exception is being handled
+	if vec := c.TLBRefill(excTLBL, 0x1000, 0x400, false); vec != 0x80000180 {
+		t.Errorf("nested refill vector = 0x%x, want 0x80000180", vec)
+	}
+
+	// the XTLB refill vector once kernel mode uses 64-bit addresses
+	c.ERET()
+	c.Write(cp0RegStatus, 0, statusKX)
+	if vec := c.TLBRefill(excTLBS, 0x1000, 0x400, false); vec != 0x80000080 {
+		t.Errorf("XTLB refill vector = 0x%x, want 0x80000080", vec)
+	}
+	if got := c.Read64(cp0RegXContext, 0) >> 31 & 3; got != 0 {
+		t.Errorf("XContext.R = %d, want 0 (user segment)", got)
+	}
+
+	// user mode follows UX, not KX
+	c.ERET()
+	c.Write(cp0RegStatus, 0, statusKX|2<<3)
+	if vec := c.TLBRefill(excTLBS, 0x1000, 0x400, false); vec != 0x80000000 {
+		t.Errorf("user refill vector = 0x%x, want 0x80000000", vec)
+	}
+
+	// the boot refill vectors, 0x80 bytes after the general vector
+	c.ERET()
+	c.Write(cp0RegPRId, 1, 0xBFC00000) // EBase
+	c.Write(cp0RegStatus, 0, statusKX)
+	if vec := c.TLBRefill(excTLBS, 0x1000, 0x400, false); vec != 0xBFC00280 {
+		t.Errorf("boot XTLB refill vector = 0x%x, want 0xbfc00280", vec)
+	}
+	c.ERET()
+	c.Write(cp0RegStatus, 0, 0)
+	if vec := c.TLBRefill(excTLBL, 0x1000, 0x400, false); vec != 0xBFC00200 {
+		t.Errorf("boot refill vector = 0x%x, want 0xbfc00200", vec)
+	}
+
+	// a 32-bit CPU has no XTLB refill vector
+	c = NewCOP0(16)
+	c.Write(cp0RegStatus, 0, statusKX)
+	if vec := c.TLBRefill(excTLBL, 0x1000, 0x400, false); vec != 0x80000000 {
+		t.Errorf("MIPS32 refill vector = 0x%x, want 0x80000000", vec)
+	}
+}
+
+func TestDecodeMIPS64(t *testing.T) {
+	tests := []struct {
+		word uint32
+		want string
+		gnu  string
+	}{
+		{0x6408FFFF, "daddiu $t0, $zero, -1", "daddiu\tt0,zero,-1"},
+		{0x0008483E, "dsrl32 $t1, $t0, 0", "dsrl32\tt1,t0,0x0"},
+		{0x012A602D, "daddu $t4, $t1, $t2", "daddu\tt4,t1,t2"},
+		{0x0000102D, "daddu $v0, $zero, $zero", "move\tv0,zero"},
+		{0x0009502F, "dsubu $t2, $zero, $t1", "dnegu\tt2,t1"},
+		{0x0128001E, "ddiv $t1, $t0", "ddiv\tzero,t1,t0"},
+		{0xFC0C0100, "sd $t4, 256($zero)", "sd\tt4,256(zero)"},
+		{0xDC0D0100, "ld $t5, 256($zero)", "ld\tt5,256(zero)"},
+		{0x4033A000, "dmfc0 $s3, $20", "dmfc0\ts3,c0_xcontext"},
+		{0x01205821, "addu $t3, $t1, $zero", "move\tt3,t1"},
+	}
+	for _, tt := range tests {
+		inst := DecodeISA(tt.word, 0, ISAMIPS64)
+		if got := inst.String(); got != tt.want {
+			t.Errorf("DecodeISA(%08x) = %q, want %q", tt.word, got, tt.want)
+		}
+		if got := inst.GNU(nil); got != tt.gnu {
+			t.Errorf("DecodeISA(%08x).GNU() = %q, want %q", tt.word, got, tt.gnu)
+		}
+	}
+
+	// MIPS32 does not know the doubleword instructions
+	if got := DecodeISA(0x012A602D, 0, ISAR2).String(); got != ".word 0x012a602d" {
+		t.Errorf("DecodeISA(012a602d, ISAR2) = %q, want %q", got, ".word 0x012a602d")
+	}
+}
@@ -0,0 +1,325 @@
+package mips32
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Reloc is a relocation in an object section: the word at Offset must be
+// adjusted by the address of Symbol, as described by Type. Symbol is a
+// symbol of the object, or ".text" or ".data" for the start of that section.
+// The addend is held in the word itself, as in ELF REL.
+type Reloc struct {
+	Offset uint32
+	Type   elf.R_MIPS
+	Symbol string
+}
+
+// ObjSection is a section of a relocatable object.
+type ObjSection struct {
+	Name   string
+	Align  uint32
+	Data   []byte
+	Relocs []Reloc
+}
+
+// ObjSymbol is a label of a relocatable object.
+type ObjSymbol struct {
+	Name    string
+	Section string // ".text" or ".data", "" if undefined
+	Value   uint32 // offset within Section
+	Global  bool
+}
+
+// Object is a relocatable object, produced by AssembleObject or read from
+// an ELF file by ReadObject, ready to be linked by Link.
+type Object struct {
+	Name       string // the source or object file, for errors
+	Text, Data ObjSection
+	Symbols    []ObjSymbol // sorted by name
+}
+
+// section returns the section called name.
+func (o *Object) section(name string) *ObjSection {
+	switch name {
+	case ".text":
+		return &o.Text
+	case ".data":
+		return &o.Data
+	}
+	return nil
+}
+
+// AssembleObject assembles src, read from file, into a relocatable object.
+// Labels are local unless declared with .globl; symbols used but not
+// defined are left for the linker. Branches to other sections and the
+// addresses used by la, li, jumps, %hi/%lo and .word become relocations.
+func AssembleObject(file string, src []byte) (*Object, error) {
+	a := newAssembler(file, src, Layout{})
+	a.object = true
+	a.externs = make(map[string]bool)
+	if err := a.run(); err != nil {
+		return nil, err
+	}
+
+	obj := &Object{
+		Name: file,
+		Text: ObjSection{Name: ".text", Align: a.text.align, Data: a.text.data, Relocs: a.text.relocs},
+		Data: ObjSection{Name: ".data", Align: a.data.align, Data: a.data.data, Relocs: a.data.relocs},
+	}
+	for name, sym := range a.symbols {
+		if sym.label {
+			obj.Symbols = append(obj.Symbols, ObjSymbol{Name: name, Section: sym.section.name, Value: uint32(sym.value), Global: a.globals[name]})
+		}
+	}
+	for name := range a.externs {
+		obj.Symbols = append(obj.Symbols, ObjSymbol{Name: name, Global: true})
+	}
+	for name := range a.globals {
+		if _, defined := a.symbols[name]; !defined && !a.externs[name] {
+			obj.Symbols = append(obj.Symbols, ObjSymbol{Name: name, Global: true})
+		}
+	}
+	sort.Slice(obj.Symbols, func(i, j int) bool { return obj.Symbols[i].Name < obj.Symbols[j].Name })
+	return obj, nil
+}
+
+// relocValue is the value of an expression that may depend on an address
+// only known at link time: the address of sym plus addend. sym is empty for
+// a constant, and otherwise a section name or an undefined symbol.
+type relocValue struct {
+	sym    string
+	addend int64
+}
+
+// relocShift moves one group of symbols while evaluating an expression, to
+// find out how its value depends on them. Far above any 32-bit value, and
+// with low bits set so that %hi and %lo of an address are not mistaken for
+// constants.
+const relocShift = 1<<40 + 0x1234567
+
+// evalReloc evaluates x during pass 2. When assembling an image every
+// address is known and the result is a constant. In an object, x may add a
+// constant to the address of one section or undefined symbol; the
+// difference of two labels of the same section is a constant.
+func (a *assembler) evalReloc(x expr) (relocValue, *asmPosError) {
+	if !a.object {
+		v, err := x.eval(a.lookup)
+		return relocValue{addend: v}, err
+	}
+
+	// evaluate with every group at 0, then with each one moved in turn
+	var groups []string
+	seen := make(map[string]bool)
+	eval := func(moved string, shift int64) (int64, *asmPosError) {
+		return x.eval(func(name string, col int) (int64, *asmPosError) {
+			group, v := name, int64(0)
+			if sym, ok := a.symbols[name]; ok {
+				if !sym.label {
+					return sym.value, nil
+				}
+				group, v = sym.section.name, sym.value
+			} else {
+				a.externs[name] = true
+			}
+			if !seen[group] {
+				seen[group] = true
+				groups = append(groups, group)
+			}
+			if group == moved {
+				v += shift
+			}
+			return v, nil
+		})
+	}
+	base, err := eval("", 0)
+	if err != nil {
+		return relocValue{}, err
+	}
+	rv := relocValue{addend: base}
+	for _, g := range groups {
+		once, _ := eval(g, relocShift)
+		twice, _ := eval(g, 2*relocShift)
+		switch {
+		case once == base && twice == base:
+		case once-base == relocShift && twice-once == relocShift:
+			if rv.sym != "" {
+				return relocValue{}, posErrorf(exprCol(x), "expression refers to both %s and %s", rv.sym, g)
+			}
+			rv.sym = g
+		default:
+			return relocValue{}, posErrorf(exprCol(x), "expression cannot be relocated against %s", g)
+		}
+	}
+	return rv, nil
+}
+
+// exprCol returns the column x starts at, as best known.
+func exprCol(x expr) int {
+	switch x := x.(type) {
+	case symExpr:
+		return x.col
+	case unaryExpr:
+		return x.col
+	case binaryExpr:
+		return exprCol(x.x)
+	}
+	return 0
+}
+
+// addReloc records a relocation of typ against sym at offset in sec, if sym
+// is not empty.
+func (a *assembler) addReloc(sec *asmSection, offset uint32, typ elf.R_MIPS, sym string) {
+	if sym != "" {
+		sec.relocs = append(sec.relocs, Reloc{Offset: offset, Type: typ, Symbol: sym})
+	}
+}
+
+// section indices of the objects written by WriteELF
+const (
+	objText = 1 + iota
+	objRelText
+	objData
+	objRelData
+	objSymtab
+	objStrtab
+)
+
+// WriteELF writes o as a big-endian ELF32 relocatable object.
+func (o *Object) WriteELF(w io.Writer) error {
+	// section symbols, then local symbols, then global ones
+	symtab := newELFSymtab()
+	index := map[string]uint32{
+		".text": symtab.add("", 0, 0, elf.ST_INFO(elf.STB_LOCAL, elf.STT_SECTION), objText),
+		".data": symtab.add("", 0, 0, elf.ST_INFO(elf.STB_LOCAL, elf.STT_SECTION), objData),
+	}
+	firstGlobal := uint32(0)
+	for _, global := range []bool{false, true} {
+		if global {
+			firstGlobal = symtab.count
+		}
+		for _, sym := range o.Symbols {
+			if sym.Global != global {
+				continue
+			}
+			bind, typ, shndx := elf.STB_LOCAL, elf.STT_NOTYPE, elf.SHN_UNDEF
+			if global {
+				bind = elf.STB_GLOBAL
+			}
+			switch sym.Section {
+			case ".text":
+				typ, shndx = elf.STT_FUNC, objText
+			case ".data":
+				typ, shndx = elf.STT_OBJECT, objData
+			}
+			index[sym.Name] = symtab.add(sym.Name, sym.Value, 0, elf.ST_INFO(bind, typ), shndx)
+		}
+	}
+
+	rel := func(s *ObjSection) []byte {
+		var b bytes.Buffer
+		for _, r := range s.Relocs {
+			binary.Write(&b, binary.BigEndian, elf.Rel32{Off: r.Offset, Info: elf.R_INFO32(index[r.Symbol], uint32(r.Type))})
+		}
+		return b.Bytes()
+	}
+	return writeELF32(w, elf.ET_REL, 0, []elfSection{
+		{name: ".text", typ: elf.SHT_PROGBITS, flags: elf.SHF_ALLOC | elf.SHF_EXECINSTR, align: o.Text.Align, data: o.Text.Data},
+		{name: ".rel.text", typ: elf.SHT_REL, align: 4, link: objSymtab, info: objText, entsize: 8, data: rel(&o.Text)},
+		{name: ".data", typ: elf.SHT_PROGBITS, flags: elf.SHF_ALLOC | elf.SHF_WRITE, align: o.Data.Align, data: o.Data.Data},
+		{name: ".rel.data", typ: elf.SHT_REL, align: 4, link: objSymtab, info: objData, entsize: 8, data: rel(&o.Data)},
+		{name: ".symtab", typ: elf.SHT_SYMTAB, align: 4, link: objStrtab, info: firstGlobal, entsize: 16, data: symtab.syms.Bytes()},
+		{name: ".strtab", typ: elf.SHT_STRTAB, align: 1, data: symtab.strtab},
+	})
+}
+
+// ReadObject reads a big-endian ELF32 MIPS relocatable object. Only the
+// .text and .data sections and REL relocations are supported.
+func ReadObject(path string) (*Object, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if f.Class != elf.ELFCLASS32 || f.Machine != elf.EM_MIPS || f.ByteOrder != binary.BigEndian {
+		return nil, fmt.Errorf("%s: not a big-endian ELF32 MIPS file", path)
+	}
+	if f.Type != elf.ET_REL {
+		return nil, fmt.Errorf("%s: not a relocatable object (type %s)", path, f.Type)
+	}
+
+	obj := &Object{Name: path, Text: ObjSection{Name: ".text"}, Data: ObjSection{Name: ".data"}}
+	for _, s := range f.Sections {
+		sec := obj.section(s.Name)
+		switch {
+		case sec != nil:
+			if sec.Data, err = s.Data(); err != nil {
+				return nil, fmt.Errorf("%s: reading %s: %w", path, s.Name, err)
+			}
+			sec.Align = uint32(s.Addralign)
+		case s.Flags&elf.SHF_ALLOC != 0 && s.Size > 0 && (s.Type == elf.SHT_PROGBITS || s.Type == elf.SHT_NOBITS):
+			return nil, fmt.Errorf("%s: unsupported section %s", path, s.Name)
+		case s.Type == elf.SHT_RELA:
+			return nil, fmt.Errorf("%s: unsupported RELA section %s", path, s.Name)
+		}
+	}
+
+	syms, err := f.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	// names[i] is the name relocations use for symbol i; f.Symbols skips the null symbol
+	names := make([]string, len(syms)+1)
+	for i, s := range syms {
+		var section string
+		if s.Section < elf.SHN_LORESERVE && int(s.Section) < len(f.Sections) && s.Section != elf.SHN_UNDEF {
+			section = f.Sections[s.Section].Name
+		}
+		switch elf.ST_TYPE(s.Info) {
+		case elf.STT_SECTION:
+			names[i+1] = section
+			continue
+		case elf.STT_FILE:
+			continue
+		}
+		if s.Section != elf.SHN_UNDEF && obj.section(section) == nil {
+			continue // absolute or in an unsupported section
+		}
+		names[i+1] = s.Name
+		obj.Symbols = append(obj.Symbols, ObjSymbol{
+			Name:    s.Name,
+			Section: section,
+			Value:   uint32(s.Value),
+			Global:  elf.ST_BIND(s.Info) != elf.STB_LOCAL,
+		})
+	}
+	sort.Slice(obj.Symbols, func(i, j int) bool { return obj.Symbols[i].Name < obj.Symbols[j].Name })
+
+	for _, s := range f.Sections {
+		if s.Type != elf.SHT_REL || int(s.Info) >= len(f.Sections) {
+			continue
+		}
+		sec := obj.section(f.Sections[s.Info].Name)
+		if sec == nil {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			return nil, fmt.Errorf("%s: reading %s: %w", path, s.Name, err)
+		}
+		for ; len(data) >= 8; data = data[8:] {
+			off, info := binary.BigEndian.Uint32(data), binary.BigEndian.Uint32(data[4:])
+			sym := elf.R_SYM32(info)
+			if int(sym) >= len(names) || names[sym] == "" {
+				return nil, fmt.Errorf("%s: relocation at %s+0x%x refers to unsupported symbol %d", path, sec.Name, off, sym)
+			}
+			sec.Relocs = append(sec.Relocs, Reloc{Offset: off, Type: elf.R_MIPS(elf.R_TYPE32(info)), Symbol: names[sym]})
+		}
+	}
+	return obj, nil
+}
@@ -0,0 +1,219 @@
+package mips32
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"time"
+)
+
+// profileBuilder encodes a profile.proto message (github.com/google/pprof/proto/profile.proto)
+// by hand so the VM does not need the protobuf runtime.
+type profileBuilder struct {
+	symbols *SymbolTable
+
+	out       protoBuffer
+	strings   map[string]int64
+	stringTab []string
+	locations map[uint32]uint64 // pc -> location id
+	functions map[string]uint64 // name -> function id
+	locBuf    protoBuffer
+	funcBuf   protoBuffer
+}
+
+// profile.proto field numbers
+const (
+	profSampleType    = 1
+	profSample        = 2
+	profMapping       = 3
+	profLocation      = 4
+	profFunction      = 5
+	profStringTable   = 6
+	profTimeNanos     = 9
+	profDurationNanos = 10
+	profPeriodType    = 11
+	profPeriod        = 12
+)
+
+const profMappingID = 1
+
+func newProfileBuilder(symbols *SymbolTable) *profileBuilder {
+	b := &profileBuilder{
+		symbols:   symbols,
+		strings:   make(map[string]int64),
+		locations: make(map[uint32]uint64),
+		functions: make(map[string]uint64),
+	}
+	b.str("") // string_table[0] must be empty
+	return b
+}
+
+func (b *profileBuilder) str(s string) int64 {
+	if i, ok := b.strings[s]; ok {
+		return i
+	}
+	i := int64(len(b.stringTab))
+	b.strings[s] = i
+	b.stringTab = append(b.stringTab, s)
+	return i
+}
+
+func (b *profileBuilder) valueType(typ, unit string) []byte {
+	var vt protoBuffer
+	vt.int64(1, b.str(typ))
+	vt.int64(2, b.str(unit))
+	return vt.data
+}
+
+func (b *profileBuilder) sampleType(typ, unit string) {
+	b.out.bytes(profSampleType, b.valueType(typ, unit))
+}
+
+func (b *profileBuilder) periodType(typ, unit string, period int64) {
+	b.out.bytes(profPeriodType, b.valueType(typ, unit))
+	b.out.int64(profPeriod, period)
+}
+
+func (b *profileBuilder) timing(start time.Time, d time.Duration) {
+	b.out.int64(profTimeNanos, start.UnixNano())
+	b.out.int64(profDurationNanos, d.Nanoseconds())
+}
+
+func (b *profileBuilder) sample(stack []uint32, values ...int64) {
+	ids := make([]uint64, len(stack))
+	for i, pc := range stack {
+		ids[i] = b.location(pc)
+	}
+
+	var s protoBuffer
+	s.packedUint64(1, ids)
+	packed := make([]uint64, len(values))
+	for i, v := range values {
+		packed[i] = uint64(v)
+	}
+	s.packedUint64(2, packed)
+	b.out.bytes(profSample, s.data)
+}
+
+func (b *profileBuilder) location(pc uint32) uint64 {
+	if id, ok := b.locations[pc]; ok {
+		return id
+	}
+	id := uint64(len(b.locations) + 1)
+	b.locations[pc] = id
+
+	name := fmt.Sprintf("0x%08x", pc)
+	if sym, ok := b.symbols.Lookup(pc); ok {
+		name = sym.Name
+	}
+
+	var line protoBuffer
+	line.uint64(1, b.function(name))
+
+	var loc protoBuffer
+	loc.uint64(1, id)
+	loc.uint64(2, profMappingID)
+	loc.uint64(3, uint64(pc))
+	loc.bytes(4, line.data)
+	b.locBuf.bytes(profLocation, loc.data)
+	return id
+}
+
+func (b *profileBuilder) function(name string) uint64 {
+	if id, ok := b.functions[name]; ok {
+		return id
+	}
+	id := uint64(len(b.functions) + 1)
+	b.functions[name] = id
+
+	var fn protoBuffer
+	fn.uint64(1, id)
+	fn.int64(2, b.str(name))
+	fn.int64(3, b.str(name))
+	b.funcBuf.bytes(profFunction, fn.data)
+	return id
+}
+
+func (b *profileBuilder) write(w io.Writer) error {
+	// a single mapping covering the guest address space; has_functions
+	// tells pprof not to try symbolizing addresses itself
+	var m protoBuffer
+	m.uint64(1, profMappingID)
+	m.uint64(2, 0)
+	m.uint64(3, 1<<32)
+	m.int64(5, b.str("guest"))
+	m.bool(7, true)
+
+	out := b.out
+	out.bytes(profMapping, m.data)
+	out.data = append(out.data, b.locBuf.data...)
+	out.data = append(out.data, b.funcBuf.data...)
+	for _, s := range b.stringTab {
+		out.string(profStringTable, s)
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(out.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// protoBuffer is a minimal protobuf wire-format encoder.
+type protoBuffer struct {
+	data []byte
+}
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (p *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		p.data = append(p.data, byte(v)|0x80)
+		v >>= 7
+	}
+	p.data = append(p.data, byte(v))
+}
+
+func (p *protoBuffer) key(field, wire int) {
+	p.varint(uint64(field)<<3 | uint64(wire))
+}
+
+func (p *protoBuffer) uint64(field int, v uint64) {
+	p.key(field, wireVarint)
+	p.varint(v)
+}
+
+func (p *protoBuffer) int64(field int, v int64) {
+	p.uint64(field, uint64(v))
+}
+
+func (p *protoBuffer) bool(field int, v bool) {
+	if v {
+		p.uint64(field, 1)
+	} else {
+		p.uint64(field, 0)
+	}
+}
+
+func (p *protoBuffer) bytes(field int, b []byte) {
+	p.key(field, wireBytes)
+	p.varint(uint64(len(b)))
+	p.data = append(p.data, b...)
+}
+
+func (p *protoBuffer) string(field int, s string) {
+	p.key(field, wireBytes)
+	p.varint(uint64(len(s)))
+	p.data = append(p.data, s...)
+}
+
+func (p *protoBuffer) packedUint64(field int, vs []uint64) {
+	var inner protoBuffer
+	for _, v := range vs {
+		inner.varint(v)
+	}
+	p.bytes(field, inner.data)
+}
@@ -0,0 +1,129 @@
+package mips32
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// maxProfileDepth bounds the shadow call stack so unbalanced call/return
+// sequences (longjmp, tail calls through jr $ra, ...) cannot grow it forever.
+const maxProfileDepth = 256
+
+// Profiler attributes retired instructions to guest PCs.
+// Call stacks are reconstructed from JAL/JALR/BxxZAL (push) and jr $ra (pop).
+type Profiler struct {
+	period    uint64 // take one sample every period retired instructions (1 = exact)
+	countdown uint64
+	symbols   *SymbolTable
+
+	stack   []uint32 // call-site PCs, outermost first
+	samples map[string]*profileSample
+	order   []*profileSample // samples in first-seen order, for stable output
+	retired uint64
+	start   time.Time
+}
+
+type profileSample struct {
+	stack []uint32 // leaf first
+	count int64
+}
+
+// NewProfiler creates a profiler sampling every period retired instructions.
+// A period of 0 or 1 records every instruction.
+func NewProfiler(period uint64, symbols *SymbolTable) *Profiler {
+	if period == 0 {
+		period = 1
+	}
+	return &Profiler{
+		period:    period,
+		countdown: period,
+		symbols:   symbols,
+		samples:   make(map[string]*profileSample),
+		start:     time.Now(),
+	}
+}
+
+// SetProfiler attaches p to the CPU; nil disables profiling.
+func (cpu *CPU) SetProfiler(p *Profiler) {
+	cpu.profiler = p
+}
+
+// Retired returns the number of instructions seen by the profiler.
+func (p *Profiler) Retired() uint64 {
+	return p.retired
+}
+
+// retire records the instruction at pc, which is about to be executed.
+func (p *Profiler) retire(pc, instr uint32) {
+	p.retired++
+	p.countdown--
+	if p.countdown == 0 {
+		p.countdown = p.period
+		p.record(pc)
+	}
+
+	// update the shadow stack after sampling so the call itself is
+	// attributed to the caller
+	op := instr >> 26
+	switch {
+	case op == 0x3: // JAL
+		p.push(pc)
+	case op == 0x0 && instr&0x3F == uint32(OpCodeJALR) && (instr>>11)&0x1F != 0:
+		p.push(pc)
+	case op == 0x1 && ((instr>>16)&0x1F == 0x10 || (instr>>16)&0x1F == 0x11): // BLTZAL/BGEZAL
+		p.push(pc)
+	case op == 0x0 && instr&0x3F == uint32(OpCodeJR) && (instr>>21)&0x1F == 31:
+		if len(p.stack) > 0 {
+			p.stack = p.stack[:len(p.stack)-1]
+		}
+	}
+}
+
+func (p *Profiler) push(pc uint32) {
+	if len(p.stack) == maxProfileDepth {
+		copy(p.stack, p.stack[1:])
+		p.stack = p.stack[:len(p.stack)-1]
+	}
+	p.stack = append(p.stack, pc)
+}
+
+func (p *Profiler) record(pc uint32) {
+	key := make([]byte, 4*(len(p.stack)+1))
+	binary.LittleEndian.PutUint32(key, pc)
+	for i, site := range p.stack {
+		binary.LittleEndian.PutUint32(key[4*(len(p.stack)-i):], site)
+	}
+
+	s, ok := p.samples[string(key)]
+	if !ok {
+		stack := make([]uint32, len(key)/4)
+		for i := range stack {
+			stack[i] = binary.LittleEndian.Uint32(key[4*i:])
+		}
+		s = &profileSample{stack: stack}
+		p.samples[string(key)] = s
+		p.order = append(p.order, s)
+	}
+	s.count++
+}
+
+// WriteProfile writes the collected samples as a gzipped profile.proto
+// that can be opened with `go tool pprof`.
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	b := newProfileBuilder(p.symbols)
+
+	b.sampleType("samples", "count")
+	b.sampleType("instructions", "count")
+	for _, s := range p.order {
+		b.sample(s.stack, s.count, s.count*int64(p.period))
+	}
+	b.periodType("instructions", "count", int64(p.period))
+	b.timing(p.start, time.Since(p.start))
+
+	if err := b.write(w); err != nil {
+		return fmt.Errorf("writing profile: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package mips32
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+)
+
+func TestProfilerCallStacks(t *testing.T) {
+	syms := NewSymbolTable([]Symbol{
+		{Name: "main", Addr: 0x100, Size: 0x100},
+		{Name: "leaf", Addr: 0x200, Size: 0x10},
+	})
+	p := NewProfiler(1, syms)
+
+	p.retire(0x100, 0x24080001) // addiu $t0, $zero, 1
+	p.retire(0x104, 0x0C000080) // jal 0x200
+	p.retire(0x200, 0x25080001) // addiu $t0, $t0, 1
+	p.retire(0x204, 0x03E00008) // jr $ra
+	p.retire(0x10C, 0x24080001) // addiu $t0, $zero, 1
+
+	if p.Retired() != 5 {
+		t.Errorf("Retired = %d, want 5", p.Retired())
+	}
+	if len(p.stack) != 0 {
+		t.Errorf("shadow stack depth = %d, want 0", len(p.stack))
+	}
+
+	// the leaf instructions must carry the call site as their caller frame
+	var leafSamples int64
+	for _, s := range p.order {
+		if s.stack[0] >= 0x200 && s.stack[0] < 0x210 {
+			if len(s.stack) != 2 || s.stack[1] != 0x104 {
+				t.Errorf("leaf stack = %x, want [pc 104]", s.stack)
+			}
+			leafSamples += s.count
+		}
+	}
+	if leafSamples != 2 {
+		t.Errorf("leaf samples = %d, want 2", leafSamples)
+	}
+}
+
+func TestProfilerSamplingPeriod(t *testing.T) {
+	p := NewProfiler(4, nil)
+	for i := 0; i < 10; i++ {
+		p.retire(0x100, 0)
+	}
+
+	var samples int64
+	for _, s := range p.order {
+		samples += s.count
+	}
+	if samples != 2 {
+		t.Errorf("samples = %d, want 2", samples)
+	}
+}
+
+func TestProfilerWriteProfile(t *testing.T) {
+	syms := NewSymbolTable([]Symbol{{Name: "hot_loop", Addr: 0x100, Size: 0x20}})
+	p := NewProfiler(1, syms)
+	p.retire(0x100, 0)
+	p.retire(0x104, 0)
+
+	var buf bytes.Buffer
+	if err := p.WriteProfile(&buf); err != nil {
+		t.Fatalf("WriteProfile: %v", err)
+	}
+
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("profile is not gzipped: %v", err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("reading profile: %v", err)
+	}
+	for _, want := range []string{"hot_loop", "instructions", "samples"} {
+		if !bytes.Contains(raw, []byte(want)) {
+			t.Errorf("profile does not contain string %q", want)
+		}
+	}
+}
+
+func TestProfilerSizelessFunctions(t *testing.T) {
+	// hand-written assembly often has no .size: the function extends to the
+	// next symbol
+	syms := NewSymbolTable([]Symbol{{Name: "start"}, {Name: "loop", Addr: 0x100}, {Name: "done", Addr: 0x200, Size: 8}})
+	p := NewProfiler(1, syms)
+	p.retire(0x180, 0)
+
+	var buf bytes.Buffer
+	if err := p.WriteProfile(&buf); err != nil {
+		t.Fatalf("WriteProfile: %v", err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("profile is not gzipped: %v", err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("reading profile: %v", err)
+	}
+	if !bytes.Contains(raw, []byte("loop")) || bytes.Contains(raw, []byte("0x00000180")) {
+		t.Errorf("sample at 0x180 is not attributed to loop")
+	}
+}
@@ -0,0 +1,397 @@
+package mips32
+
+import "math"
+
+// Release 6 opcodes, mostly reassigned from instructions it removed
+const (
+	OpCodePOP10  OpCode = 0x08 // BOVC, BEQZALC, BEQC; ADDI before R6
+	OpCodePOP30  OpCode = 0x18 // BNVC, BNEZALC, BNEC
+	OpCodePOP06  OpCode = 0x06 // BLEZALC, BGEZALC, BGEUC; BLEZ if rt is 0
+	OpCodePOP07  OpCode = 0x07 // BGTZALC, BLTZALC, BLTUC; BGTZ if rt is 0
+	OpCodePOP26  OpCode = 0x16 // BLEZC, BGEZC, BGEC; BLEZL before R6
+	OpCodePOP27  OpCode = 0x17 // BGTZC, BLTZC, BLTC; BGTZL before R6
+	OpCodeBC     OpCode = 0x32
+	OpCodeBALC   OpCode = 0x3A
+	OpCodePOP66  OpCode = 0x36 // BEQZC, JIC if rs is 0
+	OpCodePOP76  OpCode = 0x3E // BNEZC, JIALC if rs is 0
+	OpCodePCREL  OpCode = 0x3B // ADDIUPC, LWPC, AUIPC, ALUIPC
+	OpCodeSELEQZ OpCode = 0x35 // SPECIAL funct
+	OpCodeSELNEZ OpCode = 0x37
+)
+
+// r6Op is an instruction whose encoding Release 6 introduced or reassigned.
+type r6Op uint8
+
+const (
+	r6Reserved r6Op = iota // removed in Release 6
+	r6BC
+	r6BALC
+	r6BEQZC
+	r6BNEZC
+	r6JIC
+	r6JIALC
+	r6BLEZALC
+	r6BGEZALC
+	r6BGTZALC
+	r6BLTZALC
+	r6BEQZALC
+	r6BNEZALC
+	r6BLEZC
+	r6BGEZC
+	r6BGTZC
+	r6BLTZC
+	r6BGEC
+	r6BLTC
+	r6BGEUC
+	r6BLTUC
+	r6BEQC
+	r6BNEC
+	r6BOVC
+	r6BNVC
+	r6MUL
+	r6MUH
+	r6MULU
+	r6MUHU
+	r6DIV
+	r6MOD
+	r6DIVU
+	r6MODU
+	r6SELEQZ
+	r6SELNEZ
+	r6ADDIUPC
+	r6LWPC
+	r6AUIPC
+	r6ALUIPC
+	r6AUI
+)
+
+var r6Names = [...]string{
+	r6BC: "bc", r6BALC: "balc", r6BEQZC: "beqzc", r6BNEZC: "bnezc", r6JIC: "jic", r6JIALC: "jialc",
+	r6BLEZALC: "blezalc", r6BGEZALC: "bgezalc", r6BGTZALC: "bgtzalc", r6BLTZALC: "bltzalc",
+	r6BEQZALC: "beqzalc", r6BNEZALC: "bnezalc",
+	r6BLEZC: "blezc", r6BGEZC: "bgezc", r6BGTZC: "bgtzc", r6BLTZC: "bltzc",
+	r6BGEC: "bgec", r6BLTC: "bltc", r6BGEUC: "bgeuc", r6BLTUC: "bltuc",
+	r6BEQC: "beqc", r6BNEC: "bnec", r6BOVC: "bovc", r6BNVC: "bnvc",
+	r6MUL: "mul", r6MUH: "muh", r6MULU: "mulu", r6MUHU: "muhu",
+	r6DIV: "div", r6MOD: "mod", r6DIVU: "divu", r6MODU: "modu",
+	r6SELEQZ: "seleqz", r6SELNEZ: "selnez",
+	r6ADDIUPC: "addiupc", r6LWPC: "lwpc", r6AUIPC: "auipc", r6ALUIPC: "aluipc", r6AUI: "aui",
+}
+
+// r6Instruction is an instruction decoded with the Release 6 rules.
+type r6Instruction struct {
+	Op     r6Op
+	Rs     uint8
+	Rt     uint8
+	Rd     uint8
+	Offset int32 // sign-extended and scaled branch offset, or immediate
+}
+
+// decodeR6 decodes word if Release 6 gives it a meaning different from
+// earlier releases, including none for the instructions it removed:
+// branch-likely, ADDI, the HI/LO instructions, MOVN/MOVZ, the unaligned
+// accesses, trap immediates and JR, which is now JALR with rd 0.
+func decodeR6(word uint32) (r6Instruction, bool) {
+	rs, rt, rd := uint8(word>>21&31), uint8(word>>16&31), uint8(word>>11&31)
+	ri := r6Instruction{Rs: rs, Rt: rt, Rd: rd, Offset: int32(int16(word)) << 2}
+	is := func(op r6Op) (r6Instruction, bool) {
+		ri.Op = op
+		return ri, true
+	}
+	// pick selects among the compact branches sharing an opcode: one with
+	// rs 0, one with rs equal to rt and one comparing rs with rt
+	pick := func(zero, same, two r6Op) (r6Instruction, bool) {
+		switch {
+		case rs == 0:
+			return is(zero)
+		case rs == rt:
+			return is(same)
+		}
+		return is(two)
+	}
+
+	switch OpCode(word >> 26) {
+	case OpCodeBC, OpCodeBALC:
+		ri.Offset = int32(word<<6) >> 4
+		if OpCode(word>>26) == OpCodeBALC {
+			return is(r6BALC)
+		}
+		return is(r6BC)
+	case OpCodePOP66, OpCodePOP76:
+		jump, branch := r6JIC, r6BEQZC
+		if OpCode(word>>26) == OpCodePOP76 {
+			jump, branch = r6JIALC, r6BNEZC
+		}
+		if rs == 0 {
+			ri.Offset = int32(int16(word))
+			return is(jump)
+		}
+		ri.Offset = int32(word<<11) >> 9
+		return is(branch)
+	case OpCodePOP06:
+		if rt != 0 {
+			return pick(r6BLEZALC, r6BGEZALC, r6BGEUC)
+		}
+	case OpCodePOP07:
+		if rt != 0 {
+			return pick(r6BGTZALC, r6BLTZALC, r6BLTUC)
+		}
+	case OpCodePOP26:
+		if rt == 0 {
+			return is(r6Reserved)
+		}
+		return pick(r6BLEZC, r6BGEZC, r6BGEC)
+	case OpCodePOP27:
+		if rt == 0 {
+			return is(r6Reserved)
+		}
+		return pick(r6BGTZC, r6BLTZC, r6BLTC)
+	case OpCodePOP10, OpCodePOP30:
+		ovf, zero, two := r6BOVC, r6BEQZALC, r6BEQC
+		if OpCode(word>>26) == OpCodePOP30 {
+			ovf, zero, two = r6BNVC, r6BNEZALC, r6BNEC
+		}
+		switch {
+		case rs >= rt:
+			return is(ovf)
+		case rs == 0:
+			return is(zero)
+		}
+		return is(two)
+	case OpCodeBEQL, OpCodeBNEL, OpCodeLWL, OpCodeLWR, OpCodeSWL, OpCodeSWR:
+		return is(r6Reserved)
+	case OpCodeREGIMM:
+		switch rt {
+		case REGIMM_BLTZL, REGIMM_BGEZL, REGIMM_BLTZALL, REGIMM_BGEZALL,
+			REGIMM_TGEI, REGIMM_TGEIU, REGIMM_TLTI, REGIMM_TLTIU, REGIMM_TEQI, REGIMM_TNEI:
+			return is(r6Reserved)
+		case REGIMM_BLTZAL, REGIMM_BGEZAL:
+			if rs != 0 { // only NAL and BAL remain
+				return is(r6Reserved)
+			}
+		}
+	case OPCodeLUI:
+		if rs != 0 {
+			ri.Offset = int32(uint32(word) << 16)
+			return is(r6AUI)
+		}
+	case OpCodePCREL:
+		switch {
+		case rt == 0x1E:
+			ri.Offset = int32(uint32(word) << 16)
+			return is(r6AUIPC)
+		case rt == 0x1F:
+			ri.Offset = int32(uint32(word) << 16)
+			return is(r6ALUIPC)
+		case rt>>3 == 0:
+			ri.Offset = int32(word<<13) >> 11
+			return is(r6ADDIUPC)
+		case rt>>3 == 1:
+			ri.Offset = int32(word<<13) >> 11
+			return is(r6LWPC)
+		}
+		return is(r6Reserved)
+	case 0:
+		funct, sa := OpCode(word&0x3F), word>>6&31
+		switch funct {
+		case OpCodeMULT, OpCodeMULTU, OpCodeDIV, OpCodeDIVU:
+			if sa != 2 && sa != 3 {
+				return is(r6Reserved)
+			}
+			return is(r6MUL + r6Op(funct-OpCodeMULT)*2 + r6Op(sa-2))
+		case OpCodeMFHI, OpCodeMTHI, OpCodeMFLO, OpCodeMTLO, OpCodeJR, OpCodeMOVN, OpCodeMOVZ:
+			return is(r6Reserved)
+		case OpCodeSELEQZ:
+			return is(r6SELEQZ)
+		case OpCodeSELNEZ:
+			return is(r6SELNEZ)
+		}
+	}
+	return ri, false
+}
+
+func (ri r6Instruction) Decode(instr uint32) Instruction {
+	if r, ok := decodeR6(instr); ok {
+		return &r
+	}
+	return &r6Instruction{}
+}
+
+// isBranch reports whether the instruction is a compact branch or jump.
+func (ri *r6Instruction) isBranch() bool {
+	return ri.Op >= r6BC && ri.Op <= r6BNVC
+}
+
+// links reports whether the instruction saves its return address in $ra.
+func (ri *r6Instruction) links() bool {
+	switch ri.Op {
+	case r6BALC, r6JIALC, r6BLEZALC, r6BGEZALC, r6BGTZALC, r6BLTZALC, r6BEQZALC, r6BNEZALC:
+		return true
+	}
+	return false
+}
+
+// Execute runs the instruction. Compact branches have no delay slot: they
+// continue at their target at once, and the link ones save PC+4 in $ra
+// whether they branch or not.
+func (ri *r6Instruction) Execute(cpu *CPU) (flow Flow, target uint32) {
+	rs, rt := cpu.GetReg(ri.Rs), cpu.GetReg(ri.Rt)
+	branchIf := func(cond bool) (Flow, uint32) {
+		if cond {
+			return FlowJump, cpu.PC + 4 + uint32(ri.Offset)
+		}
+		return FlowNext, 0
+	}
+	if ri.links() {
+		cpu.SetReg(regRA, cpu.PC+4)
+	}
+
+	switch ri.Op {
+	case r6BC, r6BALC:
+		return branchIf(true)
+	case r6BEQZC:
+		return branchIf(rs == 0)
+	case r6BNEZC:
+		return branchIf(rs != 0)
+	case r6JIC, r6JIALC:
+		return FlowJump, rt + uint32(ri.Offset)
+	case r6BLEZALC, r6BGEZALC, r6BGTZALC, r6BLTZALC, r6BEQZALC, r6BNEZALC,
+		r6BLEZC, r6BGEZC, r6BGTZC, r6BLTZC:
+		return branchIf(compareZero(ri.Op, int32(rt)))
+	case r6BGEC:
+		return branchIf(int32(rs) >= int32(rt))
+	case r6BLTC:
+		return branchIf(int32(rs) < int32(rt))
+	case r6BGEUC:
+		return branchIf(rs >= rt)
+	case r6BLTUC:
+		return branchIf(rs < rt)
+	case r6BEQC:
+		return branchIf(rs == rt)
+	case r6BNEC:
+		return branchIf(rs != rt)
+	case r6BOVC, r6BNVC:
+		sum := int64(int32(rs)) + int64(int32(rt))
+		overflow := sum < math.MinInt32 || sum > math.MaxInt32
+		return branchIf(overflow == (ri.Op == r6BOVC))
+
+	case r6MUL, r6MULU:
+		cpu.SetReg(ri.Rd, rs*rt)
+	case r6MUH:
+		cpu.SetReg(ri.Rd, uint32(uint64(int64(int32(rs))*int64(int32(rt)))>>32))
+	case r6MUHU:
+		cpu.SetReg(ri.Rd, uint32(uint64(rs)*uint64(rt)>>32))
+	case r6DIV, r6MOD, r6DIVU, r6MODU:
+		// the result is unpredictable if the divisor is zero
+		var v uint32
+		switch {
+		case rt == 0:
+		case ri.Op == r6DIV:
+			v = uint32(int32(rs) / int32(rt))
+		case ri.Op == r6MOD:
+			v = uint32(int32(rs) % int32(rt))
+		case ri.Op == r6DIVU:
+			v = rs / rt
+		default:
+			v = rs % rt
+		}
+		cpu.SetReg(ri.Rd, v)
+	case r6SELEQZ, r6SELNEZ:
+		var v uint32
+		if (rt == 0) == (ri.Op == r6SELEQZ) {
+			v = rs
+		}
+		cpu.SetReg(ri.Rd, v)
+
+	case r6ADDIUPC, r6AUIPC:
+		cpu.SetReg(ri.Rs, cpu.PC+uint32(ri.Offset))
+	case r6ALUIPC:
+		cpu.SetReg(ri.Rs, (cpu.PC+uint32(ri.Offset))&^0xFFFF)
+	case r6AUI:
+		cpu.SetReg(ri.Rt, rs+uint32(ri.Offset))
+	case r6LWPC:
+		addr := cpu.PC + uint32(ri.Offset)
+		if addr%4 != 0 {
+			return cpu.addressError(excAdEL, addr)
+		}
+		w, ok := cpu.Memory.LoadWord(addr)
+		if !ok {
+			return cpu.addressError(excAdEL, addr)
+		}
+		cpu.dataAccess(addr, 4, w, false)
+		cpu.SetReg(ri.Rs, w)
+
+	default:
+		return cpu.raiseException(excRI)
+	}
+	return FlowNext, 0
+}
+
+// compareZero evaluates the comparison with zero of the compact branch op.
+func compareZero(op r6Op, v int32) bool {
+	switch op {
+	case r6BLEZALC, r6BLEZC:
+		return v <= 0
+	case r6BGEZALC, r6BGEZC:
+		return v >= 0
+	case r6BGTZALC, r6BGTZC:
+		return v > 0
+	case r6BLTZALC, r6BLTZC:
+		return v < 0
+	case r6BEQZALC:
+		return v == 0
+	}
+	return v != 0 // bnezalc
+}
+
+// inst returns the instruction word at addr for display.
+func (ri r6Instruction) inst(word, addr uint32) Inst {
+	inst := Inst{Addr: addr, Word: word}
+	if ri.Op == r6Reserved {
+		return inst
+	}
+	inst.Op = r6Names[ri.Op]
+	reg := func(r uint8) Arg { return Arg{Kind: ArgReg, Reg: r} }
+	target := Arg{Kind: ArgTarget, Imm: int64(addr + 4 + uint32(ri.Offset))}
+	uimm := Arg{Kind: ArgUImm, Imm: int64(uint32(ri.Offset) >> 16)}
+
+	switch ri.Op {
+	case r6BC, r6BALC:
+		inst.format, inst.Args = fmtCompact, []Arg{target}
+	case r6BEQZC, r6BNEZC:
+		inst.format, inst.Args = fmtCompact, []Arg{reg(ri.Rs), target}
+	case r6JIC, r6JIALC:
+		inst.format, inst.Args = fmtJIC, []Arg{reg(ri.Rt), {Kind: ArgImm, Imm: int64(ri.Offset)}}
+	case r6BLEZALC, r6BGEZALC, r6BGTZALC, r6BLTZALC, r6BEQZALC, r6BNEZALC, r6BLEZC, r6BGEZC, r6BGTZC, r6BLTZC:
+		inst.format, inst.Args = fmtCompact, []Arg{reg(ri.Rt), target}
+	case r6BGEC, r6BLTC, r6BGEUC, r6BLTUC, r6BEQC, r6BNEC, r6BOVC, r6BNVC:
+		inst.format, inst.Args = fmtCompact, []Arg{reg(ri.Rs), reg(ri.Rt), target}
+	case r6ADDIUPC, r6LWPC:
+		target.Imm = int64(addr + uint32(ri.Offset))
+		inst.format, inst.Args = fmtPCRel, []Arg{reg(ri.Rs), target}
+	case r6AUIPC, r6ALUIPC:
+		inst.format, inst.Args = fmtPCRel, []Arg{reg(ri.Rs), uimm}
+	case r6AUI:
+		inst.format, inst.Args = fmtLogicI, []Arg{reg(ri.Rt), reg(ri.Rs), uimm}
+	default: // mul ... selnez
+		inst.format, inst.Args = fmtR3, []Arg{reg(ri.Rd), reg(ri.Rs), reg(ri.Rt)}
+	}
+	return inst
+}
+
+// decodeR6Inst decodes word for display under the Release 6 rules, which
+// show JALR with rd 0 as JR.
+func decodeR6Inst(word, addr uint32) Inst {
+	if ri, ok := decodeR6(word); ok {
+		return ri.inst(word, addr)
+	}
+	inst := Decode(word, addr)
+	if inst.Op == "jalr" || inst.Op == "jalr.hb" {
+		if rd := uint8(word >> 11 & 31); rd == 0 {
+			jr := Decode(word&^(0x3F|31<<11)|uint32(OpCodeJR), addr)
+			jr.Word = word
+			return jr
+		}
+	}
+	return inst
+}
@@ -0,0 +1,132 @@
+package mips32
+
+import (
+	"context"
+	"testing"
+)
+
+// runR6Program loads words at address 0 and runs them as Release 6 code
+// until the CPU stops.
+func runR6Program(t *testing.T, words ...uint32) *CPU {
+	t.Helper()
+	mem := NewMemory(0x2000)
+	for i, w := range words {
+		mem.StoreWord(uint32(i*4), w)
+	}
+	cpu := NewCPU(mem)
+	cpu.SetISA(ISAR6)
+	cpu.Run(context.Background())
+	return cpu
+}
+
+func TestRunR6(t *testing.T) {
+	cpu := runR6Program(t,
+		0x24080005, // addiu $t0, $zero, 5
+		0x24090007, // addiu $t1, $zero, 7
+		0x01095098, // mul $t2, $t0, $t1
+		0x010958D8, // muh $t3, $t0, $t1
+		0x012860DA, // mod $t4, $t1, $t0
+		0x0128689A, // div $t5, $t1, $t0
+		0x01007035, // seleqz $t6, $t0, $zero
+		0x01007837, // selnez $t7, $t0, $zero
+		0xE8000003, // 0x20: balc 0x30
+		reservedInstr,
+		0,
+		0,
+		0xD9E00002, // 0x30: beqzc $t7, 0x3c
+		0x24100001, // addiu $s0, $zero, 1 (skipped)
+		reservedInstr,
+		0xEE280005, // 0x3c: lwpc $s1, 0x50
+		0xEE47FFFF, // addiupc $s2, -4
+		0x61090001, // bnec $t0, $t1, 0x4c
+		0x24100002, // addiu $s0, $zero, 2 (skipped, no delay slot)
+		0xD81F0000, // 0x4c: jic $ra, 0
+		0xCAFEBABE, // 0x50: literal
+	)
+
+	for _, r := range []struct {
+		reg  uint8
+		want uint32
+	}{{10, 35}, {11, 0}, {12, 2}, {13, 1}, {14, 5}, {15, 0}, {16, 0}, {17, 0xCAFEBABE}, {18, 0x3C}, {31, 0x24}} {
+		if got := cpu.GetReg(r.reg); got != r.want {
+			t.Errorf("$%s = 0x%x, want 0x%x", RegName(r.reg, ABIRegs), got, r.want)
+		}
+	}
+	if epc := cpu.GetCP0Reg(cp0RegEPC, 0); epc != 0x24 {
+		t.Errorf("EPC = 0x%x, want 0x24 (jic back to the return address)", epc)
+	}
+	if ar := cpu.GetCP0Reg(cp0RegConfig, 0) & config0ARMask >> config0ARShift; ar != 2 {
+		t.Errorf("Config0.AR = %d, want 2", ar)
+	}
+}
+
+func TestR6RemovedInstructions(t *testing.T) {
+	for _, w := range []uint32{
+		0x01090018, // mult $t0, $t1
+		0x00004012, // mflo $t0
+		0x03E00008, // jr $ra
+		0x51090001, // beql $t0, $t1
+		0x0109400B, // movn $t0, $t0, $t1
+	} {
+		cpu := runR6Program(t, w)
+		if epc := cpu.GetCP0Reg(cp0RegEPC, 0); epc != 0 {
+			t.Errorf("%08x: EPC = 0x%x, want 0 (reserved in Release 6)", w, epc)
+		}
+	}
+}
+
+func TestR6CompactBranchLinks(t *testing.T) {
+	// bgezalc saves the return address even when it does not branch
+	cpu := runR6Program(t,
+		0x2408FFFF, // addiu $t0, $zero, -1
+		0x19080004, // bgezalc $t0, 0x18
+		reservedInstr,
+	)
+	if got := cpu.GetReg(31); got != 8 {
+		t.Errorf("$ra = 0x%x, want 0x8", got)
+	}
+	if epc := cpu.GetCP0Reg(cp0RegEPC, 0); epc != 8 {
+		t.Errorf("EPC = 0x%x, want 0x8 (branch not taken)", epc)
+	}
+}
+
+func TestDecodeR6(t *testing.T) {
+	tests := []struct {
+		word, addr uint32
+		want       string
+		control    Control
+	}{
+		{0xE8000003, 0x20, "balc 0x00000030", ControlCall},
+		{0xCBFFFFFF, 0x20, "bc 0x00000020", ControlJump},
+		{0xD9E00002, 0x30, "beqzc $t7, 0x0000003c", ControlBranch},
+		{0x61090001, 0x44, "bnec $t0, $t1, 0x0000004c", ControlBranch},
+		{0x19080004, 0x4, "bgezalc $t0, 0x00000018", ControlCall},
+		{0xD81F0000, 0, "jic $ra, 0", ControlReturn},
+		{0xF8190010, 0, "jialc $t9, 16", ControlIndirectCall},
+		{0x03E00009, 0, "jr $ra", ControlReturn},
+		{0x01095098, 0, "mul $t2, $t0, $t1", ControlNone},
+		{0x012860DA, 0, "mod $t4, $t1, $t0", ControlNone},
+		{0x01007035, 0, "seleqz $t6, $t0, $zero", ControlNone},
+		{0xEE280005, 0x3C, "lwpc $s1, 0x00000050", ControlNone},
+		{0xEC9E0001, 0, "auipc $a0, 0x1", ControlNone},
+		{0x3C481234, 0, "aui $t0, $v0, 0x1234", ControlNone},
+		{0x01090018, 0, ".word 0x01090018", ControlNone},
+	}
+	for _, tt := range tests {
+		inst := DecodeISA(tt.word, tt.addr, ISAR6)
+		if got := inst.String(); got != tt.want {
+			t.Errorf("DecodeISA(%08x) = %q, want %q", tt.word, got, tt.want)
+		}
+		if c := inst.Control(); c != tt.control {
+			t.Errorf("DecodeISA(%08x).Control() = %v, want %v", tt.word, c, tt.control)
+		}
+		if inst.HasDelaySlot() && tt.word != 0x03E00009 {
+			t.Errorf("DecodeISA(%08x) has a delay slot", tt.word)
+		}
+	}
+
+	// the same words keep their earlier meaning by default
+	if got := DecodeISA(0x01090018, 0, ISAR2).String(); got != "mult $t0, $t1" {
+		t.Errorf("DecodeISA(01090018, ISAR2) = %q, want %q", got, "mult $t0, $t1")
+	}
+}
@@ -0,0 +1,142 @@
+package mips32
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrRunning is returned by Run when the CPU or System is already running.
+var ErrRunning = errors.New("already running")
+
+// StopReason tells why the CPU stopped.
+type StopReason uint32
+
+const (
+	StopNone             StopReason = iota // still running
+	StopHalted                             // Stop was called
+	StopExit                               // the guest exited, see Result.ExitCode
+	StopException                          // an exception the CPU does not handle
+	StopBreakpoint                         // a BREAK instruction
+	StopInstructionLimit                   // the instruction limit was reached
+	StopCanceled                           // the context passed to Run was done
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopNone:
+		return "running"
+	case StopHalted:
+		return "halted"
+	case StopExit:
+		return "exited"
+	case StopException:
+		return "unhandled exception"
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopInstructionLimit:
+		return "instruction limit reached"
+	case StopCanceled:
+		return "canceled"
+	}
+	return fmt.Sprintf("StopReason(%d)", uint32(r))
+}
+
+// ExceptionInfo describes the exception that stopped the CPU.
+type ExceptionInfo struct {
+	Code     uint8  // Cause.ExcCode
+	PC       uint32 // EPC
+	BadVAddr uint32
+}
+
+// Result describes how a run ended.
+type Result struct {
+	Reason       StopReason
+	ExitCode     int           // for StopExit
+	Exception    ExceptionInfo // for StopException and StopBreakpoint
+	Instructions uint64        // instructions executed by this run
+}
+
+func (r Result) String() string {
+	switch r.Reason {
+	case StopExit:
+		return fmt.Sprintf("exited with code %d after %d instructions", r.ExitCode, r.Instructions)
+	case StopException, StopBreakpoint:
+		return fmt.Sprintf("%v (ExcCode %d) at PC 0x%08x, BadVAddr 0x%08x after %d instructions",
+			r.Reason, r.Exception.Code, r.Exception.PC, r.Exception.BadVAddr, r.Instructions)
+	}
+	return fmt.Sprintf("%v after %d instructions", r.Reason, r.Instructions)
+}
+
+// halt stops the CPU for reason. The first reason recorded wins.
+func (cpu *CPU) halt(reason StopReason) {
+	cpu.stop.CompareAndSwap(uint32(StopNone), uint32(reason))
+	cpu.running.Store(false)
+}
+
+// haltOnException stops the CPU on the exception just raised. Like halt, only
+// the first reason is recorded, so a fault while fetching from the exception
+// vector does not hide the exception that led there.
+func (cpu *CPU) haltOnException(reason StopReason) {
+	if cpu.stop.CompareAndSwap(uint32(StopNone), uint32(reason)) {
+		cpu.exc = ExceptionInfo{
+			Code:     uint8(cpu.cp0.cause>>2) & 0x1F,
+			PC:       cpu.cp0.epc,
+			BadVAddr: cpu.cp0.badVAddr,
+		}
+	}
+	cpu.running.Store(false)
+}
+
+// Exit stops the CPU as if the guest program exited with code.
+func (cpu *CPU) Exit(code int) {
+	cpu.exitCode = code
+	cpu.halt(StopExit)
+}
+
+// SetInstructionLimit makes Run stop once the CPU has executed n instructions
+// in total; 0 removes the limit.
+func (cpu *CPU) SetInstructionLimit(n uint64) {
+	cpu.limit = n
+}
+
+// Instructions returns the number of instructions the CPU has executed.
+func (cpu *CPU) Instructions() uint64 {
+	return cpu.retired
+}
+
+// blockLimit returns how many instructions the next block may execute, or 0
+// after stopping the CPU if the instruction limit has been reached.
+func (cpu *CPU) blockLimit() int {
+	if cpu.limit == 0 {
+		return maxBlockLen
+	}
+	if cpu.retired >= cpu.limit {
+		cpu.halt(StopInstructionLimit)
+		return 0
+	}
+	return int(min(uint64(maxBlockLen), cpu.limit-cpu.retired))
+}
+
+// start marks the CPU as running with no stop reason and returns false if it already was.
+func (cpu *CPU) start() bool {
+	if !cpu.running.CompareAndSwap(false, true) {
+		return false
+	}
+	cpu.stop.Store(uint32(StopNone))
+	return true
+}
+
+// result returns the outcome of a run that began after start instructions.
+func (cpu *CPU) result(start uint64) Result {
+	r := Result{
+		Reason:       StopReason(cpu.stop.Load()),
+		Instructions: cpu.retired - start,
+	}
+	switch r.Reason {
+	case StopExit:
+		r.ExitCode = cpu.exitCode
+	case StopException, StopBreakpoint:
+		r.Exception = cpu.exc
+	}
+	return r
+}
@@ -0,0 +1,97 @@
+package mips32
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestCPU loads words at address 0.
+func newTestCPU(words ...uint32) *CPU {
+	mem := NewMemory(0x2000)
+	for i, w := range words {
+		mem.StoreWord(uint32(i*4), w)
+	}
+	return NewCPU(mem)
+}
+
+func TestRunResult(t *testing.T) {
+	tests := []struct {
+		name  string
+		words []uint32
+		limit uint64
+		want  Result
+	}{
+		{
+			name:  "exception",
+			words: []uint32{0x8C040102}, // lw $a0, 0x102($zero)
+			want: Result{Reason: StopException, Instructions: 1,
+				Exception: ExceptionInfo{Code: excAdEL, PC: 0, BadVAddr: 0x102}},
+		},
+		{
+			name:  "breakpoint",
+			words: []uint32{0x00000000, 0x0000000D}, // nop; break
+			want: Result{Reason: StopBreakpoint, Instructions: 2,
+				Exception: ExceptionInfo{Code: excBp, PC: 4}},
+		},
+		{
+			name:  "instruction limit",
+			words: []uint32{0x1000FFFF, 0x00000000}, // b .; nop
+			limit: 1001,
+			want:  Result{Reason: StopInstructionLimit, Instructions: 1001},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu := newTestCPU(tt.words...)
+			cpu.SetInstructionLimit(tt.limit)
+			got, err := cpu.Run(context.Background())
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Run = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunExit(t *testing.T) {
+	cpu := newTestCPU(
+		0x24040003, // addiu $a0, $zero, 3
+		0x0000000C, // syscall
+		reservedInstr,
+	)
+	cpu.SetHooks(Hooks{Syscall: func() bool {
+		cpu.Exit(int(cpu.GetReg(4)))
+		return true
+	}})
+
+	got, _ := cpu.Run(context.Background())
+	if got.Reason != StopExit || got.ExitCode != 3 || got.Instructions != 2 {
+		t.Errorf("Run = %+v, want exit code 3 after 2 instructions", got)
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	cpu := newTestCPU(0x1000FFFF, 0x00000000) // b .; nop
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	got, _ := cpu.Run(ctx)
+	if got.Reason != StopCanceled {
+		t.Errorf("Run = %v, want %v", got.Reason, StopCanceled)
+	}
+	if _, err := cpu.Run(ctx); err != nil {
+		t.Errorf("Run after cancellation: %v, want an immediate %v result", err, StopCanceled)
+	}
+}
+
+func TestRunWhileRunning(t *testing.T) {
+	cpu := newTestCPU()
+	cpu.running.Store(true)
+	if _, err := cpu.Run(context.Background()); !errors.Is(err, ErrRunning) {
+		t.Errorf("Run = %v, want %v", err, ErrRunning)
+	}
+}
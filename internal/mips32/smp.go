@@ -0,0 +1,193 @@
+package mips32
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Scheduling selects how the cores of a System share the host.
+type Scheduling int
+
+const (
+	// RoundRobin runs every core in turn on the calling goroutine, each for a
+	// fixed quantum of instructions. Runs are fully deterministic.
+	RoundRobin Scheduling = iota
+	// Parallel runs each core on its own goroutine.
+	Parallel
+)
+
+// IPIInterruptLine is the hardware interrupt line (IP3) raised by the IPI mailbox.
+const IPIInterruptLine = 3
+
+// System is a multi-core machine: several CPUs sharing one physical memory.
+// Core n has EBase.CPUNum = n.
+type System struct {
+	CPUs   []*CPU
+	Memory *Memory
+	IPI    *IPIMailbox
+
+	running atomic.Bool
+}
+
+// NewSystem creates cores CPUs on mem and maps an IPI mailbox at ipiBase.
+func NewSystem(mem *Memory, cores int, ipiBase uint32) (*System, error) {
+	if cores < 1 || cores > int(ebaseCPUNumMask)+1 {
+		return nil, fmt.Errorf("invalid number of cores %d", cores)
+	}
+
+	s := &System{Memory: mem}
+	for n := 0; n < cores; n++ {
+		cpu := NewCPU(mem)
+		cpu.cp0.SetCPUNum(n)
+		s.CPUs = append(s.CPUs, cpu)
+	}
+
+	s.IPI = NewIPIMailbox(s.CPUs)
+	if err := mem.MapDevice(ipiBase, s.IPI.Size(), s.IPI); err != nil {
+		return nil, fmt.Errorf("mapping IPI mailbox: %w", err)
+	}
+	return s, nil
+}
+
+// SetPC sets the initial PC of every core.
+func (s *System) SetPC(pc uint32) {
+	for _, cpu := range s.CPUs {
+		cpu.SetPC(pc)
+	}
+}
+
+// Run executes all cores until every one of them has stopped or ctx is done,
+// and returns the result of each core. quantum is the number of instructions
+// a core runs before the next one gets its turn in RoundRobin mode. It fails
+// with ErrRunning if the system is already running.
+func (s *System) Run(ctx context.Context, mode Scheduling, quantum int) ([]Result, error) {
+	if !s.running.CompareAndSwap(false, true) {
+		return nil, ErrRunning
+	}
+	defer s.running.Store(false)
+
+	results := make([]Result, len(s.CPUs))
+	switch mode {
+	case Parallel:
+		if len(s.CPUs) > 1 {
+			s.Memory.SetShared(true)
+			defer s.Memory.SetShared(false)
+		}
+		var wg sync.WaitGroup
+		for i, cpu := range s.CPUs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i], _ = cpu.Run(ctx)
+			}()
+		}
+		wg.Wait()
+
+	default:
+		if quantum <= 0 {
+			quantum = 1
+		}
+		starts := make([]uint64, len(s.CPUs))
+		for i, cpu := range s.CPUs {
+			cpu.start()
+			starts[i] = cpu.retired
+		}
+		for active := true; active; {
+			if ctx.Err() != nil {
+				for _, cpu := range s.CPUs {
+					cpu.halt(StopCanceled)
+				}
+			}
+			active = false
+			for _, cpu := range s.CPUs {
+				if cpu.running.Load() {
+					cpu.runSlice(quantum)
+					active = true
+				}
+			}
+		}
+		for i, cpu := range s.CPUs {
+			results[i] = cpu.result(starts[i])
+		}
+	}
+	return results, nil
+}
+
+// Stop halts every core.
+func (s *System) Stop() {
+	for _, cpu := range s.CPUs {
+		cpu.Stop()
+	}
+}
+
+// ipiWindow is the size of the register window each core owns in the mailbox.
+const ipiWindow = 16
+
+// IPI mailbox register offsets within a core's window
+const (
+	ipiRegMsg     = 0x0 // write: post a message and interrupt the core; read: last message
+	ipiRegAck     = 0x4 // write: clear the pending interrupt
+	ipiRegPending = 0x8 // read: 1 while an interrupt is pending
+)
+
+// IPIMailbox is a memory-mapped device that lets a core interrupt another.
+// Core n owns the 16-byte window at base + 16*n. Writing a word to its MSG
+// register stores the word and raises IPIInterruptLine on core n, which
+// acknowledges by writing to ACK.
+type IPIMailbox struct {
+	cpus    []*CPU
+	msg     []atomic.Uint32
+	pending []atomic.Bool
+}
+
+// NewIPIMailbox creates a mailbox for cpus.
+func NewIPIMailbox(cpus []*CPU) *IPIMailbox {
+	return &IPIMailbox{
+		cpus:    cpus,
+		msg:     make([]atomic.Uint32, len(cpus)),
+		pending: make([]atomic.Bool, len(cpus)),
+	}
+}
+
+// Size returns the size of the mailbox's address range.
+func (m *IPIMailbox) Size() uint32 {
+	return uint32(len(m.cpus)) * ipiWindow
+}
+
+// Send posts msg to core n and raises its IPI line.
+func (m *IPIMailbox) Send(n int, msg uint32) {
+	if n < 0 || n >= len(m.cpus) {
+		return
+	}
+	m.msg[n].Store(msg)
+	m.pending[n].Store(true)
+	m.cpus[n].SetInterrupt(IPIInterruptLine, true)
+}
+
+// ReadWord implements Device.
+func (m *IPIMailbox) ReadWord(offset uint32) uint32 {
+	n := int(offset / ipiWindow)
+	switch offset % ipiWindow {
+	case ipiRegMsg:
+		return m.msg[n].Load()
+	case ipiRegPending:
+		if m.pending[n].Load() {
+			return 1
+		}
+	}
+	return 0
+}
+
+// WriteWord implements Device.
+func (m *IPIMailbox) WriteWord(offset uint32, value uint32) {
+	n := int(offset / ipiWindow)
+	switch offset % ipiWindow {
+	case ipiRegMsg:
+		m.Send(n, value)
+	case ipiRegAck:
+		m.pending[n].Store(false)
+		m.cpus[n].SetInterrupt(IPIInterruptLine, false)
+	}
+}
@@ -0,0 +1,198 @@
+package mips32
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+const testIPIBase = 0x10000
+
+func TestSystemCPUNum(t *testing.T) {
+	s, err := NewSystem(NewMemory(0x2000), 3, testIPIBase)
+	if err != nil {
+		t.Fatalf("NewSystem: %v", err)
+	}
+	for n, cpu := range s.CPUs {
+		if got := cpu.GetCP0Reg(cp0RegPRId, 1) & ebaseCPUNumMask; got != uint32(n) {
+			t.Errorf("core %d: EBase.CPUNum = %d, want %d", n, got, n)
+		}
+	}
+
+	// software writes to EBase must not change the core number
+	s.CPUs[2].SetCP0Reg(cp0RegPRId, 1, 0x80001000)
+	if got := s.CPUs[2].CPUNum(); got != 2 {
+		t.Errorf("CPUNum after EBase write = %d, want 2", got)
+	}
+}
+
+func TestLoadLinkedStoreConditional(t *testing.T) {
+	mem := NewMemory(0x1000)
+
+	mem.LoadLinked(0, 0x100)
+	if !mem.StoreConditional(0, 0x100, 1) {
+		t.Errorf("SC without intervening store failed")
+	}
+	if mem.StoreConditional(0, 0x100, 2) {
+		t.Errorf("SC succeeded twice on one reservation")
+	}
+
+	mem.LoadLinked(0, 0x100)
+	mem.LoadLinked(1, 0x100)
+	if !mem.StoreConditional(1, 0x100, 3) {
+		t.Errorf("SC on core 1 failed")
+	}
+	if mem.StoreConditional(0, 0x100, 4) {
+		t.Errorf("SC on core 0 succeeded after core 1 stored to the word")
+	}
+
+	mem.LoadLinked(0, 0x100)
+	mem.StoreWord(0x100, 5)
+	if mem.StoreConditional(0, 0x100, 6) {
+		t.Errorf("SC succeeded after a plain store to the word")
+	}
+	if w, _ := mem.LoadWord(0x100); w != 5 {
+		t.Errorf("word = %d, want 5", w)
+	}
+}
+
+func TestSystemAtomicCounter(t *testing.T) {
+	const iters = 100
+	mem := NewMemory(0x2000)
+	for i, w := range []uint32{
+		0x34080000 | iters, // ori $t0, $zero, iters
+		0xC0091000,         // loop: ll $t1, 0x1000($zero)
+		0x25290001,         // addiu $t1, $t1, 1
+		0xE0091000,         // sc $t1, 0x1000($zero)
+		0x1120FFFC,         // beq $t1, $zero, loop
+		0x00000000,         // nop
+		0x2508FFFF,         // addiu $t0, $t0, -1
+		0x1500FFF9,         // bne $t0, $zero, loop
+		0x00000000,         // nop
+		reservedInstr,
+	} {
+		mem.StoreWord(uint32(i*4), w)
+	}
+
+	for _, mode := range []Scheduling{RoundRobin, Parallel} {
+		mem.StoreWord(0x1000, 0)
+		s, err := NewSystem(mem, 4, testIPIBase+uint32(mode)*0x100)
+		if err != nil {
+			t.Fatalf("NewSystem: %v", err)
+		}
+		if _, err := s.Run(context.Background(), mode, 3); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+
+		if got, _ := mem.LoadWord(0x1000); got != 4*iters {
+			t.Errorf("mode %d: counter = %d, want %d", mode, got, 4*iters)
+		}
+	}
+}
+
+func TestParallelStoresBreakReservations(t *testing.T) {
+	// core 0 counts in the upper halfword of the word at 0x1000 with LL/SC,
+	// core 1 counts in its lower halfword with plain stores; an SC that
+	// succeeds over one of those stores loses an increment of core 1
+	const iters = 20000
+	_, words := assemble(t, `
+		.set noreorder
+		mfc0 $t2, $15, 1
+		andi $t2, $t2, 0x3FF
+		li $t0, 20000
+		lui $t4, 1
+		bne $t2, $zero, plain
+		nop
+llsc:	ll $t1, 0x1000($zero)
+		addu $t1, $t1, $t4
+		sc $t1, 0x1000($zero)
+		beq $t1, $zero, llsc
+		nop
+		addiu $t0, $t0, -1
+		bne $t0, $zero, llsc
+		nop
+		.word 0xFC000000
+plain:	lhu $t1, 0x1002($zero)
+		addiu $t1, $t1, 1
+		sh $t1, 0x1002($zero)
+		addiu $t0, $t0, -1
+		bne $t0, $zero, plain
+		nop
+		.word 0xFC000000
+`)
+	mem := NewMemory(0x2000)
+	for i, w := range words {
+		mem.StoreWord(uint32(i*4), w)
+	}
+	s, err := NewSystem(mem, 2, testIPIBase)
+	if err != nil {
+		t.Fatalf("NewSystem: %v", err)
+	}
+	if _, err := s.Run(context.Background(), Parallel, 0); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if mem.shared.Load() {
+		t.Errorf("memory still shared after Run")
+	}
+	if got, _ := mem.LoadWord(0x1000); got != iters<<16|iters {
+		t.Errorf("counters = 0x%08x, want 0x%08x", got, iters<<16|iters)
+	}
+}
+
+func TestSystemRunWhileRunning(t *testing.T) {
+	s, err := NewSystem(NewMemory(0x1000), 2, testIPIBase)
+	if err != nil {
+		t.Fatalf("NewSystem: %v", err)
+	}
+	s.running.Store(true)
+	if _, err := s.Run(context.Background(), Parallel, 0); !errors.Is(err, ErrRunning) {
+		t.Errorf("Run = %v, want %v", err, ErrRunning)
+	}
+}
+
+func TestStoreBytesBreaksReservation(t *testing.T) {
+	mem := NewMemory(0x1000)
+	mem.LoadLinked(0, 0x100)
+	mem.StoreBytes(0xFE, []byte{1, 2, 3})
+	if mem.StoreConditional(0, 0x100, 4) {
+		t.Errorf("SC succeeded after StoreBytes wrote into the word")
+	}
+}
+
+func TestIPIMailbox(t *testing.T) {
+	mem := NewMemory(0x2000)
+	s, err := NewSystem(mem, 2, testIPIBase)
+	if err != nil {
+		t.Fatalf("NewSystem: %v", err)
+	}
+	target := s.CPUs[1]
+	ip3 := uint32(1) << (causeIPShift + IPIInterruptLine)
+
+	// core 0 posts a message to core 1
+	if !mem.StoreWord(testIPIBase+ipiWindow+ipiRegMsg, 42) {
+		t.Fatalf("store to the IPI mailbox failed")
+	}
+	target.syncInterrupts()
+	if target.cp0.cause&ip3 == 0 {
+		t.Errorf("Cause.IP3 not set on the target core")
+	}
+	if s.CPUs[0].syncInterrupts(); s.CPUs[0].cp0.cause&ip3 != 0 {
+		t.Errorf("Cause.IP3 set on the sending core")
+	}
+	if msg, _ := mem.LoadWord(testIPIBase + ipiWindow + ipiRegMsg); msg != 42 {
+		t.Errorf("MSG = %d, want 42", msg)
+	}
+	if p, _ := mem.LoadWord(testIPIBase + ipiWindow + ipiRegPending); p != 1 {
+		t.Errorf("PENDING = %d, want 1", p)
+	}
+
+	// core 1 acknowledges
+	mem.StoreWord(testIPIBase+ipiWindow+ipiRegAck, 0)
+	target.syncInterrupts()
+	if target.cp0.cause&ip3 != 0 {
+		t.Errorf("Cause.IP3 still set after ACK")
+	}
+	if p, _ := mem.LoadWord(testIPIBase + ipiWindow + ipiRegPending); p != 0 {
+		t.Errorf("PENDING = %d, want 0", p)
+	}
+}
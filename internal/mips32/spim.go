@@ -0,0 +1,289 @@
+package mips32
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SPIM/MARS system call services, selected by $v0
+const (
+	spimPrintInt      = 1
+	spimPrintString   = 4
+	spimReadInt       = 5
+	spimReadString    = 8
+	spimSbrk          = 9
+	spimExit          = 10
+	spimPrintChar     = 11
+	spimReadChar      = 12
+	spimOpen          = 13
+	spimRead          = 14
+	spimWrite         = 15
+	spimClose         = 16
+	spimExit2         = 17
+	spimTime          = 30
+	spimSleep         = 32
+	spimPrintHex      = 34
+	spimPrintBinary   = 35
+	spimPrintUnsigned = 36
+)
+
+// registers used by the syscall convention
+const (
+	regV0 = 2
+	regA0 = 4
+	regA1 = 5
+	regA2 = 6
+)
+
+// MARS open flags
+const (
+	spimOpenRead   = 0
+	spimOpenWrite  = 1
+	spimOpenAppend = 9
+)
+
+// SPIM services SYSCALL the way the SPIM and MARS simulators do, so classroom
+// programs can print, read input, grow their heap and exit. The service
+// number is in $v0 and the arguments in $a0-$a2. Floating point services are
+// not supported since there is no FPU; they and unknown services raise the
+// Syscall exception as usual. A SPIM may be shared by the cores of a System.
+type SPIM struct {
+	mu     sync.Mutex
+	mem    *Memory
+	stdin  *bufio.Reader
+	stdout io.Writer
+	stderr io.Writer
+	brk    uint32 // current heap break
+	files  map[int32]*os.File
+	nextFD int32
+}
+
+// NewSPIM creates the services for programs in mem whose heap starts at heap.
+func NewSPIM(mem *Memory, stdin io.Reader, stdout, stderr io.Writer, heap uint32) *SPIM {
+	return &SPIM{
+		mem:    mem,
+		stdin:  bufio.NewReader(stdin),
+		stdout: stdout,
+		stderr: stderr,
+		brk:    (heap + 7) &^ 7,
+		files:  make(map[int32]*os.File),
+		nextFD: 3,
+	}
+}
+
+// Install makes cpu use s for SYSCALL. It replaces the CPU's other hooks.
+func (s *SPIM) Install(cpu *CPU) {
+	cpu.SetHooks(Hooks{Syscall: func() bool { return s.Syscall(cpu) }})
+}
+
+// Close closes the files the guest left open.
+func (s *SPIM) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for fd, f := range s.files {
+		f.Close()
+		delete(s.files, fd)
+	}
+}
+
+// Syscall performs the service requested by cpu and reports whether it was handled.
+func (s *SPIM) Syscall(cpu *CPU) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a0, a1, a2 := cpu.GetReg(regA0), cpu.GetReg(regA1), cpu.GetReg(regA2)
+	switch cpu.GetReg(regV0) {
+	case spimPrintInt:
+		fmt.Fprint(s.stdout, int32(a0))
+	case spimPrintHex:
+		fmt.Fprintf(s.stdout, "0x%08x", a0)
+	case spimPrintBinary:
+		fmt.Fprintf(s.stdout, "%032b", a0)
+	case spimPrintUnsigned:
+		fmt.Fprint(s.stdout, a0)
+	case spimPrintChar:
+		s.stdout.Write([]byte{byte(a0)})
+	case spimPrintString:
+		str, ok := s.cString(a0)
+		if !ok {
+			return false
+		}
+		io.WriteString(s.stdout, str)
+
+	case spimReadInt:
+		line, _ := s.stdin.ReadString('\n')
+		n, _ := strconv.ParseInt(strings.TrimSpace(line), 0, 32)
+		cpu.SetReg(regV0, uint32(n))
+	case spimReadChar:
+		c, err := s.stdin.ReadByte()
+		if err != nil {
+			c = 0
+		}
+		cpu.SetReg(regV0, uint32(c))
+	case spimReadString:
+		// like fgets: at most a1-1 characters up to and including a newline
+		if int32(a1) < 1 {
+			return true
+		}
+		var buf []byte
+		for uint32(len(buf)) < a1-1 {
+			c, err := s.stdin.ReadByte()
+			if err != nil {
+				break
+			}
+			buf = append(buf, c)
+			if c == '\n' {
+				break
+			}
+		}
+		if !s.mem.StoreBytes(a0, append(buf, 0)) {
+			return false
+		}
+
+	case spimSbrk:
+		end := uint64(s.brk) + uint64((a0+7)&^7)
+		if int32(a0) < 0 || end > uint64(len(s.mem.Data)) {
+			return false
+		}
+		cpu.SetReg(regV0, s.brk)
+		s.brk = uint32(end)
+
+	case spimExit:
+		cpu.Exit(0)
+	case spimExit2:
+		cpu.Exit(int(int32(a0)))
+
+	case spimOpen:
+		cpu.SetReg(regV0, uint32(s.open(a0, a1)))
+	case spimRead:
+		cpu.SetReg(regV0, uint32(s.read(int32(a0), a1, a2)))
+	case spimWrite:
+		cpu.SetReg(regV0, uint32(s.write(int32(a0), a1, a2)))
+	case spimClose:
+		if f, ok := s.files[int32(a0)]; ok {
+			f.Close()
+			delete(s.files, int32(a0))
+		}
+
+	case spimTime:
+		ms := uint64(time.Now().UnixMilli())
+		cpu.SetReg(regA0, uint32(ms))
+		cpu.SetReg(regA1, uint32(ms>>32))
+	case spimSleep:
+		time.Sleep(time.Duration(a0) * time.Millisecond)
+
+	default:
+		return false
+	}
+	return true
+}
+
+// cString reads the NUL-terminated string at addr.
+func (s *SPIM) cString(addr uint32) (string, bool) {
+	data := s.mem.Data
+	if uint64(addr) >= uint64(len(data)) {
+		return "", false
+	}
+	n := bytes.IndexByte(data[addr:], 0)
+	if n < 0 {
+		return "", false
+	}
+	return string(data[addr : addr+uint32(n)]), true
+}
+
+// buffer returns the guest buffer [addr, addr+size).
+func (s *SPIM) buffer(addr, size uint32) ([]byte, bool) {
+	end := uint64(addr) + uint64(size)
+	if end > uint64(len(s.mem.Data)) {
+		return nil, false
+	}
+	return s.mem.Data[addr:end], true
+}
+
+// open opens the file named at nameAddr and returns its descriptor, or -1.
+func (s *SPIM) open(nameAddr, flags uint32) int32 {
+	name, ok := s.cString(nameAddr)
+	if !ok {
+		return -1
+	}
+	var mode int
+	switch flags {
+	case spimOpenRead:
+		mode = os.O_RDONLY
+	case spimOpenWrite:
+		mode = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case spimOpenAppend:
+		mode = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	default:
+		return -1
+	}
+	f, err := os.OpenFile(name, mode, 0o644)
+	if err != nil {
+		return -1
+	}
+	fd := s.nextFD
+	s.nextFD++
+	s.files[fd] = f
+	return fd
+}
+
+// read reads up to size bytes from fd into the guest buffer at addr and
+// returns the number of bytes read, 0 at end of file or -1 on error.
+func (s *SPIM) read(fd int32, addr, size uint32) int32 {
+	if uint64(addr)+uint64(size) > uint64(len(s.mem.Data)) {
+		return -1
+	}
+	var r io.Reader
+	if fd == 0 {
+		r = s.stdin
+	} else if f, ok := s.files[fd]; ok {
+		r = f
+	} else {
+		return -1
+	}
+	// read aside and store like the guest would, so decoded code and LL
+	// reservations in the buffer are invalidated
+	buf := make([]byte, size)
+	n, err := r.Read(buf)
+	if err != nil && err != io.EOF {
+		return -1
+	}
+	if !s.mem.StoreBytes(addr, buf[:n]) {
+		return -1
+	}
+	return int32(n)
+}
+
+// write writes size bytes from the guest buffer at addr to fd and returns the
+// number of bytes written or -1 on error.
+func (s *SPIM) write(fd int32, addr, size uint32) int32 {
+	buf, ok := s.buffer(addr, size)
+	if !ok {
+		return -1
+	}
+	var w io.Writer
+	switch fd {
+	case 1:
+		w = s.stdout
+	case 2:
+		w = s.stderr
+	default:
+		f, ok := s.files[fd]
+		if !ok {
+			return -1
+		}
+		w = f
+	}
+	n, err := w.Write(buf)
+	if err != nil {
+		return -1
+	}
+	return int32(n)
+}
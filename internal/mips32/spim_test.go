@@ -0,0 +1,92 @@
+package mips32
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSPIMSyscalls(t *testing.T) {
+	mem := NewMemory(0x2000)
+	for i, w := range []uint32{
+		0x24020005, // addiu $v0, $zero, 5 (read_int)
+		0x0000000C, // syscall
+		0x24440001, // addiu $a0, $v0, 1
+		0x24020001, // addiu $v0, $zero, 1 (print_int)
+		0x0000000C, // syscall
+		0x24040100, // addiu $a0, $zero, 0x100
+		0x24020004, // addiu $v0, $zero, 4 (print_string)
+		0x0000000C, // syscall
+		0x24040010, // addiu $a0, $zero, 16
+		0x24020009, // addiu $v0, $zero, 9 (sbrk)
+		0x0000000C, // syscall
+		0x00408025, // or $s0, $v0, $zero
+		0x24020009, // addiu $v0, $zero, 9 (sbrk)
+		0x0000000C, // syscall
+		0x00408825, // or $s1, $v0, $zero
+		0x24040003, // addiu $a0, $zero, 3
+		0x24020011, // addiu $v0, $zero, 17 (exit2)
+		0x0000000C, // syscall
+		reservedInstr,
+	} {
+		mem.StoreWord(uint32(i*4), w)
+	}
+	mem.StoreBytes(0x100, []byte("!\n\x00"))
+
+	var out bytes.Buffer
+	cpu := NewCPU(mem)
+	NewSPIM(mem, strings.NewReader("41\n"), &out, &out, 0x1001).Install(cpu)
+	res, _ := cpu.Run(context.Background())
+
+	if res.Reason != StopExit || res.ExitCode != 3 {
+		t.Errorf("Run = %v, want exit with code 3", res)
+	}
+	if got := out.String(); got != "42!\n" {
+		t.Errorf("output = %q, want %q", got, "42!\n")
+	}
+	if got := cpu.GetReg(16); got != 0x1008 {
+		t.Errorf("first sbrk = 0x%x, want 0x1008 (heap start rounded up)", got)
+	}
+	if got := cpu.GetReg(17); got != 0x1018 {
+		t.Errorf("second sbrk = 0x%x, want 0x1018", got)
+	}
+}
+
+func TestSPIMUnknownServiceRaises(t *testing.T) {
+	mem := NewMemory(0x2000)
+	mem.StoreWord(0, 0x24020002) // addiu $v0, $zero, 2 (print_float)
+	mem.StoreWord(4, 0x0000000C) // syscall
+
+	cpu := NewCPU(mem)
+	NewSPIM(mem, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, 0x1000).Install(cpu)
+	cpu.Step()
+	cpu.Step()
+
+	if code := (cpu.cp0.cause >> 2) & 0x1F; code != excSys || cpu.cp0.epc != 4 {
+		t.Errorf("ExcCode %d at EPC 0x%x, want Syscall at 0x4", code, cpu.cp0.epc)
+	}
+}
+
+func TestSPIMReadStores(t *testing.T) {
+	mem := NewMemory(0x2000)
+	spim := NewSPIM(mem, strings.NewReader("data"), &bytes.Buffer{}, &bytes.Buffer{}, 0x1000)
+	mem.LoadLinked(0, 0x100)
+	gen := mem.generation(0x100)
+
+	if n := spim.read(0, 0x100, 16); n != 4 {
+		t.Fatalf("read = %d, want 4", n)
+	}
+	if got := string(mem.Data[0x100:0x104]); got != "data" {
+		t.Errorf("buffer = %q, want %q", got, "data")
+	}
+	if mem.generation(0x100) == gen {
+		t.Errorf("read did not invalidate the page it wrote")
+	}
+	if mem.StoreConditional(0, 0x100, 1) {
+		t.Errorf("SC succeeded after read wrote into the reserved word")
+	}
+	if n := spim.read(0, 0x1FF8, 16); n != -1 {
+		t.Errorf("read past the end of memory = %d, want -1", n)
+	}
+}
@@ -0,0 +1,250 @@
+package mips32
+
+import "fmt"
+
+// TimingConfig sets the latencies of the pipeline timing model, in cycles.
+type TimingConfig struct {
+	LoadUse       int // stall when an instruction uses the result of the load just before it
+	BranchOperand int // stall when a branch reads the result of the instruction just before it (branches resolve in ID)
+	TakenBranch   int // penalty of a taken branch beyond its delay slot
+	MultLatency   int // cycles MULT/MULTU keep HI/LO busy, an MFLO right after stalls MultLatency-1
+	DivLatency    int // cycles DIV/DIVU keep HI/LO busy
+	MissPenalty   int // cycles to refill a cache line
+}
+
+// DefaultTimingConfig returns latencies typical of a classic 5-stage
+// IF/ID/EX/MEM/WB MIPS with full forwarding and an iterative divider.
+func DefaultTimingConfig() TimingConfig {
+	return TimingConfig{
+		LoadUse:       1,
+		BranchOperand: 1,
+		TakenBranch:   0,
+		MultLatency:   5,
+		DivLatency:    35,
+		MissPenalty:   20,
+	}
+}
+
+// TimingStats breaks the modelled cycles down by cause.
+type TimingStats struct {
+	Instructions uint64
+	Cycles       uint64
+
+	// stall cycles
+	LoadUse    uint64
+	Branch     uint64
+	MulDiv     uint64
+	ICacheMiss uint64
+	DCacheMiss uint64
+}
+
+// CPI returns the average number of cycles per instruction.
+func (s TimingStats) CPI() float64 {
+	return s.per(s.Cycles)
+}
+
+func (s TimingStats) per(cycles uint64) float64 {
+	if s.Instructions == 0 {
+		return 0
+	}
+	return float64(cycles) / float64(s.Instructions)
+}
+
+func (s TimingStats) String() string {
+	return fmt.Sprintf("%d instructions, %d cycles, CPI %.3f "+
+		"(base 1.000 + load-use %.3f + branch %.3f + mul/div %.3f + I-miss %.3f + D-miss %.3f)",
+		s.Instructions, s.Cycles, s.CPI(),
+		s.per(s.LoadUse), s.per(s.Branch), s.per(s.MulDiv), s.per(s.ICacheMiss), s.per(s.DCacheMiss))
+}
+
+// Timing is a cycle-approximate model of the classic 5-stage MIPS pipeline.
+// Every instruction issues in one cycle plus the stalls caused by hazards on
+// the instruction before it, the multiply/divide unit and cache misses.
+type Timing struct {
+	cfg   TimingConfig
+	cycle uint64
+
+	prevDest  uint8  // register written by the previous instruction, 0 if none
+	prevLoad  bool   // the previous instruction reads memory
+	hiloReady uint64 // cycle at which HI/LO can be accessed again
+
+	Stats TimingStats
+}
+
+// NewTiming creates a pipeline timing model.
+func NewTiming(cfg TimingConfig) *Timing {
+	return &Timing{cfg: cfg}
+}
+
+// SetTiming enables the pipeline timing model: Count then advances by the
+// modelled cycles instead of one per instruction.
+func (cpu *CPU) SetTiming(t *Timing) {
+	cpu.timing = t
+}
+
+// Timing returns the pipeline timing model, or nil.
+func (cpu *CPU) Timing() *Timing {
+	return cpu.timing
+}
+
+// issue accounts for op, which transferred control if taken, and caused
+// iMisses and dMisses cache misses. It returns the cycles op took.
+func (t *Timing) issue(op *decodedOp, taken bool, iMisses, dMisses uint64) uint64 {
+	u := usage(op)
+	var loadUse, branch, mulDiv uint64
+
+	if t.prevDest != 0 && (u.src1 == t.prevDest || u.src2 == t.prevDest) {
+		if t.prevLoad {
+			loadUse = uint64(t.cfg.LoadUse)
+		}
+		if u.branch {
+			// the comparison happens in ID, one stage before forwarding from EX
+			branch = uint64(t.cfg.BranchOperand)
+		}
+	}
+	if taken {
+		branch += uint64(t.cfg.TakenBranch)
+	}
+	if u.hilo && t.hiloReady > t.cycle {
+		mulDiv = t.hiloReady - t.cycle
+	}
+	iMiss := iMisses * uint64(t.cfg.MissPenalty)
+	dMiss := dMisses * uint64(t.cfg.MissPenalty)
+
+	cycles := 1 + loadUse + branch + mulDiv + iMiss + dMiss
+	t.cycle += cycles
+	switch u.op {
+	case mulDivMult:
+		t.hiloReady = t.cycle + uint64(t.cfg.MultLatency) - 1
+	case mulDivDiv:
+		t.hiloReady = t.cycle + uint64(t.cfg.DivLatency) - 1
+	}
+	t.prevDest, t.prevLoad = u.dest, u.load
+
+	t.Stats.Instructions++
+	t.Stats.Cycles += cycles
+	t.Stats.LoadUse += loadUse
+	t.Stats.Branch += branch
+	t.Stats.MulDiv += mulDiv
+	t.Stats.ICacheMiss += iMiss
+	t.Stats.DCacheMiss += dMiss
+	return cycles
+}
+
+// regUsage describes the pipeline resources an instruction uses.
+type regUsage struct {
+	src1, src2 uint8 // registers read, 0 if none
+	dest       uint8 // register written, 0 if none
+	load       bool  // the result is only available after MEM
+	branch     bool  // compares its operands in ID
+	hilo       bool  // uses the multiply/divide unit or HI/LO
+	op         uint8 // multiply/divide operation started, see mulDiv*
+}
+
+// operations of the multiply/divide unit
+const (
+	mulDivNone uint8 = iota
+	mulDivMult
+	mulDivDiv
+)
+
+// usage returns the resources used by op.
+func usage(op *decodedOp) regUsage {
+	switch op.kind {
+	case kindR:
+		r := &op.r
+		switch OpCode(r.Funct) {
+		case OpCodeJR:
+			return regUsage{src1: r.Rs, branch: true}
+		case OpCodeJALR:
+			return regUsage{src1: r.Rs, dest: r.Rd, branch: true}
+		case OpCodeMFHI, OpCodeMFLO:
+			return regUsage{dest: r.Rd, hilo: true}
+		case OpCodeMTHI, OpCodeMTLO:
+			return regUsage{src1: r.Rs, hilo: true}
+		case OpCodeMULT, OpCodeMULTU, OpCodeDMULT, OpCodeDMULTU:
+			return regUsage{src1: r.Rs, src2: r.Rt, hilo: true, op: mulDivMult}
+		case OpCodeDIV, OpCodeDIVU, OpCodeDDIV, OpCodeDDIVU:
+			return regUsage{src1: r.Rs, src2: r.Rt, hilo: true, op: mulDivDiv}
+		case OpCodeSLL, OpCodeSRL, OpCodeSRA,
+			OpCodeDSLL, OpCodeDSRL, OpCodeDSRA, OpCodeDSLL32, OpCodeDSRL32, OpCodeDSRA32:
+			return regUsage{src1: r.Rt, dest: r.Rd}
+		case OpCodeSYNC:
+			return regUsage{}
+		case OpCodeTEQ, OpCodeTGE, OpCodeTGEU, OpCodeTLT, OpCodeTLTU, OpCodeTNE:
+			return regUsage{src1: r.Rs, src2: r.Rt}
+		}
+		return regUsage{src1: r.Rs, src2: r.Rt, dest: r.Rd}
+
+	case kindI:
+		i := &op.i
+		switch OpCode(i.Opcode) {
+		case OpCodeREGIMM:
+			u := regUsage{src1: i.Rs, branch: true}
+			if i.Rt&0x10 != 0 {
+				u.dest = 31 // BLTZAL, BGEZAL and their likely forms
+			}
+			return u
+		case OpCodeBEQ, OpCodeBNE, OpCodeBEQL, OpCodeBNEL:
+			return regUsage{src1: i.Rs, src2: i.Rt, branch: true}
+		case OpCodeBLEZ, OpCodeBGTZ, OpCodeBLEZL, OpCodeBGTZL:
+			return regUsage{src1: i.Rs, branch: true}
+		case OpCodeLB, OpCodeLBU, OpCodeLH, OpCodeLHU, OpCodeLW, OpCodeLWU, OpCodeLL, OpCodeLD:
+			return regUsage{src1: i.Rs, dest: i.Rt, load: true}
+		case OpCodeSC:
+			return regUsage{src1: i.Rs, src2: i.Rt, dest: i.Rt, load: true}
+		case OpCodeSB, OpCodeSH, OpCodeSW, OpCodeSD:
+			return regUsage{src1: i.Rs, src2: i.Rt}
+		case OpCodeCACHE:
+			return regUsage{src1: i.Rs}
+		case OPCodeLUI:
+			return regUsage{dest: i.Rt}
+		}
+		return regUsage{src1: i.Rs, dest: i.Rt}
+
+	case kindR6:
+		ri := &op.r6
+		switch {
+		case ri.isBranch():
+			u := regUsage{src1: ri.Rs, src2: ri.Rt, branch: true}
+			if ri.links() {
+				u.dest = 31
+			}
+			return u
+		case ri.Op == r6LWPC:
+			return regUsage{dest: ri.Rs, load: true}
+		case ri.Op == r6ADDIUPC || ri.Op == r6AUIPC || ri.Op == r6ALUIPC:
+			return regUsage{dest: ri.Rs}
+		case ri.Op == r6AUI:
+			return regUsage{src1: ri.Rs, dest: ri.Rt}
+		case ri.Op >= r6MUL && ri.Op <= r6MUHU:
+			return regUsage{src1: ri.Rs, src2: ri.Rt, dest: ri.Rd, hilo: true, op: mulDivMult}
+		case ri.Op >= r6DIV && ri.Op <= r6MODU:
+			return regUsage{src1: ri.Rs, src2: ri.Rt, dest: ri.Rd, hilo: true, op: mulDivDiv}
+		}
+		return regUsage{src1: ri.Rs, src2: ri.Rt, dest: ri.Rd}
+
+	case kindJ:
+		if OpCode(op.j.Opcode) == OpCodeJAL {
+			return regUsage{dest: 31}
+		}
+		return regUsage{}
+
+	default:
+		switch op.c.Rs {
+		case COP0Funct_MFC0, COP0Funct_DMFC0:
+			return regUsage{dest: op.c.Rt, load: true}
+		case COP0Funct_MTC0, COP0Funct_DMTC0:
+			return regUsage{src1: op.c.Rt}
+		}
+		return regUsage{}
+	}
+}
+
+// misses returns the number of misses of c, which may be nil.
+func misses(c *Cache) uint64 {
+	if c == nil {
+		return 0
+	}
+	return c.Stats.Misses
+}
@@ -0,0 +1,59 @@
+package mips32
+
+import (
+	"context"
+	"testing"
+)
+
+// runTimed runs words from address 0 with the default timing model.
+func runTimed(t *testing.T, setup func(cpu *CPU), words ...uint32) *CPU {
+	t.Helper()
+	mem := NewMemory(0x2000)
+	for i, w := range words {
+		mem.StoreWord(uint32(i*4), w)
+	}
+	cpu := NewCPU(mem)
+	cpu.SetTiming(NewTiming(DefaultTimingConfig()))
+	if setup != nil {
+		setup(cpu)
+	}
+	cpu.Run(context.Background())
+	return cpu
+}
+
+func TestTimingHazards(t *testing.T) {
+	cpu := runTimed(t, nil,
+		0x8C080100, // lw $t0, 0x100($zero)
+		0x25090001, // addiu $t1, $t0, 1     load-use: 1
+		0x01290018, // mult $t1, $t1
+		0x00005012, // mflo $t2              mul/div: 4
+		0x11400001, // beq $t2, $zero, +1    branch operand: 1
+		0x00000000, // nop
+		reservedInstr,
+	)
+
+	want := TimingStats{Instructions: 7, Cycles: 13, LoadUse: 1, Branch: 1, MulDiv: 4}
+	if got := cpu.Timing().Stats; got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+	if cpu.cp0.count != 13 {
+		t.Errorf("Count = %d, want 13 (modelled cycles)", cpu.cp0.count)
+	}
+}
+
+func TestTimingCacheMissPenalty(t *testing.T) {
+	cpu := runTimed(t, func(cpu *CPU) {
+		ic, _ := NewCache(CacheConfig{Size: 4 << 10, Ways: 2, LineSize: 16})
+		dc, _ := NewCache(CacheConfig{Size: 4 << 10, Ways: 2, LineSize: 16})
+		cpu.SetCaches(ic, dc)
+	},
+		0x8C080100, // lw $t0, 0x100($zero)
+		reservedInstr,
+	)
+
+	penalty := uint64(DefaultTimingConfig().MissPenalty)
+	want := TimingStats{Instructions: 2, Cycles: 2 + 2*penalty, ICacheMiss: penalty, DCacheMiss: penalty}
+	if got := cpu.Timing().Stats; got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,78 @@
+package mips32
+
+// WatchLo/WatchHi fields
+const (
+	watchLoVAddrMask uint32 = 0xFFFFFFF8 // VAddr[31:3], doubleword granularity
+	watchLoI         uint32 = 1 << 2     // watch instruction fetches
+	watchLoR         uint32 = 1 << 1     // watch loads
+	watchLoW         uint32 = 1 << 0     // watch stores
+
+	watchHiG          uint32 = 1 << 30 // match any ASID
+	watchHiASIDMask   uint32 = 0xFF << 16
+	watchHiMaskMask   uint32 = 0x1FF << 3 // address bits [11:3] to ignore
+	watchHiStatusMask uint32 = 0x7        // I, R, W: which condition matched
+)
+
+// watchArmed reports whether any watch condition is enabled.
+func (c *COP0) watchArmed() bool {
+	return c.watchLo&(watchLoI|watchLoR|watchLoW) != 0
+}
+
+// watchMatch reports whether an access of kind (one of watchLoI/R/W) to
+// vaddr matches the watch registers.
+func (c *COP0) watchMatch(vaddr uint32, kind uint32) bool {
+	if c.watchLo&kind == 0 {
+		return false
+	}
+	ignore := c.watchHi & watchHiMaskMask
+	if (vaddr^c.watchLo)&watchLoVAddrMask&^ignore != 0 {
+		return false
+	}
+	if c.watchHi&watchHiG == 0 && (c.watchHi&watchHiASIDMask)>>16 != c.entryHi&0xFF {
+		return false
+	}
+	return true
+}
+
+// checkWatch matches an access of kind to vaddr against the watch registers
+// and reports whether it raised a Watch exception. A match while EXL or ERL
+// is set is recorded in Cause.WP and taken once both are clear.
+func (cpu *CPU) checkWatch(vaddr uint32, kind uint32) bool {
+	c := cpu.cp0
+	if !c.watchMatch(vaddr, kind) {
+		return false
+	}
+
+	c.watchHi |= kind // I, R and W status bits sit at the same positions as in WatchLo
+	if c.status&(statusEXL|statusERL) != 0 {
+		c.cause |= causeWP
+		return false
+	}
+	cpu.raiseException(excWatch)
+	return true
+}
+
+// deferredWatch reports whether a deferred Watch exception must be taken now.
+func (c *COP0) deferredWatch() bool {
+	return c.cause&causeWP != 0 && c.status&(statusEXL|statusERL) == 0
+}
+
+// memoryReference returns the effective address of op and the watch kind
+// of the access, or 0 if op does not access memory.
+func memoryReference(cpu *CPU, op *decodedOp) (vaddr uint32, kind uint32) {
+	if op.kind == kindR6 && op.r6.Op == r6LWPC {
+		return cpu.PC + uint32(op.r6.Offset), watchLoR
+	}
+	if op.kind != kindI {
+		return 0, 0
+	}
+	switch OpCode(op.i.Opcode) {
+	case OpCodeLB, OpCodeLBU, OpCodeLH, OpCodeLHU, OpCodeLW, OpCodeLWU, OpCodeLL, OpCodeLD:
+		kind = watchLoR
+	case OpCodeSB, OpCodeSH, OpCodeSW, OpCodeSC, OpCodeSD:
+		kind = watchLoW
+	default:
+		return 0, 0
+	}
+	return cpu.GetReg(op.i.Rs) + uint32(int32(int16(op.i.Immediate))), kind
+}
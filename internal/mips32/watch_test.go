@@ -0,0 +1,107 @@
+package mips32
+
+import (
+	"context"
+	"testing"
+)
+
+// newWatchCPU loads words at address 0 and returns a CPU ready for runBlock.
+func newWatchCPU(words ...uint32) *CPU {
+	mem := NewMemory(0x2000)
+	for i, w := range words {
+		mem.StoreWord(uint32(i*4), w)
+	}
+	cpu := NewCPU(mem)
+	cpu.running.Store(true)
+	return cpu
+}
+
+func TestWatchStore(t *testing.T) {
+	cpu := newWatchCPU(
+		0x24080007, // addiu $t0, $zero, 7
+		0xAC080104, // sw $t0, 0x104($zero)
+		reservedInstr,
+	)
+	cpu.SetCP0Reg(cp0RegWatchLo, 0, 0x100|watchLoW)
+	cpu.SetCP0Reg(cp0RegWatchHi, 0, watchHiG)
+	cpu.runBlock(maxBlockLen)
+
+	if code := (cpu.cp0.cause >> 2) & 0x1F; code != excWatch {
+		t.Errorf("ExcCode = %d, want %d", code, excWatch)
+	}
+	if cpu.cp0.epc != 0x4 {
+		t.Errorf("EPC = 0x%x, want 0x4", cpu.cp0.epc)
+	}
+	if got := cpu.GetCP0Reg(cp0RegWatchHi, 0) & watchHiStatusMask; got != watchLoW {
+		t.Errorf("WatchHi status = %d, want W", got)
+	}
+	if w, _ := cpu.Memory.LoadWord(0x104); w != 0 {
+		t.Errorf("store completed despite the watchpoint")
+	}
+
+	// status bits are write-one-to-clear
+	cpu.SetCP0Reg(cp0RegWatchHi, 0, watchHiG|watchLoW)
+	if got := cpu.GetCP0Reg(cp0RegWatchHi, 0) & watchHiStatusMask; got != 0 {
+		t.Errorf("WatchHi status = %d after clearing, want 0", got)
+	}
+}
+
+func TestWatchFetchMaskAndASID(t *testing.T) {
+	words := []uint32{0, 0, 0, 0, 0, 0, reservedInstr}
+
+	// without the mask only the doubleword at 0x10 matches
+	cpu := newWatchCPU(words...)
+	cpu.SetCP0Reg(cp0RegWatchLo, 0, 0x10|watchLoI)
+	cpu.SetCP0Reg(cp0RegWatchHi, 0, watchHiG)
+	cpu.runBlock(maxBlockLen)
+	if cpu.cp0.epc != 0x10 {
+		t.Errorf("EPC = 0x%x, want 0x10", cpu.cp0.epc)
+	}
+
+	// ignoring address bit 4 makes 0x0 match too
+	cpu = newWatchCPU(words...)
+	cpu.SetCP0Reg(cp0RegWatchLo, 0, 0x10|watchLoI)
+	cpu.SetCP0Reg(cp0RegWatchHi, 0, watchHiG|0x10)
+	cpu.runBlock(maxBlockLen)
+	if cpu.cp0.epc != 0x0 {
+		t.Errorf("masked: EPC = 0x%x, want 0x0", cpu.cp0.epc)
+	}
+
+	// a watchpoint for another ASID does not fire
+	cpu = newWatchCPU(words...)
+	cpu.SetCP0Reg(cp0RegWatchLo, 0, 0x10|watchLoI)
+	cpu.SetCP0Reg(cp0RegWatchHi, 0, 5<<16)
+	cpu.Stop()
+	cpu.Run(context.Background())
+	if code := (cpu.cp0.cause >> 2) & 0x1F; code != excRI {
+		t.Errorf("other ASID: ExcCode = %d, want %d", code, excRI)
+	}
+}
+
+func TestWatchDeferredUnderEXL(t *testing.T) {
+	cpu := newWatchCPU(
+		0xAC081000, // sw $t0, 0x1000($zero)
+		0x40806000, // mtc0 $zero, $12 (clears EXL)
+		0x00000000, // nop
+		reservedInstr,
+	)
+	cpu.SetCP0Reg(cp0RegStatus, 0, statusEXL)
+	cpu.SetCP0Reg(cp0RegWatchLo, 0, 0x1000|watchLoW)
+	cpu.SetCP0Reg(cp0RegWatchHi, 0, watchHiG)
+
+	cpu.runBlock(maxBlockLen)
+	if cpu.cp0.cause&causeWP == 0 {
+		t.Fatalf("Cause.WP not set for a watch hit under EXL")
+	}
+	if cpu.PC != 0x8 {
+		t.Fatalf("PC = 0x%x, want 0x8 (exception must be deferred)", cpu.PC)
+	}
+
+	cpu.runBlock(maxBlockLen)
+	if code := (cpu.cp0.cause >> 2) & 0x1F; code != excWatch {
+		t.Errorf("ExcCode = %d, want %d", code, excWatch)
+	}
+	if cpu.cp0.epc != 0x8 {
+		t.Errorf("EPC = 0x%x, want 0x8", cpu.cp0.epc)
+	}
+}
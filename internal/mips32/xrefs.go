@@ -0,0 +1,167 @@
+package mips32
+
+import "sort"
+
+// minStringLen is the shortest run of printable bytes taken for a string,
+// maxStringLen the most of it kept: the string data of Go binaries is one
+// run of printable bytes many kilobytes long.
+const (
+	minStringLen = 4
+	maxStringLen = 256
+)
+
+// Region is a block of memory that code refers to, such as .rodata.
+// Data is nil for memory without contents in the file, such as .bss.
+type Region struct {
+	Name string
+	Addr uint32
+	Size uint32
+	Data []byte
+}
+
+// Call is an edge of the call graph. Indirect calls whose target register
+// holds no recovered address have a zero To.
+type Call struct {
+	From     uint32 // address of the JAL or JALR
+	Caller   string
+	To       uint32
+	Callee   string
+	Indirect bool
+}
+
+// DataRef is an address built by a lui pair, with the region it falls in,
+// if any: addresses outside every region are often memory-mapped devices.
+type DataRef struct {
+	From   uint32
+	Func   string
+	Addr   uint32
+	Symbol string
+	Region string
+}
+
+// StringLit is a run of printable bytes that code refers to, cut to
+// maxStringLen bytes.
+type StringLit struct {
+	Addr uint32
+	Text string
+	Refs []uint32 // the instructions completing an address within Text
+}
+
+// XRefs is the cross-reference report of a listing.
+type XRefs struct {
+	Calls   []Call
+	Data    []DataRef
+	Strings []StringLit
+}
+
+// XRefs collects the calls of l, the addresses its lui pairs build and the
+// strings these point to in regions. JALR targets are resolved when the
+// register was loaded with a recovered address in the same function.
+func (l *Listing) XRefs(regions []Region) *XRefs {
+	x := &XRefs{}
+	caller := func(addr uint32) string {
+		if sym, ok := l.Func(addr); ok {
+			return sym.Name
+		}
+		return ""
+	}
+
+	var regs [32]uint32
+	var known [32]bool
+	lits := make(map[uint32]*StringLit)
+	for _, inst := range l.Insts {
+		if _, ok := l.FuncStart(inst.Addr); ok {
+			known = [32]bool{}
+		}
+
+		switch {
+		case inst.Op == "jal" || inst.Op == "jalx" || inst.Op == "balc":
+			t, _ := inst.Target()
+			x.Calls = append(x.Calls, Call{From: inst.Addr, Caller: caller(inst.Addr), To: t, Callee: l.Name(t)})
+		case inst.Op == "jalr" || inst.Op == "jalrc" || inst.Op == "jialc":
+			c := Call{From: inst.Addr, Caller: caller(inst.Addr), Indirect: true}
+			rs, off := inst.Args[len(inst.Args)-1].Reg, uint32(0)
+			if inst.Op == "jialc" { // jialc rt, offset
+				rs, off = inst.Args[0].Reg, uint32(inst.Args[1].Imm)
+			}
+			if known[rs] {
+				c.To, c.Callee = regs[rs]+off, l.Name(regs[rs]+off)
+			}
+			x.Calls = append(x.Calls, c)
+		}
+
+		ref, hasRef := l.Refs[inst.Addr]
+		if hasRef {
+			d := DataRef{From: inst.Addr, Func: caller(inst.Addr), Addr: ref, Symbol: l.Name(ref)}
+			if r, ok := regionOf(regions, ref); ok {
+				d.Region = r.Name
+				if text, ok := stringAt(r, ref); ok {
+					s := lits[ref]
+					if s == nil {
+						s = &StringLit{Addr: ref, Text: text}
+						lits[ref] = s
+					}
+					s.Refs = append(s.Refs, inst.Addr)
+				}
+			}
+			x.Data = append(x.Data, d)
+		}
+
+		if r, ok := inst.dest(); ok {
+			known[r] = hasRef && inst.format != fmtMem && inst.Op != "lw" // MIPS16e lw from a literal pool
+			regs[r] = ref
+		}
+	}
+
+	for _, s := range lits {
+		x.Strings = append(x.Strings, *s)
+	}
+	sort.Slice(x.Strings, func(i, j int) bool { return x.Strings[i].Addr < x.Strings[j].Addr })
+	x.Strings = mergeStrings(x.Strings)
+	return x
+}
+
+// mergeStrings folds the strings starting within the text of an earlier
+// one, such as the tail of a string, into it. strs is sorted by address.
+func mergeStrings(strs []StringLit) []StringLit {
+	var out []StringLit
+	for _, s := range strs {
+		if n := len(out); n > 0 {
+			prev := &out[n-1]
+			if s.Addr-prev.Addr < uint32(len(prev.Text)) {
+				prev.Refs = append(prev.Refs, s.Refs...)
+				sort.Slice(prev.Refs, func(i, j int) bool { return prev.Refs[i] < prev.Refs[j] })
+				continue
+			}
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// regionOf returns the region containing addr.
+func regionOf(regions []Region, addr uint32) (Region, bool) {
+	for _, r := range regions {
+		if addr >= r.Addr && addr-r.Addr < r.Size {
+			return r, true
+		}
+	}
+	return Region{}, false
+}
+
+// stringAt returns the printable text at addr, up to a NUL or any other
+// unprintable byte or maxStringLen bytes, if it is at least minStringLen long.
+func stringAt(r Region, addr uint32) (string, bool) {
+	if r.Data == nil || addr-r.Addr >= uint32(len(r.Data)) {
+		return "", false
+	}
+	data := r.Data[addr-r.Addr:]
+	n := 0
+	for n < min(len(data), maxStringLen) && (data[n] >= 0x20 && data[n] < 0x7f || data[n] == '\t' || data[n] == '\n') {
+		n++
+	}
+	if n < minStringLen {
+		return "", false
+	}
+	return string(data[:n]), true
+}
@@ -0,0 +1,85 @@
+package mips32
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestXRefs(t *testing.T) {
+	img, _ := assemble(t, `
+		.set noreorder
+main:	la $a0, msg
+		jal puts
+		nop
+		la $t9, puts
+		jalr $t9
+		nop
+		lw $t0, 0($a1)
+		jalr $t0
+		nop
+		lui $t1, 0xbf00
+		lw $t2, 0x10($t1)
+puts:	jr $ra
+		nop
+		.data
+msg:	.asciiz "hello"
+count:	.word 3
+`)
+	funcs := img.TextSymbols()
+	objects := NewSymbolTable([]Symbol{{Name: "msg", Addr: 0x1000, Size: 6}, {Name: "count", Addr: 0x1008, Size: 4}})
+	l := NewListing(img.Text.Data, 0, binary.BigEndian, funcs, objects)
+	x := l.XRefs([]Region{{Name: ".data", Addr: 0x1000, Size: uint32(len(img.Data.Data)), Data: img.Data.Data}})
+
+	puts := img.Symbols["puts"]
+	wantCalls := []Call{
+		{From: 0x8, Caller: "main", To: puts, Callee: "puts"},
+		{From: 0x18, Caller: "main", To: puts, Callee: "puts", Indirect: true},
+		{From: 0x24, Caller: "main", Indirect: true},
+	}
+	if !reflect.DeepEqual(x.Calls, wantCalls) {
+		t.Errorf("Calls = %+v, want %+v", x.Calls, wantCalls)
+	}
+	wantData := []DataRef{
+		{From: 0x4, Func: "main", Addr: 0x1000, Symbol: "msg", Region: ".data"},
+		{From: 0x14, Func: "main", Addr: puts, Symbol: "puts"},
+		{From: 0x30, Func: "main", Addr: 0xbf000010},
+	}
+	if !reflect.DeepEqual(x.Data, wantData) {
+		t.Errorf("Data = %+v, want %+v", x.Data, wantData)
+	}
+	wantStrings := []StringLit{{Addr: 0x1000, Text: "hello", Refs: []uint32{0x4}}}
+	if !reflect.DeepEqual(x.Strings, wantStrings) {
+		t.Errorf("Strings = %+v, want %+v", x.Strings, wantStrings)
+	}
+}
+
+func TestXRefsLongStrings(t *testing.T) {
+	img, _ := assemble(t, `
+main:	lui $a0, 1
+		addiu $a0, $a0, 0
+		lui $a1, 1
+		addiu $a1, $a1, 1
+		lui $a2, 1
+		addiu $a2, $a2, 0x190
+`)
+	// like the string data of Go binaries: one long run of printable bytes
+	blob := bytes.Repeat([]byte("abcd"), 200)
+	funcs := NewSymbolTable(append(img.TextSymbols().Symbols(), Symbol{Name: "runtime.text", Addr: 0}))
+	l := NewListing(img.Text.Data, 0, binary.BigEndian, funcs, nil)
+	x := l.XRefs([]Region{{Name: ".rodata", Addr: 0x10000, Size: uint32(len(blob)), Data: blob}})
+
+	want := []StringLit{
+		{Addr: 0x10000, Text: string(blob[:maxStringLen]), Refs: []uint32{0x4, 0xc}},
+		{Addr: 0x10190, Text: string(blob[0x190 : 0x190+maxStringLen]), Refs: []uint32{0x14}},
+	}
+	if !reflect.DeepEqual(x.Strings, want) {
+		t.Errorf("Strings = %+v, want %+v", x.Strings, want)
+	}
+
+	// call sites are named like the listing names their function
+	if fn, _ := l.Func(0x14); x.Data[2].Func != "main" || fn.Name != "main" {
+		t.Errorf("reference at 0x14 in %q, listing function %q, want main", x.Data[2].Func, fn.Name)
+	}
+}
@@ -0,0 +1,9 @@
+package mips
+
+import "awesomeVM/internal/mips32"
+
+// Disassemble formats the instruction word found at pc in assembler syntax
+// with ABI register names, for tracers and debuggers built on a Machine.
+func Disassemble(word, pc uint32) string {
+	return mips32.Decode(word, pc).String()
+}
@@ -0,0 +1,223 @@
+package mips
+
+import (
+	"fmt"
+	"math"
+
+	"awesomeVM/internal/mips32"
+)
+
+// Action tells the machine what to do after a hook returns.
+type Action int
+
+const (
+	// Continue resumes execution. For syscall hooks it also marks the
+	// SYSCALL as serviced. Exception hooks cannot resume past an exception
+	// the machine does not handle; see ExceptionHook.
+	Continue Action = iota
+	// Stop stops the machine; Run and Step return StopHook. An instruction
+	// hook that stops leaves PC at the instruction, which has not executed.
+	Stop
+	// Raise delivers the Syscall exception to the guest as if no hook were
+	// installed. It is only meaningful for syscall hooks.
+	Raise
+)
+
+// InstructionHook is called before each instruction executes.
+type InstructionHook func(m *Machine, pc, word uint32) Action
+
+// ExceptionHook is called when the guest raises an exception. Returning
+// Continue leaves the outcome unchanged: Syscall, Int and Watch exceptions
+// resume at the vector, while address, TLB, RI, CpU, Ov and Tr exceptions
+// still stop the machine with StopException and BREAK with StopBreakpoint.
+// Returning Stop stops the machine with StopHook for any exception.
+type ExceptionHook func(m *Machine, e Exception) Action
+
+// MemoryHook is called after a data load or store of size bytes at addr.
+// For loads value is the loaded data, for stores the stored data.
+type MemoryHook func(m *Machine, addr uint32, size int, value uint32) Action
+
+// SyscallHook is called on SYSCALL. The service number and arguments are in
+// the guest registers ($v0, $a0-$a3 by convention).
+type SyscallHook func(m *Machine) Action
+
+// Exception describes an exception raised by the guest.
+type Exception struct {
+	Code     int    // Cause.ExcCode
+	PC       uint32 // EPC: the faulting instruction, or the branch for a delay slot
+	BadVAddr uint32 // faulting address for address and TLB errors
+}
+
+// exception names by Cause.ExcCode
+var exceptionNames = map[int]string{
+	0: "Int", 1: "Mod", 2: "TLBL", 3: "TLBS", 4: "AdEL", 5: "AdES",
+	8: "Sys", 9: "Bp", 10: "RI", 11: "CpU", 12: "Ov", 13: "Tr", 23: "WATCH",
+}
+
+func (e Exception) String() string {
+	name, ok := exceptionNames[e.Code]
+	if !ok {
+		name = fmt.Sprintf("exception %d", e.Code)
+	}
+	return fmt.Sprintf("%s at PC 0x%08x (BadVAddr 0x%08x)", name, e.PC, e.BadVAddr)
+}
+
+// addressRange is the half-open range [lo, hi).
+type addressRange struct {
+	lo, hi uint64
+	hook   MemoryHook
+}
+
+func (r addressRange) contains(addr uint32) bool {
+	return uint64(addr) >= r.lo && uint64(addr) < r.hi
+}
+
+// config holds the options passed to New.
+type config struct {
+	memorySize       uint32
+	instructionLimit uint64
+
+	instruction []InstructionHook
+	exception   []ExceptionHook
+	reads       []addressRange
+	writes      []addressRange
+	syscall     SyscallHook
+}
+
+// Option configures a Machine.
+type Option func(*config) error
+
+// WithMemorySize sets the size of RAM in bytes.
+func WithMemorySize(size uint64) Option {
+	return func(c *config) error {
+		if size > math.MaxUint32 {
+			return fmt.Errorf("memory size %d exceeds 4 GiB", size)
+		}
+		c.memorySize = uint32(size)
+		return nil
+	}
+}
+
+// WithInstructionLimit makes Run stop with StopInstructionLimit once the
+// machine has executed n instructions.
+func WithInstructionLimit(n uint64) Option {
+	return func(c *config) error {
+		c.instructionLimit = n
+		return nil
+	}
+}
+
+// WithInstructionHook adds a hook called before each instruction.
+func WithInstructionHook(h InstructionHook) Option {
+	return func(c *config) error {
+		c.instruction = append(c.instruction, h)
+		return nil
+	}
+}
+
+// WithExceptionHook adds a hook called on each exception.
+func WithExceptionHook(h ExceptionHook) Option {
+	return func(c *config) error {
+		c.exception = append(c.exception, h)
+		return nil
+	}
+}
+
+// WithMemoryReadHook adds a hook called for loads from [lo, hi).
+func WithMemoryReadHook(lo, hi uint64, h MemoryHook) Option {
+	return func(c *config) error {
+		if lo >= hi || hi > 1<<32 {
+			return fmt.Errorf("invalid address range [0x%x, 0x%x)", lo, hi)
+		}
+		c.reads = append(c.reads, addressRange{lo: lo, hi: hi, hook: h})
+		return nil
+	}
+}
+
+// WithMemoryWriteHook adds a hook called for stores to [lo, hi).
+func WithMemoryWriteHook(lo, hi uint64, h MemoryHook) Option {
+	return func(c *config) error {
+		if lo >= hi || hi > 1<<32 {
+			return fmt.Errorf("invalid address range [0x%x, 0x%x)", lo, hi)
+		}
+		c.writes = append(c.writes, addressRange{lo: lo, hi: hi, hook: h})
+		return nil
+	}
+}
+
+// WithSyscallHook sets the hook servicing SYSCALL.
+func WithSyscallHook(h SyscallHook) Option {
+	return func(c *config) error {
+		c.syscall = h
+		return nil
+	}
+}
+
+// hooks builds the CPU hooks dispatching to the configured ones. Only the
+// kinds in use are installed, so unused hooks cost nothing.
+func (m *Machine) hooks() mips32.Hooks {
+	cfg := &m.cfg
+	var h mips32.Hooks
+
+	// exceptions are always tracked for LastException
+	h.Exception = func(code uint8, epc, badVAddr uint32) bool {
+		e := Exception{Code: int(code), PC: epc, BadVAddr: badVAddr}
+		m.lastExc, m.hasExc = e, true
+		return m.dispatch(len(cfg.exception), func(i int) Action { return cfg.exception[i](m, e) })
+	}
+
+	if len(cfg.instruction) > 0 {
+		h.Instruction = func(pc, word uint32) bool {
+			return m.dispatch(len(cfg.instruction), func(i int) Action { return cfg.instruction[i](m, pc, word) })
+		}
+	}
+
+	if len(cfg.reads) > 0 || len(cfg.writes) > 0 {
+		h.Memory = func(addr uint32, size int, value uint32, write bool) bool {
+			ranges := cfg.reads
+			if write {
+				ranges = cfg.writes
+			}
+			stop := false
+			for _, r := range ranges {
+				if r.contains(addr) && r.hook(m, addr, size, value) == Stop {
+					stop = true
+				}
+			}
+			if stop {
+				m.hookStop.Store(true)
+			}
+			return stop
+		}
+	}
+
+	if cfg.syscall != nil {
+		h.Syscall = func() bool {
+			switch cfg.syscall(m) {
+			case Stop:
+				m.hookStop.Store(true)
+				m.cpu.Stop()
+				return true
+			case Raise:
+				return false
+			}
+			return true
+		}
+	}
+
+	return h
+}
+
+// dispatch calls n hooks via call and reports whether any of them returned Stop.
+func (m *Machine) dispatch(n int, call func(i int) Action) bool {
+	stop := false
+	for i := 0; i < n; i++ {
+		if call(i) == Stop {
+			stop = true
+		}
+	}
+	if stop {
+		m.hookStop.Store(true)
+	}
+	return stop
+}
@@ -0,0 +1,239 @@
+// Package mips embeds the awesomeVM MIPS32 emulator in other Go programs.
+//
+// A Machine is a single big-endian MIPS32 core with flat physical memory
+// starting at address 0:
+//
+//	m, err := mips.New(mips.WithMemorySize(1<<20), mips.WithSyscallHook(handler))
+//	if err != nil { ... }
+//	if err := m.Load("prog.elf"); err != nil { ... }
+//	result, err := m.Run(ctx)
+package mips
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"awesomeVM/internal/mips32"
+)
+
+// DefaultMemorySize is the memory size used when WithMemorySize is not given.
+const DefaultMemorySize = 1 << 20
+
+// StopReason tells why Run or Step returned.
+type StopReason int
+
+const (
+	// StopNone means the machine can keep going (Step only).
+	StopNone StopReason = iota
+	// StopHalted means the machine stopped without a more specific reason.
+	StopHalted
+	// StopExit means the guest exited; Result.ExitCode holds its status.
+	StopExit
+	// StopException means the guest raised an exception the machine does
+	// not handle, described by Result.Exception.
+	StopException
+	// StopBreakpoint means the guest executed BREAK.
+	StopBreakpoint
+	// StopInstructionLimit means the limit set by WithInstructionLimit was reached.
+	StopInstructionLimit
+	// StopHook means a hook returned Stop.
+	StopHook
+	// StopCanceled means the context passed to Run was done.
+	StopCanceled
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopNone:
+		return "none"
+	case StopHalted:
+		return "halted"
+	case StopExit:
+		return "exited"
+	case StopException:
+		return "unhandled exception"
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopInstructionLimit:
+		return "instruction limit reached"
+	case StopHook:
+		return "stopped by hook"
+	case StopCanceled:
+		return "canceled"
+	}
+	return fmt.Sprintf("StopReason(%d)", int(r))
+}
+
+// Result describes how Run ended.
+type Result struct {
+	Reason       StopReason
+	ExitCode     int       // for StopExit
+	Exception    Exception // for StopException and StopBreakpoint
+	Instructions uint64    // instructions executed by this run
+}
+
+// Machine is an embeddable MIPS32 virtual machine.
+// Its methods must not be called concurrently with Run or Step, except from hooks.
+type Machine struct {
+	cpu *mips32.CPU
+	mem *mips32.Memory
+	cfg config
+
+	hookStop atomic.Bool // a hook asked to stop
+	lastExc  Exception
+	hasExc   bool
+}
+
+// New creates a machine configured by opts.
+func New(opts ...Option) (*Machine, error) {
+	cfg := config{memorySize: DefaultMemorySize}
+	for _, opt := range opts {
+		if err := opt(&cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	m := &Machine{cfg: cfg, mem: mips32.NewMemory(cfg.memorySize)}
+	m.cpu = mips32.NewCPU(m.mem)
+	m.cpu.SetHooks(m.hooks())
+	m.cpu.SetInstructionLimit(cfg.instructionLimit)
+	return m, nil
+}
+
+// Load loads a big-endian MIPS32 ELF executable and sets PC to its entry point.
+func (m *Machine) Load(path string) error {
+	prog, err := mips32.LoadELF(m.mem, path)
+	if err != nil {
+		return err
+	}
+	m.cpu.SetPC(prog.Entry)
+	return nil
+}
+
+// LoadAt copies a raw image into memory at addr.
+func (m *Machine) LoadAt(addr uint32, image []byte) error {
+	return m.WriteMemory(addr, image)
+}
+
+// Step executes one instruction, or takes a pending interrupt.
+// It returns StopNone if the machine can continue.
+func (m *Machine) Step() StopReason {
+	m.hookStop.Store(false)
+	return m.result(m.cpu.Step()).Reason
+}
+
+// Run executes instructions until the guest stops, a hook returns Stop or ctx is done.
+func (m *Machine) Run(ctx context.Context) (Result, error) {
+	m.hookStop.Store(false)
+	r, err := m.cpu.Run(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	return m.result(r), nil
+}
+
+// Exit stops the machine as if the guest exited with code. It is meant to be
+// called from hooks, e.g. to implement an exit system call.
+func (m *Machine) Exit(code int) {
+	m.cpu.Exit(code)
+}
+
+// result converts a CPU result.
+func (m *Machine) result(r mips32.Result) Result {
+	res := Result{
+		ExitCode:     r.ExitCode,
+		Instructions: r.Instructions,
+		Exception: Exception{
+			Code:     int(r.Exception.Code),
+			PC:       r.Exception.PC,
+			BadVAddr: r.Exception.BadVAddr,
+		},
+	}
+	switch r.Reason {
+	case mips32.StopNone:
+		res.Reason = StopNone
+	case mips32.StopHalted:
+		res.Reason = StopHalted
+		if m.hookStop.Load() {
+			res.Reason = StopHook
+		}
+	case mips32.StopExit:
+		res.Reason = StopExit
+	case mips32.StopException:
+		res.Reason = StopException
+	case mips32.StopBreakpoint:
+		res.Reason = StopBreakpoint
+	case mips32.StopInstructionLimit:
+		res.Reason = StopInstructionLimit
+	case mips32.StopCanceled:
+		res.Reason = StopCanceled
+	}
+	return res
+}
+
+// LastException returns the most recent exception raised by the guest.
+func (m *Machine) LastException() (Exception, bool) {
+	return m.lastExc, m.hasExc
+}
+
+// PC returns the program counter.
+func (m *Machine) PC() uint32 { return m.cpu.PC }
+
+// SetPC sets the program counter. An odd pc starts MIPS16e code.
+func (m *Machine) SetPC(pc uint32) { m.cpu.SetPC(pc) }
+
+// Reg returns general-purpose register n (0-31).
+func (m *Machine) Reg(n int) uint32 { return m.cpu.GetReg(uint8(n)) }
+
+// SetReg sets general-purpose register n (0-31). Writes to $zero are ignored.
+func (m *Machine) SetReg(n int, v uint32) { m.cpu.SetReg(uint8(n), v) }
+
+// HI returns the HI register.
+func (m *Machine) HI() uint32 { return uint32(m.cpu.HI) }
+
+// LO returns the LO register.
+func (m *Machine) LO() uint32 { return uint32(m.cpu.LO) }
+
+// CP0 returns CP0 register (reg, sel).
+func (m *Machine) CP0(reg, sel int) uint32 { return m.cpu.GetCP0Reg(reg, sel) }
+
+// SetCP0 writes CP0 register (reg, sel) with the same side effects as MTC0.
+func (m *Machine) SetCP0(reg, sel int, v uint32) { m.cpu.SetCP0Reg(reg, sel, v) }
+
+// MemorySize returns the size of RAM in bytes.
+func (m *Machine) MemorySize() uint32 { return uint32(len(m.mem.Data)) }
+
+// ReadMemory copies len(buf) bytes starting at addr into buf.
+func (m *Machine) ReadMemory(addr uint32, buf []byte) error {
+	if uint64(addr)+uint64(len(buf)) > uint64(len(m.mem.Data)) {
+		return fmt.Errorf("read of %d bytes at 0x%08x is outside memory", len(buf), addr)
+	}
+	copy(buf, m.mem.Data[addr:])
+	return nil
+}
+
+// WriteMemory copies data into memory starting at addr.
+func (m *Machine) WriteMemory(addr uint32, data []byte) error {
+	if !m.mem.StoreBytes(addr, data) {
+		return fmt.Errorf("write of %d bytes at 0x%08x is outside memory", len(data), addr)
+	}
+	return nil
+}
+
+// ReadWord reads the aligned big-endian word at addr.
+func (m *Machine) ReadWord(addr uint32) (uint32, error) {
+	w, ok := m.mem.LoadWord(addr)
+	if !ok {
+		return 0, fmt.Errorf("invalid word address 0x%08x", addr)
+	}
+	return w, nil
+}
+
+// WriteWord writes the aligned big-endian word at addr.
+func (m *Machine) WriteWord(addr uint32, v uint32) error {
+	if !m.mem.StoreWord(addr, v) {
+		return fmt.Errorf("invalid word address 0x%08x", addr)
+	}
+	return nil
+}
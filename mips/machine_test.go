@@ -0,0 +1,167 @@
+package mips
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const reservedInstr = 0xFC000000 // raises RI, which halts the machine
+
+// newMachine creates a machine with words loaded at address 0.
+func newMachine(t *testing.T, words []uint32, opts ...Option) *Machine {
+	t.Helper()
+	m, err := New(opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for i, w := range words {
+		if err := m.WriteWord(uint32(i*4), w); err != nil {
+			t.Fatalf("WriteWord: %v", err)
+		}
+	}
+	return m
+}
+
+func TestRunSyscallHook(t *testing.T) {
+	var service, arg uint32
+	m := newMachine(t, []uint32{
+		0x24020001, // addiu $v0, $zero, 1
+		0x2404002A, // addiu $a0, $zero, 42
+		0x0000000C, // syscall
+		0x24020002, // addiu $v0, $zero, 2
+		reservedInstr,
+	}, WithSyscallHook(func(m *Machine) Action {
+		service, arg = m.Reg(2), m.Reg(4)
+		return Continue
+	}))
+
+	res, err := m.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.Reason != StopException || res.Exception.Code != 10 || res.Exception.PC != 0x10 {
+		t.Fatalf("Run = %+v, want RI exception at 0x10", res)
+	}
+	if service != 1 || arg != 42 {
+		t.Errorf("syscall saw $v0=%d $a0=%d, want 1 and 42", service, arg)
+	}
+	if got := m.Reg(2); got != 2 {
+		t.Errorf("$v0 = %d, want 2 (execution continues after a serviced syscall)", got)
+	}
+	if e, ok := m.LastException(); !ok || e.Code != 10 || e.PC != 0x10 {
+		t.Errorf("LastException = %v, %v, want RI at 0x10", e, ok)
+	}
+}
+
+func TestExceptionHookContinue(t *testing.T) {
+	var codes []int
+	hook := func(m *Machine, e Exception) Action {
+		codes = append(codes, e.Code)
+		return Continue
+	}
+
+	// Continue does not resume past an exception the machine halts on
+	m := newMachine(t, []uint32{reservedInstr}, WithExceptionHook(hook))
+	res, _ := m.Run(context.Background())
+	if res.Reason != StopException || res.Exception.Code != 10 {
+		t.Errorf("Run = %+v, want RI exception", res)
+	}
+	if len(codes) != 1 || codes[0] != 10 {
+		t.Errorf("hook saw %v, want [10]", codes)
+	}
+
+	// Stop takes precedence
+	m = newMachine(t, []uint32{reservedInstr}, WithExceptionHook(func(m *Machine, e Exception) Action {
+		return Stop
+	}))
+	if res, _ := m.Run(context.Background()); res.Reason != StopHook {
+		t.Errorf("Run = %v, want %v", res.Reason, StopHook)
+	}
+}
+
+func TestInstructionHookAndStep(t *testing.T) {
+	m := newMachine(t, []uint32{
+		0x24080001, // addiu $t0, $zero, 1
+		0x25080001, // addiu $t0, $t0, 1
+		0x25080001, // addiu $t0, $t0, 1
+		reservedInstr,
+	}, WithInstructionHook(func(m *Machine, pc, word uint32) Action {
+		if pc == 0x8 {
+			return Stop
+		}
+		return Continue
+	}))
+
+	if reason := m.Step(); reason != StopNone || m.PC() != 0x4 {
+		t.Fatalf("Step = %v at PC 0x%x, want %v at 0x4", reason, m.PC(), StopNone)
+	}
+	if res, _ := m.Run(context.Background()); res.Reason != StopHook {
+		t.Fatalf("Run = %v, want %v", res.Reason, StopHook)
+	}
+	if m.PC() != 0x8 || m.Reg(8) != 2 {
+		t.Errorf("stopped at PC 0x%x with $t0=%d, want 0x8 and 2", m.PC(), m.Reg(8))
+	}
+}
+
+func TestMemoryWriteHookRange(t *testing.T) {
+	type write struct{ addr, value uint32 }
+	var writes []write
+	m := newMachine(t, []uint32{
+		0x2404002A, // addiu $a0, $zero, 42
+		0xAC040100, // sw $a0, 0x100($zero)
+		0xAC040200, // sw $a0, 0x200($zero)
+		reservedInstr,
+	}, WithMemoryWriteHook(0x100, 0x104, func(m *Machine, addr uint32, size int, value uint32) Action {
+		writes = append(writes, write{addr, value})
+		return Continue
+	}))
+	m.Run(context.Background())
+
+	if len(writes) != 1 || writes[0] != (write{0x100, 42}) {
+		t.Errorf("writes = %v, want [{256 42}]", writes)
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	m := newMachine(t, []uint32{
+		0x1000FFFF, // b .
+		0x00000000, // nop
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if res, _ := m.Run(ctx); res.Reason != StopCanceled {
+		t.Errorf("Run = %v, want %v", res.Reason, StopCanceled)
+	}
+}
+
+func TestRunExitAndInstructionLimit(t *testing.T) {
+	words := []uint32{
+		0x24040007, // addiu $a0, $zero, 7
+		0x0000000C, // syscall
+		0x1000FFFF, // b .
+		0x00000000, // nop
+	}
+	m := newMachine(t, words, WithSyscallHook(func(m *Machine) Action {
+		m.Exit(int(m.Reg(4)))
+		return Continue
+	}))
+	if res, _ := m.Run(context.Background()); res.Reason != StopExit || res.ExitCode != 7 {
+		t.Errorf("Run = %+v, want exit with code 7", res)
+	}
+
+	m = newMachine(t, words[2:], WithInstructionLimit(100))
+	if res, _ := m.Run(context.Background()); res.Reason != StopInstructionLimit || res.Instructions != 100 {
+		t.Errorf("Run = %+v, want instruction limit after 100 instructions", res)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	if got := Disassemble(0x25080001, 0x400000); got != "addiu $t0, $t0, 1" {
+		t.Errorf("Disassemble = %q, want \"addiu $t0, $t0, 1\"", got)
+	}
+	if got := Disassemble(0x0C100004, 0x400000); got != "jal 0x00400010" {
+		t.Errorf("Disassemble = %q, want \"jal 0x00400010\"", got)
+	}
+}